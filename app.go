@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/csv"
 	"fmt"
 	"log"
 	"mime/multipart"
@@ -26,6 +27,10 @@ type App struct {
 	sessionManager     *SessionManager
 	currentSession     *Session
 	fileService        *FileService
+	backupService      *BackupService
+	keyring            *database.Keyring // Unlocked keyring of an encrypted database
+	dbPath             string
+	fileDBPath         string
 }
 
 // NewApp creates a new App application struct
@@ -46,20 +51,17 @@ func (a *App) startup(ctx context.Context) {
 		log.Fatal("Failed to get user home directory:", err)
 	}
 
-	dbPath := filepath.Join(homeDir, "dijibill.db")
-	a.db, err = database.NewDatabase(dbPath)
-	if err != nil {
-		log.Fatal("Failed to initialize database:", err)
-	}
+	a.dbPath = filepath.Join(homeDir, "dijibill.db")
+	a.fileDBPath = filepath.Join(homeDir, "dijibill_files.db")
 
-	// Initialize HTML invoice service
-	a.htmlInvoiceService = NewHTMLInvoiceService(a.ctx, a.db, a.fileService)
+	// An encrypted database stays closed until the admin passphrase is entered
+	if database.IsEncrypted(a.dbPath) {
+		log.Println("Database is encrypted, waiting for the passphrase")
+		return
+	}
 
-	// Initialize file service
-	fileDBPath := filepath.Join(homeDir, "dijibill_files.db")
-	a.fileService, err = NewFileService(fileDBPath)
-	if err != nil {
-		log.Printf("Warning: Failed to initialize file service: %v", err)
+	if err := a.openDatabases(); err != nil {
+		log.Fatal("Failed to initialize database:", err)
 	}
 
 	// Create sample data for testing
@@ -70,6 +72,11 @@ func (a *App) startup(ctx context.Context) {
 	log.Println("Application started successfully")
 }
 
+// shutdown is called when the app is closing. Closing an encrypted database writes its last changes to disk.
+func (a *App) shutdown(ctx context.Context) {
+	a.closeDatabases()
+}
+
 // Customer Management Methods
 
 // getCurrentCompanyID returns the current company ID from the session
@@ -80,6 +87,15 @@ func (a *App) getCurrentCompanyID() int {
 	return 1 // Default to company 1 if no session (for backward compatibility)
 }
 
+// getCurrentUserID returns the ID of the signed in user, or nil when there is no session
+func (a *App) getCurrentUserID() *int {
+	if a.currentSession != nil {
+		userID := a.currentSession.UserID
+		return &userID
+	}
+	return nil
+}
+
 func (a *App) CreateCustomer(customer database.Customer) error {
 	customer.CompanyID = a.getCurrentCompanyID()
 	customer.CreatedAt = time.Now()
@@ -95,14 +111,18 @@ func (a *App) GetCustomerByID(id int) (*database.Customer, error) {
 	return a.db.GetCustomerByID(id)
 }
 
-func (a *App) UpdateCustomer(customer database.Customer) error {
+// UpdateCustomer returns the customer as saved, with its new version
+func (a *App) UpdateCustomer(customer database.Customer) (*database.Customer, error) {
 	customer.CompanyID = a.getCurrentCompanyID()
 	customer.UpdatedAt = time.Now()
-	return a.db.UpdateCustomer(&customer)
+	if err := a.db.UpdateCustomer(&customer); err != nil {
+		return nil, err
+	}
+	return a.db.GetCustomerByID(customer.ID)
 }
 
 func (a *App) DeleteCustomer(id int) error {
-	return a.db.DeleteCustomer(id)
+	return a.db.DeleteCustomer(id, a.getCurrentUserID())
 }
 
 // Supplier Management Methods
@@ -121,13 +141,17 @@ func (a *App) GetSupplierByID(id int) (*database.Supplier, error) {
 	return a.db.GetSupplierByID(id)
 }
 
-func (a *App) UpdateSupplier(supplier database.Supplier) error {
+// UpdateSupplier returns the supplier as saved, with its new version
+func (a *App) UpdateSupplier(supplier database.Supplier) (*database.Supplier, error) {
 	supplier.UpdatedAt = time.Now()
-	return a.db.UpdateSupplier(&supplier)
+	if err := a.db.UpdateSupplier(&supplier); err != nil {
+		return nil, err
+	}
+	return a.db.GetSupplierByID(supplier.ID)
 }
 
 func (a *App) DeleteSupplier(id int) error {
-	return a.db.DeleteSupplier(id)
+	return a.db.DeleteSupplier(id, a.getCurrentUserID())
 }
 
 // Product Category Management Methods
@@ -154,13 +178,17 @@ func (a *App) GetProducts() ([]database.Product, error) {
 	return a.db.GetProducts()
 }
 
-func (a *App) UpdateProduct(product database.Product) error {
+// UpdateProduct returns the product as saved, with its new version
+func (a *App) UpdateProduct(product database.Product) (*database.Product, error) {
 	product.UpdatedAt = time.Now()
-	return a.db.UpdateProduct(&product)
+	if err := a.db.UpdateProduct(&product); err != nil {
+		return nil, err
+	}
+	return a.db.GetProductByID(product.ID)
 }
 
 func (a *App) DeleteProduct(id int) error {
-	return a.db.DeleteProduct(id)
+	return a.db.DeleteProduct(id, a.getCurrentUserID())
 }
 
 // Sales Invoice Management Methods
@@ -182,6 +210,7 @@ func (a *App) CreateInvoice(invoice database.Invoice) error {
 	invoice.SubTotal = subTotal
 	invoice.VATAmount = vatAmount
 	invoice.TotalAmount = totalAmount
+	invoice.CompanyID = a.getCurrentCompanyID()
 	invoice.CreatedAt = time.Now()
 	invoice.UpdatedAt = time.Now()
 
@@ -220,6 +249,7 @@ func (a *App) CreateSalesInvoice(invoice database.SalesInvoice) (database.SalesI
 	invoice.SubTotal = subTotal
 	invoice.VATAmount = vatAmount
 	invoice.TotalAmount = totalAmount
+	invoice.CompanyID = a.getCurrentCompanyID()
 	invoice.CreatedAt = time.Now()
 	invoice.UpdatedAt = time.Now()
 
@@ -241,6 +271,41 @@ func (a *App) CreateSalesInvoice(invoice database.SalesInvoice) (database.SalesI
 	return invoice, nil
 }
 
+// Checkout saves a POS sale as a paid invoice together with its tenders (e.g. part cash, part card)
+// and returns the change due in cash. The sale is rejected when the tenders do not cover the total.
+func (a *App) Checkout(invoice database.SalesInvoice, tenders []database.CheckoutTender) (*database.CheckoutResult, error) {
+	// Calculate totals
+	var subTotal, vatAmount, totalAmount float64
+
+	for i := range invoice.Items {
+		item := &invoice.Items[i]
+		item.VATAmount = (item.UnitPrice * item.Quantity * item.VATRate) / 100
+		item.TotalAmount = (item.UnitPrice * item.Quantity) + item.VATAmount
+
+		subTotal += item.UnitPrice * item.Quantity
+		vatAmount += item.VATAmount
+		totalAmount += item.TotalAmount
+	}
+
+	invoice.SubTotal = subTotal
+	invoice.VATAmount = vatAmount
+	invoice.TotalAmount = totalAmount
+	invoice.CompanyID = a.getCurrentCompanyID()
+	invoice.CreatedAt = time.Now()
+	invoice.UpdatedAt = time.Now()
+	if invoice.IssueDate.IsZero() {
+		invoice.IssueDate = database.Date{Time: time.Now()}
+	}
+
+	// Set created_by and updated_by from current user session
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		invoice.CreatedBy = &user.ID
+		invoice.UpdatedBy = &user.ID
+	}
+
+	return a.db.Checkout(&invoice, tenders)
+}
+
 func (a *App) GetSalesInvoices() ([]database.SalesInvoice, error) {
 	return a.db.GetSalesInvoices()
 }
@@ -253,7 +318,8 @@ func (a *App) GetSalesInvoiceByID(id int) (*database.SalesInvoice, error) {
 	return a.db.GetSalesInvoiceByID(id)
 }
 
-func (a *App) UpdateSalesInvoice(invoice database.SalesInvoice) error {
+// UpdateSalesInvoice returns the invoice as saved, with its items and new version
+func (a *App) UpdateSalesInvoice(invoice database.SalesInvoice) (*database.SalesInvoice, error) {
 	// Calculate totals
 	var subTotal, vatAmount, totalAmount float64
 
@@ -277,21 +343,74 @@ func (a *App) UpdateSalesInvoice(invoice database.SalesInvoice) error {
 		invoice.UpdatedBy = &user.ID
 	}
 
-	return a.db.UpdateSalesInvoice(&invoice)
+	if err := a.db.UpdateSalesInvoice(&invoice); err != nil {
+		return nil, err
+	}
+	return a.db.GetSalesInvoiceByID(invoice.ID)
 }
 
 func (a *App) DeleteSalesInvoice(id int) error {
-	return a.db.DeleteSalesInvoice(id)
+	return a.db.DeleteSalesInvoice(id, a.getCurrentUserID())
 }
 
 // Dashboard Methods
 
 func (a *App) GetTodaysSales() (map[string]interface{}, error) {
-	return a.db.GetTodaysSales()
+	return a.db.GetTodaysSales(a.getCurrentCompanyID())
 }
 
+// GetTopSellingProducts returns the five best-selling products of all time
 func (a *App) GetTopSellingProducts() ([]map[string]interface{}, error) {
-	return a.db.GetTopSellingProducts()
+	return a.db.GetTopSellingProducts(a.getCurrentCompanyID(), time.Time{}, time.Time{}, 5)
+}
+
+// getSalesAnalyticsPeriod parses the range of a sales analysis; empty dates default to the last 30 days
+func getSalesAnalyticsPeriod(from, to string) (time.Time, time.Time, error) {
+	now := time.Now()
+	toDate, err := parseReportDate(to, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	fromDate, err := parseReportDate(from, toDate.AddDate(0, 0, -29))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return fromDate, toDate, nil
+}
+
+// GetSalesAnalytics returns the sales between two dates grouped by day, week or month with every breakdown,
+// each cut to the limit when it is positive
+func (a *App) GetSalesAnalytics(from, to, groupBy string, limit int) (*database.SalesAnalytics, error) {
+	fromDate, toDate, err := getSalesAnalyticsPeriod(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetSalesAnalytics(a.getCurrentCompanyID(), fromDate, toDate, groupBy, limit)
+}
+
+// GetSalesBreakdown returns the sales between two dates by product, product_category, customer, sales_category,
+// user or payment_type
+func (a *App) GetSalesBreakdown(from, to, dimension string, limit int) ([]database.SalesBreakdownRow, error) {
+	fromDate, toDate, err := getSalesAnalyticsPeriod(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetSalesBreakdown(a.getCurrentCompanyID(), fromDate, toDate, dimension, limit)
+}
+
+// GetGrossMargin returns the gross margin between two dates by product, product_category, invoice, day, week or month
+func (a *App) GetGrossMargin(from, to, dimension string) (*database.GrossMarginReport, error) {
+	fromDate, toDate, err := getSalesAnalyticsPeriod(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetGrossMargin(a.getCurrentCompanyID(), fromDate, toDate, dimension)
+}
+
+// RecalculateStockCosts costs every issued sales invoice again with the company's costing method, except
+// those dated in closed or locked fiscal periods, which it reports
+func (a *App) RecalculateStockCosts() (*database.StockCostRecalculation, error) {
+	return a.db.RecalculateStockCosts(a.getCurrentCompanyID())
 }
 
 // Purchase Invoice Management Methods
@@ -313,9 +432,17 @@ func (a *App) CreatePurchaseInvoice(invoice database.PurchaseInvoice) error {
 	invoice.SubTotal = subTotal
 	invoice.VATAmount = vatAmount
 	invoice.TotalAmount = totalAmount
+	invoice.CompanyID = a.getCurrentCompanyID()
 	invoice.CreatedAt = time.Now()
 	invoice.UpdatedAt = time.Now()
 
+	// Derive the due date from the supplier's payment terms when none was entered
+	if invoice.DueDate.IsZero() && invoice.SupplierID > 0 {
+		if supplier, err := a.db.GetSupplierByID(invoice.SupplierID); err == nil {
+			invoice.DueDate = database.Date{Time: invoice.IssueDate.AddDate(0, 0, database.PaymentTermsDays(supplier.PaymentTerms))}
+		}
+	}
+
 	// Set created_by and updated_by from current user session
 	if user, err := a.GetCurrentUser(); err == nil && user != nil {
 		invoice.CreatedBy = &user.ID
@@ -337,7 +464,8 @@ func (a *App) GetPurchaseInvoiceByID(id int) (*database.PurchaseInvoice, error)
 	return a.db.GetPurchaseInvoiceByID(id)
 }
 
-func (a *App) UpdatePurchaseInvoice(invoice database.PurchaseInvoice) error {
+// UpdatePurchaseInvoice returns the invoice as saved, with its items and new version
+func (a *App) UpdatePurchaseInvoice(invoice database.PurchaseInvoice) (*database.PurchaseInvoice, error) {
 	// Calculate totals
 	var subTotal, vatAmount, totalAmount float64
 
@@ -361,11 +489,14 @@ func (a *App) UpdatePurchaseInvoice(invoice database.PurchaseInvoice) error {
 		invoice.UpdatedBy = &user.ID
 	}
 
-	return a.db.UpdatePurchaseInvoice(&invoice)
+	if err := a.db.UpdatePurchaseInvoice(&invoice); err != nil {
+		return nil, err
+	}
+	return a.db.GetPurchaseInvoiceByID(invoice.ID)
 }
 
 func (a *App) DeletePurchaseInvoice(id int) error {
-	return a.db.DeletePurchaseInvoice(id)
+	return a.db.DeletePurchaseInvoice(id, a.getCurrentUserID())
 }
 
 // MarkPurchaseInvoiceReceived marks a purchase invoice as received and updates product inventory
@@ -376,8 +507,8 @@ func (a *App) MarkPurchaseInvoiceReceived(invoiceID int) error {
 		return fmt.Errorf("failed to get purchase invoice: %w", err)
 	}
 
-	// Check if invoice is already received
-	if invoice.Status == "received" {
+	// Check if invoice is already received (paid invoices have been received too)
+	if invoice.Status == "received" || invoice.Status == "partially_paid" || invoice.Status == "paid" {
 		return fmt.Errorf("invoice is already marked as received")
 	}
 
@@ -422,6 +553,89 @@ func (a *App) MarkPurchaseInvoiceReceived(invoiceID int) error {
 	return nil
 }
 
+// Supplier Payment Management Methods
+
+func (a *App) CreateSupplierPayment(payment database.SupplierPayment) (database.SupplierPayment, error) {
+	payment.CompanyID = a.getCurrentCompanyID()
+	payment.CreatedAt = time.Now()
+	payment.UpdatedAt = time.Now()
+	if payment.PaymentDate.IsZero() {
+		payment.PaymentDate = time.Now()
+	}
+
+	// Set created_by and updated_by from current user session
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		payment.CreatedBy = &user.ID
+		payment.UpdatedBy = &user.ID
+	}
+
+	err := a.db.CreateSupplierPayment(&payment)
+	if err != nil {
+		return database.SupplierPayment{}, err
+	}
+
+	return payment, nil
+}
+
+func (a *App) GetSupplierPayments() ([]database.SupplierPayment, error) {
+	return a.db.GetSupplierPaymentsByCompany(a.getCurrentCompanyID())
+}
+
+func (a *App) GetSupplierPaymentsBySupplierID(supplierID int) ([]database.SupplierPayment, error) {
+	return a.db.GetSupplierPaymentsBySupplierID(supplierID)
+}
+
+func (a *App) GetSupplierPaymentsByPurchaseInvoiceID(invoiceID int) ([]database.SupplierPayment, error) {
+	return a.db.GetSupplierPaymentsByPurchaseInvoiceID(invoiceID)
+}
+
+func (a *App) GetSupplierPaymentByID(id int) (*database.SupplierPayment, error) {
+	return a.db.GetSupplierPaymentByID(id)
+}
+
+func (a *App) CancelSupplierPayment(id int) error {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.CancelSupplierPayment(id, userID)
+}
+
+func (a *App) DeleteSupplierPayment(id int) error {
+	return a.db.DeleteSupplierPayment(id, a.getCurrentUserID())
+}
+
+// GetOpenPurchaseInvoices returns the unpaid purchase invoices of a supplier for allocating a payment
+func (a *App) GetOpenPurchaseInvoices(supplierID int) ([]database.PurchaseInvoice, error) {
+	return a.db.GetOpenPurchaseInvoices(supplierID)
+}
+
+// GetPayablesAging returns the payables aging of the current company as of a date (today when empty)
+func (a *App) GetPayablesAging(asOf string) (*database.PayablesAgingReport, error) {
+	asOfDate, err := parseReportDate(asOf, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetPayablesAging(a.getCurrentCompanyID(), asOfDate)
+}
+
+// GetPayablesForecast returns the supplier payments falling due between two dates.
+// An empty from date starts today and an empty to date looks 30 days ahead.
+func (a *App) GetPayablesForecast(from, to string) (*database.PayablesForecast, error) {
+	fromDate, err := parseReportDate(from, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := parseReportDate(to, fromDate.AddDate(0, 0, 30))
+	if err != nil {
+		return nil, err
+	}
+	if toDate.Before(fromDate) {
+		return nil, fmt.Errorf("forecast end date is before its start date")
+	}
+	return a.db.GetPayablesForecast(a.getCurrentCompanyID(), fromDate, toDate)
+}
+
 // Purchase Product Category Management Methods
 
 func (a *App) CreatePurchaseProductCategory(category database.PurchaseProductCategory) error {
@@ -526,13 +740,1175 @@ func (a *App) GetPaymentByID(id int) (database.Payment, error) {
 	return a.db.GetPaymentByID(id)
 }
 
-func (a *App) UpdatePayment(payment database.Payment) error {
-	payment.UpdatedAt = time.Now()
-	return a.db.UpdatePayment(payment)
+// UpdatePayment returns the payment as saved, with its new version
+func (a *App) UpdatePayment(payment database.Payment) (*database.Payment, error) {
+	payment.UpdatedAt = time.Now()
+	if err := a.db.UpdatePayment(&payment); err != nil {
+		return nil, err
+	}
+	saved, err := a.db.GetPaymentByID(payment.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (a *App) DeletePayment(id int) error {
+	return a.db.DeletePayment(id, a.getCurrentUserID())
+}
+
+// Credit Note Management Methods
+
+func (a *App) CreateCreditNote(note database.CreditNote) (database.CreditNote, error) {
+	// Calculate totals
+	var subTotal, vatAmount, totalAmount float64
+
+	for i := range note.Items {
+		item := &note.Items[i]
+		item.VATAmount = (item.UnitPrice * item.Quantity * item.VATRate) / 100
+		item.TotalAmount = (item.UnitPrice * item.Quantity) + item.VATAmount
+
+		subTotal += item.UnitPrice * item.Quantity
+		vatAmount += item.VATAmount
+		totalAmount += item.TotalAmount
+	}
+
+	note.SubTotal = subTotal
+	note.VATAmount = vatAmount
+	note.TotalAmount = totalAmount
+	note.CompanyID = a.getCurrentCompanyID()
+	note.CreatedAt = time.Now()
+	note.UpdatedAt = time.Now()
+
+	// Set created_by and updated_by from current user session
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		note.CreatedBy = &user.ID
+		note.UpdatedBy = &user.ID
+	}
+
+	if note.Status == "" {
+		note.Status = "issued"
+	}
+
+	err := a.db.CreateCreditNote(&note)
+	if err != nil {
+		return database.CreditNote{}, err
+	}
+
+	return note, nil
+}
+
+func (a *App) GetCreditNotes() ([]database.CreditNote, error) {
+	return a.db.GetCreditNotesByCompany(a.getCurrentCompanyID())
+}
+
+func (a *App) GetCreditNoteByID(id int) (*database.CreditNote, error) {
+	return a.db.GetCreditNoteByID(id)
+}
+
+func (a *App) GetCreditNotesByInvoiceID(invoiceID int) ([]database.CreditNote, error) {
+	return a.db.GetCreditNotesByInvoiceID(invoiceID)
+}
+
+func (a *App) CancelCreditNote(id int) error {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.CancelCreditNote(id, userID)
+}
+
+func (a *App) DeleteCreditNote(id int) error {
+	return a.db.DeleteCreditNote(id, a.getCurrentUserID())
+}
+
+// Customer Credit Management Methods
+
+// RecordCustomerAdvance receives a deposit from a customer and returns the prepayment invoice issued for it
+func (a *App) RecordCustomerAdvance(advance database.CustomerAdvance) (*database.SalesInvoice, error) {
+	advance.CompanyID = a.getCurrentCompanyID()
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		advance.CreatedBy = &user.ID
+	}
+	return a.db.RecordCustomerAdvance(&advance)
+}
+
+func (a *App) ApplyCustomerCredit(invoiceID int, amount float64) ([]database.CustomerCreditTransaction, error) {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.ApplyCustomerCredit(invoiceID, amount, userID)
+}
+
+func (a *App) RefundCustomerCredit(refund database.CustomerCreditTransaction) ([]database.CustomerCreditTransaction, error) {
+	refund.CompanyID = a.getCurrentCompanyID()
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		refund.CreatedBy = &user.ID
+	}
+	return a.db.RefundCustomerCredit(&refund)
+}
+
+func (a *App) GetCustomerCreditBalance(customerID int) (float64, error) {
+	return a.db.GetCustomerCreditBalance(customerID)
+}
+
+func (a *App) GetCustomerCreditTransactions(customerID int) ([]database.CustomerCreditTransaction, error) {
+	return a.db.GetCustomerCreditTransactions(customerID)
+}
+
+func (a *App) GetPrepaymentDeductions(invoiceID int) ([]database.PrepaymentDeduction, error) {
+	return a.db.GetPrepaymentDeductions(invoiceID)
+}
+
+// Bank Reconciliation Management Methods
+
+// ImportBankStatement lets the user pick a CSV, MT940 or camt.053 statement file, imports it and suggests matches
+func (a *App) ImportBankStatement() ([]database.BankStatement, error) {
+	options := runtime.OpenDialogOptions{
+		Title: "Select Bank Statement",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "Bank Statements (CSV, MT940, camt.053)",
+				Pattern:     "*.csv;*.txt;*.sta;*.mt940;*.940;*.xml;*.053",
+			},
+		},
+	}
+
+	filePath, err := runtime.OpenFileDialog(a.ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file dialog: %v", err)
+	}
+
+	if filePath == "" {
+		return nil, fmt.Errorf("no file selected")
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	return a.importBankStatement(filepath.Base(filePath), data)
+}
+
+// ImportBankStatementFromData imports a statement uploaded from the frontend as base64 data
+func (a *App) ImportBankStatementFromData(base64Data, filename string) ([]database.BankStatement, error) {
+	data, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 data: %v", err)
+	}
+	return a.importBankStatement(filename, data)
+}
+
+// importBankStatement saves every statement in a file for the current company and suggests matches for its lines
+func (a *App) importBankStatement(filename string, data []byte) ([]database.BankStatement, error) {
+	statements, err := ParseBankStatement(filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+
+	var imported []database.BankStatement
+	for i := range statements {
+		statement := &statements[i]
+		statement.CompanyID = a.getCurrentCompanyID()
+		statement.CreatedBy = userID
+
+		if err := a.db.CreateBankStatement(statement); err != nil {
+			return imported, err
+		}
+		if _, err := a.db.AutoMatchBankStatement(statement.ID, database.DefaultBankMatchWindowDays); err != nil {
+			log.Printf("Warning: Could not match bank statement %d: %v", statement.ID, err)
+		}
+
+		saved, err := a.db.GetBankStatementByID(statement.ID)
+		if err != nil {
+			return imported, err
+		}
+		imported = append(imported, *saved)
+	}
+
+	return imported, nil
+}
+
+func (a *App) GetBankStatements() ([]database.BankStatement, error) {
+	return a.db.GetBankStatementsByCompany(a.getCurrentCompanyID())
+}
+
+func (a *App) GetBankStatementByID(id int) (*database.BankStatement, error) {
+	return a.db.GetBankStatementByID(id)
+}
+
+func (a *App) DeleteBankStatement(id int) error {
+	return a.db.DeleteBankStatement(id)
+}
+
+// AutoMatchBankStatement recalculates the suggested matches of a statement; a window of 0 uses the default
+func (a *App) AutoMatchBankStatement(statementID, windowDays int) (int, error) {
+	return a.db.AutoMatchBankStatement(statementID, windowDays)
+}
+
+func (a *App) GetBankMatchCandidates(lineID, windowDays int) ([]database.BankMatchCandidate, error) {
+	return a.db.GetBankMatchCandidates(lineID, windowDays)
+}
+
+func (a *App) ConfirmBankMatch(lineID int, matchType string, targetID int) error {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.ConfirmBankMatch(lineID, matchType, targetID, userID)
+}
+
+func (a *App) ConfirmSuggestedBankMatches(statementID int) (int, error) {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.ConfirmSuggestedBankMatches(statementID, userID)
+}
+
+func (a *App) CreatePaymentFromBankLine(lineID int, payment database.BankLinePayment) error {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.CreatePaymentFromBankLine(lineID, payment, userID)
+}
+
+func (a *App) IgnoreBankLine(lineID int) error {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.IgnoreBankLine(lineID, userID)
+}
+
+func (a *App) UnreconcileBankLine(lineID int) error {
+	return a.db.UnreconcileBankLine(lineID)
+}
+
+// Customer Statement Methods
+
+// parseReportDate parses a YYYY-MM-DD date from the frontend, falling back to the given default when empty
+func parseReportDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	return date, nil
+}
+
+// GetCustomerLedger returns all documents of a customer up to a date (today when empty)
+func (a *App) GetCustomerLedger(customerID int, to string) ([]database.CustomerLedgerEntry, error) {
+	toDate, err := parseReportDate(to, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetCustomerLedger(customerID, toDate)
+}
+
+// GetCustomerStatement returns a statement of account for a date range. An empty from date
+// starts at the first document and an empty to date ends today.
+func (a *App) GetCustomerStatement(customerID int, from, to string) (*database.CustomerStatement, error) {
+	fromDate, err := parseReportDate(from, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := parseReportDate(to, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetCustomerStatement(customerID, fromDate, toDate)
+}
+
+// GetReceivablesAging returns the receivables aging of the current company as of a date (today when empty)
+func (a *App) GetReceivablesAging(asOf string) (*database.ReceivablesAgingReport, error) {
+	asOfDate, err := parseReportDate(asOf, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetReceivablesAging(a.getCurrentCompanyID(), asOfDate)
+}
+
+func (a *App) GenerateCustomerStatementHTML(customerID int, from, to string) (string, error) {
+	statement, err := a.GetCustomerStatement(customerID, from, to)
+	if err != nil {
+		return "", err
+	}
+	return a.htmlInvoiceService.GenerateStatementHTML(statement)
+}
+
+func (a *App) ViewCustomerStatementHTML(customerID int, from, to string) error {
+	htmlContent, err := a.GenerateCustomerStatementHTML(customerID, from, to)
+	if err != nil {
+		return err
+	}
+	return a.htmlInvoiceService.openHTMLInBrowser(htmlContent)
+}
+
+func (a *App) PrintCustomerStatementHTML(customerID int, from, to string) error {
+	htmlContent, err := a.GenerateCustomerStatementHTML(customerID, from, to)
+	if err != nil {
+		return err
+	}
+	return a.htmlInvoiceService.printStatementHTML(htmlContent, customerID)
+}
+
+// General Ledger Management Methods
+
+// GetAccounts returns the chart of accounts of the current company
+func (a *App) GetAccounts() ([]database.Account, error) {
+	return a.db.GetAccountsByCompany(a.getCurrentCompanyID())
+}
+
+func (a *App) CreateAccount(account database.Account) (*database.Account, error) {
+	account.CompanyID = a.getCurrentCompanyID()
+	if err := a.db.CreateAccount(&account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (a *App) UpdateAccount(account database.Account) error {
+	return a.db.UpdateAccount(&account)
+}
+
+func (a *App) DeleteAccount(id int) error {
+	return a.db.DeleteAccount(id)
+}
+
+func (a *App) GetAccountMappings() ([]database.AccountMapping, error) {
+	return a.db.GetAccountMappings(a.getCurrentCompanyID())
+}
+
+// SetAccountMapping sets the account used for a role of a sales category, tax rate, payment type, expense category or the company default
+func (a *App) SetAccountMapping(mapping database.AccountMapping) (*database.AccountMapping, error) {
+	mapping.CompanyID = a.getCurrentCompanyID()
+	if err := a.db.SetAccountMapping(&mapping); err != nil {
+		return nil, err
+	}
+	return &mapping, nil
+}
+
+func (a *App) DeleteAccountMapping(id int) error {
+	return a.db.DeleteAccountMapping(id)
+}
+
+// GetJournalEntries returns the journal entries of the current company for a date range. An empty from
+// date starts at the first entry and an empty to date ends today.
+func (a *App) GetJournalEntries(from, to string) ([]database.JournalEntry, error) {
+	fromDate, err := parseReportDate(from, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := parseReportDate(to, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetJournalEntries(a.getCurrentCompanyID(), fromDate, toDate)
+}
+
+func (a *App) GetJournalEntryByID(id int) (*database.JournalEntry, error) {
+	return a.db.GetJournalEntryByID(id)
+}
+
+// GetDocumentJournalEntries returns the entries posted for a document, e.g. ("sales_invoice", 12)
+func (a *App) GetDocumentJournalEntries(sourceType string, sourceID int) ([]database.JournalEntry, error) {
+	return a.db.GetJournalEntriesBySource(sourceType, sourceID)
+}
+
+func (a *App) CreateManualJournalEntry(entry database.JournalEntry) (*database.JournalEntry, error) {
+	entry.CompanyID = a.getCurrentCompanyID()
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		entry.CreatedBy = &user.ID
+	}
+	if err := a.db.CreateManualJournalEntry(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// ReverseJournalEntry reverses a manual journal entry on a date (the entry's own date when empty)
+func (a *App) ReverseJournalEntry(id int, date string) (*database.JournalEntry, error) {
+	reversalDate, err := parseReportDate(date, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.ReverseJournalEntry(id, reversalDate, userID)
+}
+
+// GetAccountLedger returns the postings to an account for a date range with running balances
+func (a *App) GetAccountLedger(accountID int, from, to string) (*database.AccountLedger, error) {
+	fromDate, err := parseReportDate(from, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := parseReportDate(to, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetAccountLedger(accountID, fromDate, toDate)
+}
+
+// SyncGeneralLedger posts documents of the current company recorded before the ledger existed
+func (a *App) SyncGeneralLedger() error {
+	return a.db.SyncGeneralLedger(a.getCurrentCompanyID())
+}
+
+// VAT Return Management Methods
+
+// getVATReturnPeriod parses the period of a VAT return; empty dates default to the current month
+func getVATReturnPeriod(from, to string) (time.Time, time.Time, error) {
+	now := time.Now()
+	fromDate, err := parseReportDate(from, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	toDate, err := parseReportDate(to, fromDate.AddDate(0, 1, -1))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return fromDate, toDate, nil
+}
+
+// GetVATReturn returns the ZATCA VAT return of the current company for a period, with the corrections
+// of earlier periods and the credit carried forward entered by the user
+func (a *App) GetVATReturn(from, to string, corrections, creditCarriedForward float64) (*database.VATReturn, error) {
+	fromDate, toDate, err := getVATReturnPeriod(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetVATReturn(a.getCurrentCompanyID(), fromDate, toDate, corrections, creditCarriedForward)
+}
+
+// GetVATReturnDocuments returns the documents behind a box of the VAT return; box 0 returns all documents
+func (a *App) GetVATReturnDocuments(from, to string, box int) ([]database.VATReturnDocument, error) {
+	fromDate, toDate, err := getVATReturnPeriod(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetVATReturnDocuments(a.getCurrentCompanyID(), fromDate, toDate, box)
+}
+
+// ExportVATReturnCSV saves the VAT return boxes and their documents as a CSV file chosen by the user.
+// It returns the saved path, or an empty path when the user cancels.
+func (a *App) ExportVATReturnCSV(from, to string, corrections, creditCarriedForward float64) (string, error) {
+	vatReturn, err := a.GetVATReturn(from, to, corrections, creditCarriedForward)
+	if err != nil {
+		return "", err
+	}
+	documents, err := a.db.GetVATReturnDocuments(vatReturn.CompanyID, vatReturn.From, vatReturn.To, 0)
+	if err != nil {
+		return "", err
+	}
+
+	filePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		DefaultFilename: fmt.Sprintf("VAT_Return_%s_%s.csv", vatReturn.From.Format("20060102"), vatReturn.To.Format("20060102")),
+		Title:           "Export VAT Return",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "CSV Files (*.csv)",
+				Pattern:     "*.csv",
+			},
+		},
+	})
+	if err != nil || filePath == "" {
+		return "", err
+	}
+
+	data, err := vatReturnCSV(vatReturn, documents)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write VAT return: %v", err)
+	}
+	return filePath, nil
+}
+
+// vatReturnCSV writes the boxes of a VAT return followed by its documents. The UTF-8 byte order mark
+// lets Excel show the Arabic labels.
+func vatReturnCSV(vatReturn *database.VATReturn, documents []database.VATReturnDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\uFEFF")
+	w := csv.NewWriter(&buf)
+	amount := func(value float64) string { return fmt.Sprintf("%.2f", value) }
+
+	w.Write([]string{"Box", "Description", "الوصف", "Amount", "Adjustment", "VAT Amount"})
+	for _, box := range append(append([]database.VATReturnBox{}, vatReturn.Sales...), vatReturn.Purchases...) {
+		w.Write([]string{fmt.Sprint(box.Box), box.Label, box.LabelArabic, amount(box.Amount), amount(box.Adjustment), amount(box.VATAmount)})
+	}
+	w.Write([]string{"13", "Total VAT due for current period", "إجمالي ضريبة القيمة المضافة المستحقة عن الفترة الحالية", "", "", amount(vatReturn.TotalVATDue)})
+	w.Write([]string{"14", "Corrections from previous period", "تصحيحات من الفترات السابقة", "", "", amount(vatReturn.Corrections)})
+	w.Write([]string{"15", "VAT credit carried forward from previous period", "ضريبة القيمة المضافة المرحلة من الفترة السابقة", "", "", amount(vatReturn.CreditCarriedForward)})
+	w.Write([]string{"16", "Net VAT due (or claimed)", "صافي الضريبة المستحقة", "", "", amount(vatReturn.NetVATDue)})
+
+	w.Write(nil)
+	w.Write([]string{"Box", "Document Type", "Document Number", "Date", "Party", "VAT Number", "Adjustment", "Amount", "VAT Amount"})
+	for _, document := range documents {
+		w.Write([]string{fmt.Sprint(document.Box), document.DocumentType, document.DocumentNumber, document.Date.Format("2006-01-02"),
+			document.PartyName, document.VATNumber, fmt.Sprint(document.IsAdjustment), amount(document.Amount), amount(document.VATAmount)})
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func (a *App) GenerateVATReturnHTML(from, to string, corrections, creditCarriedForward float64) (string, error) {
+	vatReturn, err := a.GetVATReturn(from, to, corrections, creditCarriedForward)
+	if err != nil {
+		return "", err
+	}
+	documents, err := a.db.GetVATReturnDocuments(vatReturn.CompanyID, vatReturn.From, vatReturn.To, 0)
+	if err != nil {
+		return "", err
+	}
+	return a.htmlInvoiceService.GenerateVATReturnHTML(vatReturn, documents)
+}
+
+func (a *App) ViewVATReturnHTML(from, to string, corrections, creditCarriedForward float64) error {
+	htmlContent, err := a.GenerateVATReturnHTML(from, to, corrections, creditCarriedForward)
+	if err != nil {
+		return err
+	}
+	return a.htmlInvoiceService.openHTMLInBrowser(htmlContent)
+}
+
+func (a *App) PrintVATReturnHTML(from, to string, corrections, creditCarriedForward float64) error {
+	htmlContent, err := a.GenerateVATReturnHTML(from, to, corrections, creditCarriedForward)
+	if err != nil {
+		return err
+	}
+	return a.htmlInvoiceService.printReportHTML(htmlContent, "Print VAT Return", fmt.Sprintf("VAT_Return_%s_print.html", time.Now().Format("20060102")))
+}
+
+// Branch Management Methods
+
+func (a *App) GetBranches() ([]database.Branch, error) {
+	return a.db.GetBranchesByCompany(a.getCurrentCompanyID())
+}
+
+func (a *App) CreateBranch(branch database.Branch) (*database.Branch, error) {
+	branch.CompanyID = a.getCurrentCompanyID()
+	if err := a.db.CreateBranch(&branch); err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+func (a *App) UpdateBranch(branch database.Branch) error {
+	return a.db.UpdateBranch(&branch)
+}
+
+func (a *App) DeleteBranch(id int) error {
+	return a.db.DeleteBranch(id)
+}
+
+// Financial Statement Methods
+
+// getFinancialStatementPeriod parses the period of a financial statement; empty dates default to the current
+// month and company 0 to the current company
+func (a *App) getFinancialStatementPeriod(companyID int, from, to string) (int, time.Time, time.Time, error) {
+	if companyID == 0 {
+		companyID = a.getCurrentCompanyID()
+	}
+	now := time.Now()
+	fromDate, err := parseReportDate(from, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return 0, time.Time{}, time.Time{}, err
+	}
+	toDate, err := parseReportDate(to, fromDate.AddDate(0, 1, -1))
+	if err != nil {
+		return 0, time.Time{}, time.Time{}, err
+	}
+	return companyID, fromDate, toDate, nil
+}
+
+// GetTrialBalance returns the trial balance of a company for a period. A branch of 0 covers all branches and the
+// comparison is previous_period, previous_year, both or empty.
+func (a *App) GetTrialBalance(companyID, branchID int, from, to, comparison string) (*database.TrialBalance, error) {
+	companyID, fromDate, toDate, err := a.getFinancialStatementPeriod(companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetTrialBalance(companyID, branchID, fromDate, toDate, comparison)
+}
+
+// GetProfitAndLoss returns the income statement of a company for a period
+func (a *App) GetProfitAndLoss(companyID, branchID int, from, to, comparison string) (*database.FinancialStatement, error) {
+	companyID, fromDate, toDate, err := a.getFinancialStatementPeriod(companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetProfitAndLoss(companyID, branchID, fromDate, toDate, comparison)
+}
+
+// GetBalanceSheet returns the balance sheet of a company at the end of a period
+func (a *App) GetBalanceSheet(companyID, branchID int, from, to, comparison string) (*database.FinancialStatement, error) {
+	companyID, fromDate, toDate, err := a.getFinancialStatementPeriod(companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetBalanceSheet(companyID, branchID, fromDate, toDate, comparison)
+}
+
+// GenerateFinancialStatementHTML renders a trial_balance, profit_and_loss or balance_sheet report
+func (a *App) GenerateFinancialStatementHTML(statementType string, companyID, branchID int, from, to, comparison string) (string, error) {
+	companyID, fromDate, toDate, err := a.getFinancialStatementPeriod(companyID, from, to)
+	if err != nil {
+		return "", err
+	}
+
+	var data FinancialStatementData
+	switch statementType {
+	case "trial_balance":
+		trialBalance, err := a.db.GetTrialBalance(companyID, branchID, fromDate, toDate, comparison)
+		if err != nil {
+			return "", err
+		}
+		data = FinancialStatementData{Title: "Trial Balance", TitleArabic: "ميزان المراجعة", Columns: trialBalance.Columns, TrialBalance: trialBalance}
+	case "profit_and_loss":
+		statement, err := a.db.GetProfitAndLoss(companyID, branchID, fromDate, toDate, comparison)
+		if err != nil {
+			return "", err
+		}
+		data = FinancialStatementData{Title: "Income Statement", TitleArabic: "قائمة الدخل", Columns: statement.Columns, Statement: statement}
+	case "balance_sheet":
+		statement, err := a.db.GetBalanceSheet(companyID, branchID, fromDate, toDate, comparison)
+		if err != nil {
+			return "", err
+		}
+		data = FinancialStatementData{Title: "Balance Sheet", TitleArabic: "قائمة المركز المالي", Columns: statement.Columns, Statement: statement}
+	default:
+		return "", fmt.Errorf("unknown financial statement %q", statementType)
+	}
+
+	return a.htmlInvoiceService.GenerateFinancialStatementHTML(data, companyID, branchID)
+}
+
+func (a *App) ViewFinancialStatementHTML(statementType string, companyID, branchID int, from, to, comparison string) error {
+	htmlContent, err := a.GenerateFinancialStatementHTML(statementType, companyID, branchID, from, to, comparison)
+	if err != nil {
+		return err
+	}
+	return a.htmlInvoiceService.openHTMLInBrowser(htmlContent)
+}
+
+func (a *App) PrintFinancialStatementHTML(statementType string, companyID, branchID int, from, to, comparison string) error {
+	htmlContent, err := a.GenerateFinancialStatementHTML(statementType, companyID, branchID, from, to, comparison)
+	if err != nil {
+		return err
+	}
+	return a.htmlInvoiceService.printReportHTML(htmlContent, "Print Financial Statement",
+		fmt.Sprintf("%s_%s_print.html", statementType, time.Now().Format("20060102")))
+}
+
+// Fiscal Period Management Methods
+
+func (a *App) GetFiscalYears() ([]database.FiscalYear, error) {
+	return a.db.GetFiscalYearsByCompany(a.getCurrentCompanyID())
+}
+
+// CreateFiscalYear creates a fiscal year with monthly periods; an empty end date makes it twelve months long
+func (a *App) CreateFiscalYear(name, startDate, endDate string) (*database.FiscalYear, error) {
+	start, err := parseReportDate(startDate, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseReportDate(endDate, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	year := database.FiscalYear{CompanyID: a.getCurrentCompanyID(), Name: name, StartDate: start, EndDate: end}
+	if err := a.db.CreateFiscalYear(&year); err != nil {
+		return nil, err
+	}
+	return &year, nil
+}
+
+func (a *App) DeleteFiscalYear(id int) error {
+	return a.db.DeleteFiscalYear(id)
+}
+
+// SetFiscalPeriodStatus opens, closes or locks a fiscal period
+func (a *App) SetFiscalPeriodStatus(periodID int, status string) error {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.SetFiscalPeriodStatus(periodID, status, userID)
+}
+
+// LockFiscalPeriodsThrough locks every fiscal period ending on or before a date and returns how many were locked
+func (a *App) LockFiscalPeriodsThrough(date string) (int, error) {
+	lockDate, err := parseReportDate(date, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	if lockDate.IsZero() {
+		return 0, fmt.Errorf("a lock date is required")
+	}
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.LockFiscalPeriodsThrough(a.getCurrentCompanyID(), lockDate, userID)
+}
+
+// CloseFiscalYear closes a fiscal year into retained earnings and opens the next one
+func (a *App) CloseFiscalYear(id int) (*database.FiscalYear, error) {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.CloseFiscalYear(id, userID)
+}
+
+func (a *App) ReopenFiscalYear(id int) error {
+	var userID *int
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		userID = &user.ID
+	}
+	return a.db.ReopenFiscalYear(id, userID)
+}
+
+// Expense Management Methods
+
+func (a *App) GetExpenseCategories() ([]database.ExpenseCategory, error) {
+	return a.db.GetExpenseCategoriesByCompany(a.getCurrentCompanyID())
+}
+
+func (a *App) CreateExpenseCategory(category database.ExpenseCategory) (*database.ExpenseCategory, error) {
+	category.CompanyID = a.getCurrentCompanyID()
+	if err := a.db.CreateExpenseCategory(&category); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (a *App) UpdateExpenseCategory(category database.ExpenseCategory) error {
+	return a.db.UpdateExpenseCategory(&category)
+}
+
+func (a *App) DeleteExpenseCategory(id int) error {
+	return a.db.DeleteExpenseCategory(id)
+}
+
+// GetExpenses returns the expenses between two dates; empty dates leave the range open
+func (a *App) GetExpenses(from, to string) ([]database.Expense, error) {
+	fromDate, err := parseReportDate(from, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	toDate, err := parseReportDate(to, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetExpensesByCompany(a.getCurrentCompanyID(), fromDate, toDate)
+}
+
+func (a *App) GetExpenseByID(id int) (*database.Expense, error) {
+	return a.db.GetExpenseByID(id)
+}
+
+func (a *App) CreateExpense(expense database.Expense) (*database.Expense, error) {
+	expense.CompanyID = a.getCurrentCompanyID()
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		expense.CreatedBy = &user.ID
+		expense.UpdatedBy = &user.ID
+	}
+	if err := a.db.CreateExpense(&expense); err != nil {
+		return nil, err
+	}
+	return &expense, nil
+}
+
+// UpdateExpense returns the expense as saved, with its new version
+func (a *App) UpdateExpense(expense database.Expense) (*database.Expense, error) {
+	expense.CompanyID = a.getCurrentCompanyID()
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		expense.UpdatedBy = &user.ID
+	}
+	if err := a.db.UpdateExpense(&expense); err != nil {
+		return nil, err
+	}
+	return a.db.GetExpenseByID(expense.ID)
+}
+
+// DeleteExpense moves an expense to the recycle bin. Its receipts stay attached in case it is restored.
+func (a *App) DeleteExpense(id int) error {
+	return a.db.DeleteExpense(id, a.getCurrentUserID())
+}
+
+// AttachExpenseReceipt asks for a receipt file and attaches it to an expense, returning the file ID
+func (a *App) AttachExpenseReceipt(expenseID int) (string, error) {
+	if _, err := a.db.GetExpenseByID(expenseID); err != nil {
+		return "", err
+	}
+	return a.UploadFile("expense_receipts", "expense", expenseID)
+}
+
+func (a *App) GetExpenseReceipts(expenseID int) ([]FileMetadata, error) {
+	return a.GetFilesByEntity("expense", expenseID)
+}
+
+func (a *App) GetRecurringExpenses() ([]database.RecurringExpense, error) {
+	return a.db.GetRecurringExpensesByCompany(a.getCurrentCompanyID())
+}
+
+func (a *App) CreateRecurringExpense(recurring database.RecurringExpense) (*database.RecurringExpense, error) {
+	recurring.CompanyID = a.getCurrentCompanyID()
+	if user, err := a.GetCurrentUser(); err == nil && user != nil {
+		recurring.CreatedBy = &user.ID
+	}
+	if err := a.db.CreateRecurringExpense(&recurring); err != nil {
+		return nil, err
+	}
+	return &recurring, nil
+}
+
+func (a *App) UpdateRecurringExpense(recurring database.RecurringExpense) error {
+	recurring.CompanyID = a.getCurrentCompanyID()
+	return a.db.UpdateRecurringExpense(&recurring)
+}
+
+func (a *App) DeleteRecurringExpense(id int) error {
+	return a.db.DeleteRecurringExpense(id)
+}
+
+// GenerateRecurringExpenses records the recurring expenses of the current company that are due by today
+func (a *App) GenerateRecurringExpenses() ([]database.Expense, error) {
+	return a.db.GenerateRecurringExpenses(a.getCurrentCompanyID(), time.Now())
+}
+
+// Export Methods
+
+// exportRecordTypes are the lists and reports that can be exported, by the name GetExportColumns takes
+var exportRecordTypes = map[string]interface{}{
+	"customers":         database.Customer{},
+	"suppliers":         database.Supplier{},
+	"products":          database.Product{},
+	"sales_invoices":    database.SalesInvoice{},
+	"purchase_invoices": database.PurchaseInvoice{},
+	"payments":          database.Payment{},
+	"supplier_payments": database.SupplierPayment{},
+	"credit_notes":      database.CreditNote{},
+	"expenses":          database.Expense{},
+	"accounts":          database.Account{},
+	"trial_balance":     database.TrialBalanceLine{},
+	"profit_and_loss":   database.FinancialStatementLine{},
+	"balance_sheet":     database.FinancialStatementLine{},
+	"account_ledger":    database.AccountLedgerEntry{},
+	"receivables_aging": database.ReceivableAgingRow{},
+	"payables_aging":    database.PayableAgingRow{},
+	"vat_return":        database.VATReturnDocument{},
+	"sales_analytics":   database.SalesAnalyticsPeriod{},
+	"sales_breakdown":   database.SalesBreakdownRow{},
+	"gross_margin":      database.MarginRow{},
+	"audit_log":         database.AuditLogEntry{},
+}
+
+// GetExportColumns returns the columns a list or report can be exported with, e.g. customers or trial_balance
+func (a *App) GetExportColumns(name string) ([]ExportColumn, error) {
+	record, ok := exportRecordTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown export %q", name)
+	}
+	return ExportColumnsOf(record), nil
+}
+
+// saveExport writes a table as CSV or XLSX to a file chosen by the user.
+// It returns the saved path, or an empty path when the user cancels.
+func (a *App) saveExport(table *ExportTable, format string) (string, error) {
+	filter := runtime.FileFilter{DisplayName: "CSV Files (*.csv)", Pattern: "*.csv"}
+	switch format {
+	case ExportFormatCSV:
+	case ExportFormatXLSX:
+		filter = runtime.FileFilter{DisplayName: "Excel Workbooks (*.xlsx)", Pattern: "*.xlsx"}
+	default:
+		return "", fmt.Errorf("unsupported export format %q", format)
+	}
+
+	filePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		DefaultFilename: fmt.Sprintf("%s_%s.%s", strings.ReplaceAll(table.Title, " ", "_"), time.Now().Format("20060102"), format),
+		Title:           "Export " + table.Title,
+		Filters:         []runtime.FileFilter{filter},
+	})
+	if err != nil || filePath == "" {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := table.Write(&buf, format); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("failed to write export: %v", err)
+	}
+	return filePath, nil
+}
+
+// exportRecords saves a list of records with the chosen columns, or the default columns when none are chosen
+func (a *App) exportRecords(title, format string, columns []string, records interface{}) (string, error) {
+	table, err := NewExportTable(title, records, columns)
+	if err != nil {
+		return "", err
+	}
+	return a.saveExport(table, format)
+}
+
+// financialColumnHeaders names the columns of amounts after the periods of a financial report
+func financialColumnHeaders(key string, columns []database.FinancialReportColumn, offset int) map[string]string {
+	headers := make(map[string]string)
+	for i := offset; i < len(columns); i++ {
+		headers[fmt.Sprintf("%s_%d", key, i-offset+1)] = columns[i].Label
+	}
+	return headers
+}
+
+func (a *App) ExportCustomers(format string, columns []string) (string, error) {
+	customers, err := a.GetCustomers()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Customers", format, columns, customers)
+}
+
+func (a *App) ExportSuppliers(format string, columns []string) (string, error) {
+	suppliers, err := a.GetSuppliers()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Suppliers", format, columns, suppliers)
+}
+
+func (a *App) ExportProducts(format string, columns []string) (string, error) {
+	products, err := a.GetProducts()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Products", format, columns, products)
+}
+
+func (a *App) ExportSalesInvoices(format string, columns []string) (string, error) {
+	invoices, err := a.GetSalesInvoices()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Sales Invoices", format, columns, invoices)
+}
+
+func (a *App) ExportPurchaseInvoices(format string, columns []string) (string, error) {
+	invoices, err := a.GetPurchaseInvoices()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Purchase Invoices", format, columns, invoices)
+}
+
+func (a *App) ExportPayments(format string, columns []string) (string, error) {
+	payments, err := a.GetPayments()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Payments", format, columns, payments)
+}
+
+func (a *App) ExportSupplierPayments(format string, columns []string) (string, error) {
+	payments, err := a.GetSupplierPayments()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Supplier Payments", format, columns, payments)
+}
+
+func (a *App) ExportCreditNotes(format string, columns []string) (string, error) {
+	creditNotes, err := a.GetCreditNotes()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Credit Notes", format, columns, creditNotes)
+}
+
+func (a *App) ExportExpenses(from, to, format string, columns []string) (string, error) {
+	expenses, err := a.GetExpenses(from, to)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Expenses", format, columns, expenses)
+}
+
+func (a *App) ExportAccounts(format string, columns []string) (string, error) {
+	accounts, err := a.GetAccounts()
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Chart of Accounts", format, columns, accounts)
+}
+
+// ExportTrialBalance saves the trial balance lines; the closing balance of each comparison is named after its period
+func (a *App) ExportTrialBalance(companyID, branchID int, from, to, comparison, format string, columns []string) (string, error) {
+	trialBalance, err := a.GetTrialBalance(companyID, branchID, from, to, comparison)
+	if err != nil {
+		return "", err
+	}
+	table, err := NewExportTable("Trial Balance", trialBalance.Lines, columns)
+	if err != nil {
+		return "", err
+	}
+	table.SetHeaders(financialColumnHeaders("comparisons", trialBalance.Columns, 1))
+	return a.saveExport(table, format)
+}
+
+func (a *App) ExportProfitAndLoss(companyID, branchID int, from, to, comparison, format string, columns []string) (string, error) {
+	statement, err := a.GetProfitAndLoss(companyID, branchID, from, to, comparison)
+	if err != nil {
+		return "", err
+	}
+	table, err := NewExportTable("Income Statement", statement.Lines, columns)
+	if err != nil {
+		return "", err
+	}
+	table.SetHeaders(financialColumnHeaders("amounts", statement.Columns, 0))
+	return a.saveExport(table, format)
+}
+
+func (a *App) ExportBalanceSheet(companyID, branchID int, from, to, comparison, format string, columns []string) (string, error) {
+	statement, err := a.GetBalanceSheet(companyID, branchID, from, to, comparison)
+	if err != nil {
+		return "", err
+	}
+	table, err := NewExportTable("Balance Sheet", statement.Lines, columns)
+	if err != nil {
+		return "", err
+	}
+	table.SetHeaders(financialColumnHeaders("amounts", statement.Columns, 0))
+	return a.saveExport(table, format)
+}
+
+func (a *App) ExportAccountLedger(accountID int, from, to, format string, columns []string) (string, error) {
+	ledger, err := a.GetAccountLedger(accountID, from, to)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Ledger "+ledger.Account.Code, format, columns, ledger.Entries)
+}
+
+func (a *App) ExportReceivablesAging(asOf, format string, columns []string) (string, error) {
+	report, err := a.GetReceivablesAging(asOf)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Receivables Aging", format, columns, report.Rows)
+}
+
+func (a *App) ExportPayablesAging(asOf, format string, columns []string) (string, error) {
+	report, err := a.GetPayablesAging(asOf)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Payables Aging", format, columns, report.Rows)
+}
+
+// ExportVATReturnDocuments saves the documents behind a box of the VAT return; box 0 saves all documents
+func (a *App) ExportVATReturnDocuments(from, to string, box int, format string, columns []string) (string, error) {
+	documents, err := a.GetVATReturnDocuments(from, to, box)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("VAT Return Documents", format, columns, documents)
+}
+
+// ExportSalesAnalytics saves the sales between two dates by day, week or month
+func (a *App) ExportSalesAnalytics(from, to, groupBy, format string, columns []string) (string, error) {
+	analytics, err := a.GetSalesAnalytics(from, to, groupBy, 0)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Sales Analytics", format, columns, analytics.Periods)
+}
+
+func (a *App) ExportSalesBreakdown(from, to, dimension, format string, columns []string) (string, error) {
+	rows, err := a.GetSalesBreakdown(from, to, dimension, 0)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Sales by "+exportHeader(dimension), format, columns, rows)
+}
+
+func (a *App) ExportGrossMargin(from, to, dimension, format string, columns []string) (string, error) {
+	report, err := a.GetGrossMargin(from, to, dimension)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Gross Margin by "+exportHeader(dimension), format, columns, append(report.Rows, report.Totals))
+}
+
+// Import Methods
+
+// GetImportFields returns the fields the columns of a products, customers or suppliers import can be mapped to
+func (a *App) GetImportFields(entity string) ([]database.ImportField, error) {
+	return database.GetImportFields(entity)
+}
+
+// SelectImportFile lets the user pick a CSV or XLSX file to import products, customers or suppliers from and
+// returns its headers, first rows and a suggested column mapping
+func (a *App) SelectImportFile(entity string) (*ImportPreview, error) {
+	options := runtime.OpenDialogOptions{
+		Title: "Select File to Import",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "Spreadsheets (*.csv, *.xlsx)",
+				Pattern:     "*.csv;*.txt;*.xlsx",
+			},
+		},
+	}
+
+	filePath, err := runtime.OpenFileDialog(a.ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file dialog: %v", err)
+	}
+
+	if filePath == "" {
+		return nil, fmt.Errorf("no file selected")
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	return readImportPreview(entity, filePath, data)
 }
 
-func (a *App) DeletePayment(id int) error {
-	return a.db.DeletePayment(id)
+// ImportFile imports the rows of a file with columns mapped to fields by header. A dry run validates every
+// row and reports what would be inserted and updated without saving anything.
+func (a *App) ImportFile(entity, filePath string, mapping map[string]string, dryRun bool) (*database.ImportResult, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	headers, lines, err := ReadImportFile(filePath, data)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := mapImportRows(headers, lines, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.ImportRecords(a.getCurrentCompanyID(), entity, rows, dryRun)
 }
 
 // Sales Category Management Methods
@@ -1410,6 +2786,556 @@ func (a *App) UpdateLastBackupTime(backupTime time.Time) error {
 	return a.db.UpdateLastBackupTime(backupTime)
 }
 
+// Backup Methods
+
+// BackupNow takes a backup of the application and file databases
+func (a *App) BackupNow() (*BackupInfo, error) {
+	return a.backupService.Backup()
+}
+
+// GetBackupDirectory returns the directory backups are written to
+func (a *App) GetBackupDirectory() (string, error) {
+	settings, err := a.db.GetSystemSettings()
+	if err != nil {
+		return "", err
+	}
+	return a.backupService.Directory(settings), nil
+}
+
+// GetBackups returns the backups in the backup directory, newest first
+func (a *App) GetBackups() ([]BackupInfo, error) {
+	dir, err := a.GetBackupDirectory()
+	if err != nil {
+		return nil, err
+	}
+	return ListBackups(dir)
+}
+
+// SelectBackupDirectory opens a dialog to choose the backup directory
+func (a *App) SelectBackupDirectory() (string, error) {
+	return runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:                "Select Backup Directory",
+		CanCreateDirectories: true,
+	})
+}
+
+// UpdateBackupSettings sets the backup directory, empty for the default, and the number of backups kept
+func (a *App) UpdateBackupSettings(directory string, retention int) error {
+	return a.db.UpdateBackupSettings(strings.TrimSpace(directory), retention)
+}
+
+// SelectBackupFile opens a dialog to choose a database file to restore
+func (a *App) SelectBackupFile() (string, error) {
+	dir, err := a.GetBackupDirectory()
+	if err != nil {
+		return "", err
+	}
+	filePath, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:            "Select Backup",
+		DefaultDirectory: dir,
+		Filters: []runtime.FileFilter{
+			{DisplayName: "DijiBill Database (*.db, *.db.enc)", Pattern: "*.db;*.db.enc"},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to open file dialog: %v", err)
+	}
+	if filePath == "" {
+		return "", fmt.Errorf("no file selected")
+	}
+	return filePath, nil
+}
+
+// InspectBackup validates a backup, either a backup directory or a database file, and summarises what it holds.
+// Encrypted backups are read with the keyring of the open database.
+func (a *App) InspectBackup(path string) (*database.BackupSummary, error) {
+	return a.inspectBackup(path, a.keyring)
+}
+
+// InspectBackupWithPassphrase inspects an encrypted backup with the keyring kept in it, for backups taken
+// under a passphrase or keyring other than the current one
+func (a *App) InspectBackupWithPassphrase(path, passphrase string) (*database.BackupSummary, error) {
+	keyring, err := database.UnlockKeyring(backupKeyringPath(path), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return a.inspectBackup(path, keyring)
+}
+
+func (a *App) inspectBackup(path string, keyring *database.Keyring) (*database.BackupSummary, error) {
+	mainFile, _, err := backupSource(path)
+	if err != nil {
+		return nil, err
+	}
+	return inspectBackupFile(mainFile, keyring)
+}
+
+// RestoreBackup replaces the databases with a backup while the app keeps running. The backup is validated
+// and copied alongside the databases first; the swap then only renames files, and the replaced databases are
+// kept as pre-restore copies. If the restored database cannot be opened the previous databases are put back.
+// The restored database may have other users, so the current session is ended. When the database is
+// encrypted the restored copy is sealed with its current key.
+func (a *App) RestoreBackup(path string) (*RestoreResult, error) {
+	return a.restoreBackup(path, a.keyring)
+}
+
+// RestoreBackupWithPassphrase restores an encrypted backup, decrypting it with the keyring kept in it
+func (a *App) RestoreBackupWithPassphrase(path, passphrase string) (*RestoreResult, error) {
+	keyring, err := database.UnlockKeyring(backupKeyringPath(path), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return a.restoreBackup(path, keyring)
+}
+
+func (a *App) restoreBackup(path string, keyring *database.Keyring) (*RestoreResult, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("the database is locked")
+	}
+	summary, err := a.inspectBackup(path, keyring)
+	if err != nil {
+		return nil, err
+	}
+	mainFile, filesFile, err := backupSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []restoreSource{{file: mainFile, target: a.dbPath}}
+	if a.keyring != nil {
+		sources[0] = restoreSource{file: mainFile, target: database.EncryptedPath(a.dbPath), seal: a.keyring}
+	}
+	if filesFile != "" {
+		sources = append(sources, restoreSource{file: filesFile, target: a.fileDBPath})
+	}
+	plan, err := stageRestore(sources, keyring)
+	if err != nil {
+		return nil, err
+	}
+	defer plan.cleanup()
+
+	a.closeDatabases()
+	if err := plan.swap(time.Now().Format(backupTimeLayout)); err != nil {
+		if reopenErr := a.openDatabases(); reopenErr != nil {
+			return nil, fmt.Errorf("%v; the previous database could not be reopened: %v", err, reopenErr)
+		}
+		return nil, err
+	}
+
+	if err := a.openDatabases(); err != nil {
+		a.closeDatabases()
+		if rollbackErr := plan.rollback(); rollbackErr != nil {
+			return nil, fmt.Errorf("the restored database could not be opened: %v; %v", err, rollbackErr)
+		}
+		if reopenErr := a.openDatabases(); reopenErr != nil {
+			return nil, fmt.Errorf("the restored database could not be opened: %v; the previous database could not be reopened: %v", err, reopenErr)
+		}
+		return nil, fmt.Errorf("the restored database could not be opened, the previous database is back in use: %v", err)
+	}
+
+	a.Logout()
+	log.Printf("Restored backup %s", path)
+	return &RestoreResult{
+		Summary:        summary,
+		FilesRestored:  filesFile != "",
+		PreRestoreCopy: plan.keptCopies(),
+		RestoredAt:     time.Now(),
+	}, nil
+}
+
+// closeDatabases stops the backup scheduler and closes both databases
+func (a *App) closeDatabases() {
+	if a.backupService != nil {
+		a.backupService.Stop()
+		a.backupService = nil
+	}
+	if a.fileService != nil {
+		a.fileService.Close()
+		a.fileService = nil
+	}
+	if a.db != nil {
+		if err := a.db.Close(); err != nil {
+			log.Printf("Warning: Could not close the database: %v", err)
+		}
+		a.db = nil
+	}
+}
+
+// openDatabases opens both databases and recreates the services that use them. The application database is
+// opened from its sealed file when a keyring is unlocked.
+func (a *App) openDatabases() error {
+	var db *database.Database
+	var err error
+	if a.keyring != nil {
+		db, err = database.OpenEncryptedDatabase(database.EncryptedPath(a.dbPath), a.keyring)
+	} else {
+		db, err = database.NewDatabase(a.dbPath)
+	}
+	if err != nil {
+		return err
+	}
+	fileService, err := NewFileService(a.fileDBPath)
+	if err != nil {
+		log.Printf("Warning: Failed to initialize file service: %v", err)
+		fileService = nil
+	}
+
+	a.db = db
+	a.attributeChanges()
+	a.fileService = fileService
+	a.htmlInvoiceService = NewHTMLInvoiceService(a.ctx, a.db, a.fileService)
+	a.backupService = NewBackupService(a.db, a.fileService, filepath.Join(filepath.Dir(a.dbPath), "dijibill_backups"), a.keyring)
+	a.backupService.Start()
+	return nil
+}
+
+// Encryption Methods
+
+// GetEncryptionStatus reports whether the database is encrypted at rest and, once unlocked, its keys
+func (a *App) GetEncryptionStatus() database.EncryptionStatus {
+	if a.keyring != nil && a.db != nil {
+		return a.keyring.Status()
+	}
+	return database.EncryptionStatus{Enabled: database.IsEncrypted(a.dbPath)}
+}
+
+// UnlockDatabase opens an encrypted database with the admin passphrase
+func (a *App) UnlockDatabase(passphrase string) error {
+	if a.db != nil {
+		return fmt.Errorf("the database is already open")
+	}
+	keyring, err := database.UnlockKeyring(database.KeyringPath(a.dbPath), passphrase)
+	if err != nil {
+		return err
+	}
+	if err := recoverEncryptedDatabase(a.dbPath, keyring); err != nil {
+		return err
+	}
+
+	a.keyring = keyring
+	if err := a.openDatabases(); err != nil {
+		a.keyring = nil
+		return err
+	}
+	if err := a.CreateSampleData(); err != nil {
+		log.Printf("Warning: Could not create sample data: %v", err)
+	}
+	log.Println("Encrypted database unlocked")
+	return nil
+}
+
+// recoverEncryptedDatabase finishes switching encryption on or off when the switch was interrupted. The sealed
+// file is the database once it exists, so a plaintext file left beside it is removed; without it the plaintext
+// file is sealed.
+func recoverEncryptedDatabase(dbPath string, keyring *database.Keyring) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(database.EncryptedPath(dbPath)); err == nil {
+		log.Printf("Removing the plaintext database left beside the encrypted one")
+		return database.RemovePlaintextDatabase(dbPath)
+	}
+	log.Printf("Encrypting the plaintext database left by an interrupted switch")
+	return database.EncryptDatabaseFile(dbPath, keyring)
+}
+
+// EnableEncryption encrypts the database at rest with a key derived from passphrase. Only an admin can turn
+// encryption on; the passphrase is then needed every time the application starts and to read its backups.
+func (a *App) EnableEncryption(passphrase string) error {
+	if err := a.requireAdmin("change database encryption"); err != nil {
+		return err
+	}
+	if a.keyring != nil {
+		return fmt.Errorf("the database is already encrypted")
+	}
+
+	a.closeDatabases()
+	keyring, err := database.CreateKeyring(database.KeyringPath(a.dbPath), passphrase)
+	if err == nil {
+		if err = database.EncryptDatabaseFile(a.dbPath, keyring); err != nil {
+			os.Remove(database.EncryptedPath(a.dbPath))
+			os.Remove(keyring.Path())
+		}
+	}
+	if err != nil {
+		if reopenErr := a.openDatabases(); reopenErr != nil {
+			return fmt.Errorf("%v; the database could not be reopened: %v", err, reopenErr)
+		}
+		return err
+	}
+
+	a.keyring = keyring
+	if err := a.openDatabases(); err != nil {
+		return fmt.Errorf("the database was encrypted but could not be reopened: %v", err)
+	}
+	log.Println("Database encryption enabled")
+	return nil
+}
+
+// DisableEncryption stores the database in plaintext again. Backups taken while it was encrypted stay
+// encrypted and keep their keyring copy.
+func (a *App) DisableEncryption(passphrase string) error {
+	keyring, err := a.verifyPassphrase(passphrase)
+	if err != nil {
+		return err
+	}
+
+	a.closeDatabases()
+	if err := database.DecryptDatabaseFile(a.dbPath, keyring); err != nil {
+		if reopenErr := a.openDatabases(); reopenErr != nil {
+			return fmt.Errorf("%v; the database could not be reopened: %v", err, reopenErr)
+		}
+		return err
+	}
+
+	a.keyring = nil
+	if err := a.openDatabases(); err != nil {
+		return fmt.Errorf("the database was decrypted but could not be reopened: %v", err)
+	}
+	log.Println("Database encryption disabled")
+	return nil
+}
+
+// RotateEncryptionKey seals the database with a new key. Retired keys are kept so older backups can still be
+// restored. A non-empty newPassphrase replaces the passphrase too.
+func (a *App) RotateEncryptionKey(passphrase, newPassphrase string) (database.EncryptionStatus, error) {
+	if _, err := a.verifyPassphrase(passphrase); err != nil {
+		return database.EncryptionStatus{}, err
+	}
+	if err := a.keyring.Rotate(); err != nil {
+		return database.EncryptionStatus{}, fmt.Errorf("error rotating key: %v", err)
+	}
+	if err := a.db.Flush(); err != nil {
+		return database.EncryptionStatus{}, fmt.Errorf("the key was rotated but the database could not be saved with it: %v", err)
+	}
+	if newPassphrase != "" {
+		if err := a.keyring.ChangePassphrase(newPassphrase); err != nil {
+			return database.EncryptionStatus{}, err
+		}
+	}
+	log.Printf("Encryption key rotated, active key %s", a.keyring.Status().ActiveKeyID)
+	return a.keyring.Status(), nil
+}
+
+// verifyPassphrase checks that the current user is an admin and that passphrase unlocks the open keyring
+func (a *App) verifyPassphrase(passphrase string) (*database.Keyring, error) {
+	if err := a.requireAdmin("change database encryption"); err != nil {
+		return nil, err
+	}
+	if a.keyring == nil {
+		return nil, fmt.Errorf("the database is not encrypted")
+	}
+	return database.UnlockKeyring(a.keyring.Path(), passphrase)
+}
+
+// requireAdmin fails unless the current user is an admin. action completes "only an admin can ...".
+func (a *App) requireAdmin(action string) error {
+	if a.db == nil {
+		return fmt.Errorf("the database is locked")
+	}
+	user, err := a.GetCurrentUser()
+	if err != nil {
+		return err
+	}
+	if user.Role != "admin" {
+		return fmt.Errorf("only an admin can %s", action)
+	}
+	return nil
+}
+
+// Company Archive Methods
+
+// ExportCompany saves everything belonging to a company, with its files and settings, to an archive chosen
+// by the user. It returns nil when the user cancels.
+func (a *App) ExportCompany(companyID int) (*CompanyArchiveManifest, error) {
+	if err := a.requireAdmin("export a company"); err != nil {
+		return nil, err
+	}
+	company, err := a.db.GetCompanyByID(companyID)
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		DefaultFilename: fmt.Sprintf("%s_%s%s", strings.ReplaceAll(company.Name, " ", "_"), time.Now().Format("20060102"), companyArchiveExtension),
+		Title:           "Export Company",
+		Filters:         []runtime.FileFilter{{DisplayName: "DijiBill Company Archive (*.dijibill)", Pattern: "*" + companyArchiveExtension}},
+	})
+	if err != nil || filePath == "" {
+		return nil, err
+	}
+	return ExportCompanyArchive(a.db, a.fileService, companyID, filePath)
+}
+
+// SelectCompanyArchive opens a dialog to choose a company archive to import
+func (a *App) SelectCompanyArchive() (string, error) {
+	filePath, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:   "Select Company Archive",
+		Filters: []runtime.FileFilter{{DisplayName: "DijiBill Company Archive (*.dijibill)", Pattern: "*" + companyArchiveExtension}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to open file dialog: %v", err)
+	}
+	if filePath == "" {
+		return "", fmt.Errorf("no file selected")
+	}
+	return filePath, nil
+}
+
+// InspectCompanyArchive checks a company archive and describes the company in it before it is imported
+func (a *App) InspectCompanyArchive(path string) (*CompanyArchiveManifest, error) {
+	return InspectCompanyArchive(path)
+}
+
+// ImportCompany adds the company in an archive as a new company with new ids, alongside the existing ones
+func (a *App) ImportCompany(path string) (*database.CompanyImportResult, error) {
+	if err := a.requireAdmin("import a company"); err != nil {
+		return nil, err
+	}
+	result, err := ImportCompanyArchive(a.db, a.fileService, path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Imported company %s as company %d", result.CompanyName, result.CompanyID)
+	return result, nil
+}
+
+// Recycle Bin Methods
+
+// GetRecycleBin returns the deleted customers, suppliers, products and documents of the current company
+func (a *App) GetRecycleBin() ([]database.RecycledRecord, error) {
+	return a.db.GetRecycleBin(a.getCurrentCompanyID())
+}
+
+// RestoreRecord takes a deleted record out of the recycle bin, e.g. RestoreRecord("sales_invoice", 12)
+func (a *App) RestoreRecord(entityType string, id int) error {
+	return a.db.RestoreRecord(entityType, id)
+}
+
+// Audit Log Methods
+
+// GetAuditLog returns the changes made in the current company, newest first. Empty or zero filters match
+// everything and dates are YYYY-MM-DD.
+func (a *App) GetAuditLog(entityType string, entityID, userID int, from, to string, limit, offset int) ([]database.AuditLogEntry, error) {
+	if err := a.requireAdmin("view the audit log"); err != nil {
+		return nil, err
+	}
+	filter, err := a.auditLogFilter(entityType, entityID, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit, filter.Offset = limit, offset
+	return a.db.GetAuditLog(filter)
+}
+
+// ExportAuditLog saves the changes made in the current company for auditors, as csv or xlsx
+func (a *App) ExportAuditLog(entityType string, entityID, userID int, from, to, format string, columns []string) (string, error) {
+	if err := a.requireAdmin("export the audit log"); err != nil {
+		return "", err
+	}
+	filter, err := a.auditLogFilter(entityType, entityID, userID, from, to)
+	if err != nil {
+		return "", err
+	}
+	entries, err := a.db.GetAuditLog(filter)
+	if err != nil {
+		return "", err
+	}
+	return a.exportRecords("Audit Log", format, columns, entries)
+}
+
+func (a *App) auditLogFilter(entityType string, entityID, userID int, from, to string) (database.AuditLogFilter, error) {
+	filter := database.AuditLogFilter{CompanyID: a.getCurrentCompanyID(), EntityType: entityType, EntityID: entityID, UserID: userID}
+	var err error
+	if filter.From, err = parseReportDate(from, time.Time{}); err != nil {
+		return filter, err
+	}
+	filter.To, err = parseReportDate(to, time.Time{})
+	return filter, err
+}
+
+// Integrity Methods
+
+// CheckIntegrity checks the data for corruption, broken references, records without a company, documents
+// whose totals differ from their items, QR codes that do not match their invoice and orphaned files
+func (a *App) CheckIntegrity() (*database.IntegrityReport, error) {
+	if err := a.requireAdmin("check the integrity of the data"); err != nil {
+		return nil, err
+	}
+	return CheckIntegrity(a.db, a.fileService)
+}
+
+// RepairIntegrity fixes the issues of an integrity check with the given keys, or every issue with a fix when
+// no key is given, and returns the issues that are left
+func (a *App) RepairIntegrity(keys []string) (*database.IntegrityReport, error) {
+	if err := a.requireAdmin("repair the data"); err != nil {
+		return nil, err
+	}
+	report, err := RepairIntegrity(a.db, a.fileService, keys, a.getCurrentUserID())
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Integrity repair made %d fixes, %d failed", len(report.Fixed), len(report.Errors))
+	return report, nil
+}
+
+// Search Methods
+
+// Search finds the customers, suppliers, products and invoices of the current company matching a query,
+// best matches first. A limit of 0 returns every match.
+func (a *App) Search(query string, limit int) ([]database.SearchResult, error) {
+	return a.db.Search(a.getCurrentCompanyID(), query, limit)
+}
+
+// Paged List Methods
+
+// The List methods return a page of a list of the current company. The first page is requested with an
+// empty cursor and each next page with the NextCursor of the page before it.
+
+func (a *App) ListCustomers(query database.ListQuery) (*database.CustomerPage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListCustomers(query)
+}
+
+func (a *App) ListSuppliers(query database.ListQuery) (*database.SupplierPage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListSuppliers(query)
+}
+
+func (a *App) ListProducts(query database.ListQuery) (*database.ProductPage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListProducts(query)
+}
+
+func (a *App) ListSalesInvoices(query database.ListQuery) (*database.SalesInvoicePage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListSalesInvoices(query)
+}
+
+func (a *App) ListPurchaseInvoices(query database.ListQuery) (*database.PurchaseInvoicePage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListPurchaseInvoices(query)
+}
+
+func (a *App) ListPayments(query database.ListQuery) (*database.PaymentPage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListPayments(query)
+}
+
+func (a *App) ListSupplierPayments(query database.ListQuery) (*database.SupplierPaymentPage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListSupplierPayments(query)
+}
+
+func (a *App) ListCreditNotes(query database.ListQuery) (*database.CreditNotePage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListCreditNotes(query)
+}
+
+func (a *App) ListExpenses(query database.ListQuery) (*database.ExpensePage, error) {
+	query.CompanyID = a.getCurrentCompanyID()
+	return a.db.ListExpenses(query)
+}
+
 // Authentication Methods
 
 func (a *App) Login(username, password string) (*AuthContext, error) {
@@ -1437,12 +3363,18 @@ func (a *App) Login(username, password string) (*AuthContext, error) {
 
 	// Update current session
 	a.currentSession = session
+	a.attributeChanges()
 
 	// Update last login
 	if err := a.db.UpdateUserLastLogin(user.ID); err != nil {
 		log.Printf("Warning: Failed to update last login: %v", err)
 	}
 
+	// Record the recurring expenses that fell due since the last login
+	if _, err := a.db.GenerateRecurringExpenses(session.CompanyID, time.Now()); err != nil {
+		log.Printf("Warning: Failed to record recurring expenses: %v", err)
+	}
+
 	return &AuthContext{
 		SessionID: session.ID,
 		UserID:    session.UserID,
@@ -1742,6 +3674,7 @@ func (a *App) Logout() error {
 	if a.currentSession != nil {
 		a.sessionManager.DeleteSession(a.currentSession.ID)
 		a.currentSession = nil
+		a.attributeChanges()
 	}
 	return nil
 }
@@ -1757,9 +3690,27 @@ func (a *App) SwitchCompany(companyID int) error {
 	}
 
 	a.currentSession.CompanyID = companyID
+	a.attributeChanges()
 	return nil
 }
 
+// attributeChanges tells the database who makes the changes that follow, so the audit log records them
+// against the signed in user and company
+func (a *App) attributeChanges() {
+	if a.db == nil {
+		return
+	}
+	var err error
+	if a.currentSession != nil {
+		err = a.db.SetAuditUser(a.currentSession.UserID, a.currentSession.CompanyID)
+	} else {
+		err = a.db.ClearAuditUser()
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to update the audit user: %v", err)
+	}
+}
+
 // User Management Methods
 
 func (a *App) CreateUser(user database.User) error {
@@ -1910,6 +3861,7 @@ func (a *App) Signup(request SignupRequest) (*AuthContext, error) {
 
 	// Update current session
 	a.currentSession = session
+	a.attributeChanges()
 
 	return &AuthContext{
 		SessionID: session.ID,
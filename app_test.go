@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"dijibill/database"
+)
+
+func TestUpdateReturnsSavedRecord(t *testing.T) {
+	a := newTestApp(t)
+	if err := a.CreateCustomer(database.Customer{Name: "Before"}); err != nil {
+		t.Fatal(err)
+	}
+	customers, err := a.GetCustomers()
+	if err != nil || len(customers) != 1 {
+		t.Fatalf("GetCustomers = %+v, %v", customers, err)
+	}
+	read := customers[0]
+
+	// Each save is made from the record the previous one returned
+	saved := &read
+	for _, name := range []string{"First", "Second"} {
+		saved.Name = name
+		if saved, err = a.UpdateCustomer(*saved); err != nil {
+			t.Fatalf("saving the returned customer: %v", err)
+		}
+	}
+	if saved.Name != "Second" || saved.Version != read.Version+2 {
+		t.Errorf("saved %s at version %d, want Second at %d", saved.Name, saved.Version, read.Version+2)
+	}
+
+	read.Name = "Stale"
+	_, err = a.UpdateCustomer(read)
+	var conflict *database.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("saving the customer as first read: %v, want a ConflictError", err)
+	}
+	if got := customerNames(t, a); got != "Second" {
+		t.Errorf("customers after the refused save are %q", got)
+	}
+}
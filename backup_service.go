@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dijibill/database"
+)
+
+const (
+	backupSetPrefix     = "dijibill-backup-"
+	backupTimeLayout    = "20060102-150405"
+	backupManifestName  = "manifest.json"
+	mainBackupFileName  = "dijibill.db"
+	filesBackupFileName = "dijibill_files.db"
+	sealedFileSuffix    = ".enc"
+	backupKeyringName   = "dijibill.key"
+
+	// backupCheckInterval is how often the scheduler checks whether a backup is due
+	backupCheckInterval = 15 * time.Minute
+)
+
+// BackupFile is a database file in a backup with its size and checksum
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupInfo describes a backup set: a directory holding a snapshot of each database and a manifest
+type BackupInfo struct {
+	Name          string       `json:"name"`
+	Path          string       `json:"path"`
+	CreatedAt     time.Time    `json:"created_at"`
+	SchemaVersion int          `json:"schema_version"`
+	Automatic     bool         `json:"automatic"`
+	Files         []BackupFile `json:"files"`
+	Size          int64        `json:"size"`
+	Encrypted     bool         `json:"encrypted"`
+	KeyID         string       `json:"key_id,omitempty"` // The key the files were sealed with
+}
+
+// BackupService takes backups of the application and file databases, on demand and on the schedule in the
+// system settings. When the database is encrypted the backups are sealed with its keyring.
+type BackupService struct {
+	db          *database.Database
+	fileService *FileService
+	defaultDir  string
+	keyring     *database.Keyring // nil when the database is not encrypted
+
+	mu      sync.Mutex // Held while a backup is taken
+	stop    chan struct{}
+	done    chan struct{} // Closed when the scheduler has returned
+	stopped bool          // Set under mu once the scheduler is stopped
+}
+
+// NewBackupService creates a backup service writing to defaultDir unless the settings name another directory.
+// Backups are encrypted with keyring unless it is nil.
+func NewBackupService(db *database.Database, fileService *FileService, defaultDir string, keyring *database.Keyring) *BackupService {
+	return &BackupService{db: db, fileService: fileService, defaultDir: defaultDir, keyring: keyring}
+}
+
+// Start checks now and then every backupCheckInterval whether a scheduled backup is due
+func (bs *BackupService) Start() {
+	if bs.stop != nil {
+		return
+	}
+	bs.stop = make(chan struct{})
+	bs.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(backupCheckInterval)
+		defer ticker.Stop()
+		for {
+			bs.runIfDue(time.Now())
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}(bs.stop, bs.done)
+}
+
+// Stop stops the scheduler. A backup that is running is allowed to finish. Once Stop returns the service
+// no longer uses the databases, so they can be closed and their files replaced: a connection left open on a
+// database file that is renamed would take the journal of the file put in its place for its own.
+func (bs *BackupService) Stop() {
+	if bs.stop != nil {
+		close(bs.stop)
+		<-bs.done
+		bs.stop = nil
+	}
+	// Wait for a running backup and keep a scheduled one that is about to start from running
+	bs.mu.Lock()
+	bs.stopped = true
+	bs.mu.Unlock()
+}
+
+// runIfDue takes a backup when automatic backups are on and the configured frequency has passed since the last
+func (bs *BackupService) runIfDue(now time.Time) {
+	settings, err := bs.db.GetSystemSettings()
+	if err != nil {
+		log.Printf("Warning: Could not read backup settings: %v", err)
+		return
+	}
+	if !settings.AutoBackup {
+		return
+	}
+	if settings.LastBackupTime != nil && now.Before(nextBackupTime(*settings.LastBackupTime, settings.BackupFrequency)) {
+		return
+	}
+
+	backup, err := bs.backup(settings, true)
+	if err != nil {
+		log.Printf("Warning: Scheduled backup failed: %v", err)
+		return
+	}
+	log.Printf("Scheduled backup written to %s", backup.Path)
+}
+
+// nextBackupTime returns when the backup after one taken at last is due. The frequency is hourly, daily,
+// weekly, monthly or a number of hours.
+func nextBackupTime(last time.Time, frequency string) time.Time {
+	switch strings.ToLower(strings.TrimSpace(frequency)) {
+	case "hourly":
+		return last.Add(time.Hour)
+	case "weekly":
+		return last.AddDate(0, 0, 7)
+	case "monthly":
+		return last.AddDate(0, 1, 0)
+	}
+	if hours, err := strconv.Atoi(frequency); err == nil && hours > 0 {
+		return last.Add(time.Duration(hours) * time.Hour)
+	}
+	return last.AddDate(0, 0, 1)
+}
+
+// Directory returns the directory backups are written to
+func (bs *BackupService) Directory(settings *database.SystemSettings) string {
+	if settings != nil && strings.TrimSpace(settings.BackupDirectory) != "" {
+		return settings.BackupDirectory
+	}
+	return bs.defaultDir
+}
+
+// Backup takes a backup now, whatever the schedule
+func (bs *BackupService) Backup() (*BackupInfo, error) {
+	settings, err := bs.db.GetSystemSettings()
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup settings: %v", err)
+	}
+	return bs.backup(settings, false)
+}
+
+// backup snapshots both databases into a new backup set, verifies the snapshots, records the backup time and
+// removes the backups beyond the retention count. The set is written under a temporary name and only renamed
+// into place once verified, so an interrupted backup never counts as one.
+func (bs *BackupService) backup(settings *database.SystemSettings, automatic bool) (*BackupInfo, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if automatic && bs.stopped {
+		return nil, fmt.Errorf("scheduled backups are stopped")
+	}
+
+	dir := bs.Directory(settings)
+	if err := ensureDir(dir); err != nil {
+		return nil, fmt.Errorf("error creating backup directory: %v", err)
+	}
+
+	now := time.Now()
+	name := backupSetPrefix + now.Format(backupTimeLayout)
+	target := filepath.Join(dir, name)
+	for n := 2; backupExists(target); n++ {
+		// Another backup was taken in the same second
+		name = fmt.Sprintf("%s%s-%d", backupSetPrefix, now.Format(backupTimeLayout), n)
+		target = filepath.Join(dir, name)
+	}
+	partial := target + ".partial"
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		return nil, fmt.Errorf("error creating backup: %v", err)
+	}
+	defer os.RemoveAll(partial)
+
+	version, err := bs.db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	info := &BackupInfo{Name: name, Path: target, CreatedAt: now, SchemaVersion: version, Automatic: automatic}
+
+	type snapshot struct {
+		name     string
+		backup   func(path string) error
+		snapshot func() ([]byte, error)
+	}
+	snapshots := []snapshot{{mainBackupFileName, bs.db.BackupTo, bs.db.Snapshot}}
+	if bs.fileService != nil {
+		snapshots = append(snapshots, snapshot{filesBackupFileName, bs.fileService.fileDB.BackupTo, bs.fileService.fileDB.Snapshot})
+	}
+
+	for _, snapshot := range snapshots {
+		path := filepath.Join(partial, snapshot.name)
+		if bs.keyring != nil {
+			path += sealedFileSuffix
+			if err := sealSnapshot(path, snapshot.snapshot, bs.keyring); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := snapshot.backup(path); err != nil {
+				return nil, err
+			}
+			if err := database.VerifyBackupFile(path); err != nil {
+				return nil, err
+			}
+		}
+		file, err := describeBackupFile(path)
+		if err != nil {
+			return nil, err
+		}
+		info.Files = append(info.Files, *file)
+		info.Size += file.Size
+	}
+
+	if bs.keyring != nil {
+		// The keyring copy lets the backup be restored with the passphrase after the live keyring is lost or
+		// replaced. Its keys stay wrapped by the passphrase.
+		keyring, err := bs.keyring.Export()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(partial, backupKeyringName), keyring, 0600); err != nil {
+			return nil, fmt.Errorf("error writing backup keyring: %v", err)
+		}
+		info.Encrypted = true
+		info.KeyID = bs.keyring.Status().ActiveKeyID
+	}
+
+	manifest, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(partial, backupManifestName), manifest, 0644); err != nil {
+		return nil, fmt.Errorf("error writing backup manifest: %v", err)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return nil, fmt.Errorf("error finishing backup: %v", err)
+	}
+
+	if err := bs.db.UpdateLastBackupTime(now); err != nil {
+		return nil, fmt.Errorf("backup written but the backup time could not be saved: %v", err)
+	}
+	if err := pruneBackups(dir, settings.BackupRetention); err != nil {
+		log.Printf("Warning: Could not remove old backups: %v", err)
+	}
+
+	return info, nil
+}
+
+// sealSnapshot takes a snapshot in memory, checks it, seals it with the keyring and writes it to path. The
+// written file is read back and opened to make sure it decrypts.
+func sealSnapshot(path string, snapshot func() ([]byte, error), keyring *database.Keyring) error {
+	image, err := snapshot()
+	if err != nil {
+		return err
+	}
+	if err := database.VerifyBackupData(image); err != nil {
+		return err
+	}
+	sealed, err := keyring.Seal(image)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, sealed, 0600); err != nil {
+		return fmt.Errorf("error writing backup %s: %v", path, err)
+	}
+	_, err = readBackupData(path, keyring)
+	return err
+}
+
+// backupExists reports whether a backup set, finished or not, is at path
+func backupExists(path string) bool {
+	for _, candidate := range []string{path, path + ".partial"} {
+		if _, err := os.Stat(candidate); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// describeBackupFile returns the size and SHA-256 checksum of a backup file
+func describeBackupFile(path string) (*BackupFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup %s: %v", path, err)
+	}
+	return &BackupFile{Name: filepath.Base(path), Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// ListBackups returns the backup sets in a directory, newest first. Directories without a readable manifest
+// are not backups and are left out.
+func ListBackups(dir string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup directory: %v", err)
+	}
+
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), backupSetPrefix) || strings.HasSuffix(entry.Name(), ".partial") {
+			continue
+		}
+		info, err := readBackupManifest(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, *info)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// readBackupManifest reads the manifest of a backup set
+func readBackupManifest(path string) (*BackupInfo, error) {
+	data, err := os.ReadFile(filepath.Join(path, backupManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a backup: %v", filepath.Base(path), err)
+	}
+	var info BackupInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("the manifest of %s is damaged: %v", filepath.Base(path), err)
+	}
+	info.Name = filepath.Base(path)
+	info.Path = path
+	return &info, nil
+}
+
+// pruneBackups removes the oldest backup sets in a directory beyond the newest keep
+func pruneBackups(dir string, keep int) error {
+	if keep < 1 {
+		keep = 1
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.RemoveAll(backups[i].Path); err != nil {
+			return err
+		}
+		log.Printf("Removed old backup %s", backups[i].Name)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dijibill/database"
+)
+
+// TestMain refuses to run the tests with a SQLite built without the full-text search every database needs
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dijibill-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	db, err := database.NewDatabase(filepath.Join(dir, "dijibill.db"))
+	if err == nil {
+		db.Close()
+	}
+	os.RemoveAll(dir)
+	if errors.Is(err, database.ErrNoFTS5) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestBackupService(t *testing.T) (*BackupService, string) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewDatabase(filepath.Join(dir, "dijibill.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	fileService, err := NewFileService(filepath.Join(dir, "dijibill_files.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fileService.Close() })
+	backupDir := filepath.Join(dir, "backups")
+	return NewBackupService(db, fileService, backupDir, nil), backupDir
+}
+
+func TestBackupWritesVerifiedSet(t *testing.T) {
+	bs, dir := newTestBackupService(t)
+	info, err := bs.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Path != filepath.Join(dir, info.Name) || !strings.HasPrefix(info.Name, backupSetPrefix) {
+		t.Errorf("backup written to %s", info.Path)
+	}
+	version, err := bs.db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SchemaVersion != version || info.Automatic || info.Encrypted {
+		t.Errorf("backup info %+v", info)
+	}
+
+	manifest, err := readBackupManifest(info.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("manifest lists %d files, want both databases", len(manifest.Files))
+	}
+	for _, file := range manifest.Files {
+		actual, err := describeBackupFile(filepath.Join(info.Path, file.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *actual != file {
+			t.Errorf("manifest has %+v for %s, the file is %+v", file, file.Name, *actual)
+		}
+		if err := database.VerifyBackupFile(filepath.Join(info.Path, file.Name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".partial") {
+			t.Errorf("%s was left behind", entry.Name())
+		}
+	}
+	settings, err := bs.db.GetSystemSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.LastBackupTime == nil || settings.LastBackupTime.Sub(info.CreatedAt).Abs() > time.Second {
+		t.Errorf("last backup time %v, want %v", settings.LastBackupTime, info.CreatedAt)
+	}
+}
+
+func TestBackupSkipsPartialSets(t *testing.T) {
+	bs, dir := newTestBackupService(t)
+	info, err := bs.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A set interrupted before it was renamed into place has a manifest but is not a backup
+	partial := filepath.Join(dir, backupSetPrefix+"20000101-000000.partial")
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(info.Path, backupManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(partial, backupManifestName), manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ListBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name != info.Name {
+		t.Errorf("ListBackups = %+v, want only %s", backups, info.Name)
+	}
+	if !backupExists(strings.TrimSuffix(partial, ".partial")) {
+		t.Error("the name of an interrupted set is free to reuse")
+	}
+}
+
+func TestBackupPrunesBeyondRetention(t *testing.T) {
+	bs, dir := newTestBackupService(t)
+	if err := bs.db.UpdateBackupSettings(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := 0; i < 4; i++ {
+		info, err := bs.Backup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, info.Name)
+	}
+
+	backups, err := ListBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Name != names[3] || backups[1].Name != names[2] {
+		t.Fatalf("kept %+v, want the newest two of %v", backups, names)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("the backup directory holds %d entries, want the 2 kept sets", len(entries))
+	}
+}
+
+func TestNextBackupTime(t *testing.T) {
+	last := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		frequency string
+		want      time.Time
+	}{
+		{"hourly", last.Add(time.Hour)},
+		{"daily", last.AddDate(0, 0, 1)},
+		{"Weekly", last.AddDate(0, 0, 7)},
+		{"monthly", last.AddDate(0, 1, 0)},
+		{"6", last.Add(6 * time.Hour)},
+		{"", last.AddDate(0, 0, 1)},
+		{"-2", last.AddDate(0, 0, 1)},
+	}
+	for _, test := range tests {
+		if got := nextBackupTime(last, test.frequency); !got.Equal(test.want) {
+			t.Errorf("nextBackupTime(%q) = %v, want %v", test.frequency, got, test.want)
+		}
+	}
+}
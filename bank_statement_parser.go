@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dijibill/database"
+)
+
+// ParseBankStatement reads a CSV, MT940 or camt.053 bank statement. The format is taken from the
+// file extension and, failing that, from the content. A file may hold several statements.
+func ParseBankStatement(fileName string, data []byte) ([]database.BankStatement, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	content := string(data)
+
+	var statements []database.BankStatement
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(fileName)); {
+	case ext == ".xml" || ext == ".053" || strings.Contains(content, "BkToCstmrStmt"):
+		statements, err = parseCamt053(data)
+	case ext == ".sta" || ext == ".mt940" || ext == ".940" || strings.Contains(content, ":61:"):
+		statements, err = parseMT940(content)
+	case ext == ".csv" || ext == ".txt":
+		statements, err = parseBankCSV(content)
+	default:
+		return nil, fmt.Errorf("unsupported bank statement format: %s", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var result []database.BankStatement
+	for _, statement := range statements {
+		if len(statement.Lines) == 0 {
+			continue
+		}
+		statement.FileName = filepath.Base(fileName)
+		result = append(result, statement)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no transactions found in %s", filepath.Base(fileName))
+	}
+	return result, nil
+}
+
+// parseStatementAmount parses an amount written with either a decimal comma or a decimal point,
+// with optional thousands separators, currency codes, a minus sign, a trailing minus or parentheses
+func parseStatementAmount(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	negative := false
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		negative = true
+		value = strings.Trim(value, "()")
+	}
+	if strings.HasSuffix(value, "-") {
+		negative = true
+		value = strings.TrimSuffix(value, "-")
+	}
+
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9', r == '.', r == ',':
+			b.WriteRune(r)
+		case r == '-':
+			negative = !negative
+		}
+	}
+	number := b.String()
+
+	// The last separator is the decimal one when followed by one or two digits
+	lastDot := strings.LastIndex(number, ".")
+	lastComma := strings.LastIndex(number, ",")
+	decimal := max(lastDot, lastComma)
+	if decimal >= 0 && len(number)-decimal-1 <= 2 {
+		number = strings.NewReplacer(".", "", ",", "").Replace(number[:decimal]) + "." + number[decimal+1:]
+	} else {
+		number = strings.NewReplacer(".", "", ",", "").Replace(number)
+	}
+
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if negative {
+		amount = -amount
+	}
+	return math.Round(amount*100) / 100, nil
+}
+
+// statementDateLayouts are the date formats accepted in CSV statements; day-first formats win over month-first
+var statementDateLayouts = []string{
+	"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "02/01/2006", "02-01-2006", "02.01.2006",
+	"2006/01/02", "02/01/06", "02-Jan-2006", "02 Jan 2006", "Jan 2, 2006", "01/02/2006",
+}
+
+func parseStatementDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range statementDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// bankCSVColumns lists the header names recognised for each CSV column, in English and Arabic
+var bankCSVColumns = map[string][]string{
+	"date":         {"date", "booking date", "transaction date", "posting date", "التاريخ", "تاريخ العملية"},
+	"value_date":   {"value date", "تاريخ القيمة"},
+	"amount":       {"amount", "المبلغ"},
+	"debit":        {"debit", "withdrawal", "withdrawals", "مدين", "سحب"},
+	"credit":       {"credit", "deposit", "deposits", "دائن", "إيداع"},
+	"reference":    {"reference", "ref", "customer reference", "المرجع"},
+	"description":  {"description", "details", "narrative", "narration", "البيان", "الوصف"},
+	"counterparty": {"counterparty", "name", "payee", "payer", "beneficiary", "الاسم", "المستفيد"},
+	"account":      {"counterparty account", "iban", "account", "رقم الحساب"},
+	"bank_ref":     {"bank reference", "transaction id", "رقم العملية"},
+	"balance":      {"balance", "الرصيد"},
+}
+
+// parseBankCSV reads a CSV statement with a header row. Amounts come either from one signed amount column
+// or from separate debit and credit columns.
+func parseBankCSV(content string) ([]database.BankStatement, error) {
+	firstLine := content
+	if i := strings.IndexAny(content, "\r\n"); i >= 0 {
+		firstLine = content[:i]
+	}
+	delimiter := ','
+	for _, candidate := range []rune{';', '\t'} {
+		if strings.Count(firstLine, string(candidate)) > strings.Count(firstLine, string(delimiter)) {
+			delimiter = candidate
+		}
+	}
+
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV statement: %v", err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("the CSV statement has no transactions")
+	}
+
+	columns := make(map[string]int)
+	for i, header := range records[0] {
+		header = strings.ToLower(strings.TrimSpace(header))
+		for column, names := range bankCSVColumns {
+			for _, name := range names {
+				if _, found := columns[column]; !found && header == name {
+					columns[column] = i
+				}
+			}
+		}
+	}
+	if _, ok := columns["date"]; !ok {
+		return nil, fmt.Errorf("the CSV statement has no date column")
+	}
+	_, hasAmount := columns["amount"]
+	_, hasDebit := columns["debit"]
+	_, hasCredit := columns["credit"]
+	if !hasAmount && !hasDebit && !hasCredit {
+		return nil, fmt.Errorf("the CSV statement has no amount, debit or credit column")
+	}
+
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	statement := database.BankStatement{Format: "csv"}
+	for n, record := range records[1:] {
+		rowNumber := n + 2
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		var line database.BankStatementLine
+		if line.BookingDate, err = parseStatementDate(field(record, "date")); err != nil {
+			return nil, fmt.Errorf("row %d: %v", rowNumber, err)
+		}
+		if value := field(record, "value_date"); value != "" {
+			if line.ValueDate, err = parseStatementDate(value); err != nil {
+				return nil, fmt.Errorf("row %d: %v", rowNumber, err)
+			}
+		}
+
+		if hasAmount {
+			if line.Amount, err = parseStatementAmount(field(record, "amount")); err != nil {
+				return nil, fmt.Errorf("row %d: %v", rowNumber, err)
+			}
+		} else {
+			debit, err := parseStatementAmount(field(record, "debit"))
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %v", rowNumber, err)
+			}
+			credit, err := parseStatementAmount(field(record, "credit"))
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %v", rowNumber, err)
+			}
+			line.Amount = math.Abs(credit) - math.Abs(debit)
+		}
+		if line.Amount == 0 {
+			continue
+		}
+
+		line.Reference = field(record, "reference")
+		line.Description = field(record, "description")
+		line.CounterpartyName = field(record, "counterparty")
+		line.CounterpartyAccount = field(record, "account")
+		line.BankReference = field(record, "bank_ref")
+
+		if balance, err := parseStatementAmount(field(record, "balance")); err == nil && field(record, "balance") != "" {
+			statement.ClosingBalance = balance
+		}
+		if line.BookingDate.After(statement.StatementDate) {
+			statement.StatementDate = line.BookingDate
+		}
+		statement.Lines = append(statement.Lines, line)
+	}
+
+	return []database.BankStatement{statement}, nil
+}
+
+// mt940Tag matches the start of an MT940 field such as ":61:" or ":60F:"
+var mt940Tag = regexp.MustCompile(`^:(\d{2}[A-Z]?):`)
+
+// mt940Line parses the :61: statement line: value date, optional entry date, debit/credit mark,
+// optional funds code, amount, transaction type, customer reference and optional bank reference
+var mt940Line = regexp.MustCompile(`^(\d{6})(\d{4})?(R?[CD])([A-Z])?([\d,]+)([NSF][A-Z0-9]{3})([^/]*)(?://(.*))?$`)
+
+// mt940Balance parses balance fields such as ":60F:C240115SAR12345,67"
+var mt940Balance = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})([\d,]+)$`)
+
+// mt940SubField matches the "?20" style sub-field separators some banks use in :86:
+var mt940SubField = regexp.MustCompile(`\?\d{2}`)
+
+func parseMT940Date(value string) (time.Time, error) {
+	return time.Parse("060102", value)
+}
+
+// parseMT940 reads SWIFT MT940 customer statements
+func parseMT940(content string) ([]database.BankStatement, error) {
+	// Join continuation lines onto the field they belong to
+	type field struct{ tag, value string }
+	var fields []field
+	for _, raw := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		raw = strings.TrimRight(raw, " ")
+		if match := mt940Tag.FindStringSubmatch(raw); match != nil {
+			fields = append(fields, field{tag: match[1], value: raw[len(match[0]):]})
+			continue
+		}
+		if raw == "" || raw == "-" || strings.HasPrefix(raw, "{") || strings.HasPrefix(raw, "-}") || len(fields) == 0 {
+			continue
+		}
+		fields[len(fields)-1].value += "\n" + raw
+	}
+
+	var statements []database.BankStatement
+	var current *database.BankStatement
+	var lastLine *database.BankStatementLine
+
+	for _, f := range fields {
+		switch f.tag {
+		case "20":
+			statements = append(statements, database.BankStatement{Format: "mt940"})
+			current = &statements[len(statements)-1]
+			lastLine = nil
+		case "25":
+			if current != nil {
+				current.AccountNumber = strings.TrimSpace(f.value)
+			}
+		case "28C", "28":
+			if current != nil {
+				current.StatementNumber = strings.TrimSpace(f.value)
+			}
+		case "60F", "60M", "62F", "62M":
+			if current == nil {
+				continue
+			}
+			match := mt940Balance.FindStringSubmatch(strings.TrimSpace(f.value))
+			if match == nil {
+				return nil, fmt.Errorf("invalid MT940 balance %q", f.value)
+			}
+			amount, err := parseStatementAmount(match[4])
+			if err != nil {
+				return nil, err
+			}
+			if match[1] == "D" {
+				amount = -amount
+			}
+			current.Currency = match[3]
+			if strings.HasPrefix(f.tag, "60") {
+				current.OpeningBalance = amount
+			} else {
+				current.ClosingBalance = amount
+				if date, err := parseMT940Date(match[2]); err == nil {
+					current.StatementDate = date
+				}
+			}
+		case "61":
+			if current == nil {
+				return nil, fmt.Errorf("MT940 statement line before the :20: header")
+			}
+			first, supplementary, _ := strings.Cut(f.value, "\n")
+			match := mt940Line.FindStringSubmatch(strings.TrimSpace(first))
+			if match == nil {
+				return nil, fmt.Errorf("invalid MT940 statement line %q", first)
+			}
+
+			valueDate, err := parseMT940Date(match[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid MT940 date %q", match[1])
+			}
+			bookingDate := valueDate
+			if match[2] != "" {
+				// The entry date has no year; it may fall in the year before or after the value date
+				entry, err := time.Parse("20060102", fmt.Sprintf("%04d%s", valueDate.Year(), match[2]))
+				if err == nil {
+					if entry.Sub(valueDate) > 180*24*time.Hour {
+						entry = entry.AddDate(-1, 0, 0)
+					} else if valueDate.Sub(entry) > 180*24*time.Hour {
+						entry = entry.AddDate(1, 0, 0)
+					}
+					bookingDate = entry
+				}
+			}
+
+			amount, err := parseStatementAmount(match[5])
+			if err != nil {
+				return nil, err
+			}
+			// Debits and reversed credits take money out of the account
+			if match[3] == "D" || match[3] == "RC" {
+				amount = -amount
+			}
+
+			reference := strings.TrimSpace(match[7])
+			if reference == "NONREF" {
+				reference = ""
+			}
+
+			current.Lines = append(current.Lines, database.BankStatementLine{
+				BookingDate:   bookingDate,
+				ValueDate:     valueDate,
+				Amount:        amount,
+				Reference:     reference,
+				BankReference: strings.TrimSpace(match[8]),
+				Description:   strings.TrimSpace(supplementary),
+			})
+			lastLine = &current.Lines[len(current.Lines)-1]
+		case "86":
+			if lastLine == nil {
+				continue
+			}
+			info := strings.ReplaceAll(f.value, "\n", "")
+			info = strings.Join(strings.Fields(mt940SubField.ReplaceAllString(info, " ")), " ")
+			lastLine.Description = strings.TrimSpace(strings.TrimSpace(lastLine.Description) + " " + info)
+		}
+	}
+
+	return statements, nil
+}
+
+// camt053 mirrors the parts of an ISO 20022 camt.053 bank-to-customer statement that are imported
+type camt053 struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	ID             string        `xml:"Id"`
+	ElectronicSeq  string        `xml:"ElctrncSeqNb"`
+	CreatedAt      string        `xml:"CreDtTm"`
+	IBAN           string        `xml:"Acct>Id>IBAN"`
+	OtherAccountID string        `xml:"Acct>Id>Othr>Id"`
+	Currency       string        `xml:"Acct>Ccy"`
+	Balances       []camtBalance `xml:"Bal"`
+	Entries        []camtEntry   `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d camtDate) time() time.Time {
+	if d.Date != "" {
+		if t, err := time.Parse("2006-01-02", d.Date); err == nil {
+			return t
+		}
+	}
+	if len(d.DateTime) >= 10 {
+		if t, err := time.Parse("2006-01-02", d.DateTime[:10]); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	Indicator string     `xml:"CdtDbtInd"`
+	Date      camtDate   `xml:"Dt"`
+}
+
+type camtEntry struct {
+	Amount      camtAmount        `xml:"Amt"`
+	Indicator   string            `xml:"CdtDbtInd"`
+	Reversal    bool              `xml:"RvslInd"`
+	BookingDate camtDate          `xml:"BookgDt"`
+	ValueDate   camtDate          `xml:"ValDt"`
+	BankRef     string            `xml:"AcctSvcrRef"`
+	Details     []camtTransaction `xml:"NtryDtls>TxDtls"`
+	Info        string            `xml:"AddtlNtryInf"`
+}
+
+type camtTransaction struct {
+	Amount         camtAmount `xml:"Amt"`
+	EndToEndID     string     `xml:"Refs>EndToEndId"`
+	InstructionID  string     `xml:"Refs>InstrId"`
+	BankRef        string     `xml:"Refs>AcctSvcrRef"`
+	DebtorName     string     `xml:"RltdPties>Dbtr>Nm"`
+	DebtorIBAN     string     `xml:"RltdPties>DbtrAcct>Id>IBAN"`
+	CreditorName   string     `xml:"RltdPties>Cdtr>Nm"`
+	CreditorIBAN   string     `xml:"RltdPties>CdtrAcct>Id>IBAN"`
+	Unstructured   []string   `xml:"RmtInf>Ustrd"`
+	CreditorRef    string     `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	AdditionalInfo string     `xml:"AddtlTxInf"`
+}
+
+// parseCamt053 reads ISO 20022 camt.053 statements. Batch entries with several priced transactions
+// become one line per transaction.
+func parseCamt053(data []byte) ([]database.BankStatement, error) {
+	var document camt053
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error reading camt.053 statement: %v", err)
+	}
+	if len(document.Statements) == 0 {
+		return nil, fmt.Errorf("no statements found in camt.053 file")
+	}
+
+	var statements []database.BankStatement
+	for _, s := range document.Statements {
+		statement := database.BankStatement{
+			Format:          "camt053",
+			AccountNumber:   s.IBAN,
+			Currency:        s.Currency,
+			StatementNumber: s.ID,
+		}
+		if statement.AccountNumber == "" {
+			statement.AccountNumber = s.OtherAccountID
+		}
+		if s.ElectronicSeq != "" {
+			statement.StatementNumber = s.ElectronicSeq
+		}
+		if len(s.CreatedAt) >= 10 {
+			statement.StatementDate, _ = time.Parse("2006-01-02", s.CreatedAt[:10])
+		}
+
+		for _, balance := range s.Balances {
+			amount, err := parseStatementAmount(balance.Amount.Value)
+			if err != nil {
+				return nil, err
+			}
+			if balance.Indicator == "DBIT" {
+				amount = -amount
+			}
+			switch balance.Code {
+			case "OPBD", "PRCD":
+				statement.OpeningBalance = amount
+			case "CLBD":
+				statement.ClosingBalance = amount
+				if date := balance.Date.time(); !date.IsZero() {
+					statement.StatementDate = date
+				}
+			}
+			if statement.Currency == "" {
+				statement.Currency = balance.Amount.Currency
+			}
+		}
+
+		for _, entry := range s.Entries {
+			// A debit takes money out of the account; a reversal turns the direction around
+			sign := 1.0
+			if entry.Indicator == "DBIT" {
+				sign = -1
+			}
+			if entry.Reversal {
+				sign = -sign
+			}
+
+			lines := []database.BankStatementLine{}
+			split := len(entry.Details) > 1
+			for _, detail := range entry.Details {
+				if detail.Amount.Value == "" {
+					split = false
+				}
+			}
+
+			details := entry.Details
+			if len(details) == 0 {
+				details = []camtTransaction{{}}
+			}
+			if !split {
+				details = details[:1]
+			}
+
+			for _, detail := range details {
+				value := entry.Amount.Value
+				if split {
+					value = detail.Amount.Value
+				}
+				amount, err := parseStatementAmount(value)
+				if err != nil {
+					return nil, err
+				}
+
+				line := database.BankStatementLine{
+					BookingDate:   entry.BookingDate.time(),
+					ValueDate:     entry.ValueDate.time(),
+					Amount:        sign * amount,
+					BankReference: entry.BankRef,
+				}
+				if detail.BankRef != "" {
+					line.BankReference = detail.BankRef
+				}
+
+				line.Reference = detail.CreditorRef
+				if line.Reference == "" && detail.EndToEndID != "NOTPROVIDED" {
+					line.Reference = detail.EndToEndID
+				}
+				if line.Reference == "" {
+					line.Reference = detail.InstructionID
+				}
+
+				// The counterparty is the debtor of money received and the creditor of money paid out
+				line.CounterpartyName, line.CounterpartyAccount = detail.DebtorName, detail.DebtorIBAN
+				if line.Amount < 0 {
+					line.CounterpartyName, line.CounterpartyAccount = detail.CreditorName, detail.CreditorIBAN
+				}
+
+				description := append([]string{}, detail.Unstructured...)
+				if detail.AdditionalInfo != "" {
+					description = append(description, detail.AdditionalInfo)
+				}
+				if entry.Info != "" {
+					description = append(description, entry.Info)
+				}
+				line.Description = strings.Join(description, " ")
+
+				if line.BookingDate.IsZero() {
+					line.BookingDate = line.ValueDate
+				}
+				lines = append(lines, line)
+			}
+			statement.Lines = append(statement.Lines, lines...)
+		}
+
+		statements = append(statements, statement)
+	}
+
+	return statements, nil
+}
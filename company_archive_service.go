@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"dijibill/database"
+)
+
+const (
+	companyArchiveFormat    = "dijibill-company"
+	companyArchiveVersion   = 1
+	companyArchiveExtension = ".dijibill"
+
+	companyArchiveManifest = "manifest.json"
+	companyArchiveTables   = "tables/"
+	companyArchiveFiles    = "files/"
+	companyArchiveFileList = "files/index.json"
+)
+
+// companyFileEntities are the entity types of attachments that travel with a company, with the table the
+// entity id refers to. Company logos are attached to no entity and are found through the company instead.
+var companyFileEntities = map[string]string{
+	"company": "companies",
+	"product": "products",
+}
+
+// CompanyArchiveManifest describes a company archive: the company in it, the schema it was exported from and
+// how many rows and files it holds
+type CompanyArchiveManifest struct {
+	Format            string         `json:"format"`
+	Version           int            `json:"version"`
+	SchemaVersion     int            `json:"schema_version"`
+	ExportedAt        time.Time      `json:"exported_at"`
+	CompanyID         int            `json:"company_id"`
+	CompanyName       string         `json:"company_name"`
+	CompanyNameArabic string         `json:"company_name_arabic"`
+	Tables            map[string]int `json:"tables"` // Rows per table
+	Files             int            `json:"files"`
+}
+
+// companyArchive is the content of a company archive, read into memory
+type companyArchive struct {
+	manifest CompanyArchiveManifest
+	tables   []database.CompanyArchiveTable
+	files    []FileMetadata
+	content  map[int][]byte // File content by archived file id
+}
+
+// ExportCompanyArchive writes everything belonging to a company to a zip archive at path: a manifest, the
+// rows of each table and the company's attachments from the file database. The archive is written under a
+// temporary name and renamed once complete.
+func ExportCompanyArchive(db *database.Database, fileService *FileService, companyID int, path string) (*CompanyArchiveManifest, error) {
+	tables, err := db.ExportCompanyData(companyID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	company, err := db.GetCompanyByID(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &CompanyArchiveManifest{
+		Format:            companyArchiveFormat,
+		Version:           companyArchiveVersion,
+		SchemaVersion:     version,
+		ExportedAt:        time.Now(),
+		CompanyID:         companyID,
+		CompanyName:       company.Name,
+		CompanyNameArabic: company.NameArabic,
+		Tables:            make(map[string]int),
+	}
+	for _, table := range tables {
+		manifest.Tables[table.Name] = len(table.Rows)
+	}
+
+	files := []FileMetadata{}
+	content := make(map[int][]byte)
+	if fileService != nil {
+		if files, content, err = companyFiles(fileService, company, tables); err != nil {
+			return nil, err
+		}
+	}
+	manifest.Files = len(files)
+
+	partial := path + ".partial"
+	out, err := os.Create(partial)
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive: %v", err)
+	}
+	defer os.Remove(partial)
+
+	archive := zip.NewWriter(out)
+	write := func(name string, value interface{}) error {
+		w, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		if data, ok := value.([]byte); ok {
+			_, err = w.Write(data)
+			return err
+		}
+		return json.NewEncoder(w).Encode(value)
+	}
+	err = write(companyArchiveManifest, manifest)
+	for i := 0; err == nil && i < len(tables); i++ {
+		err = write(companyArchiveTables+tables[i].Name+".json", tables[i])
+	}
+	if err == nil {
+		err = write(companyArchiveFileList, files)
+	}
+	for i := 0; err == nil && i < len(files); i++ {
+		err = write(companyArchiveFiles+strconv.Itoa(files[i].ID), content[files[i].ID])
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing archive: %v", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return nil, fmt.Errorf("error finishing archive: %v", err)
+	}
+	return manifest, nil
+}
+
+// companyFiles returns the company's logo and the files attached to the company and its records
+func companyFiles(fileService *FileService, company *database.Company, tables []database.CompanyArchiveTable) ([]FileMetadata, map[int][]byte, error) {
+	files := []FileMetadata{}
+	content := make(map[int][]byte)
+	add := func(file FileMetadata) error {
+		if _, ok := content[file.ID]; ok {
+			return nil
+		}
+		data, err := fileService.fileDB.GetFileContentByHash(file.Hash)
+		if err != nil {
+			return fmt.Errorf("error reading file %s: %v", file.OriginalName, err)
+		}
+		files = append(files, file)
+		content[file.ID] = data
+		return nil
+	}
+
+	if company.LogoFileID != nil {
+		logo, err := fileService.fileDB.GetFileMetadataByID(*company.LogoFileID)
+		if err != nil {
+			log.Printf("Warning: Company logo file %d not found: %v", *company.LogoFileID, err)
+		} else if err := add(*logo); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, table := range tables {
+		for entityType, name := range companyFileEntities {
+			idColumn := columnIndex(table, "id")
+			if table.Name != name || idColumn < 0 {
+				continue
+			}
+			for _, row := range table.Rows {
+				id, ok := row[idColumn].(int64)
+				if !ok {
+					continue
+				}
+				attached, err := fileService.GetFilesByEntity(entityType, int(id))
+				if err != nil {
+					return nil, nil, err
+				}
+				for _, file := range attached {
+					if err := add(file); err != nil {
+						return nil, nil, err
+					}
+				}
+			}
+		}
+	}
+	return files, content, nil
+}
+
+// readCompanyArchive reads and checks a company archive. Archives of another format, a newer archive version
+// or a newer schema are refused.
+func readCompanyArchive(archivePath string) (*companyArchive, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a company archive: %v", filepath.Base(archivePath), err)
+	}
+	defer reader.Close()
+
+	entries := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		entries[file.Name] = file
+	}
+	decode := func(name string, value interface{}) error {
+		entry, ok := entries[name]
+		if !ok {
+			return fmt.Errorf("the archive has no %s", name)
+		}
+		r, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		decoder := json.NewDecoder(r)
+		decoder.UseNumber()
+		if err := decoder.Decode(value); err != nil {
+			return fmt.Errorf("%s in the archive is damaged: %v", name, err)
+		}
+		return nil
+	}
+
+	archive := &companyArchive{content: make(map[int][]byte)}
+	if err := decode(companyArchiveManifest, &archive.manifest); err != nil {
+		return nil, err
+	}
+	manifest := archive.manifest
+	if manifest.Format != companyArchiveFormat {
+		return nil, fmt.Errorf("%s is not a company archive", filepath.Base(archivePath))
+	}
+	if manifest.Version > companyArchiveVersion || manifest.SchemaVersion > database.LatestSchemaVersion() {
+		return nil, fmt.Errorf("the archive was exported by a newer version of the application; please update the application")
+	}
+
+	for name, count := range manifest.Tables {
+		var table database.CompanyArchiveTable
+		if err := decode(companyArchiveTables+name+".json", &table); err != nil {
+			return nil, err
+		}
+		if table.Name != name || len(table.Rows) != count {
+			return nil, fmt.Errorf("the %s table in the archive does not match its manifest", name)
+		}
+		archive.tables = append(archive.tables, table)
+	}
+
+	if err := decode(companyArchiveFileList, &archive.files); err != nil {
+		return nil, err
+	}
+	if len(archive.files) != manifest.Files {
+		return nil, fmt.Errorf("the files in the archive do not match its manifest")
+	}
+	for _, file := range archive.files {
+		entry, ok := entries[companyArchiveFiles+strconv.Itoa(file.ID)]
+		if !ok {
+			return nil, fmt.Errorf("the archive is missing file %s", file.OriginalName)
+		}
+		r, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		var data bytes.Buffer
+		_, err = io.Copy(&data, r)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s from the archive: %v", file.OriginalName, err)
+		}
+		archive.content[file.ID] = data.Bytes()
+	}
+	return archive, nil
+}
+
+// InspectCompanyArchive reads and checks a company archive and returns its manifest
+func InspectCompanyArchive(archivePath string) (*CompanyArchiveManifest, error) {
+	archive, err := readCompanyArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	return &archive.manifest, nil
+}
+
+// ImportCompanyArchive adds the company in an archive to the database as a new company, then copies its
+// files into the file database and links them to the new records. A file that cannot be copied is logged
+// and left out; the company itself is imported in one transaction.
+func ImportCompanyArchive(db *database.Database, fileService *FileService, archivePath string) (*database.CompanyImportResult, error) {
+	archive, err := readCompanyArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.ImportCompanyData(archive.tables, archive.manifest.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	if fileService == nil {
+		if len(archive.files) > 0 {
+			log.Printf("Warning: File service not available, %d files of the archive were not imported", len(archive.files))
+		}
+		return result, nil
+	}
+
+	logoID := archivedLogoID(archive.tables)
+	for _, file := range archive.files {
+		entityID := file.EntityID
+		if table, ok := companyFileEntities[file.EntityType]; ok && entityID != 0 {
+			if entityID, ok = result.IDs[table][file.EntityID]; !ok {
+				continue
+			}
+		}
+		imported, err := fileService.ImportFile(file, archive.content[file.ID], entityID)
+		if err != nil {
+			log.Printf("Warning: Could not import file %s: %v", file.OriginalName, err)
+			continue
+		}
+		result.Files++
+		if file.ID == logoID {
+			if err := db.SetCompanyLogoFile(result.CompanyID, imported.ID); err != nil {
+				log.Printf("Warning: Could not set the imported company logo: %v", err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// archivedLogoID returns the file id of the archived company's logo, or 0 when it has none
+func archivedLogoID(tables []database.CompanyArchiveTable) int {
+	for _, table := range tables {
+		column := columnIndex(table, "logo_file_id")
+		if table.Name != "companies" || len(table.Rows) != 1 || column < 0 {
+			continue
+		}
+		if number, ok := table.Rows[0][column].(json.Number); ok {
+			id, _ := number.Int64()
+			return int(id)
+		}
+	}
+	return 0
+}
+
+// columnIndex returns the position of a column in an archived table, or -1
+func columnIndex(table database.CompanyArchiveTable, name string) int {
+	for i, column := range table.Columns {
+		if column == name {
+			return i
+		}
+	}
+	return -1
+}
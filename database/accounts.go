@@ -0,0 +1,365 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Account roles used when posting documents to the general ledger
+const (
+	AccountRoleReceivable       = "receivable"
+	AccountRolePayable          = "payable"
+	AccountRoleRevenue          = "revenue"
+	AccountRoleOutputVAT        = "output_vat"
+	AccountRoleInputVAT         = "input_vat"
+	AccountRoleInventory        = "inventory"
+	AccountRolePurchases        = "purchases"
+	AccountRoleCustomerAdvances = "customer_advances"
+	AccountRoleCash             = "cash"
+	AccountRoleBank             = "bank"
+	AccountRoleCard             = "card"
+	AccountRoleCOGS             = "cogs"
+	AccountRoleExpenses         = "expenses"
+	AccountRoleRetainedEarnings = "retained_earnings"
+
+	// AccountRolePayment is the role of a payment type mapping: where money paid with it is kept
+	AccountRolePayment = "payment"
+)
+
+// accountTypes are the valid account types
+var accountTypes = map[string]bool{"asset": true, "liability": true, "equity": true, "revenue": true, "expense": true}
+
+// defaultChartOfAccounts is created for every company the first time its ledger is used
+var defaultChartOfAccounts = []struct {
+	Code, Name, NameArabic, Type, Role string
+}{
+	{"1100", "Cash on Hand", "النقدية في الصندوق", "asset", AccountRoleCash},
+	{"1110", "Bank", "البنك", "asset", AccountRoleBank},
+	{"1120", "Card Settlements", "مستحقات البطاقات", "asset", AccountRoleCard},
+	{"1200", "Accounts Receivable", "الذمم المدينة", "asset", AccountRoleReceivable},
+	{"1300", "Inventory", "المخزون", "asset", AccountRoleInventory},
+	{"1400", "Input VAT", "ضريبة القيمة المضافة على المشتريات", "asset", AccountRoleInputVAT},
+	{"2100", "Accounts Payable", "الذمم الدائنة", "liability", AccountRolePayable},
+	{"2200", "Output VAT", "ضريبة القيمة المضافة على المبيعات", "liability", AccountRoleOutputVAT},
+	{"2300", "Customer Advances", "دفعات مقدمة من العملاء", "liability", AccountRoleCustomerAdvances},
+	{"3100", "Owner's Capital", "رأس المال", "equity", ""},
+	{"3200", "Retained Earnings", "الأرباح المبقاة", "equity", AccountRoleRetainedEarnings},
+	{"4100", "Sales Revenue", "إيرادات المبيعات", "revenue", AccountRoleRevenue},
+	{"5100", "Purchases", "المشتريات", "expense", AccountRolePurchases},
+	{"5200", "Cost of Goods Sold", "تكلفة البضاعة المباعة", "expense", AccountRoleCOGS},
+	{"6100", "General Expenses", "مصروفات عامة", "expense", AccountRoleExpenses},
+}
+
+// paymentTypeRoles gives the default account role of the built-in payment types; others go to the bank
+var paymentTypeRoles = map[string]string{
+	"cash": AccountRoleCash,
+	"card": AccountRoleCard,
+}
+
+// ensureChartOfAccounts creates the default chart of accounts and default mappings for a company that has none
+func ensureChartOfAccounts(exec execer, companyID int) error {
+	var count int
+	if err := exec.QueryRow(`SELECT COUNT(*) FROM accounts WHERE company_id = ?`, companyID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, account := range defaultChartOfAccounts {
+		result, err := exec.Exec(`INSERT INTO accounts (company_id, code, name, name_arabic, type, is_system, is_active) VALUES (?, ?, ?, ?, ?, 1, 1)`,
+			companyID, account.Code, account.Name, account.NameArabic, account.Type)
+		if err != nil {
+			return fmt.Errorf("error creating default account %s: %v", account.Code, err)
+		}
+		if account.Role == "" {
+			continue
+		}
+		accountID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		_, err = exec.Exec(`INSERT INTO account_mappings (company_id, entity_type, entity_id, role, account_id) VALUES (?, 'default', 0, ?, ?)`,
+			companyID, account.Role, accountID)
+		if err != nil {
+			return fmt.Errorf("error creating default account mapping %s: %v", account.Role, err)
+		}
+	}
+	return nil
+}
+
+// resolveAccount returns the account for a role, preferring a mapping for the given entity over the
+// company default. An entity type of "default" looks up the company default only.
+func resolveAccount(exec execer, companyID int, entityType string, entityID int, role string) (int, error) {
+	if err := ensureChartOfAccounts(exec, companyID); err != nil {
+		return 0, err
+	}
+
+	var accountID int
+	err := exec.QueryRow(`
+		SELECT account_id FROM account_mappings
+		WHERE company_id = ? AND role = ? AND ((entity_type = ? AND entity_id = ?) OR entity_type = 'default')
+		ORDER BY CASE WHEN entity_type = 'default' THEN 1 ELSE 0 END
+		LIMIT 1`, companyID, role, entityType, entityID).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no account is mapped to %s for company %d", role, companyID)
+	}
+	return accountID, err
+}
+
+// resolvePaymentAccount returns the account money paid with a payment type is kept in. Without a mapping,
+// cash and card fall back to the cash and card accounts and every other type to the bank.
+func resolvePaymentAccount(exec execer, companyID, paymentTypeID int) (int, error) {
+	if err := ensureChartOfAccounts(exec, companyID); err != nil {
+		return 0, err
+	}
+
+	var accountID int
+	err := exec.QueryRow(`SELECT account_id FROM account_mappings WHERE company_id = ? AND entity_type = 'payment_type' AND entity_id = ? AND role = ?`,
+		companyID, paymentTypeID, AccountRolePayment).Scan(&accountID)
+	if err == nil {
+		return accountID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var code string
+	if err := exec.QueryRow(`SELECT COALESCE(code, '') FROM payment_types WHERE id = ?`, paymentTypeID).Scan(&code); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	role, ok := paymentTypeRoles[code]
+	if !ok {
+		role = AccountRoleBank
+	}
+	return resolveAccount(exec, companyID, "default", 0, role)
+}
+
+// resolveTaxAccount returns the VAT account for a rate, using the mapping of the company's tax rate with that rate if any
+func resolveTaxAccount(exec execer, companyID int, rate float64, role string) (int, error) {
+	var taxRateID int
+	err := exec.QueryRow(`SELECT id FROM tax_rates WHERE company_id = ? AND ABS(rate - ?) < 0.001 ORDER BY is_active DESC, id LIMIT 1`,
+		companyID, rate).Scan(&taxRateID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	return resolveAccount(exec, companyID, "tax_rate", taxRateID, role)
+}
+
+// GetAccountsByCompany retrieves the chart of accounts of a company, creating the default chart if it has none
+func (d *Database) GetAccountsByCompany(companyID int) ([]Account, error) {
+	if err := ensureChartOfAccounts(d.db, companyID); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Query(`
+		SELECT id, company_id, code, name, COALESCE(name_arabic, ''), type, parent_id, is_system, is_active, created_at, updated_at
+		FROM accounts WHERE company_id = ? ORDER BY code`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.ID, &a.CompanyID, &a.Code, &a.Name, &a.NameArabic, &a.Type, &a.ParentID, &a.IsSystem, &a.IsActive,
+			&a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// GetAccountByID retrieves an account
+func (d *Database) GetAccountByID(id int) (*Account, error) {
+	var a Account
+	err := d.db.QueryRow(`
+		SELECT id, company_id, code, name, COALESCE(name_arabic, ''), type, parent_id, is_system, is_active, created_at, updated_at
+		FROM accounts WHERE id = ?`, id).
+		Scan(&a.ID, &a.CompanyID, &a.Code, &a.Name, &a.NameArabic, &a.Type, &a.ParentID, &a.IsSystem, &a.IsActive, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// CreateAccount adds an account to a company's chart of accounts
+func (d *Database) CreateAccount(account *Account) error {
+	if !accountTypes[account.Type] {
+		return fmt.Errorf("invalid account type %q", account.Type)
+	}
+	if account.Code == "" || account.Name == "" {
+		return fmt.Errorf("account code and name are required")
+	}
+
+	// Default to company 1 for backward compatibility
+	if account.CompanyID == 0 {
+		account.CompanyID = 1
+	}
+	if err := ensureChartOfAccounts(d.db, account.CompanyID); err != nil {
+		return err
+	}
+
+	result, err := d.db.Exec(`INSERT INTO accounts (company_id, code, name, name_arabic, type, parent_id, is_system, is_active) VALUES (?, ?, ?, ?, ?, ?, 0, ?)`,
+		account.CompanyID, account.Code, account.Name, account.NameArabic, account.Type, account.ParentID, account.IsActive)
+	if err != nil {
+		return fmt.Errorf("error creating account: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	account.ID = int(id)
+	return nil
+}
+
+// UpdateAccount updates an account. The type of an account with postings cannot change.
+func (d *Database) UpdateAccount(account *Account) error {
+	if !accountTypes[account.Type] {
+		return fmt.Errorf("invalid account type %q", account.Type)
+	}
+	if account.ParentID != nil && *account.ParentID == account.ID {
+		return fmt.Errorf("an account cannot be its own parent")
+	}
+
+	var currentType string
+	var postings int
+	err := d.db.QueryRow(`SELECT type, (SELECT COUNT(*) FROM journal_lines WHERE account_id = accounts.id) FROM accounts WHERE id = ?`, account.ID).
+		Scan(&currentType, &postings)
+	if err != nil {
+		return err
+	}
+	if currentType != account.Type && postings > 0 {
+		return fmt.Errorf("the type of an account with postings cannot be changed")
+	}
+
+	_, err = d.db.Exec(`UPDATE accounts SET code = ?, name = ?, name_arabic = ?, type = ?, parent_id = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		account.Code, account.Name, account.NameArabic, account.Type, account.ParentID, account.IsActive, account.ID)
+	return err
+}
+
+// DeleteAccount deletes an account that has no postings, is not mapped and is not part of the default chart
+func (d *Database) DeleteAccount(id int) error {
+	var isSystem bool
+	var postings, mappings, children int
+	err := d.db.QueryRow(`
+		SELECT is_system,
+			(SELECT COUNT(*) FROM journal_lines WHERE account_id = a.id),
+			(SELECT COUNT(*) FROM account_mappings WHERE account_id = a.id),
+			(SELECT COUNT(*) FROM accounts WHERE parent_id = a.id)
+		FROM accounts a WHERE a.id = ?`, id).Scan(&isSystem, &postings, &mappings, &children)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case isSystem:
+		return fmt.Errorf("accounts of the default chart cannot be deleted; deactivate the account instead")
+	case postings > 0:
+		return fmt.Errorf("the account has %d postings and cannot be deleted", postings)
+	case mappings > 0:
+		return fmt.Errorf("the account is used in %d account mappings", mappings)
+	case children > 0:
+		return fmt.Errorf("the account has %d sub-accounts", children)
+	}
+
+	_, err = d.db.Exec(`DELETE FROM accounts WHERE id = ?`, id)
+	return err
+}
+
+// GetAccountMappings retrieves the account mappings of a company
+func (d *Database) GetAccountMappings(companyID int) ([]AccountMapping, error) {
+	if err := ensureChartOfAccounts(d.db, companyID); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Query(`
+		SELECT m.id, m.company_id, m.entity_type, m.entity_id, m.role, m.account_id, a.code, a.name
+		FROM account_mappings m
+		JOIN accounts a ON m.account_id = a.id
+		WHERE m.company_id = ?
+		ORDER BY m.entity_type, m.entity_id, m.role`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappings []AccountMapping
+	for rows.Next() {
+		var m AccountMapping
+		if err := rows.Scan(&m.ID, &m.CompanyID, &m.EntityType, &m.EntityID, &m.Role, &m.AccountID, &m.AccountCode, &m.AccountName); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// SetAccountMapping creates or replaces the account used for a role of a sales category, tax rate,
+// payment type, expense category or the company default
+func (d *Database) SetAccountMapping(mapping *AccountMapping) error {
+	switch mapping.EntityType {
+	case "default":
+		mapping.EntityID = 0
+	case "sales_category", "tax_rate", "payment_type", "expense_category":
+		if mapping.EntityID == 0 {
+			return fmt.Errorf("a %s mapping needs the id of the %s", mapping.EntityType, mapping.EntityType)
+		}
+	default:
+		return fmt.Errorf("invalid mapping type %q", mapping.EntityType)
+	}
+	if mapping.EntityType == "payment_type" {
+		mapping.Role = AccountRolePayment
+	}
+	if mapping.Role == "" {
+		return fmt.Errorf("a mapping role is required")
+	}
+
+	// Default to company 1 for backward compatibility
+	if mapping.CompanyID == 0 {
+		mapping.CompanyID = 1
+	}
+
+	var accountCompanyID int
+	var active bool
+	err := d.db.QueryRow(`SELECT company_id, is_active FROM accounts WHERE id = ?`, mapping.AccountID).Scan(&accountCompanyID, &active)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("account %d not found", mapping.AccountID)
+	}
+	if err != nil {
+		return err
+	}
+	if accountCompanyID != mapping.CompanyID {
+		return fmt.Errorf("the account belongs to a different company")
+	}
+	if !active {
+		return fmt.Errorf("inactive accounts cannot be mapped")
+	}
+
+	_, err = d.db.Exec(`
+		INSERT INTO account_mappings (company_id, entity_type, entity_id, role, account_id) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (company_id, entity_type, entity_id, role) DO UPDATE SET account_id = excluded.account_id`,
+		mapping.CompanyID, mapping.EntityType, mapping.EntityID, mapping.Role, mapping.AccountID)
+	if err != nil {
+		return err
+	}
+
+	return d.db.QueryRow(`SELECT id FROM account_mappings WHERE company_id = ? AND entity_type = ? AND entity_id = ? AND role = ?`,
+		mapping.CompanyID, mapping.EntityType, mapping.EntityID, mapping.Role).Scan(&mapping.ID)
+}
+
+// DeleteAccountMapping removes an override; company defaults cannot be removed, only changed
+func (d *Database) DeleteAccountMapping(id int) error {
+	var entityType string
+	if err := d.db.QueryRow(`SELECT entity_type FROM account_mappings WHERE id = ?`, id).Scan(&entityType); err != nil {
+		return err
+	}
+	if entityType == "default" {
+		return fmt.Errorf("default account mappings cannot be removed")
+	}
+	_, err := d.db.Exec(`DELETE FROM account_mappings WHERE id = ?`, id)
+	return err
+}
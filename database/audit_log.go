@@ -0,0 +1,328 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Audit log actions
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionRestore = "restore"
+)
+
+// auditSkippedTables are not audited: the bookkeeping of migrations and of the audit log itself, the
+// stock cost layers, which are derived from invoices and rebuilt whenever costs are recalculated, and the
+// search index, which is derived from the records it finds
+var auditSkippedTables = map[string]bool{
+	"schema_migrations":       true,
+	"audit_log":               true,
+	"audit_session":           true,
+	"stock_cost_layers":       true,
+	"stock_cost_consumptions": true,
+	"search_index":            true,
+}
+
+// auditRedactedColumns are logged as changed without their values
+var auditRedactedColumns = map[string]map[string]bool{
+	"users": {"password": true},
+}
+
+const auditRedacted = "[redacted]"
+
+// createAuditLog creates the append-only audit log and the session row its triggers read the signed in
+// user from. The triggers themselves are installed by migrate once the schema is up to date.
+func createAuditLog(tx *sql.Tx) error {
+	statements := []string{
+		`CREATE TABLE audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER,
+			user_id INTEGER,
+			username TEXT,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			changes TEXT NOT NULL DEFAULT '{}',
+			created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+		)`,
+		`CREATE INDEX idx_audit_log_company_created ON audit_log(company_id, created_at)`,
+		`CREATE INDEX idx_audit_log_entity ON audit_log(entity_type, entity_id)`,
+		`CREATE INDEX idx_audit_log_user ON audit_log(user_id)`,
+		`CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END`,
+		`CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END`,
+		`CREATE TABLE audit_session (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			user_id INTEGER,
+			company_id INTEGER
+		)`,
+		`INSERT INTO audit_session (id) VALUES (1)`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("error creating audit log: %v", err)
+		}
+	}
+	return nil
+}
+
+// refreshAuditTriggers rebuilds the audit triggers of every table. A trigger lists the columns of its table,
+// so the triggers are rebuilt after every schema change.
+func (d *Database) refreshAuditTriggers() error {
+	var exists int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'audit_log'`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := installAuditTriggers(tx); err != nil {
+		return fmt.Errorf("error installing audit triggers: %v", err)
+	}
+	return tx.Commit()
+}
+
+// installAuditTriggers replaces the triggers that log every insert, update and delete of the audited tables
+// to the audit log, in the transaction of the change
+func installAuditTriggers(tx *sql.Tx) error {
+	triggers, err := queryNames(tx, `SELECT name FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'audit_%' AND tbl_name != 'audit_log'`)
+	if err != nil {
+		return err
+	}
+	for _, trigger := range triggers {
+		if _, err := tx.Exec(fmt.Sprintf(`DROP TRIGGER "%s"`, trigger)); err != nil {
+			return err
+		}
+	}
+
+	// Virtual tables and the shadow tables that hold their data cannot have triggers
+	tables, err := queryNames(tx, `
+		SELECT t.name FROM sqlite_master t
+		WHERE t.type = 'table' AND t.name NOT LIKE 'sqlite_%' AND t.sql NOT LIKE 'CREATE VIRTUAL TABLE%'
+			AND NOT EXISTS (SELECT 1 FROM sqlite_master v WHERE v.sql LIKE 'CREATE VIRTUAL TABLE%' AND t.name LIKE v.name || '\_%' ESCAPE '\')
+		ORDER BY t.name`)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if auditSkippedTables[table] {
+			continue
+		}
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return err
+		}
+		for _, trigger := range auditTriggers(table, columns) {
+			if _, err := tx.Exec(trigger); err != nil {
+				return fmt.Errorf("error creating audit trigger on %s: %v", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// auditTriggers returns the insert, update and delete triggers of a table. Changes are logged as a JSON
+// object of the changed columns with their value before and after. updated_at and version are left out of
+// updates, so the trigger raising a version does not log a second update.
+func auditTriggers(table string, columns []string) []string {
+	has := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		has[column] = true
+	}
+	company := func(row string) string {
+		switch {
+		case table == "companies":
+			return row + ".id"
+		case has["company_id"]:
+			return fmt.Sprintf("COALESCE(%s.company_id, (SELECT company_id FROM audit_session))", row)
+		}
+		return "(SELECT company_id FROM audit_session)"
+	}
+	// BLOBs cannot be held in JSON and are logged as hex
+	value := func(row, column string) string {
+		if auditRedactedColumns[table][column] {
+			return "'" + auditRedacted + "'"
+		}
+		return fmt.Sprintf(`CASE WHEN typeof(%[1]s."%[2]s") = 'blob' THEN hex(%[1]s."%[2]s") ELSE %[1]s."%[2]s" END`, row, column)
+	}
+	insert := func(action, row, changes string) string {
+		return fmt.Sprintf(`INSERT INTO audit_log (company_id, user_id, username, entity_type, entity_id, action, changes)
+			VALUES (%s, (SELECT user_id FROM audit_session), (SELECT u.username FROM audit_session s JOIN users u ON u.id = s.user_id),
+				'%s', %s.rowid, %s, json_patch('{}', json_object(%s)));`, company(row), table, row, action, changes)
+	}
+
+	var created, deleted, updated, changed []string
+	for _, column := range columns {
+		created = append(created, fmt.Sprintf(`'%[1]s', CASE WHEN NEW."%[1]s" IS NOT NULL THEN json_object('after', %[2]s) END`, column, value("NEW", column)))
+		deleted = append(deleted, fmt.Sprintf(`'%[1]s', CASE WHEN OLD."%[1]s" IS NOT NULL THEN json_object('before', %[2]s) END`, column, value("OLD", column)))
+		if column == "updated_at" || column == "version" {
+			continue
+		}
+		updated = append(updated, fmt.Sprintf(`'%[1]s', CASE WHEN OLD."%[1]s" IS NOT NEW."%[1]s" THEN json_object('before', %[2]s, 'after', %[3]s) END`,
+			column, value("OLD", column), value("NEW", column)))
+		changed = append(changed, fmt.Sprintf(`OLD."%[1]s" IS NOT NEW."%[1]s"`, column))
+	}
+
+	updateAction := "'" + AuditActionUpdate + "'"
+	if has["deleted_at"] {
+		updateAction = fmt.Sprintf(`CASE WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN '%s'
+			WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN '%s' ELSE '%s' END`,
+			AuditActionDelete, AuditActionRestore, AuditActionUpdate)
+	}
+
+	triggers := []string{
+		fmt.Sprintf(`CREATE TRIGGER "audit_%[1]s_insert" AFTER INSERT ON "%[1]s" BEGIN %[2]s END`,
+			table, insert("'"+AuditActionCreate+"'", "NEW", strings.Join(created, ", "))),
+		fmt.Sprintf(`CREATE TRIGGER "audit_%[1]s_delete" AFTER DELETE ON "%[1]s" BEGIN %[2]s END`,
+			table, insert("'"+AuditActionDelete+"'", "OLD", strings.Join(deleted, ", "))),
+	}
+	if len(changed) > 0 {
+		triggers = append(triggers, fmt.Sprintf(`CREATE TRIGGER "audit_%[1]s_update" AFTER UPDATE ON "%[1]s" WHEN %[2]s BEGIN %[3]s END`,
+			table, strings.Join(changed, " OR "), insert(updateAction, "NEW", strings.Join(updated, ", "))))
+	}
+	return triggers
+}
+
+func queryNames(exec execer, query string, args ...interface{}) ([]string, error) {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// SetAuditUser makes the audit log attribute the changes that follow to a user of a company
+func (d *Database) SetAuditUser(userID, companyID int) error {
+	_, err := d.db.Exec(`UPDATE audit_session SET user_id = ?, company_id = ? WHERE id = 1`, userID, companyID)
+	return err
+}
+
+// ClearAuditUser logs the changes that follow without a user, e.g. after signing out
+func (d *Database) ClearAuditUser() error {
+	_, err := d.db.Exec(`UPDATE audit_session SET user_id = NULL, company_id = NULL WHERE id = 1`)
+	return err
+}
+
+// GetAuditLog retrieves the audit log entries matching a filter, latest first
+func (d *Database) GetAuditLog(filter AuditLogFilter) ([]AuditLogEntry, error) {
+	where := []string{"1 = 1"}
+	args := []interface{}{}
+	if filter.CompanyID != 0 {
+		where = append(where, "company_id = ?")
+		args = append(args, filter.CompanyID)
+	}
+	if filter.EntityType != "" {
+		where = append(where, "entity_type = ?")
+		args = append(args, filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		where = append(where, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "DATE(created_at) >= DATE(?)")
+		args = append(args, dateOnly(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "DATE(created_at) <= DATE(?)")
+		args = append(args, dateOnly(filter.To))
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit, filter.Offset)
+
+	rows, err := d.db.Query(`
+		SELECT id, company_id, user_id, COALESCE(username, ''), entity_type, entity_id, action, changes, created_at
+		FROM audit_log
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditLogEntry{}
+	for rows.Next() {
+		var entry AuditLogEntry
+		var companyID, userID sql.NullInt64
+		var changes string
+		if err := rows.Scan(&entry.ID, &companyID, &userID, &entry.Username, &entry.EntityType, &entry.EntityID,
+			&entry.Action, &changes, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if companyID.Valid {
+			id := int(companyID.Int64)
+			entry.CompanyID = &id
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			entry.UserID = &id
+		}
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			return nil, fmt.Errorf("audit log entry %d is damaged: %v", entry.ID, err)
+		}
+		entry.ChangeSummary = auditChangeSummary(entry.Action, entry.Changes)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// auditChangeSummary describes changes in one line, e.g. "name: Old → New; phone:  → 0500000000"
+func auditChangeSummary(action string, changes map[string]AuditChange) string {
+	columns := make([]string, 0, len(changes))
+	for column := range changes {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	text := func(value interface{}) string {
+		if value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+	parts := make([]string, 0, len(columns))
+	for _, column := range columns {
+		change := changes[column]
+		switch action {
+		case AuditActionCreate:
+			parts = append(parts, fmt.Sprintf("%s: %s", column, text(change.After)))
+		case AuditActionDelete:
+			if change.After == nil {
+				parts = append(parts, fmt.Sprintf("%s: %s", column, text(change.Before)))
+				continue
+			}
+			fallthrough
+		default:
+			parts = append(parts, fmt.Sprintf("%s: %s → %s", column, text(change.Before), text(change.After)))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BackupTo writes a consistent snapshot of the database to path
+func (d *Database) BackupTo(path string) error {
+	return BackupSQLite(d.db, path)
+}
+
+// BackupSQLite writes a snapshot of an open SQLite database to path with VACUUM INTO. The copy is read in a
+// single transaction, so it is consistent while the application keeps writing, and it comes out compacted.
+func BackupSQLite(db *sql.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup file %s already exists", path)
+	}
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("error writing backup %s: %v", path, err)
+	}
+	return nil
+}
+
+// openReadOnly opens a database file that must not be changed, such as a backup being inspected
+func openReadOnly(path string) (*sql.DB, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	uri := url.URL{Scheme: "file", Path: filepath.ToSlash(absolute), RawQuery: "mode=ro"}
+	return sql.Open("sqlite3", uri.String())
+}
+
+// VerifyBackupFile opens a backup read-only and runs SQLite's integrity check on it
+func VerifyBackupFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("backup file not found: %v", err)
+	}
+	db, err := openReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return verifyIntegrity(db, path)
+}
+
+// VerifyBackupData runs SQLite's integrity check on a database image held in memory
+func VerifyBackupData(image []byte) error {
+	db, err := deserialize(image)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return verifyIntegrity(db, "backup")
+}
+
+// verifyIntegrity runs SQLite's integrity check on a backup opened under name
+func verifyIntegrity(db *sql.DB, name string) error {
+	problems, err := integrityProblems(db)
+	if err != nil {
+		return fmt.Errorf("error checking %s: %v", name, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s failed the integrity check: %s", name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// integrityProblems runs SQLite's integrity check and returns the problems it reports
+func integrityProblems(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// InspectBackup checks a backup of the application database and summarises what it holds so it can be
+// confirmed before it is restored. The backup is opened read-only. A backup from a newer version of the
+// application is refused with a SchemaTooNewError; older backups are migrated when they are opened after
+// the restore.
+func InspectBackup(path string) (*BackupSummary, error) {
+	if err := VerifyBackupFile(path); err != nil {
+		return nil, err
+	}
+	db, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return inspectBackupDB(db, path)
+}
+
+// InspectBackupData checks and summarises a backup held in memory, such as a decrypted one
+func InspectBackupData(path string, image []byte) (*BackupSummary, error) {
+	if err := VerifyBackupData(image); err != nil {
+		return nil, err
+	}
+	db, err := deserialize(image)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return inspectBackupDB(db, path)
+}
+
+// inspectBackupDB summarises an opened backup
+func inspectBackupDB(db *sql.DB, path string) (*BackupSummary, error) {
+	summary := &BackupSummary{Path: path}
+	var tables int
+	var err error
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('companies', 'sales_invoices', 'purchase_invoices')").Scan(&tables)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup: %v", err)
+	}
+	if tables < 3 {
+		return nil, fmt.Errorf("%s is not a DijiBill database backup", filepath.Base(path))
+	}
+
+	var versioned int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&versioned); err != nil {
+		return nil, err
+	}
+	if versioned > 0 {
+		if summary.SchemaVersion, err = schemaVersion(db); err != nil {
+			return nil, err
+		}
+	}
+	if summary.SchemaVersion > LatestSchemaVersion() {
+		return nil, &SchemaTooNewError{DatabaseVersion: summary.SchemaVersion, SupportedVersion: LatestSchemaVersion()}
+	}
+
+	rows, err := db.Query("SELECT id, name, COALESCE(name_arabic, '') FROM companies ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup companies: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var company BackupCompany
+		if err := rows.Scan(&company.ID, &company.Name, &company.NameArabic); err != nil {
+			return nil, err
+		}
+		summary.Companies = append(summary.Companies, company)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := []struct {
+		table string
+		count *int
+	}{
+		{"customers", &summary.CustomerCount},
+		{"products", &summary.ProductCount},
+		{"sales_invoices", &summary.SalesInvoiceCount},
+		{"purchase_invoices", &summary.PurchaseInvoiceCount},
+	}
+	for _, c := range counts {
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", c.table)).Scan(c.count); err != nil {
+			return nil, fmt.Errorf("error counting %s in backup: %v", c.table, err)
+		}
+	}
+
+	var first, last sql.NullString
+	err = db.QueryRow(`SELECT DATE(MIN(issue_date)), DATE(MAX(issue_date)) FROM (
+		SELECT issue_date FROM sales_invoices UNION ALL SELECT issue_date FROM purchase_invoices)`).Scan(&first, &last)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup date range: %v", err)
+	}
+	if first.Valid && last.Valid {
+		from, errFrom := time.Parse("2006-01-02", first.String)
+		to, errTo := time.Parse("2006-01-02", last.String)
+		if errFrom == nil && errTo == nil {
+			summary.FirstDocumentDate = &from
+			summary.LastDocumentDate = &to
+		}
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,619 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// DefaultBankMatchWindowDays is how far apart a booking and a payment date may be to match on amount alone
+	DefaultBankMatchWindowDays = 5
+
+	// bankReferenceLookbackDays is how far back payments are searched when the statement line quotes their reference
+	bankReferenceLookbackDays = 90
+
+	// bankAutoMatchMinScore is the lowest score suggested automatically; an amount match on the same day scores 60
+	bankAutoMatchMinScore = 60
+)
+
+// bankMatchTypeOrder ranks candidates with equal scores: existing payments before open invoices
+var bankMatchTypeOrder = map[string]int{
+	"payment":          0,
+	"supplier_payment": 0,
+	"invoice":          1,
+	"purchase_invoice": 1,
+}
+
+// normalizeMatchText uppercases text and drops everything but letters and digits, so that
+// "INV SI-000012" and "si000012" compare equal
+func normalizeMatchText(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// queryBankCandidates reads candidates from a query returning id, number, party name, Arabic party name,
+// date, amount and reference
+func queryBankCandidates(exec execer, matchType, query string, args ...interface{}) ([]BankMatchCandidate, error) {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []BankMatchCandidate
+	for rows.Next() {
+		c := BankMatchCandidate{MatchType: matchType}
+		var date sql.NullTime
+		if err := rows.Scan(&c.ID, &c.Number, &c.PartyName, &c.PartyNameAr, &date, &c.Amount, &c.Reference); err != nil {
+			return nil, err
+		}
+		c.Date = date.Time
+		c.Amount = roundAmount(c.Amount)
+		if c.Amount < 0.005 {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// bankMatchCandidates returns the unreconciled payments and open invoices that may correspond to a
+// statement line, best match first. Money received is matched to customer payments and sales invoices,
+// money paid out to supplier payments and purchase invoices.
+func bankMatchCandidates(exec execer, line *BankStatementLine, windowDays int) ([]BankMatchCandidate, error) {
+	from := dateOnly(line.BookingDate.AddDate(0, 0, -bankReferenceLookbackDays))
+	to := dateOnly(line.BookingDate.AddDate(0, 0, windowDays))
+	bookingDate := dateOnly(line.BookingDate)
+
+	var payments, invoices []BankMatchCandidate
+	var err error
+	if line.Amount > 0 {
+		payments, err = queryBankCandidates(exec, "payment", `
+			SELECT p.id, si.invoice_number, COALESCE(c.name, ''), COALESCE(c.name_arabic, ''), p.payment_date, p.amount, COALESCE(p.reference, '')
+			FROM payments p
+			JOIN sales_invoices si ON p.invoice_id = si.id
+			LEFT JOIN customers c ON si.customer_id = c.id
+			WHERE p.company_id = ? AND p.status = 'completed' AND p.deleted_at IS NULL AND DATE(p.payment_date) BETWEEN DATE(?) AND DATE(?)
+				AND NOT EXISTS (SELECT 1 FROM bank_statement_lines bsl WHERE bsl.payment_id = p.id AND bsl.status = 'reconciled')`,
+			line.CompanyID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		invoices, err = queryBankCandidates(exec, "invoice", `
+			SELECT si.id, si.invoice_number, COALESCE(c.name, ''), COALESCE(c.name_arabic, ''), si.due_date, `+salesInvoiceOutstandingSQL+`, ''
+			FROM sales_invoices si
+			LEFT JOIN customers c ON si.customer_id = c.id
+			WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+				AND DATE(si.issue_date) <= DATE(?)`,
+			line.CompanyID, bookingDate)
+	} else {
+		payments, err = queryBankCandidates(exec, "supplier_payment", `
+			SELECT sp.id, sp.payment_number, COALESCE(s.company_name, ''), COALESCE(s.company_name_arabic, ''), sp.payment_date, sp.amount, COALESCE(sp.reference, '')
+			FROM supplier_payments sp
+			LEFT JOIN suppliers s ON sp.supplier_id = s.id
+			WHERE sp.company_id = ? AND sp.status = 'completed' AND sp.deleted_at IS NULL AND DATE(sp.payment_date) BETWEEN DATE(?) AND DATE(?)
+				AND NOT EXISTS (SELECT 1 FROM bank_statement_lines bsl WHERE bsl.supplier_payment_id = sp.id AND bsl.status = 'reconciled')`,
+			line.CompanyID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		invoices, err = queryBankCandidates(exec, "purchase_invoice", `
+			SELECT pi.id, pi.invoice_number, COALESCE(s.company_name, ''), COALESCE(s.company_name_arabic, ''), pi.due_date,
+				pi.total_amount - `+purchaseInvoicePaidAmountSQL+`, ''
+			FROM purchase_invoices pi
+			LEFT JOIN suppliers s ON pi.supplier_id = s.id
+			WHERE pi.company_id = ? AND pi.status IN ('received', 'partially_paid') AND DATE(pi.issue_date) <= DATE(?)`,
+			line.CompanyID, bookingDate)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []BankMatchCandidate
+	for _, c := range append(payments, invoices...) {
+		if scoreBankCandidate(line, &c, windowDays) {
+			matches = append(matches, c)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return bankMatchTypeOrder[matches[i].MatchType] < bankMatchTypeOrder[matches[j].MatchType]
+	})
+	return matches, nil
+}
+
+// scoreBankCandidate scores how well a candidate fits a statement line and reports whether it is a
+// candidate at all. Payments need the same amount within the date window or their reference quoted on
+// the line; invoices need their outstanding amount, or their number quoted for a part payment.
+func scoreBankCandidate(line *BankStatementLine, c *BankMatchCandidate, windowDays int) bool {
+	amount := roundAmount(math.Abs(line.Amount))
+	text := normalizeMatchText(line.Reference + " " + line.Description + " " + line.BankReference)
+	party := normalizeMatchText(line.CounterpartyName + " " + line.Description)
+
+	amountMatch := math.Abs(amount-c.Amount) < 0.005
+	referenceMatch := false
+	if number := normalizeMatchText(c.Number); number != "" && strings.Contains(text, number) {
+		referenceMatch = true
+	}
+	if reference := normalizeMatchText(c.Reference); len(reference) >= 4 && strings.Contains(text, reference) {
+		referenceMatch = true
+	}
+
+	days := -1
+	if !c.Date.IsZero() {
+		days = daysBetween(c.Date, line.BookingDate)
+		if days < 0 {
+			days = -days
+		}
+	}
+	inWindow := days >= 0 && days <= windowDays
+
+	isPayment := c.MatchType == "payment" || c.MatchType == "supplier_payment"
+	if isPayment && !(amountMatch && inWindow) && !referenceMatch {
+		return false
+	}
+	if !isPayment && !amountMatch && !(referenceMatch && amount <= c.Amount+0.005) {
+		return false
+	}
+
+	c.Score = 0
+	c.Reasons = []string{}
+	if amountMatch {
+		c.Score += 50
+		c.Reasons = append(c.Reasons, "amount")
+	}
+	if referenceMatch {
+		c.Score += 40
+		c.Reasons = append(c.Reasons, "reference")
+	}
+	if inWindow {
+		c.Score += 10 - days*10/(windowDays+1)
+		c.Reasons = append(c.Reasons, "date")
+	}
+	if name := normalizeMatchText(c.PartyName); len(name) >= 3 && strings.Contains(party, name) {
+		c.Score += 10
+		c.Reasons = append(c.Reasons, "name")
+	}
+	c.Score = min(c.Score, 100)
+	return true
+}
+
+// GetBankMatchCandidates returns the payments and open invoices that may correspond to a statement line,
+// best match first, for review
+func (d *Database) GetBankMatchCandidates(lineID, windowDays int) ([]BankMatchCandidate, error) {
+	line, err := d.GetBankStatementLineByID(lineID)
+	if err != nil {
+		return nil, err
+	}
+	if windowDays <= 0 {
+		windowDays = DefaultBankMatchWindowDays
+	}
+	return bankMatchCandidates(d.db, line, windowDays)
+}
+
+// AutoMatchBankStatement suggests a match for every open line of a statement whose best candidate scores
+// well enough and is not tied with another. Suggestions still have to be confirmed. Returns the number of
+// lines with a suggestion.
+func (d *Database) AutoMatchBankStatement(statementID, windowDays int) (int, error) {
+	if windowDays <= 0 {
+		windowDays = DefaultBankMatchWindowDays
+	}
+
+	// Earlier suggestions of this statement are recalculated
+	_, err := d.db.Exec(`UPDATE bank_statement_lines SET status = 'unmatched', match_type = NULL, payment_id = NULL, supplier_payment_id = NULL,
+		invoice_id = NULL, purchase_invoice_id = NULL, match_score = 0 WHERE statement_id = ? AND status = 'suggested'`, statementID)
+	if err != nil {
+		return 0, err
+	}
+
+	lines, err := d.queryBankStatementLines(`WHERE bsl.statement_id = ? AND bsl.status = 'unmatched'`, statementID)
+	if err != nil {
+		return 0, err
+	}
+
+	// A payment or invoice is suggested for one line only, including lines of other statements
+	used := make(map[string]bool)
+	rows, err := d.db.Query(`SELECT COALESCE(match_type, ''), COALESCE(payment_id, supplier_payment_id, invoice_id, purchase_invoice_id, 0)
+		FROM bank_statement_lines WHERE status = 'suggested'`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var matchType string
+		var id int
+		if err := rows.Scan(&matchType, &id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		used[fmt.Sprintf("%s:%d", matchType, id)] = true
+	}
+	rows.Close()
+
+	type suggestion struct {
+		line      BankStatementLine
+		candidate BankMatchCandidate
+	}
+	var suggestions []suggestion
+	for i := range lines {
+		candidates, err := bankMatchCandidates(d.db, &lines[i], windowDays)
+		if err != nil {
+			return 0, err
+		}
+
+		var available []BankMatchCandidate
+		for _, c := range candidates {
+			if !used[fmt.Sprintf("%s:%d", c.MatchType, c.ID)] {
+				available = append(available, c)
+			}
+		}
+		if len(available) == 0 || available[0].Score < bankAutoMatchMinScore {
+			continue
+		}
+		if len(available) > 1 && available[1].Score == available[0].Score {
+			continue
+		}
+
+		best := available[0]
+		used[fmt.Sprintf("%s:%d", best.MatchType, best.ID)] = true
+		suggestions = append(suggestions, suggestion{line: lines[i], candidate: best})
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, s := range suggestions {
+		column := s.candidate.MatchType + "_id"
+		_, err = tx.Exec(`UPDATE bank_statement_lines SET status = 'suggested', match_type = ?, `+column+` = ?, match_score = ? WHERE id = ?`,
+			s.candidate.MatchType, s.candidate.ID, s.candidate.Score, s.line.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(suggestions), nil
+}
+
+// ConfirmBankMatch reconciles a statement line with a payment or invoice. Matching an existing payment
+// marks both reconciled; matching an open invoice records the payment from the line first.
+func (d *Database) ConfirmBankMatch(lineID int, matchType string, targetID int, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	line, err := openBankLine(tx, lineID)
+	if err != nil {
+		return err
+	}
+
+	switch matchType {
+	case "payment", "supplier_payment":
+		err = reconcileBankLine(tx, line, matchType, targetID, userID)
+	case "invoice":
+		err = d.recordBankLinePayment(tx, line, BankLinePayment{InvoiceID: targetID}, userID)
+	case "purchase_invoice":
+		err = d.recordBankLinePayment(tx, line, BankLinePayment{PurchaseInvoiceID: targetID}, userID)
+	default:
+		err = fmt.Errorf("unknown match type %q", matchType)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConfirmSuggestedBankMatches confirms every suggested match of a statement and returns how many lines were reconciled
+func (d *Database) ConfirmSuggestedBankMatches(statementID int, userID *int) (int, error) {
+	lines, err := d.queryBankStatementLines(`WHERE bsl.statement_id = ? AND bsl.status = 'suggested'`, statementID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for i := range lines {
+		line := &lines[i]
+		switch line.MatchType {
+		case "payment":
+			err = reconcileBankLine(tx, line, "payment", *line.PaymentID, userID)
+		case "supplier_payment":
+			err = reconcileBankLine(tx, line, "supplier_payment", *line.SupplierPaymentID, userID)
+		case "invoice":
+			err = d.recordBankLinePayment(tx, line, BankLinePayment{InvoiceID: *line.InvoiceID}, userID)
+		case "purchase_invoice":
+			err = d.recordBankLinePayment(tx, line, BankLinePayment{PurchaseInvoiceID: *line.PurchaseInvoiceID}, userID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %v", line.LineNumber, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(lines), nil
+}
+
+// CreatePaymentFromBankLine records a new payment for an unmatched statement line and reconciles the line with it.
+// Money received is paid against a sales invoice; money paid out is paid to a supplier, against a purchase
+// invoice when one is given.
+func (d *Database) CreatePaymentFromBankLine(lineID int, request BankLinePayment, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	line, err := openBankLine(tx, lineID)
+	if err != nil {
+		return err
+	}
+	if err = d.recordBankLinePayment(tx, line, request, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IgnoreBankLine closes a statement line that has no payment in the books, such as bank charges or
+// transfers between own accounts
+func (d *Database) IgnoreBankLine(lineID int, userID *int) error {
+	result, err := d.db.Exec(`UPDATE bank_statement_lines SET status = 'ignored', match_type = NULL, payment_id = NULL, supplier_payment_id = NULL,
+		invoice_id = NULL, purchase_invoice_id = NULL, match_score = 0, reconciled_by = ?, reconciled_at = ?
+		WHERE id = ? AND status IN ('unmatched', 'suggested')`, userID, time.Now(), lineID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("bank statement line %d is not open", lineID)
+	}
+	return nil
+}
+
+// UnreconcileBankLine reopens a reconciled or ignored statement line. Payments recorded from the line are kept.
+func (d *Database) UnreconcileBankLine(lineID int) error {
+	_, err := d.db.Exec(`UPDATE bank_statement_lines SET status = 'unmatched', match_type = NULL, payment_id = NULL, supplier_payment_id = NULL,
+		invoice_id = NULL, purchase_invoice_id = NULL, match_score = 0, reconciled_by = NULL, reconciled_at = NULL
+		WHERE id = ?`, lineID)
+	return err
+}
+
+// refuseReconciledPayment stops a payment reconciled with a bank statement line from being cancelled
+// or deleted; the line has to be unreconciled first
+func refuseReconciledPayment(tx *sql.Tx, column string, paymentID int) error {
+	var lineNumber int
+	err := tx.QueryRow(`SELECT line_number FROM bank_statement_lines WHERE `+column+` = ? AND status = 'reconciled' LIMIT 1`, paymentID).Scan(&lineNumber)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("the payment is reconciled with bank statement line %d; unreconcile it first", lineNumber)
+}
+
+// openBankLine loads a statement line that is not yet reconciled or ignored
+func openBankLine(tx *sql.Tx, lineID int) (*BankStatementLine, error) {
+	var line BankStatementLine
+	err := tx.QueryRow(`SELECT id, company_id, line_number, booking_date, amount, COALESCE(reference, ''), COALESCE(bank_reference, ''),
+		COALESCE(description, ''), status FROM bank_statement_lines WHERE id = ?`, lineID).
+		Scan(&line.ID, &line.CompanyID, &line.LineNumber, &line.BookingDate, &line.Amount, &line.Reference, &line.BankReference,
+			&line.Description, &line.Status)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("bank statement line %d not found", lineID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if line.Status == "reconciled" || line.Status == "ignored" {
+		return nil, fmt.Errorf("bank statement line %d is already %s", line.LineNumber, line.Status)
+	}
+	return &line, nil
+}
+
+// reconcileBankLine links a statement line to an existing payment of the same amount and direction
+func reconcileBankLine(tx *sql.Tx, line *BankStatementLine, matchType string, paymentID int, userID *int) error {
+	table, column, expected := "payments", "payment_id", line.Amount
+	if matchType == "supplier_payment" {
+		table, column, expected = "supplier_payments", "supplier_payment_id", -line.Amount
+	}
+	if expected <= 0 {
+		return fmt.Errorf("a %s cannot be matched to a line of %.2f", strings.ReplaceAll(matchType, "_", " "), line.Amount)
+	}
+
+	var companyID int
+	var amount float64
+	var status string
+	err := tx.QueryRow(`SELECT company_id, amount, status FROM `+table+` WHERE id = ?`, paymentID).Scan(&companyID, &amount, &status)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s %d not found", strings.ReplaceAll(matchType, "_", " "), paymentID)
+	}
+	if err != nil {
+		return err
+	}
+	if companyID != line.CompanyID {
+		return fmt.Errorf("the payment belongs to a different company")
+	}
+	if status != "completed" {
+		return fmt.Errorf("cannot reconcile a %s payment", status)
+	}
+	if math.Abs(roundAmount(amount)-roundAmount(expected)) >= 0.005 {
+		return fmt.Errorf("payment amount %.2f does not match the statement amount %.2f", amount, expected)
+	}
+
+	var reconciled int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM bank_statement_lines WHERE `+column+` = ? AND status = 'reconciled' AND id != ?`, paymentID, line.ID).Scan(&reconciled)
+	if err != nil {
+		return err
+	}
+	if reconciled > 0 {
+		return fmt.Errorf("the payment is already reconciled with another statement line")
+	}
+
+	return markBankLineReconciled(tx, line.ID, matchType, column, paymentID, userID)
+}
+
+// markBankLineReconciled records what a statement line was reconciled with
+func markBankLineReconciled(tx *sql.Tx, lineID int, matchType, column string, paymentID int, userID *int) error {
+	query := `UPDATE bank_statement_lines SET status = 'reconciled', match_type = ?, payment_id = NULL, supplier_payment_id = NULL,
+		invoice_id = NULL, purchase_invoice_id = NULL, ` + column + ` = ?, reconciled_by = ?, reconciled_at = ? WHERE id = ?`
+	_, err := tx.Exec(query, matchType, paymentID, userID, time.Now(), lineID)
+	return err
+}
+
+// recordBankLinePayment records the payment a statement line represents and reconciles the line with it
+func (d *Database) recordBankLinePayment(tx *sql.Tx, line *BankStatementLine, request BankLinePayment, userID *int) error {
+	paymentTypeID := request.PaymentTypeID
+	if paymentTypeID == 0 {
+		err := tx.QueryRow(`SELECT id FROM payment_types WHERE code = 'bank_transfer' AND is_active = 1`).Scan(&paymentTypeID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("a payment type is required: no active bank transfer payment type")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	reference := line.Reference
+	if reference == "" {
+		reference = line.BankReference
+	}
+
+	if line.Amount > 0 {
+		if request.InvoiceID == 0 {
+			return fmt.Errorf("money received must be paid against a sales invoice")
+		}
+
+		var companyID int
+		var status, invoiceType string
+		err := tx.QueryRow(`SELECT company_id, status, COALESCE(invoice_type, 'standard') FROM sales_invoices WHERE id = ?`, request.InvoiceID).
+			Scan(&companyID, &status, &invoiceType)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("sales invoice %d not found", request.InvoiceID)
+		}
+		if err != nil {
+			return err
+		}
+		if companyID != line.CompanyID {
+			return fmt.Errorf("the invoice belongs to a different company")
+		}
+		if status == "draft" || status == "cancelled" || invoiceType == "prepayment" {
+			return fmt.Errorf("cannot record a payment against this invoice")
+		}
+
+		payment := Payment{
+			CompanyID:     line.CompanyID,
+			InvoiceID:     request.InvoiceID,
+			PaymentTypeID: paymentTypeID,
+			Amount:        roundAmount(line.Amount),
+			PaymentDate:   line.BookingDate,
+			Reference:     reference,
+			Notes:         request.Notes,
+			Status:        "completed",
+		}
+		if err = insertPayment(tx, &payment); err != nil {
+			return err
+		}
+		if err = recordOverpayment(tx, &payment); err != nil {
+			return err
+		}
+
+		_, _, outstanding, err := salesInvoiceOutstanding(tx, request.InvoiceID)
+		if err != nil {
+			return err
+		}
+		if outstanding < 0.005 && status != "paid" {
+			if _, err = tx.Exec(`UPDATE sales_invoices SET status = 'paid', updated_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, userID, request.InvoiceID); err != nil {
+				return err
+			}
+		}
+
+		if err = markBankLineReconciled(tx, line.ID, "invoice", "payment_id", payment.ID, userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE bank_statement_lines SET invoice_id = ? WHERE id = ?`, request.InvoiceID, line.ID)
+		return err
+	}
+
+	amount := roundAmount(-line.Amount)
+	supplierID := request.SupplierID
+	var allocations []SupplierPaymentAllocation
+	if request.PurchaseInvoiceID != 0 {
+		var companyID int
+		var outstanding float64
+		err := tx.QueryRow(`SELECT pi.company_id, pi.supplier_id, pi.total_amount - `+purchaseInvoicePaidAmountSQL+` FROM purchase_invoices pi WHERE pi.id = ?`,
+			request.PurchaseInvoiceID).Scan(&companyID, &supplierID, &outstanding)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("purchase invoice %d not found", request.PurchaseInvoiceID)
+		}
+		if err != nil {
+			return err
+		}
+		if companyID != line.CompanyID {
+			return fmt.Errorf("the purchase invoice belongs to a different company")
+		}
+		if outstanding > 0.005 {
+			allocations = append(allocations, SupplierPaymentAllocation{
+				PurchaseInvoiceID: request.PurchaseInvoiceID,
+				Amount:            roundAmount(min(amount, outstanding)),
+			})
+		}
+	}
+	if supplierID == 0 {
+		return fmt.Errorf("money paid out must be paid to a supplier")
+	}
+
+	payment := SupplierPayment{
+		CompanyID:     line.CompanyID,
+		SupplierID:    supplierID,
+		PaymentTypeID: paymentTypeID,
+		Amount:        amount,
+		PaymentDate:   line.BookingDate,
+		Reference:     reference,
+		Notes:         request.Notes,
+		Status:        "completed",
+		Allocations:   allocations,
+		CreatedBy:     userID,
+	}
+	if err := d.insertSupplierPayment(tx, &payment); err != nil {
+		return err
+	}
+
+	matchType := "supplier_payment"
+	if request.PurchaseInvoiceID != 0 {
+		matchType = "purchase_invoice"
+	}
+	if err := markBankLineReconciled(tx, line.ID, matchType, "supplier_payment_id", payment.ID, userID); err != nil {
+		return err
+	}
+	if request.PurchaseInvoiceID != 0 {
+		_, err := tx.Exec(`UPDATE bank_statement_lines SET purchase_invoice_id = ? WHERE id = ?`, request.PurchaseInvoiceID, line.ID)
+		return err
+	}
+	return nil
+}
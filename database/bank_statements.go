@@ -0,0 +1,227 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// CreateBankStatement saves an imported bank statement with its lines. A statement already imported
+// for the same account is refused so that bookings are not reconciled twice.
+func (d *Database) CreateBankStatement(statement *BankStatement) error {
+	if len(statement.Lines) == 0 {
+		return fmt.Errorf("the bank statement has no lines")
+	}
+
+	// Default to company 1 for backward compatibility
+	if statement.CompanyID == 0 {
+		statement.CompanyID = 1
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if statement.StatementNumber != "" {
+		var count int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM bank_statements WHERE company_id = ? AND account_number = ? AND statement_number = ?`,
+			statement.CompanyID, statement.AccountNumber, statement.StatementNumber).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("statement %s of account %s has already been imported", statement.StatementNumber, statement.AccountNumber)
+		}
+	}
+
+	query := `
+		INSERT INTO bank_statements (company_id, format, account_number, currency, statement_number, statement_date,
+			opening_balance, closing_balance, file_name, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(query, statement.CompanyID, statement.Format, statement.AccountNumber, statement.Currency, statement.StatementNumber,
+		statement.StatementDate, statement.OpeningBalance, statement.ClosingBalance, statement.FileName, statement.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("error creating bank statement: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	statement.ID = int(id)
+
+	lineQuery := `
+		INSERT INTO bank_statement_lines (statement_id, company_id, line_number, booking_date, value_date, amount, reference, description,
+			counterparty_name, counterparty_account, bank_reference, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'unmatched')`
+
+	for i := range statement.Lines {
+		line := &statement.Lines[i]
+		line.StatementID = statement.ID
+		line.CompanyID = statement.CompanyID
+		line.LineNumber = i + 1
+		line.Status = "unmatched"
+		if line.ValueDate.IsZero() {
+			line.ValueDate = line.BookingDate
+		}
+
+		result, err := tx.Exec(lineQuery, line.StatementID, line.CompanyID, line.LineNumber, line.BookingDate, line.ValueDate, line.Amount,
+			line.Reference, line.Description, line.CounterpartyName, line.CounterpartyAccount, line.BankReference)
+		if err != nil {
+			return fmt.Errorf("error creating bank statement line %d: %v", line.LineNumber, err)
+		}
+		lineID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		line.ID = int(lineID)
+	}
+	statement.LineCount = len(statement.Lines)
+
+	return tx.Commit()
+}
+
+// GetBankStatementsByCompany retrieves the bank statements of a company with their reconciliation progress
+func (d *Database) GetBankStatementsByCompany(companyID int) ([]BankStatement, error) {
+	return d.queryBankStatements(`WHERE bs.company_id = ?`, companyID)
+}
+
+func (d *Database) queryBankStatements(where string, args ...interface{}) ([]BankStatement, error) {
+	query := `
+		SELECT bs.id, bs.company_id, bs.format, COALESCE(bs.account_number, ''), COALESCE(bs.currency, ''), COALESCE(bs.statement_number, ''),
+			bs.statement_date, bs.opening_balance, bs.closing_balance, COALESCE(bs.file_name, ''),
+			(SELECT COUNT(*) FROM bank_statement_lines WHERE statement_id = bs.id),
+			(SELECT COUNT(*) FROM bank_statement_lines WHERE statement_id = bs.id AND status IN ('reconciled', 'ignored')),
+			bs.created_by, bs.created_at
+		FROM bank_statements bs
+		` + where + `
+		ORDER BY bs.statement_date DESC, bs.id DESC`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statements []BankStatement
+	for rows.Next() {
+		var s BankStatement
+		var statementDate sql.NullTime
+		err := rows.Scan(&s.ID, &s.CompanyID, &s.Format, &s.AccountNumber, &s.Currency, &s.StatementNumber,
+			&statementDate, &s.OpeningBalance, &s.ClosingBalance, &s.FileName,
+			&s.LineCount, &s.ReconciledCount, &s.CreatedBy, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		s.StatementDate = statementDate.Time
+		statements = append(statements, s)
+	}
+	return statements, rows.Err()
+}
+
+// GetBankStatementByID retrieves a bank statement with all its lines
+func (d *Database) GetBankStatementByID(id int) (*BankStatement, error) {
+	statements, err := d.queryBankStatements(`WHERE bs.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	statement := statements[0]
+
+	lines, err := d.queryBankStatementLines(`WHERE bsl.statement_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	statement.Lines = lines
+
+	return &statement, nil
+}
+
+// GetBankStatementLineByID retrieves a single bank statement line
+func (d *Database) GetBankStatementLineByID(id int) (*BankStatementLine, error) {
+	lines, err := d.queryBankStatementLines(`WHERE bsl.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &lines[0], nil
+}
+
+func (d *Database) queryBankStatementLines(where string, args ...interface{}) ([]BankStatementLine, error) {
+	query := `
+		SELECT bsl.id, bsl.statement_id, bsl.company_id, bsl.line_number, bsl.booking_date, bsl.value_date, bsl.amount,
+			COALESCE(bsl.reference, ''), COALESCE(bsl.description, ''), COALESCE(bsl.counterparty_name, ''),
+			COALESCE(bsl.counterparty_account, ''), COALESCE(bsl.bank_reference, ''), bsl.status, COALESCE(bsl.match_type, ''),
+			bsl.payment_id, bsl.supplier_payment_id, bsl.invoice_id, bsl.purchase_invoice_id, bsl.match_score,
+			COALESCE(sp.payment_number, pi.invoice_number, si.invoice_number, psi.invoice_number, ''),
+			bsl.reconciled_by, bsl.reconciled_at, bsl.created_at
+		FROM bank_statement_lines bsl
+		LEFT JOIN sales_invoices si ON bsl.invoice_id = si.id
+		LEFT JOIN payments p ON bsl.payment_id = p.id
+		LEFT JOIN sales_invoices psi ON p.invoice_id = psi.id
+		LEFT JOIN supplier_payments sp ON bsl.supplier_payment_id = sp.id
+		LEFT JOIN purchase_invoices pi ON bsl.purchase_invoice_id = pi.id
+		` + where + `
+		ORDER BY bsl.statement_id, bsl.line_number`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []BankStatementLine
+	for rows.Next() {
+		var l BankStatementLine
+		var valueDate, reconciledAt sql.NullTime
+		err := rows.Scan(&l.ID, &l.StatementID, &l.CompanyID, &l.LineNumber, &l.BookingDate, &valueDate, &l.Amount,
+			&l.Reference, &l.Description, &l.CounterpartyName,
+			&l.CounterpartyAccount, &l.BankReference, &l.Status, &l.MatchType,
+			&l.PaymentID, &l.SupplierPaymentID, &l.InvoiceID, &l.PurchaseInvoiceID, &l.MatchScore,
+			&l.MatchDescription,
+			&l.ReconciledBy, &reconciledAt, &l.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		l.ValueDate = valueDate.Time
+		if reconciledAt.Valid {
+			l.ReconciledAt = &reconciledAt.Time
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// DeleteBankStatement deletes an imported statement. Statements with reconciled lines must be
+// unreconciled first.
+func (d *Database) DeleteBankStatement(id int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var reconciled int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM bank_statement_lines WHERE statement_id = ? AND status = 'reconciled'`, id).Scan(&reconciled)
+	if err != nil {
+		return err
+	}
+	if reconciled > 0 {
+		return fmt.Errorf("cannot delete a bank statement with %d reconciled lines", reconciled)
+	}
+
+	if _, err = tx.Exec(`DELETE FROM bank_statement_lines WHERE statement_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM bank_statements WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
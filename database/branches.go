@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+)
+
+// GetBranchesByCompany retrieves the branches of a company
+func (d *Database) GetBranchesByCompany(companyID int) ([]Branch, error) {
+	rows, err := d.db.Query(`
+		SELECT id, company_id, code, name, COALESCE(name_arabic, ''), COALESCE(address, ''), COALESCE(address_arabic, ''), is_active, created_at, updated_at
+		FROM branches WHERE company_id = ? ORDER BY code`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var branches []Branch
+	for rows.Next() {
+		var b Branch
+		if err := rows.Scan(&b.ID, &b.CompanyID, &b.Code, &b.Name, &b.NameArabic, &b.Address, &b.AddressArabic, &b.IsActive,
+			&b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		branches = append(branches, b)
+	}
+	return branches, rows.Err()
+}
+
+// GetBranchByID retrieves a branch
+func (d *Database) GetBranchByID(id int) (*Branch, error) {
+	var b Branch
+	err := d.db.QueryRow(`
+		SELECT id, company_id, code, name, COALESCE(name_arabic, ''), COALESCE(address, ''), COALESCE(address_arabic, ''), is_active, created_at, updated_at
+		FROM branches WHERE id = ?`, id).
+		Scan(&b.ID, &b.CompanyID, &b.Code, &b.Name, &b.NameArabic, &b.Address, &b.AddressArabic, &b.IsActive, &b.CreatedAt, &b.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CreateBranch adds a branch to a company
+func (d *Database) CreateBranch(branch *Branch) error {
+	if branch.Code == "" || branch.Name == "" {
+		return fmt.Errorf("branch code and name are required")
+	}
+
+	// Default to company 1 for backward compatibility
+	if branch.CompanyID == 0 {
+		branch.CompanyID = 1
+	}
+
+	result, err := d.db.Exec(`INSERT INTO branches (company_id, code, name, name_arabic, address, address_arabic, is_active) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		branch.CompanyID, branch.Code, branch.Name, branch.NameArabic, branch.Address, branch.AddressArabic, branch.IsActive)
+	if err != nil {
+		return fmt.Errorf("error creating branch: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	branch.ID = int(id)
+	return nil
+}
+
+// UpdateBranch updates a branch
+func (d *Database) UpdateBranch(branch *Branch) error {
+	if branch.Code == "" || branch.Name == "" {
+		return fmt.Errorf("branch code and name are required")
+	}
+
+	_, err := d.db.Exec(`
+		UPDATE branches SET code = ?, name = ?, name_arabic = ?, address = ?, address_arabic = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		branch.Code, branch.Name, branch.NameArabic, branch.Address, branch.AddressArabic, branch.IsActive, branch.ID)
+	if err != nil {
+		return fmt.Errorf("error updating branch: %v", err)
+	}
+	return nil
+}
+
+// DeleteBranch deletes a branch that no document or journal entry refers to
+func (d *Database) DeleteBranch(id int) error {
+	var documents int
+	err := d.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM sales_invoices WHERE branch_id = ?)
+			+ (SELECT COUNT(*) FROM purchase_invoices WHERE branch_id = ?)
+			+ (SELECT COUNT(*) FROM journal_entries WHERE branch_id = ?)`, id, id, id).Scan(&documents)
+	if err != nil {
+		return err
+	}
+	if documents > 0 {
+		return fmt.Errorf("the branch is used by %d documents and journal entries; deactivate it instead", documents)
+	}
+
+	_, err = d.db.Exec(`DELETE FROM branches WHERE id = ?`, id)
+	return err
+}
+
+// checkBranch verifies that a branch belongs to the company of a document
+func checkBranch(exec execer, companyID int, branchID *int) error {
+	if branchID == nil {
+		return nil
+	}
+	var branchCompanyID int
+	if err := exec.QueryRow(`SELECT company_id FROM branches WHERE id = ?`, *branchID).Scan(&branchCompanyID); err != nil {
+		return fmt.Errorf("branch %d not found", *branchID)
+	}
+	if branchCompanyID != companyID {
+		return fmt.Errorf("the branch belongs to a different company")
+	}
+	return nil
+}
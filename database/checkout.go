@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// roundAmount rounds a currency amount to two decimals
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// Checkout saves a paid sales invoice together with one payment per tender in a single transaction.
+// Tenders are rounded to cents. Non-cash tenders are applied first and may not exceed what is due; cash
+// covers the remainder and any cash over the remainder is returned as change. Underpayment is rejected, as
+// is a tender of which nothing is applied because the tenders before it already cover the total. An invoice
+// with a zero total is checked out without tenders.
+func (d *Database) Checkout(invoice *SalesInvoice, tenders []CheckoutTender) (*CheckoutResult, error) {
+	total := roundAmount(invoice.TotalAmount)
+	if len(tenders) == 0 && total != 0 {
+		return nil, fmt.Errorf("at least one tender is required")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Look up the payment type of every tender to tell cash from the rest
+	isCash := make([]bool, len(tenders))
+	amounts := make([]float64, len(tenders))
+	var totalTendered, cashTendered float64
+	for i, tender := range tenders {
+		amounts[i] = roundAmount(tender.Amount)
+		if amounts[i] <= 0 {
+			return nil, fmt.Errorf("tender amounts must be greater than zero")
+		}
+
+		var code string
+		var active bool
+		err = tx.QueryRow(`SELECT COALESCE(code, ''), is_active FROM payment_types WHERE id = ?`, tender.PaymentTypeID).Scan(&code, &active)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("payment type %d not found", tender.PaymentTypeID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, fmt.Errorf("payment type %d is not active", tender.PaymentTypeID)
+		}
+
+		isCash[i] = code == "cash"
+		totalTendered += amounts[i]
+		if isCash[i] {
+			cashTendered += amounts[i]
+		}
+	}
+
+	totalTendered = roundAmount(totalTendered)
+	if totalTendered < total {
+		return nil, fmt.Errorf("payment of %.2f is less than the invoice total %.2f", totalTendered, total)
+	}
+
+	nonCash := roundAmount(totalTendered - cashTendered)
+	if nonCash > total {
+		return nil, fmt.Errorf("non-cash tenders of %.2f exceed the invoice total %.2f; only cash can be given change", nonCash, total)
+	}
+
+	// Apply non-cash tenders first so that change is only ever given in cash
+	order := make([]int, 0, len(tenders))
+	for i := range tenders {
+		if !isCash[i] {
+			order = append(order, i)
+		}
+	}
+	for i := range tenders {
+		if isCash[i] {
+			order = append(order, i)
+		}
+	}
+
+	applied := make([]float64, len(tenders))
+	remaining := total
+	for _, i := range order {
+		applied[i] = roundAmount(math.Min(amounts[i], remaining))
+		remaining = roundAmount(remaining - applied[i])
+		if applied[i] <= 0 {
+			return nil, fmt.Errorf("the tender of %.2f is not needed: the other tenders already cover the invoice total %.2f", amounts[i], total)
+		}
+	}
+
+	invoice.Status = "paid"
+	if err = d.insertSalesInvoice(tx, invoice); err != nil {
+		return nil, err
+	}
+
+	result := &CheckoutResult{TotalTendered: totalTendered}
+	paymentDate := time.Now()
+	payments := make([]Payment, len(tenders))
+	for _, i := range order {
+		tender := tenders[i]
+		payment := Payment{
+			CompanyID:      invoice.CompanyID,
+			InvoiceID:      invoice.ID,
+			PaymentTypeID:  tender.PaymentTypeID,
+			Amount:         applied[i],
+			TenderedAmount: amounts[i],
+			ChangeAmount:   roundAmount(amounts[i] - applied[i]),
+			PaymentDate:    paymentDate,
+			Reference:      tender.Reference,
+			Status:         "completed",
+		}
+		if err = insertPayment(tx, &payment); err != nil {
+			return nil, err
+		}
+
+		result.ChangeDue += payment.ChangeAmount
+		payments[i] = payment
+	}
+	result.ChangeDue = roundAmount(result.ChangeDue)
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	result.Invoice = *invoice
+	result.Payments = payments
+	return result, nil
+}
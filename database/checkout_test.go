@@ -0,0 +1,118 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckout(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	if _, err := d.db.Exec(`INSERT INTO customers (id, name, company_id) VALUES (1, 'Walk-in', 1)`); err != nil {
+		t.Fatal(err)
+	}
+	// The default payment types
+	const cash, card, transfer = 1, 2, 3
+
+	type payment struct{ amount, change float64 }
+	tests := []struct {
+		name     string
+		total    float64
+		tenders  []CheckoutTender
+		payments []payment // In the order of the tenders
+		change   float64
+		err      string
+	}{
+		{name: "exact cash", total: 100, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 100}},
+			payments: []payment{{100, 0}}},
+		{name: "exact card", total: 100, tenders: []CheckoutTender{{PaymentTypeID: card, Amount: 100, Reference: "A1"}},
+			payments: []payment{{100, 0}}},
+		{name: "split", total: 100, tenders: []CheckoutTender{{PaymentTypeID: card, Amount: 60}, {PaymentTypeID: transfer, Amount: 25}, {PaymentTypeID: cash, Amount: 15}},
+			payments: []payment{{60, 0}, {25, 0}, {15, 0}}},
+		{name: "cash change", total: 100, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 150}},
+			payments: []payment{{100, 50}}, change: 50},
+		// Cash is applied after the card whatever the order it was tendered in, so the change is in cash
+		{name: "cash applied last", total: 100, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 50}, {PaymentTypeID: card, Amount: 70}},
+			payments: []payment{{30, 20}, {70, 0}}, change: 20},
+		{name: "change from two cash tenders", total: 100, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 50}, {PaymentTypeID: cash, Amount: 70}},
+			payments: []payment{{50, 0}, {50, 20}}, change: 20},
+		{name: "rounded to cents", total: 100, tenders: []CheckoutTender{{PaymentTypeID: card, Amount: 33.333}, {PaymentTypeID: cash, Amount: 66.667}},
+			payments: []payment{{33.33, 0}, {66.67, 0}}},
+		{name: "no float drift", total: 0.3, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 0.1}, {PaymentTypeID: cash, Amount: 0.2}},
+			payments: []payment{{0.1, 0}, {0.2, 0}}},
+		{name: "zero total without tenders", total: 0},
+
+		{name: "underpaid", total: 100, tenders: []CheckoutTender{{PaymentTypeID: card, Amount: 50}, {PaymentTypeID: cash, Amount: 49.99}},
+			err: "less than the invoice total"},
+		{name: "card over the total", total: 100, tenders: []CheckoutTender{{PaymentTypeID: card, Amount: 120}},
+			err: "only cash can be given change"},
+		{name: "card and transfer over the total", total: 100, tenders: []CheckoutTender{{PaymentTypeID: card, Amount: 60}, {PaymentTypeID: transfer, Amount: 60}, {PaymentTypeID: cash, Amount: 10}},
+			err: "only cash can be given change"},
+		{name: "cash not needed after card", total: 100, tenders: []CheckoutTender{{PaymentTypeID: card, Amount: 100}, {PaymentTypeID: cash, Amount: 20}},
+			err: "is not needed"},
+		{name: "second cash not needed", total: 100, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 100}, {PaymentTypeID: cash, Amount: 50}},
+			err: "is not needed"},
+		{name: "zero tender", total: 100, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 100}, {PaymentTypeID: card, Amount: 0}},
+			err: "greater than zero"},
+		{name: "tender rounded to zero", total: 100, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 100}, {PaymentTypeID: cash, Amount: 0.004}},
+			err: "greater than zero"},
+		{name: "no tenders", total: 100, err: "at least one tender"},
+		{name: "tender for a zero total", total: 0, tenders: []CheckoutTender{{PaymentTypeID: cash, Amount: 10}},
+			err: "is not needed"},
+		{name: "unknown payment type", total: 100, tenders: []CheckoutTender{{PaymentTypeID: 99, Amount: 100}},
+			err: "not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var invoicesBefore int
+			if err := d.db.QueryRow(`SELECT COUNT(*) FROM sales_invoices`).Scan(&invoicesBefore); err != nil {
+				t.Fatal(err)
+			}
+			invoice := &SalesInvoice{CustomerID: 1, SalesCategoryID: 1, SubTotal: test.total, TotalAmount: test.total,
+				IssueDate: Date{Time: time.Now()}, DueDate: Date{Time: time.Now()}}
+			result, err := d.Checkout(invoice, test.tenders)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Checkout: %v, want an error with %q", err, test.err)
+				}
+				var invoicesAfter int
+				if err := d.db.QueryRow(`SELECT COUNT(*) FROM sales_invoices`).Scan(&invoicesAfter); err != nil {
+					t.Fatal(err)
+				}
+				if invoicesAfter != invoicesBefore {
+					t.Error("the refused checkout saved an invoice")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Invoice.Status != "paid" || result.ChangeDue != test.change {
+				t.Errorf("invoice %s with change %.2f, want paid with %.2f", result.Invoice.Status, result.ChangeDue, test.change)
+			}
+			if len(result.Payments) != len(test.payments) {
+				t.Fatalf("%d payments, want %d", len(result.Payments), len(test.payments))
+			}
+			for i, want := range test.payments {
+				got := result.Payments[i]
+				if got.Amount != want.amount || got.ChangeAmount != want.change || got.PaymentTypeID != test.tenders[i].PaymentTypeID {
+					t.Errorf("payment %d of type %d applies %.2f with change %.2f, want %.2f with %.2f",
+						i, got.PaymentTypeID, got.Amount, got.ChangeAmount, want.amount, want.change)
+				}
+			}
+
+			var count int
+			var applied float64
+			err = d.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE invoice_id = ? AND amount > 0`, invoice.ID).
+				Scan(&count, &applied)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(test.tenders) || roundAmount(applied) != roundAmount(test.total) {
+				t.Errorf("%d payments applying %.2f were saved, want %d applying the total %.2f", count, applied, len(test.tenders), test.total)
+			}
+		})
+	}
+}
@@ -6,11 +6,11 @@ import (
 
 // Company operations
 func (d *Database) GetCompany() (*Company, error) {
-	query := `SELECT id, name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, COALESCE(logo, '') as logo, logo_file_id FROM companies LIMIT 1`
+	query := `SELECT id, name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, COALESCE(logo, '') as logo, logo_file_id, COALESCE(costing_method, 'weighted_average') FROM companies LIMIT 1`
 
 	var c Company
 	err := d.db.QueryRow(query).Scan(&c.ID, &c.Name, &c.NameArabic, &c.VATNumber, &c.CRNumber, &c.Email, &c.Phone,
-		&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic, &c.Logo, &c.LogoFileID)
+		&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic, &c.Logo, &c.LogoFileID, &c.CostingMethod)
 	if err != nil {
 		return nil, err
 	}
@@ -18,7 +18,7 @@ func (d *Database) GetCompany() (*Company, error) {
 }
 
 func (d *Database) GetCompanies() ([]Company, error) {
-	query := `SELECT id, name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, COALESCE(logo, '') as logo, logo_file_id FROM companies ORDER BY name`
+	query := `SELECT id, name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, COALESCE(logo, '') as logo, logo_file_id, COALESCE(costing_method, 'weighted_average') FROM companies ORDER BY name`
 
 	rows, err := d.db.Query(query)
 	if err != nil {
@@ -30,7 +30,7 @@ func (d *Database) GetCompanies() ([]Company, error) {
 	for rows.Next() {
 		var c Company
 		err := rows.Scan(&c.ID, &c.Name, &c.NameArabic, &c.VATNumber, &c.CRNumber, &c.Email, &c.Phone,
-			&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic, &c.Logo, &c.LogoFileID)
+			&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic, &c.Logo, &c.LogoFileID, &c.CostingMethod)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning company: %v", err)
 		}
@@ -41,11 +41,11 @@ func (d *Database) GetCompanies() ([]Company, error) {
 }
 
 func (d *Database) GetCompanyByID(id int) (*Company, error) {
-	query := `SELECT id, name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, COALESCE(logo, '') as logo, logo_file_id FROM companies WHERE id = ?`
+	query := `SELECT id, name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, COALESCE(logo, '') as logo, logo_file_id, COALESCE(costing_method, 'weighted_average') FROM companies WHERE id = ?`
 
 	var c Company
 	err := d.db.QueryRow(query, id).Scan(&c.ID, &c.Name, &c.NameArabic, &c.VATNumber, &c.CRNumber, &c.Email, &c.Phone,
-		&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic, &c.Logo, &c.LogoFileID)
+		&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic, &c.Logo, &c.LogoFileID, &c.CostingMethod)
 	if err != nil {
 		return nil, fmt.Errorf("error getting company: %v", err)
 	}
@@ -53,12 +53,19 @@ func (d *Database) GetCompanyByID(id int) (*Company, error) {
 }
 
 func (d *Database) CreateCompany(company *Company) error {
-	query := `INSERT INTO companies (name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, logo, logo_file_id) 
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`
+	if company.CostingMethod == "" {
+		company.CostingMethod = CostingWeightedAverage
+	}
+	if err := validateCostingMethod(company.CostingMethod); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO companies (name, name_arabic, vat_number, cr_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, logo, logo_file_id, costing_method) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)`
 
 	result, err := d.db.Exec(query, company.Name, company.NameArabic, company.VATNumber, company.CRNumber,
 		company.Email, company.Phone, company.Address, company.AddressArabic, company.City, company.CityArabic,
-		company.Country, company.CountryArabic, company.Logo, company.LogoFileID)
+		company.Country, company.CountryArabic, company.Logo, company.LogoFileID, company.CostingMethod)
 	if err != nil {
 		return fmt.Errorf("error creating company: %v", err)
 	}
@@ -72,14 +79,22 @@ func (d *Database) CreateCompany(company *Company) error {
 	return nil
 }
 
+// UpdateCompany updates a company; an empty costing method keeps the current one
 func (d *Database) UpdateCompany(company *Company) error {
+	if company.CostingMethod != "" {
+		if err := validateCostingMethod(company.CostingMethod); err != nil {
+			return err
+		}
+	}
+
 	query := `
 		UPDATE companies SET name = ?, name_arabic = ?, vat_number = ?, cr_number = ?, email = ?, phone = ?, 
-		address = ?, address_arabic = ?, city = ?, city_arabic = ?, country = ?, country_arabic = ?, logo = NULLIF(?, ''), logo_file_id = ?
+		address = ?, address_arabic = ?, city = ?, city_arabic = ?, country = ?, country_arabic = ?, logo = NULLIF(?, ''), logo_file_id = ?,
+		costing_method = COALESCE(NULLIF(?, ''), costing_method)
 		WHERE id = ?`
 
 	_, err := d.db.Exec(query, company.Name, company.NameArabic, company.VATNumber, company.CRNumber,
 		company.Email, company.Phone, company.Address, company.AddressArabic, company.City, company.CityArabic,
-		company.Country, company.CountryArabic, company.Logo, company.LogoFileID, company.ID)
+		company.Country, company.CountryArabic, company.Logo, company.LogoFileID, company.CostingMethod, company.ID)
 	return err
 }
\ No newline at end of file
@@ -0,0 +1,397 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// archiveTable describes how rows of a table belong to a company and which columns hold the ids of other
+// rows, so an exported company can be imported under new ids
+type archiveTable struct {
+	name  string
+	owner string            // Column holding the id of the owning row, for tables without company_id
+	refs  map[string]string // Columns holding ids, with the table the ids belong to
+	typed map[string]typedRef
+}
+
+// typedRef is a column holding the id of a row in a table named by another column, such as the source of a
+// journal entry
+type typedRef struct {
+	typeColumn string
+	tables     map[string]string
+}
+
+// archiveTables are the tables of a company in the order they are imported, each after the tables it refers
+// to. References to rows of the same table are set once the whole table is in.
+var archiveTables = []archiveTable{
+	{name: "companies"},
+	{name: "users"},
+	{name: "branches"},
+	{name: "customers", refs: map[string]string{"deleted_by": "users"}},
+	{name: "suppliers", refs: map[string]string{"deleted_by": "users"}},
+	{name: "product_categories", refs: map[string]string{"deleted_by": "users"}},
+	{name: "purchase_product_categories"},
+	{name: "payment_types"},
+	{name: "sales_categories"},
+	{name: "tax_rates"},
+	{name: "units_of_measurement"},
+	{name: "products", refs: map[string]string{"category_id": "product_categories", "deleted_by": "users"}},
+	{name: "purchase_products", refs: map[string]string{"category_id": "purchase_product_categories"}},
+	{name: "default_product_settings", refs: map[string]string{"default_tax_rate_id": "tax_rates", "default_unit_id": "units_of_measurement"}},
+	{name: "system_settings"},
+	{name: "accounts", refs: map[string]string{"parent_id": "accounts"}},
+	{name: "expense_categories"},
+	{name: "account_mappings", refs: map[string]string{"account_id": "accounts"}, typed: map[string]typedRef{
+		"entity_id": {"entity_type", map[string]string{
+			"sales_category":   "sales_categories",
+			"tax_rate":         "tax_rates",
+			"payment_type":     "payment_types",
+			"expense_category": "expense_categories",
+		}},
+	}},
+	{name: "fiscal_years", refs: map[string]string{"closed_by": "users"}},
+	{name: "fiscal_periods", refs: map[string]string{"fiscal_year_id": "fiscal_years", "updated_by": "users"}},
+	{name: "sales_invoices", refs: map[string]string{"customer_id": "customers", "sales_category_id": "sales_categories",
+		"branch_id": "branches", "created_by": "users", "updated_by": "users", "deleted_by": "users"}},
+	{name: "sales_invoice_items", owner: "invoice_id", refs: map[string]string{"invoice_id": "sales_invoices", "product_id": "products"}},
+	{name: "purchase_invoices", refs: map[string]string{"supplier_id": "suppliers", "branch_id": "branches",
+		"created_by": "users", "updated_by": "users", "deleted_by": "users"}},
+	{name: "purchase_invoice_items", owner: "invoice_id", refs: map[string]string{"invoice_id": "purchase_invoices", "product_id": "products"}},
+	{name: "payments", refs: map[string]string{"invoice_id": "sales_invoices", "payment_type_id": "payment_types",
+		"deleted_by": "users"}},
+	{name: "credit_notes", refs: map[string]string{"invoice_id": "sales_invoices", "customer_id": "customers",
+		"created_by": "users", "updated_by": "users", "deleted_by": "users"}},
+	{name: "credit_note_items", owner: "credit_note_id", refs: map[string]string{"credit_note_id": "credit_notes", "product_id": "products"}},
+	{name: "supplier_payments", refs: map[string]string{"supplier_id": "suppliers", "payment_type_id": "payment_types",
+		"created_by": "users", "updated_by": "users", "deleted_by": "users"}},
+	{name: "supplier_payment_allocations", owner: "supplier_payment_id", refs: map[string]string{
+		"supplier_payment_id": "supplier_payments", "purchase_invoice_id": "purchase_invoices"}},
+	{name: "customer_credit_transactions", refs: map[string]string{"customer_id": "customers", "payment_type_id": "payment_types",
+		"payment_id": "payments", "invoice_id": "sales_invoices", "credit_note_id": "credit_notes",
+		"prepayment_invoice_id": "sales_invoices", "reverses_id": "customer_credit_transactions", "created_by": "users"}},
+	{name: "recurring_expenses", refs: map[string]string{"branch_id": "branches", "category_id": "expense_categories",
+		"supplier_id": "suppliers", "payment_type_id": "payment_types", "created_by": "users"}},
+	{name: "expenses", refs: map[string]string{"branch_id": "branches", "category_id": "expense_categories",
+		"supplier_id": "suppliers", "payment_type_id": "payment_types", "recurring_expense_id": "recurring_expenses",
+		"created_by": "users", "updated_by": "users", "deleted_by": "users"}},
+	{name: "stock_cost_layers", refs: map[string]string{"product_id": "products", "purchase_invoice_id": "purchase_invoices"}},
+	{name: "stock_cost_consumptions", refs: map[string]string{"sales_invoice_id": "sales_invoices", "product_id": "products",
+		"layer_id": "stock_cost_layers"}},
+	{name: "bank_statements", refs: map[string]string{"created_by": "users"}},
+	{name: "bank_statement_lines", refs: map[string]string{"statement_id": "bank_statements", "payment_id": "payments",
+		"supplier_payment_id": "supplier_payments", "invoice_id": "sales_invoices", "purchase_invoice_id": "purchase_invoices",
+		"reconciled_by": "users"}},
+	{name: "journal_entries", refs: map[string]string{"branch_id": "branches", "reverses_id": "journal_entries",
+		"reversed_by_id": "journal_entries", "created_by": "users"}, typed: map[string]typedRef{
+		"source_id": {"source_type", map[string]string{
+			"sales_invoice":    "sales_invoices",
+			"sales_cost":       "sales_invoices",
+			"payment":          "payments",
+			"credit_note":      "credit_notes",
+			"purchase_invoice": "purchase_invoices",
+			"stock_receipt":    "purchase_invoices",
+			"supplier_payment": "supplier_payments",
+			"customer_credit":  "customer_credit_transactions",
+			"expense":          "expenses",
+			yearEndCloseSource: "fiscal_years",
+		}},
+	}},
+	{name: "journal_lines", owner: "entry_id", refs: map[string]string{"entry_id": "journal_entries", "account_id": "accounts"}},
+}
+
+// tableColumns returns the columns of a table in the live schema
+func tableColumns(exec execer, table string) ([]string, error) {
+	rows, err := exec.Query("SELECT name FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// ExportCompanyData reads every row belonging to a company, table by table, in one read transaction. Values
+// are read as SQLite stores them, so dates keep their stored text.
+func (d *Database) ExportCompanyData(companyID int) ([]CompanyArchiveTable, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM companies WHERE id = ?", companyID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("company %d not found", companyID)
+	}
+
+	tables := []CompanyArchiveTable{}
+	for _, spec := range archiveTables {
+		columns, err := tableColumns(tx, spec.name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading columns of %s: %v", spec.name, err)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		// Rows from before multi-tenancy may have no company and belong to the first
+		where := "COALESCE(company_id, 1) = ?"
+		switch {
+		case spec.name == "companies":
+			where = "id = ?"
+		case spec.owner != "":
+			where = fmt.Sprintf("%s IN (SELECT id FROM %s WHERE COALESCE(company_id, 1) = ?)", spec.owner, spec.refs[spec.owner])
+		}
+		// The unary plus hides the declared type, so the driver returns the stored value instead of
+		// converting dates and booleans
+		selected := make([]string, len(columns))
+		for i, column := range columns {
+			selected[i] = "+" + column
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id", strings.Join(selected, ", "), spec.name, where)
+
+		table := CompanyArchiveTable{Name: spec.name, Columns: columns, Rows: [][]interface{}{}}
+		rows, err := tx.Query(query, companyID)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", spec.name, err)
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			pointers := make([]interface{}, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			if err := rows.Scan(pointers...); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error reading %s: %v", spec.name, err)
+			}
+			for i, value := range values {
+				if b, ok := value.([]byte); ok {
+					values[i] = string(b)
+				}
+			}
+			table.Rows = append(table.Rows, values)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", spec.name, err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// archiveValue converts a value decoded from an archive's JSON to one to store. Numbers are decoded as
+// json.Number and stored as integers when they have no fraction.
+func archiveValue(value interface{}) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	f, _ := number.Float64()
+	return f
+}
+
+// archiveID returns the id a value refers to, or false for NULL and 0, which refer to nothing
+func archiveID(value interface{}) (int64, bool) {
+	switch v := archiveValue(value).(type) {
+	case int64:
+		return v, v != 0
+	case float64:
+		return int64(v), v != 0
+	}
+	return 0, false
+}
+
+// deferredRef is a reference to a row of the same table, set once the whole table is imported
+type deferredRef struct {
+	table  string
+	id     int64
+	column string
+	oldID  int64
+}
+
+// ImportCompanyData inserts an exported company as a new company. Every row gets a new id and the columns
+// referring to other rows are rewritten to the new ids, so the company never collides with the companies
+// already in the database. Users whose username or email is taken are left out, and the records they
+// created lose that link. The company logo is not set, as its file is imported separately. The archive
+// must not come from a newer schema; columns the database no longer has are dropped.
+func (d *Database) ImportCompanyData(tables []CompanyArchiveTable, schemaVersion int) (*CompanyImportResult, error) {
+	if schemaVersion > LatestSchemaVersion() {
+		return nil, &SchemaTooNewError{DatabaseVersion: schemaVersion, SupportedVersion: LatestSchemaVersion()}
+	}
+	byName := make(map[string]*CompanyArchiveTable)
+	for i := range tables {
+		byName[tables[i].Name] = &tables[i]
+	}
+	if company := byName["companies"]; company == nil || len(company.Rows) != 1 {
+		return nil, fmt.Errorf("the archive does not hold exactly one company")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &CompanyImportResult{Rows: make(map[string]int), SkippedUsers: []string{}, IDs: make(map[string]map[int]int)}
+	ids := make(map[string]map[int64]int64)
+	var deferred []deferredRef
+
+	for _, spec := range archiveTables {
+		table := byName[spec.name]
+		if table == nil {
+			continue
+		}
+		ids[spec.name] = make(map[int64]int64)
+		result.IDs[spec.name] = make(map[int]int)
+
+		columns, err := tableColumns(tx, spec.name)
+		if err != nil {
+			return nil, err
+		}
+		live := make(map[string]bool)
+		for _, column := range columns {
+			live[column] = true
+		}
+
+		for _, row := range table.Rows {
+			if len(row) != len(table.Columns) {
+				return nil, fmt.Errorf("a row of %s has %d values for %d columns", spec.name, len(row), len(table.Columns))
+			}
+			values := make(map[string]interface{})
+			for i, column := range table.Columns {
+				values[column] = archiveValue(row[i])
+			}
+			oldID, _ := archiveID(values["id"])
+
+			if spec.name == "users" {
+				var taken int
+				err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ? OR email = ?", values["username"], values["email"]).Scan(&taken)
+				if err != nil {
+					return nil, err
+				}
+				if taken > 0 {
+					result.SkippedUsers = append(result.SkippedUsers, fmt.Sprint(values["username"]))
+					continue
+				}
+			}
+
+			var selfRefs []deferredRef
+			insertColumns := []string{}
+			insertValues := []interface{}{}
+			for _, column := range table.Columns {
+				if column == "id" || !live[column] {
+					continue
+				}
+				value := values[column]
+				switch {
+				case column == "company_id" && spec.name != "companies":
+					value = result.CompanyID
+				case spec.name == "companies" && column == "logo_file_id":
+					value = nil
+				case spec.refs[column] != "":
+					target := spec.refs[column]
+					old, ok := archiveID(value)
+					if !ok {
+						break
+					}
+					if target == spec.name {
+						selfRefs = append(selfRefs, deferredRef{table: spec.name, column: column, oldID: old})
+						value = nil
+						break
+					}
+					newID, found := ids[target][old]
+					if column == spec.owner && !found {
+						return nil, fmt.Errorf("a row of %s belongs to %s %d, which is not in the archive", spec.name, target, old)
+					}
+					value = nil
+					if found {
+						value = newID
+					}
+				case spec.typed[column].typeColumn != "":
+					ref := spec.typed[column]
+					old, ok := archiveID(value)
+					if !ok {
+						break
+					}
+					kind := fmt.Sprint(values[ref.typeColumn])
+					target, known := ref.tables[kind]
+					if !known {
+						return nil, fmt.Errorf("%s %d of %s refers to an unknown %s %q", column, old, spec.name, ref.typeColumn, kind)
+					}
+					newID, found := ids[target][old]
+					if !found {
+						return nil, fmt.Errorf("%s refers to %s %d, which is not in the archive", spec.name, target, old)
+					}
+					value = newID
+				}
+				insertColumns = append(insertColumns, column)
+				insertValues = append(insertValues, value)
+			}
+
+			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", spec.name, strings.Join(insertColumns, ", "),
+				strings.TrimSuffix(strings.Repeat("?, ", len(insertColumns)), ", "))
+			res, err := tx.Exec(query, insertValues...)
+			if err != nil {
+				return nil, fmt.Errorf("error importing %s %d: %v", spec.name, oldID, err)
+			}
+			newID, err := res.LastInsertId()
+			if err != nil {
+				return nil, err
+			}
+			ids[spec.name][oldID] = newID
+			result.IDs[spec.name][int(oldID)] = int(newID)
+			result.Rows[spec.name]++
+			if spec.name == "companies" {
+				result.CompanyID = int(newID)
+				result.CompanyName = fmt.Sprint(values["name"])
+			}
+			for _, ref := range selfRefs {
+				ref.id = newID
+				deferred = append(deferred, ref)
+			}
+		}
+	}
+
+	for _, ref := range deferred {
+		newID, found := ids[ref.table][ref.oldID]
+		if !found {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", ref.table, ref.column), newID, ref.id); err != nil {
+			return nil, fmt.Errorf("error linking %s: %v", ref.table, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SetCompanyLogoFile links a company to the file holding its logo
+func (d *Database) SetCompanyLogoFile(companyID, fileID int) error {
+	_, err := d.db.Exec("UPDATE companies SET logo_file_id = ? WHERE id = ?", fileID, companyID)
+	return err
+}
+
+// ClearCompanyLogoFile unlinks a company from the file of its logo, if the company still links to that file
+func (d *Database) ClearCompanyLogoFile(companyID, fileID int) error {
+	_, err := d.db.Exec("UPDATE companies SET logo_file_id = NULL WHERE id = ? AND logo_file_id = ?", companyID, fileID)
+	return err
+}
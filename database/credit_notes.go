@@ -0,0 +1,309 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreateCreditNote creates a credit note with its items. When the credit note references
+// a sales invoice, that invoice must be issued by the same company and the credited total
+// may not exceed what is left of it.
+func (d *Database) CreateCreditNote(note *CreditNote) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Default to company 1 for backward compatibility
+	if note.CompanyID == 0 {
+		note.CompanyID = 1
+	}
+
+	// Whatever the credit note credits beyond the invoice outstanding becomes customer credit
+	creditExcess := note.TotalAmount
+	if note.InvoiceID != nil && *note.InvoiceID > 0 {
+		var invoiceCompanyID, invoiceCustomerID int
+		var invoiceNumber, invoiceStatus string
+		var invoiceTotal, credited float64
+		err = tx.QueryRow(`
+			SELECT si.company_id, si.customer_id, si.invoice_number, si.status, si.total_amount,
+				COALESCE((SELECT SUM(cn.total_amount) FROM credit_notes cn WHERE cn.invoice_id = si.id AND cn.status = 'issued'), 0)
+			FROM sales_invoices si WHERE si.id = ?`, *note.InvoiceID).
+			Scan(&invoiceCompanyID, &invoiceCustomerID, &invoiceNumber, &invoiceStatus, &invoiceTotal, &credited)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("sales invoice %d not found", *note.InvoiceID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if invoiceCompanyID != note.CompanyID {
+			return fmt.Errorf("sales invoice %s belongs to a different company", invoiceNumber)
+		}
+		if invoiceStatus == "draft" || invoiceStatus == "cancelled" {
+			return fmt.Errorf("cannot credit %s sales invoice %s", invoiceStatus, invoiceNumber)
+		}
+
+		if note.CustomerID == 0 {
+			note.CustomerID = invoiceCustomerID
+		} else if note.CustomerID != invoiceCustomerID {
+			return fmt.Errorf("credit note customer does not match the invoice customer")
+		}
+
+		if note.Status == "issued" && note.TotalAmount > invoiceTotal-credited+0.005 {
+			return fmt.Errorf("credit note total %.2f exceeds the remaining invoice amount %.2f", note.TotalAmount, invoiceTotal-credited)
+		}
+
+		_, _, outstanding, outstandingErr := salesInvoiceOutstanding(tx, *note.InvoiceID)
+		if outstandingErr != nil {
+			return outstandingErr
+		}
+		creditExcess = note.TotalAmount - max(outstanding, 0)
+	}
+
+	if note.CreditNoteNumber == "" {
+		note.CreditNoteNumber, err = nextDocumentNumber(tx, "credit_notes", "credit_note_number", "CN-", note.CompanyID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = checkDocumentPeriod(tx, note.CompanyID, note.IssueDate.Time); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO credit_notes (company_id, credit_note_number, invoice_id, customer_id, issue_date, reason, reason_arabic, sub_total, vat_amount, total_amount, status, notes, notes_arabic, created_by, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(query, note.CompanyID, note.CreditNoteNumber, note.InvoiceID, note.CustomerID, note.IssueDate.Time, note.Reason, note.ReasonArabic,
+		note.SubTotal, note.VATAmount, note.TotalAmount, note.Status, note.Notes, note.NotesArabic, note.CreatedBy, note.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	noteID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	note.ID = int(noteID)
+
+	for _, item := range note.Items {
+		itemQuery := `
+			INSERT INTO credit_note_items (credit_note_id, product_id, description, quantity, unit_price, vat_rate, vat_amount, total_amount, vat_category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+		_, execErr := tx.Exec(itemQuery, noteID, item.ProductID, item.Description, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount, item.VATCategory)
+		if execErr != nil {
+			return execErr
+		}
+	}
+
+	if err = postDocument(tx, "credit_note", note.ID); err != nil {
+		return err
+	}
+
+	if note.Status == "issued" && note.CustomerID > 0 && creditExcess > 0.005 {
+		err = insertCreditTransaction(tx, &CustomerCreditTransaction{
+			CompanyID:       note.CompanyID,
+			CustomerID:      note.CustomerID,
+			TransactionDate: note.IssueDate.Time,
+			Type:            "credit_note",
+			Amount:          creditExcess,
+			InvoiceID:       note.InvoiceID,
+			CreditNoteID:    &note.ID,
+			Reference:       note.CreditNoteNumber,
+			CreatedBy:       note.CreatedBy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetCreditNotesByCompany retrieves all credit notes for a company
+func (d *Database) GetCreditNotesByCompany(companyID int) ([]CreditNote, error) {
+	return d.queryCreditNotes(`WHERE cn.company_id = ? AND cn.deleted_at IS NULL ORDER BY cn.issue_date DESC, cn.id DESC`, companyID)
+}
+
+// GetCreditNotesByInvoiceID retrieves all credit notes raised against a sales invoice
+func (d *Database) GetCreditNotesByInvoiceID(invoiceID int) ([]CreditNote, error) {
+	return d.queryCreditNotes(`WHERE cn.invoice_id = ? AND cn.deleted_at IS NULL ORDER BY cn.issue_date DESC, cn.id DESC`, invoiceID)
+}
+
+var creditNoteList = listSpec{
+	table:   "credit_notes",
+	alias:   "cn",
+	search:  []string{"cn.credit_note_number", "cn.reason", "cn.reason_arabic", "cn.notes", "cn.notes_arabic"},
+	date:    "cn.issue_date",
+	filters: map[string]string{"status": "cn.status", "customer_id": "cn.customer_id", "invoice_id": "cn.invoice_id"},
+	sorts: map[string]string{"issue_date": "cn.issue_date", "credit_note_number": "cn.credit_note_number",
+		"total_amount": "cn.total_amount"},
+	sort: "issue_date",
+	desc: true,
+}
+
+// ListCreditNotes retrieves a page of the credit notes of a company, latest first by default. They can be
+// filtered by status, customer_id and invoice_id and sorted by issue_date, credit_note_number or
+// total_amount.
+func (d *Database) ListCreditNotes(q ListQuery) (*CreditNotePage, error) {
+	page := &CreditNotePage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, creditNoteList, q, d.queryCreditNotes, func(note CreditNote) int { return note.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Database) queryCreditNotes(where string, args ...interface{}) ([]CreditNote, error) {
+	rows, err := d.db.Query(`
+		SELECT cn.id, cn.company_id, cn.credit_note_number, cn.invoice_id, cn.customer_id, cn.issue_date,
+			COALESCE(cn.reason, ''), COALESCE(cn.reason_arabic, ''), cn.sub_total, cn.vat_amount, cn.total_amount, cn.status,
+			COALESCE(cn.notes, ''), COALESCE(cn.notes_arabic, ''), cn.created_by, cn.updated_by, cn.created_at, cn.updated_at,
+			c.name, c.name_arabic
+		FROM credit_notes cn
+		LEFT JOIN customers c ON cn.customer_id = c.id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []CreditNote
+	for rows.Next() {
+		var note CreditNote
+		var issueDate time.Time
+		var customerName, customerNameArabic sql.NullString
+
+		err := rows.Scan(&note.ID, &note.CompanyID, &note.CreditNoteNumber, &note.InvoiceID, &note.CustomerID, &issueDate,
+			&note.Reason, &note.ReasonArabic, &note.SubTotal, &note.VATAmount, &note.TotalAmount, &note.Status,
+			&note.Notes, &note.NotesArabic, &note.CreatedBy, &note.UpdatedBy, &note.CreatedAt, &note.UpdatedAt,
+			&customerName, &customerNameArabic)
+		if err != nil {
+			return nil, err
+		}
+
+		note.IssueDate = Date{Time: issueDate}
+		if customerName.Valid {
+			note.Customer = &Customer{ID: note.CustomerID, Name: customerName.String, NameArabic: customerNameArabic.String}
+		}
+
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// GetCreditNoteByID retrieves a credit note with its customer and items
+func (d *Database) GetCreditNoteByID(id int) (*CreditNote, error) {
+	query := `
+		SELECT id, company_id, credit_note_number, invoice_id, customer_id, issue_date,
+			COALESCE(reason, ''), COALESCE(reason_arabic, ''), sub_total, vat_amount, total_amount, status,
+			COALESCE(notes, ''), COALESCE(notes_arabic, ''), created_by, updated_by, created_at, updated_at
+		FROM credit_notes WHERE id = ?`
+
+	var note CreditNote
+	var issueDate time.Time
+	err := d.db.QueryRow(query, id).Scan(&note.ID, &note.CompanyID, &note.CreditNoteNumber, &note.InvoiceID, &note.CustomerID, &issueDate,
+		&note.Reason, &note.ReasonArabic, &note.SubTotal, &note.VATAmount, &note.TotalAmount, &note.Status,
+		&note.Notes, &note.NotesArabic, &note.CreatedBy, &note.UpdatedBy, &note.CreatedAt, &note.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	note.IssueDate = Date{Time: issueDate}
+
+	if note.CustomerID > 0 {
+		customer, customerErr := d.GetCustomerByID(note.CustomerID)
+		if customerErr == nil {
+			note.Customer = customer
+		}
+	}
+
+	items, itemsErr := d.GetCreditNoteItems(note.ID)
+	if itemsErr == nil {
+		note.Items = items
+	}
+
+	return &note, nil
+}
+
+// GetCreditNoteItems retrieves the items of a credit note
+func (d *Database) GetCreditNoteItems(creditNoteID int) ([]CreditNoteItem, error) {
+	query := `SELECT id, credit_note_id, COALESCE(product_id, 0), COALESCE(description, ''), quantity, unit_price, vat_rate, vat_amount, total_amount, COALESCE(vat_category, ''), created_at
+		FROM credit_note_items WHERE credit_note_id = ?`
+
+	rows, err := d.db.Query(query, creditNoteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []CreditNoteItem
+	for rows.Next() {
+		var item CreditNoteItem
+		scanErr := rows.Scan(&item.ID, &item.CreditNoteID, &item.ProductID, &item.Description, &item.Quantity, &item.UnitPrice,
+			&item.VATRate, &item.VATAmount, &item.TotalAmount, &item.VATCategory, &item.CreatedAt)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// CancelCreditNote marks a credit note as cancelled so it no longer affects balances.
+// Customer credit it created is reversed, which fails when that credit has been used.
+func (d *Database) CancelCreditNote(id int, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkStoredDocumentPeriod(tx, "credit_notes", "issue_date", id); err != nil {
+		return err
+	}
+	if err = reverseCreditTransactions(tx, "credit_note_id = ?", id); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE credit_notes SET status = 'cancelled', updated_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, userID, id)
+	if err != nil {
+		return err
+	}
+	if err = postDocument(tx, "credit_note", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCreditNote moves a draft credit note to the recycle bin. Issued credit notes must be cancelled instead.
+func (d *Database) DeleteCreditNote(id int, userID *int) error {
+	var status string
+	if err := d.db.QueryRow(`SELECT status FROM credit_notes WHERE id = ? AND deleted_at IS NULL`, id).Scan(&status); err != nil {
+		return err
+	}
+	if status != "draft" {
+		return fmt.Errorf("only draft credit notes can be deleted; cancel issued credit notes instead")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkStoredDocumentPeriod(tx, "credit_notes", "issue_date", id); err != nil {
+		return err
+	}
+	if err = softDelete(tx, "credit_notes", id, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateCreditNoteChecksInvoice(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	statements := []string{
+		`INSERT INTO companies (id, name, vat_number) VALUES (2, 'Second Company', '300000000000010')`,
+		`INSERT INTO customers (id, name, company_id) VALUES (1, 'First', 1), (2, 'Second', 2)`,
+		`INSERT INTO sales_invoices (id, company_id, invoice_number, customer_id, sales_category_id, issue_date, due_date, sub_total, vat_amount, total_amount, status)
+			VALUES (1, 1, 'SENT', 1, 1, '2024-01-10', '2024-01-10', 100, 0, 100, 'sent'),
+				(2, 1, 'DRAFT', 1, 1, '2024-01-10', '2024-01-10', 100, 0, 100, 'draft'),
+				(3, 1, 'CANCELLED', 1, 1, '2024-01-10', '2024-01-10', 100, 0, 100, 'cancelled'),
+				(4, 2, 'OTHER', 2, 1, '2024-01-10', '2024-01-10', 100, 0, 100, 'sent')`,
+	}
+	for _, statement := range statements {
+		if _, err := d.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		invoiceID, companyID int
+		err                  string
+	}{
+		{1, 1, ""},
+		{1, 0, ""},
+		{2, 1, "cannot credit draft"},
+		{3, 1, "cannot credit cancelled"},
+		{4, 1, "different company"},
+		{1, 2, "different company"},
+	}
+	for _, test := range tests {
+		invoiceID := test.invoiceID
+		note := &CreditNote{CompanyID: test.companyID, InvoiceID: &invoiceID, Status: "draft", SubTotal: 10, TotalAmount: 10,
+			IssueDate: Date{Time: time.Now()}}
+		err := d.CreateCreditNote(note)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("crediting invoice %d for company %d: %v", test.invoiceID, test.companyID, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("crediting invoice %d for company %d: %v, want an error with %q", test.invoiceID, test.companyID, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// salesInvoiceOutstandingSQL is what is left to pay on the sales invoice aliased si after payments,
+// credit notes and applied customer credit
+const salesInvoiceOutstandingSQL = `si.total_amount
+	- COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = si.id AND p.status = 'completed' AND p.deleted_at IS NULL), 0)
+	- COALESCE((SELECT SUM(cn.total_amount) FROM credit_notes cn WHERE cn.invoice_id = si.id AND cn.status = 'issued'), 0)
+	+ COALESCE((SELECT SUM(cct.amount) FROM customer_credit_transactions cct WHERE cct.invoice_id = si.id AND cct.type = 'applied'), 0)`
+
+// salesInvoiceOutstanding returns the customer, total and outstanding amount of a sales invoice
+func salesInvoiceOutstanding(exec execer, invoiceID int) (customerID int, total, outstanding float64, err error) {
+	err = exec.QueryRow(`SELECT si.customer_id, si.total_amount, `+salesInvoiceOutstandingSQL+` FROM sales_invoices si WHERE si.id = ?`, invoiceID).
+		Scan(&customerID, &total, &outstanding)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("sales invoice %d not found", invoiceID)
+	}
+	return
+}
+
+// customerCreditBalance returns the credit a customer has available
+func customerCreditBalance(exec execer, customerID int) (float64, error) {
+	var balance float64
+	err := exec.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM customer_credit_transactions WHERE customer_id = ?`, customerID).Scan(&balance)
+	return roundAmount(balance), err
+}
+
+// insertCreditTransaction appends an entry to a customer's credit history
+func insertCreditTransaction(exec execer, t *CustomerCreditTransaction) error {
+	// Default to company 1 for backward compatibility
+	if t.CompanyID == 0 {
+		t.CompanyID = 1
+	}
+	if t.TransactionDate.IsZero() {
+		t.TransactionDate = time.Now()
+	}
+	if err := checkDocumentPeriod(exec, t.CompanyID, t.TransactionDate); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO customer_credit_transactions (company_id, customer_id, transaction_date, type, amount, payment_type_id, payment_id, invoice_id, credit_note_id,
+			prepayment_invoice_id, reverses_id, reference, notes, notes_arabic, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := exec.Exec(query, t.CompanyID, t.CustomerID, t.TransactionDate, t.Type, roundAmount(t.Amount), t.PaymentTypeID, t.PaymentID, t.InvoiceID, t.CreditNoteID,
+		t.PrepaymentInvoiceID, t.ReversesID, t.Reference, t.Notes, t.NotesArabic, t.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = int(id)
+	t.CreatedAt = time.Now()
+	return postDocument(exec, "customer_credit", t.ID)
+}
+
+// creditSource is an entry that added credit, with how much of it is still unused
+type creditSource struct {
+	ID                  int
+	PrepaymentInvoiceID *int
+	Available           float64
+}
+
+// availableCreditSources returns the entries that still hold unused credit, oldest first.
+// Credit is used first-in first-out so that every use can be traced back to the advance it consumed.
+func availableCreditSources(exec execer, customerID int) ([]creditSource, error) {
+	var used float64
+	err := exec.QueryRow(`SELECT COALESCE(-SUM(amount), 0) FROM customer_credit_transactions WHERE customer_id = ? AND amount < 0 AND type != 'reversal'`, customerID).Scan(&used)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reversals cancel the entry they reverse rather than the oldest credit
+	rows, err := exec.Query(`
+		SELECT cct.id, CASE WHEN cct.type = 'advance' THEN cct.invoice_id END,
+			cct.amount + COALESCE((SELECT SUM(r.amount) FROM customer_credit_transactions r WHERE r.reverses_id = cct.id), 0)
+		FROM customer_credit_transactions cct
+		WHERE cct.customer_id = ? AND cct.amount > 0
+		ORDER BY cct.transaction_date, cct.id`, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []creditSource
+	for rows.Next() {
+		var source creditSource
+		if err := rows.Scan(&source.ID, &source.PrepaymentInvoiceID, &source.Available); err != nil {
+			return nil, err
+		}
+
+		if used >= source.Available {
+			used -= source.Available
+			continue
+		}
+		source.Available -= used
+		used = 0
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// useCustomerCredit takes an amount from a customer's credit balance, splitting it across the oldest
+// sources so each entry records the prepayment invoice it draws on
+func useCustomerCredit(tx *sql.Tx, template CustomerCreditTransaction, amount float64) ([]CustomerCreditTransaction, error) {
+	balance, err := customerCreditBalance(tx, template.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+	if amount > balance+0.005 {
+		return nil, fmt.Errorf("amount %.2f exceeds the customer's credit balance %.2f", amount, balance)
+	}
+
+	sources, err := availableCreditSources(tx, template.CustomerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CustomerCreditTransaction
+	remaining := roundAmount(amount)
+	for _, source := range sources {
+		if remaining <= 0 {
+			break
+		}
+		part := roundAmount(min(source.Available, remaining))
+		if part <= 0 {
+			continue
+		}
+
+		entry := template
+		entry.Amount = -part
+		entry.PrepaymentInvoiceID = source.PrepaymentInvoiceID
+		if err := insertCreditTransaction(tx, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		remaining = roundAmount(remaining - part)
+	}
+
+	return entries, nil
+}
+
+// RecordCustomerAdvance receives a deposit from a customer. As ZATCA requires, the advance is invoiced
+// on a prepayment tax invoice carrying the VAT due on it; the amount then becomes customer credit that
+// is deducted on later invoices.
+func (d *Database) RecordCustomerAdvance(advance *CustomerAdvance) (*SalesInvoice, error) {
+	if advance.Amount <= 0 {
+		return nil, fmt.Errorf("advance amount must be greater than zero")
+	}
+	if advance.CustomerID == 0 {
+		return nil, fmt.Errorf("an advance needs a customer to hold the credit")
+	}
+	if advance.Date.IsZero() {
+		advance.Date = Date{Time: time.Now()}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var salesCategoryID int
+	err = tx.QueryRow(`SELECT id FROM sales_categories WHERE is_active = 1 ORDER BY is_default DESC, id LIMIT 1`).Scan(&salesCategoryID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding a sales category for the prepayment invoice: %v", err)
+	}
+
+	// The advance is VAT inclusive
+	amount := roundAmount(advance.Amount)
+	vatAmount := roundAmount(amount * advance.VATRate / (100 + advance.VATRate))
+	subTotal := roundAmount(amount - vatAmount)
+
+	invoice := &SalesInvoice{
+		CompanyID:       advance.CompanyID,
+		CustomerID:      advance.CustomerID,
+		SalesCategoryID: salesCategoryID,
+		IssueDate:       advance.Date,
+		DueDate:         advance.Date,
+		SubTotal:        subTotal,
+		VATAmount:       vatAmount,
+		TotalAmount:     amount,
+		Status:          "paid",
+		InvoiceType:     "prepayment",
+		Notes:           advance.Notes,
+		NotesArabic:     advance.NotesArabic,
+		CreatedBy:       advance.CreatedBy,
+		UpdatedBy:       advance.CreatedBy,
+		Items: []SalesInvoiceItem{{
+			Quantity:    1,
+			UnitPrice:   subTotal,
+			VATRate:     advance.VATRate,
+			VATAmount:   vatAmount,
+			TotalAmount: amount,
+		}},
+	}
+	if err = d.insertSalesInvoice(tx, invoice); err != nil {
+		return nil, err
+	}
+
+	payment := Payment{
+		CompanyID:     invoice.CompanyID,
+		InvoiceID:     invoice.ID,
+		PaymentTypeID: advance.PaymentTypeID,
+		Amount:        amount,
+		PaymentDate:   advance.Date.Time,
+		Reference:     advance.Reference,
+		Status:        "completed",
+	}
+	if err = insertPayment(tx, &payment); err != nil {
+		return nil, err
+	}
+
+	entry := CustomerCreditTransaction{
+		CompanyID:       invoice.CompanyID,
+		CustomerID:      advance.CustomerID,
+		TransactionDate: advance.Date.Time,
+		Type:            "advance",
+		Amount:          amount,
+		PaymentTypeID:   &advance.PaymentTypeID,
+		PaymentID:       &payment.ID,
+		InvoiceID:       &invoice.ID,
+		Reference:       advance.Reference,
+		Notes:           advance.Notes,
+		NotesArabic:     advance.NotesArabic,
+		CreatedBy:       advance.CreatedBy,
+	}
+	if err = insertCreditTransaction(tx, &entry); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// ApplyCustomerCredit settles (part of) a sales invoice from the customer's credit balance
+func (d *Database) ApplyCustomerCredit(invoiceID int, amount float64, userID *int) ([]CustomerCreditTransaction, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be greater than zero")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var companyID int
+	var status, invoiceType, invoiceNumber string
+	err = tx.QueryRow(`SELECT company_id, status, COALESCE(invoice_type, 'standard'), invoice_number FROM sales_invoices WHERE id = ?`, invoiceID).
+		Scan(&companyID, &status, &invoiceType, &invoiceNumber)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("sales invoice %d not found", invoiceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == "draft" || status == "cancelled" {
+		return nil, fmt.Errorf("credit cannot be applied to a %s invoice", status)
+	}
+	if invoiceType == "prepayment" {
+		return nil, fmt.Errorf("credit cannot be applied to a prepayment invoice")
+	}
+
+	customerID, _, outstanding, err := salesInvoiceOutstanding(tx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if amount > outstanding+0.005 {
+		return nil, fmt.Errorf("amount %.2f exceeds the outstanding amount %.2f of invoice %s", amount, outstanding, invoiceNumber)
+	}
+
+	entries, err := useCustomerCredit(tx, CustomerCreditTransaction{
+		CompanyID:     companyID,
+		CustomerID:    customerID,
+		Type:          "applied",
+		InvoiceID:     &invoiceID,
+		InvoiceNumber: invoiceNumber,
+		CreatedBy:     userID,
+	}, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount >= outstanding-0.005 {
+		if _, err = tx.Exec(`UPDATE sales_invoices SET status = 'paid', updated_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, userID, invoiceID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RefundCustomerCredit pays (part of) a customer's credit balance back through a payment type
+func (d *Database) RefundCustomerCredit(refund *CustomerCreditTransaction) ([]CustomerCreditTransaction, error) {
+	if refund.Amount <= 0 {
+		return nil, fmt.Errorf("refund amount must be greater than zero")
+	}
+	if refund.PaymentTypeID == nil || *refund.PaymentTypeID == 0 {
+		return nil, fmt.Errorf("a payment type is required for a refund")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	template := *refund
+	template.Type = "refund"
+	entries, err := useCustomerCredit(tx, template, refund.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// recordOverpayment keeps whatever a payment paid beyond the invoice outstanding as customer credit
+func recordOverpayment(tx *sql.Tx, payment *Payment) error {
+	if payment.Status != "completed" {
+		return nil
+	}
+
+	customerID, _, outstanding, err := salesInvoiceOutstanding(tx, payment.InvoiceID)
+	if err != nil {
+		return err
+	}
+	// Walk-in customers have no account to hold credit
+	if customerID == 0 || outstanding > -0.005 {
+		return nil
+	}
+
+	// Only the part of the overpayment caused by this payment is new credit
+	excess := min(-outstanding, payment.Amount)
+	return insertCreditTransaction(tx, &CustomerCreditTransaction{
+		CompanyID:       payment.CompanyID,
+		CustomerID:      customerID,
+		TransactionDate: payment.PaymentDate,
+		Type:            "overpayment",
+		Amount:          excess,
+		PaymentTypeID:   &payment.PaymentTypeID,
+		PaymentID:       &payment.ID,
+		InvoiceID:       &payment.InvoiceID,
+		Reference:       payment.Reference,
+	})
+}
+
+// reverseCreditTransactions posts reversals for the credit entries matching a condition, refusing when the
+// credit has already been used
+func reverseCreditTransactions(tx *sql.Tx, where string, args ...interface{}) error {
+	rows, err := tx.Query(`
+		SELECT id, company_id, customer_id, type, amount FROM customer_credit_transactions
+		WHERE `+where+` AND amount > 0 AND id NOT IN (SELECT reverses_id FROM customer_credit_transactions WHERE reverses_id IS NOT NULL)`, args...)
+	if err != nil {
+		return err
+	}
+	var originals []CustomerCreditTransaction
+	for rows.Next() {
+		var t CustomerCreditTransaction
+		if err := rows.Scan(&t.ID, &t.CompanyID, &t.CustomerID, &t.Type, &t.Amount); err != nil {
+			rows.Close()
+			return err
+		}
+		originals = append(originals, t)
+	}
+	rows.Close()
+
+	for _, original := range originals {
+		balance, err := customerCreditBalance(tx, original.CustomerID)
+		if err != nil {
+			return err
+		}
+		if original.Amount > balance+0.005 {
+			return fmt.Errorf("the %s credit of %.2f has already been used; the customer's credit balance is %.2f", original.Type, original.Amount, balance)
+		}
+
+		reversesID := original.ID
+		if err := insertCreditTransaction(tx, &CustomerCreditTransaction{
+			CompanyID:  original.CompanyID,
+			CustomerID: original.CustomerID,
+			Type:       "reversal",
+			Amount:     -original.Amount,
+			ReversesID: &reversesID,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCustomerCreditBalance returns the credit a customer has available
+func (d *Database) GetCustomerCreditBalance(customerID int) (float64, error) {
+	return customerCreditBalance(d.db, customerID)
+}
+
+// GetCustomerCreditTransactions returns a customer's full credit history with a running balance
+func (d *Database) GetCustomerCreditTransactions(customerID int) ([]CustomerCreditTransaction, error) {
+	query := `
+		SELECT cct.id, cct.company_id, cct.customer_id, cct.transaction_date, cct.type, cct.amount, cct.payment_type_id, cct.payment_id,
+			cct.invoice_id, COALESCE(si.invoice_number, ''), cct.credit_note_id, cct.prepayment_invoice_id, cct.reverses_id,
+			COALESCE(cct.reference, ''), COALESCE(cct.notes, ''), COALESCE(cct.notes_arabic, ''), cct.created_by, cct.created_at
+		FROM customer_credit_transactions cct
+		LEFT JOIN sales_invoices si ON cct.invoice_id = si.id
+		WHERE cct.customer_id = ?
+		ORDER BY cct.transaction_date, cct.id`
+
+	rows, err := d.db.Query(query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []CustomerCreditTransaction
+	var balance float64
+	for rows.Next() {
+		var t CustomerCreditTransaction
+		err := rows.Scan(&t.ID, &t.CompanyID, &t.CustomerID, &t.TransactionDate, &t.Type, &t.Amount, &t.PaymentTypeID, &t.PaymentID,
+			&t.InvoiceID, &t.InvoiceNumber, &t.CreditNoteID, &t.PrepaymentInvoiceID, &t.ReversesID,
+			&t.Reference, &t.Notes, &t.NotesArabic, &t.CreatedBy, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		balance += t.Amount
+		t.Balance = roundAmount(balance)
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+// GetPrepaymentDeductions returns the advances deducted on an invoice, per prepayment invoice, with the VAT
+// already charged on them. ZATCA requires these to be shown on the final invoice.
+func (d *Database) GetPrepaymentDeductions(invoiceID int) ([]PrepaymentDeduction, error) {
+	query := `
+		SELECT pi.id, pi.invoice_number, pi.issue_date, pi.total_amount, pi.vat_amount, -SUM(cct.amount)
+		FROM customer_credit_transactions cct
+		JOIN sales_invoices pi ON cct.prepayment_invoice_id = pi.id
+		WHERE cct.invoice_id = ? AND cct.type = 'applied'
+		GROUP BY pi.id
+		ORDER BY pi.issue_date, pi.id`
+
+	rows, err := d.db.Query(query, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deductions []PrepaymentDeduction
+	for rows.Next() {
+		var deduction PrepaymentDeduction
+		var prepaymentTotal, prepaymentVAT float64
+		if err := rows.Scan(&deduction.PrepaymentInvoiceID, &deduction.InvoiceNumber, &deduction.IssueDate,
+			&prepaymentTotal, &prepaymentVAT, &deduction.Amount); err != nil {
+			return nil, err
+		}
+
+		// The deducted part carries VAT in the same proportion as the prepayment invoice
+		if prepaymentTotal > 0 {
+			deduction.VATAmount = roundAmount(deduction.Amount * prepaymentVAT / prepaymentTotal)
+		}
+		deduction.SubTotal = roundAmount(deduction.Amount - deduction.VATAmount)
+		if deduction.SubTotal > 0 {
+			deduction.VATRate = roundAmount(deduction.VATAmount / deduction.SubTotal * 100)
+		}
+		deductions = append(deductions, deduction)
+	}
+	return deductions, rows.Err()
+}
@@ -22,35 +22,40 @@ func (d *Database) CreateCustomer(customer *Customer) error {
 }
 
 func (d *Database) GetCustomers() ([]Customer, error) {
-	query := `SELECT id, name, name_arabic, vat_number, email, phone, address, address_arabic, 
-			  city, city_arabic, country, country_arabic, company_id, created_at, updated_at FROM customers ORDER BY name`
+	return d.queryCustomers(`WHERE c.deleted_at IS NULL ORDER BY c.name`)
+}
+
+func (d *Database) GetCustomersByCompany(companyID int) ([]Customer, error) {
+	return d.queryCustomers(`WHERE c.company_id = ? AND c.deleted_at IS NULL ORDER BY c.name`, companyID)
+}
+
+var customerList = listSpec{
+	table:   "customers",
+	alias:   "c",
+	entity:  "customer",
+	filters: map[string]string{"city": "c.city", "country": "c.country", "vat_number": "c.vat_number"},
+	sorts:   map[string]string{"name": "c.name", "id": "c.id"},
+	sort:    "name",
+}
 
-	rows, err := d.db.Query(query)
+// ListCustomers retrieves a page of the customers of a company. They can be filtered by city, country and
+// vat_number and sorted by name or id, the order they were added in.
+func (d *Database) ListCustomers(q ListQuery) (*CustomerPage, error) {
+	page := &CustomerPage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, customerList, q, d.queryCustomers, func(c Customer) int { return c.ID })
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var customers []Customer
-	for rows.Next() {
-		var c Customer
-		err := rows.Scan(&c.ID, &c.Name, &c.NameArabic, &c.VATNumber, &c.Email, &c.Phone,
-			&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic,
-			&c.CompanyID, &c.CreatedAt, &c.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		customers = append(customers, c)
-	}
-	return customers, nil
+	return page, nil
 }
 
-func (d *Database) GetCustomersByCompany(companyID int) ([]Customer, error) {
-	query := `SELECT id, name, name_arabic, vat_number, email, phone, address, address_arabic, 
-			  city, city_arabic, country, country_arabic, company_id, created_at, updated_at 
-			  FROM customers WHERE company_id = ? ORDER BY name`
+func (d *Database) queryCustomers(where string, args ...interface{}) ([]Customer, error) {
+	query := `SELECT c.id, c.name, c.name_arabic, c.vat_number, c.email, c.phone, c.address, c.address_arabic, 
+			  c.city, c.city_arabic, c.country, c.country_arabic, c.company_id, c.created_at, c.updated_at, c.version 
+			  FROM customers c ` + where
 
-	rows, err := d.db.Query(query, companyID)
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +66,7 @@ func (d *Database) GetCustomersByCompany(companyID int) ([]Customer, error) {
 		var c Customer
 		err := rows.Scan(&c.ID, &c.Name, &c.NameArabic, &c.VATNumber, &c.Email, &c.Phone,
 			&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic,
-			&c.CompanyID, &c.CreatedAt, &c.UpdatedAt)
+			&c.CompanyID, &c.CreatedAt, &c.UpdatedAt, &c.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -72,12 +77,12 @@ func (d *Database) GetCustomersByCompany(companyID int) ([]Customer, error) {
 
 func (d *Database) GetCustomerByID(id int) (*Customer, error) {
 	query := `SELECT id, name, name_arabic, vat_number, email, phone, address, address_arabic, 
-			  city, city_arabic, country, country_arabic, company_id, created_at, updated_at FROM customers WHERE id = ?`
+			  city, city_arabic, country, country_arabic, company_id, created_at, updated_at, version FROM customers WHERE id = ?`
 
 	var c Customer
 	err := d.db.QueryRow(query, id).Scan(&c.ID, &c.Name, &c.NameArabic, &c.VATNumber, &c.Email, &c.Phone,
 		&c.Address, &c.AddressArabic, &c.City, &c.CityArabic, &c.Country, &c.CountryArabic,
-		&c.CompanyID, &c.CreatedAt, &c.UpdatedAt)
+		&c.CompanyID, &c.CreatedAt, &c.UpdatedAt, &c.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -89,16 +94,21 @@ func (d *Database) UpdateCustomer(customer *Customer) error {
 		UPDATE customers SET name = ?, name_arabic = ?, vat_number = ?, email = ?, phone = ?, 
 		address = ?, address_arabic = ?, city = ?, city_arabic = ?, country = ?, country_arabic = ?, 
 		company_id = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`
+		WHERE id = ? AND ` + versionCondition
 
-	_, err := d.db.Exec(query, customer.Name, customer.NameArabic, customer.VATNumber,
+	result, err := d.db.Exec(query, customer.Name, customer.NameArabic, customer.VATNumber,
 		customer.Email, customer.Phone, customer.Address, customer.AddressArabic,
 		customer.City, customer.CityArabic, customer.Country, customer.CountryArabic, 
-		customer.CompanyID, customer.ID)
+		customer.CompanyID, customer.ID, customer.Version)
+	if err != nil {
+		return err
+	}
+	customer.Version, err = checkVersion(d.db, "customers", customer.ID, customer.Version, result,
+		func() (interface{}, error) { return d.GetCustomerByID(customer.ID) })
 	return err
 }
 
-func (d *Database) DeleteCustomer(id int) error {
-	_, err := d.db.Exec("DELETE FROM customers WHERE id = ?", id)
-	return err
+// DeleteCustomer moves a customer to the recycle bin. Invoices issued to the customer keep it.
+func (d *Database) DeleteCustomer(id int, userID *int) error {
+	return softDelete(d.db, "customers", id, userID)
 }
\ No newline at end of file
@@ -2,12 +2,21 @@ package database
 
 import (
 	"database/sql"
+	"log"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Database struct {
-	db *sql.DB
+	db    *sql.DB
+	store *sealedStore // Set when the database is encrypted at rest
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx so writes can join a running transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
 // NewDatabase creates a new database connection
@@ -18,15 +27,46 @@ func NewDatabase(dbPath string) (*Database, error) {
 	}
 
 	database := &Database{db: db}
-	if err := database.createTables(); err != nil {
+	if err := database.initialize(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return database, nil
 }
 
-// Close closes the database connection
+// initialize brings the schema up to date and inserts the default data
+func (d *Database) initialize() error {
+	if err := checkFTS5(d.db); err != nil {
+		return err
+	}
+	if err := d.migrate(migrations); err != nil {
+		return err
+	}
+
+	// Changes are attributed to a user once one signs in
+	if err := d.ClearAuditUser(); err != nil {
+		return err
+	}
+
+	// Insert default company if not exists
+	if err := d.insertDefaultCompany(); err != nil {
+		log.Printf("Warning: Could not insert default company: %v", err)
+	}
+
+	// Insert default settings data
+	if err := d.insertDefaultSettings(); err != nil {
+		log.Printf("Warning: Could not insert default settings: %v", err)
+	}
+
+	return nil
+}
+
+// Close closes the database connection. An encrypted database is sealed one last time first.
 func (d *Database) Close() error {
+	if d.store != nil {
+		return d.store.close(d.db)
+	}
 	return d.db.Close()
 }
 
@@ -57,9 +57,9 @@ func (d *Database) insertDefaultSettings() error {
 
 	if taxCount == 0 {
 		taxRates := []TaxRate{
-			{Name: "Standard VAT", NameArabic: "ضريبة القيمة المضافة", Rate: 15.0, Description: "Standard VAT rate", IsDefault: true, IsActive: true},
-			{Name: "Zero VAT", NameArabic: "معفى من الضريبة", Rate: 0.0, Description: "Zero VAT rate", IsDefault: false, IsActive: true},
-			{Name: "Exempt", NameArabic: "معفى", Rate: 0.0, Description: "VAT exempt", IsDefault: false, IsActive: true},
+			{Name: "Standard VAT", NameArabic: "ضريبة القيمة المضافة", Rate: 15.0, VATCategory: VATCategoryStandard, Description: "Standard VAT rate", IsDefault: true, IsActive: true},
+			{Name: "Zero VAT", NameArabic: "معفى من الضريبة", Rate: 0.0, VATCategory: VATCategoryZeroRated, Description: "Zero VAT rate", IsDefault: false, IsActive: true},
+			{Name: "Exempt", NameArabic: "معفى", Rate: 0.0, VATCategory: VATCategoryExempt, Description: "VAT exempt", IsDefault: false, IsActive: true},
 		}
 
 		for _, tr := range taxRates {
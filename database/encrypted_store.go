@@ -0,0 +1,392 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// sealedStore backs an encrypted database. The plaintext database lives only in memory, in a memdb shared by
+// the connections of the pool, and is sealed with the keyring and written to disk as part of each commit: the
+// statement or transaction that commits only returns once the sealed file holding it has replaced the old one.
+// A write seals the whole database, so its cost follows the size of the database rather than the size of the
+// change: BenchmarkEncryptedCommit measures it at about 10 ms for 3 MiB and 80 ms for 23 MiB. Commits made
+// while a write is under way share the next one.
+type sealedStore struct {
+	path    string
+	keyring *Keyring
+	anchor  *sql.Conn // Keeps the in-memory database alive and is used to load and snapshot it
+
+	mu      sync.Mutex    // Serialises writes of the sealed file
+	opened  atomic.Bool   // Set once the database is loaded and migrated; commits before are written together
+	commits atomic.Uint64 // Counts the commits saved
+	written uint64        // The number of commits held by the sealed file, guarded by mu
+}
+
+// sealedConnector opens the connections of an encrypted database
+type sealedConnector struct {
+	dsn   string
+	store *sealedStore
+}
+
+func (c sealedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	sealed := &sealedConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), store: c.store}
+	sealed.RegisterCommitHook(func() int {
+		sealed.committed = true
+		return 0
+	})
+	return sealed, nil
+}
+
+func (c sealedConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+// sealedConn is a connection to an encrypted database. Once a statement run outside a transaction, or a
+// transaction, has committed, it writes the database to disk before returning. Statements that commit
+// otherwise, such as prepared ones, are written with the next commit or when the database is closed.
+type sealedConn struct {
+	*sqlite3.SQLiteConn
+	store     *sealedStore
+	committed bool // Set by the commit hook, which runs on the goroutine using the connection
+}
+
+func (c *sealedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *sealedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedTx{Tx: tx, conn: c}, nil
+}
+
+func (c *sealedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// save writes the database once the connection has committed and is no longer in a transaction
+func (c *sealedConn) save() error {
+	if !c.committed || !c.AutoCommit() {
+		return nil
+	}
+	c.committed = false
+	if !c.store.opened.Load() {
+		return nil
+	}
+	if err := c.store.save(); err != nil {
+		return fmt.Errorf("error saving the encrypted database: %v", err)
+	}
+	return nil
+}
+
+// sealedTx is a transaction on an encrypted database, written to disk when it commits
+type sealedTx struct {
+	driver.Tx
+	conn *sealedConn
+}
+
+func (tx *sealedTx) Commit() error {
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+	return tx.conn.save()
+}
+
+// OpenEncryptedDatabase decrypts the sealed database at path with the keyring into memory and opens it. The
+// schema is migrated and the database sealed again with the keyring's active key.
+func OpenEncryptedDatabase(path string, keyring *Keyring) (*Database, error) {
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encrypted database: %v", err)
+	}
+	plain, err := keyring.Unseal(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	store := &sealedStore{path: path, keyring: keyring}
+	db := sql.OpenDB(sealedConnector{dsn: fmt.Sprintf("file:/dijibill-%s?vfs=memdb", hex.EncodeToString(name)), store: store})
+	store.anchor, err = db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := store.load(plain); err != nil {
+		store.anchor.Close()
+		db.Close()
+		return nil, err
+	}
+
+	database := &Database{db: db, store: store}
+	if err := database.initialize(); err != nil {
+		store.anchor.Close()
+		db.Close()
+		return nil, err
+	}
+	if err := store.flush(); err != nil {
+		database.Close()
+		return nil, err
+	}
+	store.opened.Store(true)
+	return database, nil
+}
+
+// load copies a database image into the in-memory database with SQLite's backup API
+func (s *sealedStore) load(image []byte) error {
+	source, err := deserialize(image)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	conn, err := source.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(src interface{}) error {
+		return s.anchor.Raw(func(dest interface{}) error {
+			backup, err := rawSQLiteConn(dest).Backup("main", rawSQLiteConn(src), "main")
+			if err != nil {
+				return fmt.Errorf("error loading encrypted database: %v", err)
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return fmt.Errorf("error loading encrypted database: %v", err)
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// save returns once the commit that called it is in the sealed file. Commits that land while the file is
+// being written wait for it and are then written together, so a busy database is not sealed once per commit.
+func (s *sealedStore) save() error {
+	commit := s.commits.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.written >= commit {
+		return nil
+	}
+	return s.write()
+}
+
+// flush seals a snapshot of the in-memory database and writes it over the sealed file
+func (s *sealedStore) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write()
+}
+
+// write seals and writes the database with mu held. The commits counted before the snapshot are all in it.
+func (s *sealedStore) write() error {
+	commits := s.commits.Load()
+	image, err := snapshotConn(s.anchor)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.keyring.Seal(image)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, sealed, 0600); err != nil {
+		return err
+	}
+	s.written = commits
+	return nil
+}
+
+// close writes the database a last time and closes it
+func (s *sealedStore) close(db *sql.DB) error {
+	err := s.flush()
+	s.anchor.Close()
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Encrypted reports whether the database is encrypted at rest
+func (d *Database) Encrypted() bool {
+	return d.store != nil
+}
+
+// Flush writes an encrypted database to disk now, sealed with the keyring's active key. It does nothing for
+// a database that is not encrypted.
+func (d *Database) Flush() error {
+	if d.store == nil {
+		return nil
+	}
+	return d.store.flush()
+}
+
+// Snapshot returns a consistent image of the database file, taken in memory
+func (d *Database) Snapshot() ([]byte, error) {
+	if d.store != nil {
+		d.store.mu.Lock()
+		defer d.store.mu.Unlock()
+		return snapshotConn(d.store.anchor)
+	}
+	return SnapshotSQLite(d.db)
+}
+
+// SnapshotSQLite returns a consistent image of an open SQLite database without writing it to disk
+func SnapshotSQLite(db *sql.DB) ([]byte, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return snapshotConn(conn)
+}
+
+// rawSQLiteConn returns the SQLite connection of a driver connection, which may be that of an encrypted database
+func rawSQLiteConn(dc interface{}) *sqlite3.SQLiteConn {
+	if sealed, ok := dc.(*sealedConn); ok {
+		return sealed.SQLiteConn
+	}
+	return dc.(*sqlite3.SQLiteConn)
+}
+
+// snapshotConn serialises the database of a connection inside a read transaction, so no commit lands
+// halfway through the copy
+func snapshotConn(conn *sql.Conn) ([]byte, error) {
+	var image []byte
+	err := conn.Raw(func(dc interface{}) error {
+		c := rawSQLiteConn(dc)
+		if _, err := c.Exec("BEGIN", nil); err != nil {
+			return err
+		}
+		defer c.Exec("COMMIT", nil)
+
+		// The first read takes the shared lock that is then held until COMMIT
+		rows, err := c.Query("SELECT COUNT(*) FROM sqlite_master", nil)
+		if err != nil {
+			return err
+		}
+		dest := make([]driver.Value, 1)
+		err = rows.Next(dest)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		image, err = c.Serialize("main")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error taking database snapshot: %v", err)
+	}
+	return image, nil
+}
+
+// deserialize opens a database image in a private in-memory database. The image cannot grow, so the database
+// is only fit for reading.
+func deserialize(image []byte) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	// Every connection to :memory: is a separate database, so keep the one the image is loaded into
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc interface{}) error {
+		return dc.(*sqlite3.SQLiteConn).Deserialize(image, "main")
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("the data is not a SQLite database: %v", err)
+	}
+	return db, nil
+}
+
+// EncryptDatabaseFile seals the closed plaintext database at dbPath with the keyring into EncryptedPath(dbPath)
+// and removes the plaintext file
+func EncryptDatabaseFile(dbPath string, keyring *Keyring) error {
+	image, err := os.ReadFile(dbPath)
+	if err != nil {
+		return fmt.Errorf("error reading database: %v", err)
+	}
+	if err := VerifyBackupData(image); err != nil {
+		return err
+	}
+	sealed, err := keyring.Seal(image)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(EncryptedPath(dbPath), sealed, 0600); err != nil {
+		return fmt.Errorf("error writing encrypted database: %v", err)
+	}
+	return RemovePlaintextDatabase(dbPath)
+}
+
+// DecryptDatabaseFile writes the sealed database of dbPath back as a plaintext file and removes the sealed
+// file and the keyring. The keyring goes first so an interruption leaves a database that opens either way.
+func DecryptDatabaseFile(dbPath string, keyring *Keyring) error {
+	sealed, err := os.ReadFile(EncryptedPath(dbPath))
+	if err != nil {
+		return fmt.Errorf("error reading encrypted database: %v", err)
+	}
+	image, err := keyring.Unseal(sealed)
+	if err != nil {
+		return err
+	}
+	if err := VerifyBackupData(image); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(dbPath, image, 0644); err != nil {
+		return fmt.Errorf("error writing database: %v", err)
+	}
+	if err := os.Remove(keyring.Path()); err != nil {
+		return fmt.Errorf("error removing keyring: %v", err)
+	}
+	if err := os.Remove(EncryptedPath(dbPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing encrypted database: %v", err)
+	}
+	return nil
+}
+
+// RemovePlaintextDatabase removes a plaintext database file and its rollback journal, once its content is
+// held in the sealed file
+func RemovePlaintextDatabase(dbPath string) error {
+	for _, path := range []string{dbPath, dbPath + "-journal"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing plaintext database: %v", err)
+		}
+	}
+	return nil
+}
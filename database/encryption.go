@@ -0,0 +1,412 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	keyringVersion      = 1
+	minPassphraseLength = 8
+
+	// sealedMagic starts every file sealed with a keyring key
+	sealedMagic = "DJBSEAL1"
+)
+
+// Argon2id parameters for deriving the key that wraps the data keys from the passphrase
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024
+	kdfThreads = 4
+)
+
+// KeyringPath returns where the keyring of a database is kept, e.g. dijibill.key for dijibill.db
+func KeyringPath(dbPath string) string {
+	return strings.TrimSuffix(dbPath, filepath.Ext(dbPath)) + ".key"
+}
+
+// EncryptedPath returns where the sealed copy of an encrypted database is kept
+func EncryptedPath(dbPath string) string {
+	return dbPath + ".enc"
+}
+
+// IsEncrypted reports whether the database at dbPath is encrypted at rest, that is whether it has a keyring
+func IsEncrypted(dbPath string) bool {
+	_, err := os.Stat(KeyringPath(dbPath))
+	return err == nil
+}
+
+// kdfParams are the Argon2id parameters a keyring's passphrase is derived with
+type kdfParams struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// wrappedKey is a data key encrypted with the key derived from the passphrase
+type wrappedKey struct {
+	ID        string     `json:"id"`
+	Key       []byte     `json:"key"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+type keyringFile struct {
+	Version int          `json:"version"`
+	KDF     kdfParams    `json:"kdf"`
+	Active  string       `json:"active"`
+	Keys    []wrappedKey `json:"keys"`
+}
+
+// Keyring holds the data keys that encrypt the database and its backups. The keys are stored wrapped with a
+// key derived from the admin passphrase. Rotating adds a new active key and keeps the retired ones so older
+// backups can still be read.
+type Keyring struct {
+	mu   sync.RWMutex
+	path string
+	file keyringFile
+	kek  []byte
+	keys map[string][]byte
+}
+
+func validatePassphrase(passphrase string) error {
+	if len([]rune(passphrase)) < minPassphraseLength {
+		return fmt.Errorf("the passphrase must be at least %d characters", minPassphraseLength)
+	}
+	return nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("error generating random bytes: %v", err)
+	}
+	return b, nil
+}
+
+func newKDFParams() (kdfParams, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return kdfParams{}, err
+	}
+	return kdfParams{Salt: salt, Time: kdfTime, Memory: kdfMemory, Threads: kdfThreads}, nil
+}
+
+func (p kdfParams) derive(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, 32)
+}
+
+// CreateKeyring creates a keyring with a new data key at path, protected by passphrase
+func CreateKeyring(path, passphrase string) (*Keyring, error) {
+	if err := validatePassphrase(passphrase); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("a keyring already exists at %s", path)
+	}
+	params, err := newKDFParams()
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{
+		path: path,
+		file: keyringFile{Version: keyringVersion, KDF: params},
+		kek:  params.derive(passphrase),
+		keys: make(map[string][]byte),
+	}
+	if err := k.addKey(); err != nil {
+		return nil, err
+	}
+	if err := k.save(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// UnlockKeyring reads the keyring at path and unwraps its keys with passphrase
+func UnlockKeyring(path, passphrase string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring: %v", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("the keyring is damaged: %v", err)
+	}
+	if file.Version > keyringVersion {
+		return nil, fmt.Errorf("the keyring was written by a newer version of the application")
+	}
+
+	k := &Keyring{path: path, file: file, kek: file.KDF.derive(passphrase), keys: make(map[string][]byte)}
+	for _, wrapped := range file.Keys {
+		key, err := openGCM(k.kek, wrapped.Key, []byte(wrapped.ID))
+		if err != nil {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+		k.keys[wrapped.ID] = key
+	}
+	if _, ok := k.keys[file.Active]; !ok {
+		return nil, fmt.Errorf("the keyring has no active key")
+	}
+	return k, nil
+}
+
+// addKey generates a data key, wraps it and makes it the active key, retiring the previous one
+func (k *Keyring) addKey() error {
+	key, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	id, err := randomBytes(8)
+	if err != nil {
+		return err
+	}
+	keyID := hex.EncodeToString(id)
+	wrapped, err := sealGCM(k.kek, key, []byte(keyID))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range k.file.Keys {
+		if k.file.Keys[i].RetiredAt == nil {
+			k.file.Keys[i].RetiredAt = &now
+		}
+	}
+	k.file.Keys = append(k.file.Keys, wrappedKey{ID: keyID, Key: wrapped, CreatedAt: now})
+	k.file.Active = keyID
+	k.keys[keyID] = key
+	return nil
+}
+
+// save writes the keyring to its file
+func (k *Keyring) save() error {
+	data, err := json.MarshalIndent(k.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(k.path, data, 0600)
+}
+
+// Rotate makes a new data key active. The database has to be sealed again for the new key to protect it;
+// retired keys are kept to read older backups.
+func (k *Keyring) Rotate() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	previous := k.file
+	if err := k.addKey(); err != nil {
+		return err
+	}
+	if err := k.save(); err != nil {
+		k.file = previous
+		return err
+	}
+	return nil
+}
+
+// ChangePassphrase wraps every key with a key derived from a new passphrase
+func (k *Keyring) ChangePassphrase(passphrase string) error {
+	if err := validatePassphrase(passphrase); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	params, err := newKDFParams()
+	if err != nil {
+		return err
+	}
+	kek := params.derive(passphrase)
+	file := k.file
+	file.KDF = params
+	file.Keys = make([]wrappedKey, len(k.file.Keys))
+	for i, wrapped := range k.file.Keys {
+		rewrapped, err := sealGCM(kek, k.keys[wrapped.ID], []byte(wrapped.ID))
+		if err != nil {
+			return err
+		}
+		wrapped.Key = rewrapped
+		file.Keys[i] = wrapped
+	}
+
+	previous, previousKEK := k.file, k.kek
+	k.file, k.kek = file, kek
+	if err := k.save(); err != nil {
+		k.file, k.kek = previous, previousKEK
+		return err
+	}
+	return nil
+}
+
+// Path returns the keyring's file
+func (k *Keyring) Path() string {
+	return k.path
+}
+
+// Export returns the keyring file as stored. The keys in it stay wrapped by the passphrase.
+func (k *Keyring) Export() ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return json.MarshalIndent(k.file, "", "  ")
+}
+
+// Status describes the keyring's keys
+func (k *Keyring) Status() EncryptionStatus {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	status := EncryptionStatus{Enabled: true, Unlocked: true, ActiveKeyID: k.file.Active}
+	for _, key := range k.file.Keys {
+		if key.ID == k.file.Active {
+			created := key.CreatedAt
+			status.ActiveKeyCreatedAt = &created
+		} else {
+			status.RetiredKeys++
+		}
+	}
+	return status
+}
+
+// IsSealed reports whether data was sealed with a keyring key
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedMagic))
+}
+
+// SealedKeyID returns the ID of the key data was sealed with
+func SealedKeyID(data []byte) (string, error) {
+	header, _, err := splitSealed(data)
+	if err != nil {
+		return "", err
+	}
+	return string(header[len(sealedMagic)+1:]), nil
+}
+
+// Seal encrypts data with AES-256-GCM under the active key. The header naming the key is authenticated too.
+func (k *Keyring) Seal(data []byte) ([]byte, error) {
+	k.mu.RLock()
+	keyID, key := k.file.Active, k.keys[k.file.Active]
+	k.mu.RUnlock()
+
+	header := append([]byte(sealedMagic), byte(len(keyID)))
+	header = append(header, keyID...)
+	sealed, err := sealGCM(key, data, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, sealed...), nil
+}
+
+// Unseal decrypts data sealed with any key of the keyring, active or retired
+func (k *Keyring) Unseal(data []byte) ([]byte, error) {
+	header, sealed, err := splitSealed(data)
+	if err != nil {
+		return nil, err
+	}
+	keyID := string(header[len(sealedMagic)+1:])
+	k.mu.RLock()
+	key, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("the data was encrypted with key %s, which is not in this keyring", keyID)
+	}
+
+	plain, err := openGCM(key, sealed, header)
+	if err != nil {
+		return nil, fmt.Errorf("the encrypted data is damaged or was altered")
+	}
+	return plain, nil
+}
+
+// splitSealed splits sealed data into its header and the nonce and ciphertext that follow it
+func splitSealed(data []byte) ([]byte, []byte, error) {
+	if !IsSealed(data) || len(data) < len(sealedMagic)+1 {
+		return nil, nil, fmt.Errorf("the data is not encrypted")
+	}
+	end := len(sealedMagic) + 1 + int(data[len(sealedMagic)])
+	if len(data) < end {
+		return nil, nil, fmt.Errorf("the encrypted data is truncated")
+	}
+	return data[:end], data[end:], nil
+}
+
+// sealGCM encrypts plain with AES-GCM and returns the nonce followed by the ciphertext
+func sealGCM(key, plain, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, additional), nil
+}
+
+// openGCM decrypts the output of sealGCM
+func openGCM(key, sealed, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("the encrypted data is truncated")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
+
+// writeFileAtomic writes data to a temporary file beside path, syncs it and renames it over path, so path
+// holds either the old or the new content even if the write is interrupted
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Sync the directory so the rename survives a crash. Not every platform can open a directory, and the
+	// file is complete either way.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
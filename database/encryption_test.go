@@ -0,0 +1,327 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery"
+
+func TestSealAndUnseal(t *testing.T) {
+	keyring, err := CreateKeyring(filepath.Join(t.TempDir(), "dijibill.key"), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte("SQLite format 3\x00 and the rest of the database")
+	sealed, err := keyring.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || IsSealed(plain) || bytes.Contains(sealed, plain[16:]) {
+		t.Fatal("the sealed data is not told apart from plaintext")
+	}
+	if id, err := SealedKeyID(sealed); err != nil || id != keyring.Status().ActiveKeyID {
+		t.Errorf("sealed with key %q (%v), want the active key %s", id, err, keyring.Status().ActiveKeyID)
+	}
+	opened, err := keyring.Unseal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Errorf("Unseal = %q, want %q", opened, plain)
+	}
+
+	for _, i := range []int{len(sealed) - 1, len(sealed) / 2, 10} {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		if _, err := keyring.Unseal(tampered); err == nil {
+			t.Errorf("data changed at byte %d was unsealed", i)
+		}
+	}
+}
+
+func TestUnlockKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dijibill.key")
+	keyring, err := CreateKeyring(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := keyring.Seal([]byte("invoice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnlockKeyring(path, "wrong horse battery"); err == nil {
+		t.Fatal("the keyring was unlocked with the wrong passphrase")
+	}
+	unlocked, err := UnlockKeyring(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := unlocked.Unseal(sealed); err != nil || string(opened) != "invoice" {
+		t.Errorf("Unseal with the unlocked keyring = %q, %v", opened, err)
+	}
+	if _, err := CreateKeyring(filepath.Join(t.TempDir(), "short.key"), "short"); err == nil {
+		t.Error("a short passphrase was accepted")
+	}
+}
+
+func TestRotateKeepsRetiredKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dijibill.key")
+	keyring, err := CreateKeyring(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := keyring.Seal([]byte("before"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey := keyring.Status().ActiveKeyID
+
+	if err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	newKey := keyring.Status().ActiveKeyID
+	if newKey == oldKey {
+		t.Fatal("the active key did not change")
+	}
+	current, err := keyring.Seal([]byte("after"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := SealedKeyID(current); id != newKey {
+		t.Errorf("sealed with key %s after the rotation, want %s", id, newKey)
+	}
+
+	// The rotation is saved with the keyring, retired key included
+	unlocked, err := UnlockKeyring(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.Status().ActiveKeyID != newKey {
+		t.Errorf("the saved keyring has active key %s, want %s", unlocked.Status().ActiveKeyID, newKey)
+	}
+	for want, sealed := range map[string][]byte{"before": old, "after": current} {
+		if opened, err := unlocked.Unseal(sealed); err != nil || string(opened) != want {
+			t.Errorf("Unseal = %q, %v, want %q", opened, err, want)
+		}
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dijibill.key")
+	keyring, err := CreateKeyring(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := keyring.Seal([]byte("invoice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.ChangePassphrase("short"); err == nil {
+		t.Fatal("a short passphrase was accepted")
+	}
+	if err := keyring.ChangePassphrase("battery staple horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnlockKeyring(path, testPassphrase); err == nil {
+		t.Error("the keyring still unlocks with the old passphrase")
+	}
+	unlocked, err := UnlockKeyring(path, "battery staple horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.Status().ActiveKeyID != keyring.Status().ActiveKeyID {
+		t.Error("changing the passphrase changed the keys")
+	}
+	if opened, err := unlocked.Unseal(sealed); err != nil || string(opened) != "invoice" {
+		t.Errorf("Unseal = %q, %v", opened, err)
+	}
+}
+
+// sealedCustomers reads the sealed file of an encrypted database as it is on disk and counts its customers
+// named name
+func sealedCustomers(t *testing.T, path string, keyring *Keyring, name string) int {
+	t.Helper()
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := keyring.Unseal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := deserialize(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM customers WHERE name = ?", name).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// openEncryptedDatabase creates a database at dbPath, lets seed fill it and opens it encrypted. Seeding the
+// plaintext file is faster than sealing the database after each statement.
+func openEncryptedDatabase(tb testing.TB, dbPath string, seed func(*sql.DB) error) *Database {
+	tb.Helper()
+	plain, err := NewDatabase(dbPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if seed != nil {
+		err = seed(plain.db)
+	}
+	plain.Close()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keyring, err := CreateKeyring(KeyringPath(dbPath), testPassphrase)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := EncryptDatabaseFile(dbPath, keyring); err != nil {
+		tb.Fatal(err)
+	}
+	d, err := OpenEncryptedDatabase(EncryptedPath(dbPath), keyring)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestEncryptedDatabaseWritesEachCommit(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "dijibill.db")
+	plain, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+	keyring, err := CreateKeyring(KeyringPath(dbPath), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptDatabaseFile(dbPath, keyring); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatal("the plaintext database was not removed")
+	}
+
+	d, err := OpenEncryptedDatabase(EncryptedPath(dbPath), keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	sealedPath := EncryptedPath(dbPath)
+
+	// Each commit is on disk once it returns, without waiting for the database to be closed
+	if _, err := d.db.Exec("INSERT INTO customers (name, company_id) VALUES ('Statement', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	if sealedCustomers(t, sealedPath, keyring, "Statement") != 1 {
+		t.Error("a committed statement is not in the sealed file")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO customers (name, company_id) VALUES ('Transaction', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	if sealedCustomers(t, sealedPath, keyring, "Transaction") != 0 {
+		t.Error("an uncommitted transaction is in the sealed file")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if sealedCustomers(t, sealedPath, keyring, "Transaction") != 1 {
+		t.Error("a committed transaction is not in the sealed file")
+	}
+
+	tx, err = d.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO customers (name, company_id) VALUES ('Rolled back', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	if sealedCustomers(t, sealedPath, keyring, "Rolled back") != 0 {
+		t.Error("a rolled back transaction is in the sealed file")
+	}
+	if _, err := os.Stat(sealedPath + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+
+	// After a rotation the database is sealed with the new key
+	if err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(sealedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := SealedKeyID(sealed); id != keyring.Status().ActiveKeyID {
+		t.Errorf("the database is sealed with key %s after the rotation, want %s", id, keyring.Status().ActiveKeyID)
+	}
+}
+
+// BenchmarkEncryptedCommit measures a one-row insert into encrypted databases of growing size. Each commit
+// seals the whole database and writes it to disk before it returns, so the cost follows the size of the file.
+func BenchmarkEncryptedCommit(b *testing.B) {
+	for _, invoices := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprintf("%d invoices", invoices), func(b *testing.B) {
+			d, size := benchmarkEncryptedDatabase(b, invoices)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := d.db.Exec("INSERT INTO customers (name, company_id) VALUES (?, 1)", fmt.Sprintf("Bench %d", i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(size, "MiB")
+		})
+	}
+}
+
+// BenchmarkEncryptedCommitParallel measures inserts made at the same time, which share the writes of the file
+func BenchmarkEncryptedCommitParallel(b *testing.B) {
+	d, size := benchmarkEncryptedDatabase(b, 10000)
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := d.db.Exec("INSERT INTO customers (name, company_id) VALUES ('Bench', 1)"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(size, "MiB")
+}
+
+// benchmarkEncryptedDatabase opens an encrypted database with the number of sales invoices and products
+// given, and returns it with the size of its sealed file in MiB
+func benchmarkEncryptedDatabase(b *testing.B, invoices int) (*Database, float64) {
+	dbPath := filepath.Join(b.TempDir(), "dijibill.db")
+	d := openEncryptedDatabase(b, dbPath, func(db *sql.DB) error {
+		return seedSalesInvoices(db, 1, 100, invoices)
+	})
+	info, err := os.Stat(EncryptedPath(dbPath))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return d, float64(info.Size()) / (1 << 20)
+}
@@ -0,0 +1,694 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Recurring expense frequencies
+const (
+	FrequencyWeekly    = "weekly"
+	FrequencyMonthly   = "monthly"
+	FrequencyQuarterly = "quarterly"
+	FrequencyYearly    = "yearly"
+)
+
+// defaultExpenseCategories are created for a company the first time its expense categories are read
+var defaultExpenseCategories = []ExpenseCategory{
+	{Code: "rent", Name: "Rent", NameArabic: "الإيجار", VATCategory: VATCategoryStandard},
+	{Code: "utilities", Name: "Utilities", NameArabic: "المرافق", VATCategory: VATCategoryStandard},
+	{Code: "salaries", Name: "Salaries and Wages", NameArabic: "الرواتب والأجور", VATCategory: VATCategoryOutOfScope},
+	{Code: "office", Name: "Office Supplies", NameArabic: "مستلزمات مكتبية", VATCategory: VATCategoryStandard},
+	{Code: "government_fees", Name: "Government Fees", NameArabic: "رسوم حكومية", VATCategory: VATCategoryOutOfScope},
+	{Code: "other", Name: "Other Expenses", NameArabic: "مصروفات أخرى", VATCategory: VATCategoryStandard},
+}
+
+// GetExpenseCategoriesByCompany retrieves the expense categories of a company, creating the default ones if it has none
+func (d *Database) GetExpenseCategoriesByCompany(companyID int) ([]ExpenseCategory, error) {
+	var count int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM expense_categories WHERE company_id = ?`, companyID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		for _, category := range defaultExpenseCategories {
+			category.CompanyID = companyID
+			category.IsActive = true
+			if err := d.CreateExpenseCategory(&category); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows, err := d.db.Query(`
+		SELECT id, company_id, code, name, COALESCE(name_arabic, ''), COALESCE(vat_category, ''), is_active, created_at, updated_at
+		FROM expense_categories WHERE company_id = ? ORDER BY name`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []ExpenseCategory
+	for rows.Next() {
+		var c ExpenseCategory
+		if err := rows.Scan(&c.ID, &c.CompanyID, &c.Code, &c.Name, &c.NameArabic, &c.VATCategory, &c.IsActive, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// GetExpenseCategoryByID retrieves an expense category
+func (d *Database) GetExpenseCategoryByID(id int) (*ExpenseCategory, error) {
+	return getExpenseCategory(d.db, id)
+}
+
+func getExpenseCategory(exec execer, id int) (*ExpenseCategory, error) {
+	var c ExpenseCategory
+	err := exec.QueryRow(`
+		SELECT id, company_id, code, name, COALESCE(name_arabic, ''), COALESCE(vat_category, ''), is_active, created_at, updated_at
+		FROM expense_categories WHERE id = ?`, id).
+		Scan(&c.ID, &c.CompanyID, &c.Code, &c.Name, &c.NameArabic, &c.VATCategory, &c.IsActive, &c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("expense category %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CreateExpenseCategory adds an expense category to a company
+func (d *Database) CreateExpenseCategory(category *ExpenseCategory) error {
+	if err := validateExpenseCategory(category); err != nil {
+		return err
+	}
+
+	// Default to company 1 for backward compatibility
+	if category.CompanyID == 0 {
+		category.CompanyID = 1
+	}
+
+	result, err := d.db.Exec(`INSERT INTO expense_categories (company_id, code, name, name_arabic, vat_category, is_active) VALUES (?, ?, ?, ?, ?, ?)`,
+		category.CompanyID, category.Code, category.Name, category.NameArabic, category.VATCategory, category.IsActive)
+	if err != nil {
+		return fmt.Errorf("error creating expense category: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	category.ID = int(id)
+	return nil
+}
+
+// UpdateExpenseCategory updates an expense category
+func (d *Database) UpdateExpenseCategory(category *ExpenseCategory) error {
+	if err := validateExpenseCategory(category); err != nil {
+		return err
+	}
+
+	_, err := d.db.Exec(`
+		UPDATE expense_categories SET code = ?, name = ?, name_arabic = ?, vat_category = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		category.Code, category.Name, category.NameArabic, category.VATCategory, category.IsActive, category.ID)
+	if err != nil {
+		return fmt.Errorf("error updating expense category: %v", err)
+	}
+	return nil
+}
+
+// DeleteExpenseCategory deletes an expense category that no expense uses
+func (d *Database) DeleteExpenseCategory(id int) error {
+	var used int
+	err := d.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM expenses WHERE category_id = ?)
+			+ (SELECT COUNT(*) FROM recurring_expenses WHERE category_id = ?)`, id, id).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used > 0 {
+		return fmt.Errorf("the expense category is used by %d expenses; deactivate it instead", used)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM account_mappings WHERE entity_type = 'expense_category' AND entity_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM expense_categories WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func validateExpenseCategory(category *ExpenseCategory) error {
+	if category.Code == "" || category.Name == "" {
+		return fmt.Errorf("expense category code and name are required")
+	}
+	if category.VATCategory != "" && !vatCategories[category.VATCategory] {
+		return fmt.Errorf("invalid VAT category %q", category.VATCategory)
+	}
+	return nil
+}
+
+// prepareExpense validates an expense and fills in its VAT, totals and payee from its category and supplier
+func prepareExpense(exec execer, expense *Expense) error {
+	// Default to company 1 for backward compatibility
+	if expense.CompanyID == 0 {
+		expense.CompanyID = 1
+	}
+	if expense.Status == "" {
+		expense.Status = "recorded"
+	}
+	switch expense.Status {
+	case "draft", "recorded", "cancelled":
+	default:
+		return fmt.Errorf("invalid expense status %q", expense.Status)
+	}
+	if expense.Description == "" {
+		return fmt.Errorf("an expense needs a description")
+	}
+	if expense.ExpenseDate.IsZero() {
+		return fmt.Errorf("an expense needs a date")
+	}
+	if expense.Amount <= 0 {
+		return fmt.Errorf("expense amount must be greater than zero")
+	}
+	if expense.VATRate < 0 {
+		return fmt.Errorf("the VAT rate cannot be negative")
+	}
+	if expense.PaymentTypeID == 0 {
+		return fmt.Errorf("select how the expense was paid")
+	}
+	if expense.BranchID != nil && *expense.BranchID == 0 {
+		expense.BranchID = nil
+	}
+	if err := checkBranch(exec, expense.CompanyID, expense.BranchID); err != nil {
+		return err
+	}
+
+	category, err := getExpenseCategory(exec, expense.CategoryID)
+	if err != nil {
+		return err
+	}
+	if category.CompanyID != expense.CompanyID {
+		return fmt.Errorf("the expense category belongs to a different company")
+	}
+	if expense.VATCategory == "" {
+		expense.VATCategory = category.VATCategory
+	}
+	if expense.VATCategory != "" && !vatCategories[expense.VATCategory] {
+		return fmt.Errorf("invalid VAT category %q", expense.VATCategory)
+	}
+	if expense.VATCategory == VATCategoryOutOfScope || expense.VATCategory == VATCategoryExempt {
+		expense.VATRate = 0
+	}
+
+	if expense.SupplierID != nil && *expense.SupplierID == 0 {
+		expense.SupplierID = nil
+	}
+	if expense.SupplierID != nil {
+		var supplierName string
+		err := exec.QueryRow(`SELECT company_name FROM suppliers WHERE id = ?`, *expense.SupplierID).Scan(&supplierName)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("supplier %d not found", *expense.SupplierID)
+		}
+		if err != nil {
+			return err
+		}
+		expense.PayeeName = supplierName
+	}
+	if expense.PayeeName == "" {
+		return fmt.Errorf("an expense needs a supplier or payee")
+	}
+
+	expense.Amount = roundAmount(expense.Amount)
+	expense.VATAmount = roundAmount(expense.Amount * expense.VATRate / 100)
+	expense.TotalAmount = roundAmount(expense.Amount + expense.VATAmount)
+	return nil
+}
+
+// CreateExpense records an expense and posts it to the general ledger
+func (d *Database) CreateExpense(expense *Expense) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertExpense(tx, expense); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertExpense inserts and posts an expense within a transaction
+func insertExpense(exec execer, expense *Expense) error {
+	if err := prepareExpense(exec, expense); err != nil {
+		return err
+	}
+	if err := checkDocumentPeriod(exec, expense.CompanyID, expense.ExpenseDate.Time); err != nil {
+		return err
+	}
+	if expense.ExpenseNumber == "" {
+		number, err := generateExpenseNumber(exec, expense.CompanyID)
+		if err != nil {
+			return err
+		}
+		expense.ExpenseNumber = number
+	}
+
+	result, err := exec.Exec(`
+		INSERT INTO expenses (company_id, branch_id, expense_number, category_id, supplier_id, payee_name, expense_date, description, description_arabic,
+			amount, vat_rate, vat_amount, vat_category, vat_recoverable, total_amount, payment_type_id, reference, notes, notes_arabic, status,
+			recurring_expense_id, created_by, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		expense.CompanyID, expense.BranchID, expense.ExpenseNumber, expense.CategoryID, expense.SupplierID, expense.PayeeName, expense.ExpenseDate.Time,
+		expense.Description, expense.DescriptionArabic, expense.Amount, expense.VATRate, expense.VATAmount, expense.VATCategory, expense.VATRecoverable,
+		expense.TotalAmount, expense.PaymentTypeID, expense.Reference, expense.Notes, expense.NotesArabic, expense.Status,
+		expense.RecurringExpenseID, expense.CreatedBy, expense.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("error creating expense: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	expense.ID = int(id)
+	return postDocument(exec, "expense", expense.ID)
+}
+
+// GetExpensesByCompany retrieves the expenses of a company between two dates, latest first.
+// Zero dates leave the range open.
+func (d *Database) GetExpensesByCompany(companyID int, from, to time.Time) ([]Expense, error) {
+	where := "WHERE e.company_id = ? AND e.deleted_at IS NULL"
+	args := []interface{}{companyID}
+	if !from.IsZero() {
+		where += " AND DATE(e.expense_date) >= DATE(?)"
+		args = append(args, dateOnly(from))
+	}
+	if !to.IsZero() {
+		where += " AND DATE(e.expense_date) <= DATE(?)"
+		args = append(args, dateOnly(to))
+	}
+	return queryExpenses(d.db, where+" ORDER BY e.expense_date DESC, e.id DESC", args...)
+}
+
+var expenseList = listSpec{
+	table:  "expenses",
+	alias:  "e",
+	search: []string{"e.expense_number", "e.payee_name", "e.description", "e.description_arabic", "e.reference"},
+	date:   "e.expense_date",
+	filters: map[string]string{"category_id": "e.category_id", "supplier_id": "e.supplier_id", "branch_id": "e.branch_id",
+		"status": "e.status"},
+	sorts: map[string]string{"expense_date": "e.expense_date", "expense_number": "e.expense_number",
+		"total_amount": "e.total_amount"},
+	sort: "expense_date",
+	desc: true,
+}
+
+// ListExpenses retrieves a page of the expenses of a company, latest first by default. They can be filtered
+// by category_id, supplier_id, branch_id and status and sorted by expense_date, expense_number or
+// total_amount.
+func (d *Database) ListExpenses(q ListQuery) (*ExpensePage, error) {
+	query := func(where string, args ...interface{}) ([]Expense, error) { return queryExpenses(d.db, where, args...) }
+	page := &ExpensePage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, expenseList, q, query, func(e Expense) int { return e.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// GetExpenseByID retrieves an expense
+func (d *Database) GetExpenseByID(id int) (*Expense, error) {
+	expenses, err := queryExpenses(d.db, "WHERE e.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(expenses) == 0 {
+		return nil, fmt.Errorf("expense %d not found", id)
+	}
+	return &expenses[0], nil
+}
+
+func queryExpenses(exec execer, where string, args ...interface{}) ([]Expense, error) {
+	rows, err := exec.Query(`
+		SELECT e.id, e.company_id, e.branch_id, e.expense_number, e.category_id, e.supplier_id, COALESCE(e.payee_name, ''), e.expense_date,
+			e.description, COALESCE(e.description_arabic, ''), e.amount, e.vat_rate, e.vat_amount, COALESCE(e.vat_category, ''), e.vat_recoverable,
+			e.total_amount, e.payment_type_id, COALESCE(e.reference, ''), COALESCE(e.notes, ''), COALESCE(e.notes_arabic, ''), e.status,
+			e.recurring_expense_id, e.created_by, e.updated_by, e.created_at, e.updated_at, e.version,
+			ec.id, ec.company_id, ec.code, ec.name, COALESCE(ec.name_arabic, ''), COALESCE(ec.vat_category, ''), ec.is_active
+		FROM expenses e
+		JOIN expense_categories ec ON e.category_id = ec.id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expenses []Expense
+	for rows.Next() {
+		var e Expense
+		var category ExpenseCategory
+		var expenseDate time.Time
+		if err := rows.Scan(&e.ID, &e.CompanyID, &e.BranchID, &e.ExpenseNumber, &e.CategoryID, &e.SupplierID, &e.PayeeName, &expenseDate,
+			&e.Description, &e.DescriptionArabic, &e.Amount, &e.VATRate, &e.VATAmount, &e.VATCategory, &e.VATRecoverable,
+			&e.TotalAmount, &e.PaymentTypeID, &e.Reference, &e.Notes, &e.NotesArabic, &e.Status,
+			&e.RecurringExpenseID, &e.CreatedBy, &e.UpdatedBy, &e.CreatedAt, &e.UpdatedAt, &e.Version,
+			&category.ID, &category.CompanyID, &category.Code, &category.Name, &category.NameArabic, &category.VATCategory, &category.IsActive); err != nil {
+			return nil, err
+		}
+		e.ExpenseDate = Date{Time: expenseDate}
+		e.Category = &category
+		expenses = append(expenses, e)
+	}
+	return expenses, rows.Err()
+}
+
+// UpdateExpense updates an expense and reposts it
+func (d *Database) UpdateExpense(expense *Expense) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = prepareExpense(tx, expense); err != nil {
+		return err
+	}
+	if err = checkStoredDocumentPeriod(tx, "expenses", "expense_date", expense.ID, expense.ExpenseDate.Time); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE expenses SET branch_id = ?, category_id = ?, supplier_id = ?, payee_name = ?, expense_date = ?, description = ?, description_arabic = ?,
+			amount = ?, vat_rate = ?, vat_amount = ?, vat_category = ?, vat_recoverable = ?, total_amount = ?, payment_type_id = ?, reference = ?,
+			notes = ?, notes_arabic = ?, status = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND `+versionCondition,
+		expense.BranchID, expense.CategoryID, expense.SupplierID, expense.PayeeName, expense.ExpenseDate.Time, expense.Description, expense.DescriptionArabic,
+		expense.Amount, expense.VATRate, expense.VATAmount, expense.VATCategory, expense.VATRecoverable, expense.TotalAmount, expense.PaymentTypeID, expense.Reference,
+		expense.Notes, expense.NotesArabic, expense.Status, expense.UpdatedBy, expense.ID, expense.Version)
+	if err != nil {
+		return fmt.Errorf("error updating expense: %v", err)
+	}
+	version, err := checkVersion(tx, "expenses", expense.ID, expense.Version, result,
+		func() (interface{}, error) { return d.GetExpenseByID(expense.ID) })
+	if err != nil {
+		return err
+	}
+	if err = postDocument(tx, "expense", expense.ID); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	expense.Version = version
+	return nil
+}
+
+// DeleteExpense moves an expense to the recycle bin and reverses its posting
+func (d *Database) DeleteExpense(id int, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkStoredDocumentPeriod(tx, "expenses", "expense_date", id); err != nil {
+		return err
+	}
+	if err = softDelete(tx, "expenses", id, userID); err != nil {
+		return err
+	}
+	if err = postDocument(tx, "expense", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func generateExpenseNumber(exec execer, companyID int) (string, error) {
+	var count int
+	if err := exec.QueryRow(`SELECT COUNT(*) FROM expenses WHERE company_id = ?`, companyID).Scan(&count); err != nil {
+		return "", err
+	}
+	for {
+		count++
+		number := fmt.Sprintf("EX-%06d", count)
+		var exists int
+		if err := exec.QueryRow(`SELECT COUNT(*) FROM expenses WHERE company_id = ? AND expense_number = ?`, companyID, number).Scan(&exists); err != nil {
+			return "", err
+		}
+		if exists == 0 {
+			return number, nil
+		}
+	}
+}
+
+// recurrenceDate returns the date of the nth occurrence of a recurring expense, counting from 0. Monthly
+// occurrences keep the day of the start date, falling back to the end of shorter months.
+func recurrenceDate(start time.Time, frequency string, n int) (time.Time, error) {
+	var months int
+	switch frequency {
+	case FrequencyWeekly:
+		return start.AddDate(0, 0, 7*n), nil
+	case FrequencyMonthly:
+		months = n
+	case FrequencyQuarterly:
+		months = 3 * n
+	case FrequencyYearly:
+		months = 12 * n
+	default:
+		return time.Time{}, fmt.Errorf("invalid frequency %q", frequency)
+	}
+	first := time.Date(start.Year(), start.Month()+time.Month(months), 1, 0, 0, 0, 0, start.Location())
+	return time.Date(first.Year(), first.Month(), min(start.Day(), monthEnd(first).Day()), 0, 0, 0, 0, start.Location()), nil
+}
+
+// validateRecurringExpense checks a recurring expense against the rules of the expenses it records
+func validateRecurringExpense(exec execer, recurring *RecurringExpense) error {
+	// Default to company 1 for backward compatibility
+	if recurring.CompanyID == 0 {
+		recurring.CompanyID = 1
+	}
+	if recurring.StartDate.IsZero() {
+		return fmt.Errorf("a recurring expense needs a start date")
+	}
+	if recurring.EndDate != nil && recurring.EndDate.IsZero() {
+		recurring.EndDate = nil
+	}
+	if recurring.EndDate != nil && recurring.EndDate.Before(recurring.StartDate.Time) {
+		return fmt.Errorf("the end date is before the start date")
+	}
+	if _, err := recurrenceDate(recurring.StartDate.Time, recurring.Frequency, 0); err != nil {
+		return err
+	}
+	sample := recurring.expense(recurring.StartDate.Time)
+	return prepareExpense(exec, sample)
+}
+
+// expense returns the expense a recurring expense records on a date
+func (r *RecurringExpense) expense(date time.Time) *Expense {
+	return &Expense{
+		CompanyID:          r.CompanyID,
+		BranchID:           r.BranchID,
+		CategoryID:         r.CategoryID,
+		SupplierID:         r.SupplierID,
+		PayeeName:          r.PayeeName,
+		ExpenseDate:        Date{Time: date},
+		Description:        r.Description,
+		DescriptionArabic:  r.DescriptionArabic,
+		Amount:             r.Amount,
+		VATRate:            r.VATRate,
+		VATRecoverable:     r.VATRecoverable,
+		PaymentTypeID:      r.PaymentTypeID,
+		Status:             "recorded",
+		RecurringExpenseID: &r.ID,
+		CreatedBy:          r.CreatedBy,
+	}
+}
+
+// CreateRecurringExpense creates a recurring expense whose first expense falls on its start date
+func (d *Database) CreateRecurringExpense(recurring *RecurringExpense) error {
+	if err := validateRecurringExpense(d.db, recurring); err != nil {
+		return err
+	}
+	recurring.NextDate = recurring.StartDate
+	recurring.Occurrences = 0
+
+	result, err := d.db.Exec(`
+		INSERT INTO recurring_expenses (company_id, branch_id, category_id, supplier_id, payee_name, description, description_arabic, amount, vat_rate,
+			vat_recoverable, payment_type_id, frequency, start_date, end_date, next_date, occurrences, is_active, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		recurring.CompanyID, recurring.BranchID, recurring.CategoryID, recurring.SupplierID, recurring.PayeeName, recurring.Description,
+		recurring.DescriptionArabic, recurring.Amount, recurring.VATRate, recurring.VATRecoverable, recurring.PaymentTypeID, recurring.Frequency,
+		recurring.StartDate.Time, recurringEndDate(recurring), recurring.NextDate.Time, recurring.Occurrences, recurring.IsActive, recurring.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("error creating recurring expense: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	recurring.ID = int(id)
+	return nil
+}
+
+// UpdateRecurringExpense updates a recurring expense. Expenses it already recorded are not changed; a new
+// start date restarts the schedule from that date.
+func (d *Database) UpdateRecurringExpense(recurring *RecurringExpense) error {
+	if err := validateRecurringExpense(d.db, recurring); err != nil {
+		return err
+	}
+
+	var storedStart time.Time
+	var storedFrequency string
+	err := d.db.QueryRow(`SELECT start_date, frequency FROM recurring_expenses WHERE id = ?`, recurring.ID).Scan(&storedStart, &storedFrequency)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("recurring expense %d not found", recurring.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	reschedule := ""
+	args := []interface{}{recurring.BranchID, recurring.CategoryID, recurring.SupplierID, recurring.PayeeName, recurring.Description,
+		recurring.DescriptionArabic, recurring.Amount, recurring.VATRate, recurring.VATRecoverable, recurring.PaymentTypeID,
+		recurringEndDate(recurring), recurring.IsActive}
+	if !storedStart.Equal(recurring.StartDate.Time) || storedFrequency != recurring.Frequency {
+		reschedule = ", frequency = ?, start_date = ?, next_date = ?, occurrences = 0"
+		args = append(args, recurring.Frequency, recurring.StartDate.Time, recurring.StartDate.Time)
+	}
+	args = append(args, recurring.ID)
+
+	_, err = d.db.Exec(`
+		UPDATE recurring_expenses SET branch_id = ?, category_id = ?, supplier_id = ?, payee_name = ?, description = ?, description_arabic = ?,
+			amount = ?, vat_rate = ?, vat_recoverable = ?, payment_type_id = ?, end_date = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP`+reschedule+`
+		WHERE id = ?`, args...)
+	if err != nil {
+		return fmt.Errorf("error updating recurring expense: %v", err)
+	}
+	return nil
+}
+
+func recurringEndDate(recurring *RecurringExpense) interface{} {
+	if recurring.EndDate == nil {
+		return nil
+	}
+	return recurring.EndDate.Time
+}
+
+// DeleteRecurringExpense deletes a recurring expense; the expenses it recorded are kept
+func (d *Database) DeleteRecurringExpense(id int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`UPDATE expenses SET recurring_expense_id = NULL WHERE recurring_expense_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM recurring_expenses WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRecurringExpensesByCompany retrieves the recurring expenses of a company by their next date
+func (d *Database) GetRecurringExpensesByCompany(companyID int) ([]RecurringExpense, error) {
+	return queryRecurringExpenses(d.db, "WHERE company_id = ? ORDER BY is_active DESC, next_date", companyID)
+}
+
+func queryRecurringExpenses(exec execer, where string, args ...interface{}) ([]RecurringExpense, error) {
+	rows, err := exec.Query(`
+		SELECT id, company_id, branch_id, category_id, supplier_id, COALESCE(payee_name, ''), description, COALESCE(description_arabic, ''),
+			amount, vat_rate, vat_recoverable, payment_type_id, frequency, start_date, end_date, next_date, occurrences, is_active, created_by,
+			created_at, updated_at
+		FROM recurring_expenses `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recurring []RecurringExpense
+	for rows.Next() {
+		var r RecurringExpense
+		var startDate, nextDate time.Time
+		var endDate sql.NullTime
+		if err := rows.Scan(&r.ID, &r.CompanyID, &r.BranchID, &r.CategoryID, &r.SupplierID, &r.PayeeName, &r.Description, &r.DescriptionArabic,
+			&r.Amount, &r.VATRate, &r.VATRecoverable, &r.PaymentTypeID, &r.Frequency, &startDate, &endDate, &nextDate, &r.Occurrences, &r.IsActive,
+			&r.CreatedBy, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		r.StartDate = Date{Time: startDate}
+		r.NextDate = Date{Time: nextDate}
+		if endDate.Valid {
+			r.EndDate = &Date{Time: endDate.Time}
+		}
+		recurring = append(recurring, r)
+	}
+	return recurring, rows.Err()
+}
+
+// GenerateRecurringExpenses records every occurrence of the company's active recurring expenses due on or
+// before a date and returns the expenses recorded. Recurring expenses past their end date are deactivated.
+func (d *Database) GenerateRecurringExpenses(companyID int, through time.Time) ([]Expense, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	due, err := queryRecurringExpenses(tx, "WHERE company_id = ? AND is_active = 1 AND DATE(next_date) <= DATE(?) ORDER BY next_date, id",
+		companyID, dateOnly(through))
+	if err != nil {
+		return nil, err
+	}
+
+	var recorded []Expense
+	for _, recurring := range due {
+		next := recurring.NextDate.Time
+		active := true
+		for !next.After(through) {
+			if recurring.EndDate != nil && next.After(recurring.EndDate.Time) {
+				active = false
+				break
+			}
+			expense := recurring.expense(next)
+			if err := insertExpense(tx, expense); err != nil {
+				return nil, fmt.Errorf("error recording %s on %s: %v", recurring.Description, dateOnly(next), err)
+			}
+			recorded = append(recorded, *expense)
+
+			recurring.Occurrences++
+			if next, err = recurrenceDate(recurring.StartDate.Time, recurring.Frequency, recurring.Occurrences); err != nil {
+				return nil, err
+			}
+		}
+		if recurring.EndDate != nil && next.After(recurring.EndDate.Time) {
+			active = false
+		}
+
+		_, err = tx.Exec(`UPDATE recurring_expenses SET next_date = ?, occurrences = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			next, recurring.Occurrences, active, recurring.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
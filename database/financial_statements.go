@@ -0,0 +1,386 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Comparison columns of financial statements
+const (
+	ComparisonNone           = ""
+	ComparisonPreviousPeriod = "previous_period"
+	ComparisonPreviousYear   = "previous_year"
+	ComparisonBoth           = "both"
+)
+
+// accountBalance is the sum of the postings to an account
+type accountBalance struct {
+	Debit  float64
+	Credit float64
+}
+
+func (b accountBalance) net() float64 {
+	return roundAmount(b.Debit - b.Credit)
+}
+
+// signed returns the balance as a debit (sign 1) or credit (sign -1) amount
+func (b accountBalance) signed(sign float64) float64 {
+	net := b.net()
+	if net == 0 {
+		return 0
+	}
+	return sign * net
+}
+
+// isMonthStart and isMonthEnd tell whether a period is made of whole calendar months
+func isMonthStart(date time.Time) bool {
+	return date.Day() == 1
+}
+
+func isMonthEnd(date time.Time) bool {
+	return date.AddDate(0, 0, 1).Day() == 1
+}
+
+// monthEnd returns the last day of the month of a date
+func monthEnd(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month()+1, 0, 0, 0, 0, 0, date.Location())
+}
+
+// financialReportColumns returns the current period followed by the requested comparison columns. Periods of whole
+// months compare with the same number of months, other periods with the same number of days.
+func financialReportColumns(from, to time.Time, comparison string) ([]FinancialReportColumn, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("the period end is before its start")
+	}
+	columns := []FinancialReportColumn{{Key: "current", Label: "Current period", LabelArabic: "الفترة الحالية", From: from, To: to}}
+
+	switch comparison {
+	case ComparisonNone, ComparisonPreviousPeriod, ComparisonPreviousYear, ComparisonBoth:
+	default:
+		return nil, fmt.Errorf("invalid comparison %q", comparison)
+	}
+
+	if comparison == ComparisonPreviousPeriod || comparison == ComparisonBoth {
+		previous := FinancialReportColumn{Key: ComparisonPreviousPeriod, Label: "Previous period", LabelArabic: "الفترة السابقة", To: from.AddDate(0, 0, -1)}
+		if isMonthStart(from) && isMonthEnd(to) {
+			months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1
+			previous.From = from.AddDate(0, -months, 0)
+		} else {
+			previous.From = previous.To.AddDate(0, 0, -daysBetween(from, to))
+		}
+		columns = append(columns, previous)
+	}
+	if comparison == ComparisonPreviousYear || comparison == ComparisonBoth {
+		previous := FinancialReportColumn{Key: ComparisonPreviousYear, Label: "Same period last year", LabelArabic: "نفس الفترة من العام السابق",
+			From: from.AddDate(-1, 0, 0), To: to.AddDate(-1, 0, 0)}
+		if isMonthEnd(to) {
+			previous.To = monthEnd(time.Date(to.Year()-1, to.Month(), 1, 0, 0, 0, 0, to.Location()))
+		}
+		columns = append(columns, previous)
+	}
+	return columns, nil
+}
+
+// accountBalances sums the postings per account of a company between two dates, optionally for one branch.
+// A zero from date starts at the first posting; the income statement leaves out the entries closing fiscal years.
+func (d *Database) accountBalances(companyID, branchID int, from, to time.Time, excludeClosing bool) (map[int]accountBalance, error) {
+	query := `
+		SELECT jl.account_id, SUM(jl.debit), SUM(jl.credit)
+		FROM journal_lines jl
+		JOIN journal_entries je ON jl.entry_id = je.id
+		WHERE je.company_id = ? AND DATE(je.entry_date) >= DATE(?) AND DATE(je.entry_date) <= DATE(?)`
+	args := []interface{}{companyID, dateOnly(from), dateOnly(to)}
+	if branchID > 0 {
+		query += ` AND je.branch_id = ?`
+		args = append(args, branchID)
+	}
+	if excludeClosing {
+		query += ` AND je.source_type != ?`
+		args = append(args, yearEndCloseSource)
+	}
+	query += ` GROUP BY jl.account_id`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[int]accountBalance)
+	for rows.Next() {
+		var accountID int
+		var balance accountBalance
+		if err := rows.Scan(&accountID, &balance.Debit, &balance.Credit); err != nil {
+			return nil, err
+		}
+		balances[accountID] = balance
+	}
+	return balances, rows.Err()
+}
+
+// GetTrialBalance returns the opening balance, movements and closing balance of every account with postings.
+// The comparison columns show the closing balances at the end of earlier periods.
+func (d *Database) GetTrialBalance(companyID, branchID int, from, to time.Time, comparison string) (*TrialBalance, error) {
+	columns, err := financialReportColumns(from, to, comparison)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := d.GetAccountsByCompany(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	opening, err := d.accountBalances(companyID, branchID, time.Time{}, from.AddDate(0, 0, -1), false)
+	if err != nil {
+		return nil, err
+	}
+	movements, err := d.accountBalances(companyID, branchID, from, to, false)
+	if err != nil {
+		return nil, err
+	}
+	var comparisons []map[int]accountBalance
+	for _, column := range columns[1:] {
+		balances, err := d.accountBalances(companyID, branchID, time.Time{}, column.To, false)
+		if err != nil {
+			return nil, err
+		}
+		comparisons = append(comparisons, balances)
+	}
+
+	trialBalance := &TrialBalance{CompanyID: companyID, BranchID: branchID, Columns: columns, Lines: []TrialBalanceLine{}}
+	for _, account := range accounts {
+		line := TrialBalanceLine{
+			AccountID:         account.ID,
+			AccountCode:       account.Code,
+			AccountName:       account.Name,
+			AccountNameArabic: account.NameArabic,
+			AccountType:       account.Type,
+			OpeningBalance:    opening[account.ID].net(),
+			Debit:             roundAmount(movements[account.ID].Debit),
+			Credit:            roundAmount(movements[account.ID].Credit),
+			Comparisons:       []float64{},
+		}
+		closing := roundAmount(line.OpeningBalance + line.Debit - line.Credit)
+		if closing > 0 {
+			line.ClosingDebit = closing
+		} else {
+			line.ClosingCredit = -closing
+		}
+
+		hasBalance := line.OpeningBalance != 0 || line.Debit != 0 || line.Credit != 0
+		for _, balances := range comparisons {
+			balance := balances[account.ID].net()
+			line.Comparisons = append(line.Comparisons, balance)
+			hasBalance = hasBalance || balance != 0
+		}
+		if !hasBalance {
+			continue
+		}
+
+		trialBalance.Lines = append(trialBalance.Lines, line)
+		trialBalance.TotalDebit += line.Debit
+		trialBalance.TotalCredit += line.Credit
+		trialBalance.TotalClosingDebit += line.ClosingDebit
+		trialBalance.TotalClosingCredit += line.ClosingCredit
+	}
+	trialBalance.TotalDebit = roundAmount(trialBalance.TotalDebit)
+	trialBalance.TotalCredit = roundAmount(trialBalance.TotalCredit)
+	trialBalance.TotalClosingDebit = roundAmount(trialBalance.TotalClosingDebit)
+	trialBalance.TotalClosingCredit = roundAmount(trialBalance.TotalClosingCredit)
+
+	return trialBalance, nil
+}
+
+// statementBuilder adds the lines of a financial statement with one amount per column
+type statementBuilder struct {
+	statement *FinancialStatement
+}
+
+func (b *statementBuilder) heading(section, label, labelArabic string) {
+	b.statement.Lines = append(b.statement.Lines, FinancialStatementLine{Kind: "heading", Section: section, Label: label, LabelArabic: labelArabic})
+}
+
+// accounts adds a line per account with a balance in any column and returns the section totals. Balances are
+// turned into amounts with sign: 1 for debit balances, -1 for credit balances.
+func (b *statementBuilder) accounts(section string, accounts []Account, balances []map[int]accountBalance, sign float64) []float64 {
+	totals := make([]float64, len(balances))
+	for _, account := range accounts {
+		amounts := make([]float64, len(balances))
+		hasBalance := false
+		for i, columnBalances := range balances {
+			amounts[i] = columnBalances[account.ID].signed(sign)
+			totals[i] += amounts[i]
+			hasBalance = hasBalance || amounts[i] != 0
+		}
+		if !hasBalance {
+			continue
+		}
+		b.statement.Lines = append(b.statement.Lines, FinancialStatementLine{
+			Kind:        "account",
+			Section:     section,
+			AccountID:   account.ID,
+			AccountCode: account.Code,
+			Label:       account.Name,
+			LabelArabic: account.NameArabic,
+			Amounts:     amounts,
+		})
+	}
+	for i := range totals {
+		totals[i] = roundAmount(totals[i])
+	}
+	return totals
+}
+
+func (b *statementBuilder) total(section, label, labelArabic string, amounts []float64) {
+	b.statement.Lines = append(b.statement.Lines, FinancialStatementLine{Kind: "total", Section: section, Label: label, LabelArabic: labelArabic, Amounts: amounts})
+}
+
+// accountTotals sums the balances of accounts per column with sign: 1 for debit balances, -1 for credit balances
+func accountTotals(accounts []Account, balances []map[int]accountBalance, sign float64) []float64 {
+	totals := make([]float64, len(balances))
+	for i, columnBalances := range balances {
+		for _, account := range accounts {
+			totals[i] += columnBalances[account.ID].signed(sign)
+		}
+		totals[i] = roundAmount(totals[i])
+	}
+	return totals
+}
+
+// combine adds or subtracts column totals
+func combine(a []float64, sign float64, b []float64) []float64 {
+	result := make([]float64, len(a))
+	for i := range a {
+		result[i] = roundAmount(a[i] + sign*b[i])
+	}
+	return result
+}
+
+// accountsByType splits the chart of accounts by type; expense accounts used for purchases or the cost of
+// goods sold form the cost of sales
+func (d *Database) accountsByType(companyID int) (map[string][]Account, error) {
+	accounts, err := d.GetAccountsByCompany(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	costOfSales := make(map[int]bool)
+	rows, err := d.db.Query(`SELECT DISTINCT account_id FROM account_mappings WHERE company_id = ? AND role IN (?, ?)`,
+		companyID, AccountRolePurchases, AccountRoleCOGS)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var accountID int
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, err
+		}
+		costOfSales[accountID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byType := make(map[string][]Account)
+	for _, account := range accounts {
+		accountType := account.Type
+		if accountType == "expense" && costOfSales[account.ID] {
+			accountType = "cost_of_sales"
+		}
+		byType[accountType] = append(byType[accountType], account)
+	}
+	return byType, nil
+}
+
+// GetProfitAndLoss returns the income statement of a company for a period, optionally for one branch
+func (d *Database) GetProfitAndLoss(companyID, branchID int, from, to time.Time, comparison string) (*FinancialStatement, error) {
+	columns, err := financialReportColumns(from, to, comparison)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := d.accountsByType(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var balances []map[int]accountBalance
+	for _, column := range columns {
+		columnBalances, err := d.accountBalances(companyID, branchID, column.From, column.To, true)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, columnBalances)
+	}
+
+	statement := &FinancialStatement{Type: "profit_and_loss", CompanyID: companyID, BranchID: branchID, Columns: columns}
+	b := &statementBuilder{statement: statement}
+
+	b.heading("revenue", "Revenue", "الإيرادات")
+	revenue := b.accounts("revenue", accounts["revenue"], balances, -1)
+	b.total("revenue", "Total revenue", "إجمالي الإيرادات", revenue)
+
+	b.heading("cost_of_sales", "Cost of sales", "تكلفة المبيعات")
+	costOfSales := b.accounts("cost_of_sales", accounts["cost_of_sales"], balances, 1)
+	b.total("cost_of_sales", "Total cost of sales", "إجمالي تكلفة المبيعات", costOfSales)
+	grossProfit := combine(revenue, -1, costOfSales)
+	b.total("gross_profit", "Gross profit", "مجمل الربح", grossProfit)
+
+	b.heading("expenses", "Expenses", "المصروفات")
+	expenses := b.accounts("expenses", accounts["expense"], balances, 1)
+	b.total("expenses", "Total expenses", "إجمالي المصروفات", expenses)
+
+	b.total("net_profit", "Net profit", "صافي الربح", combine(grossProfit, -1, expenses))
+
+	return statement, nil
+}
+
+// GetBalanceSheet returns the balance sheet of a company at the end of a period, optionally for one branch. Profit
+// not yet closed to retained earnings is shown in equity as current earnings.
+func (d *Database) GetBalanceSheet(companyID, branchID int, from, to time.Time, comparison string) (*FinancialStatement, error) {
+	columns, err := financialReportColumns(from, to, comparison)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := d.accountsByType(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var balances []map[int]accountBalance
+	for _, column := range columns {
+		columnBalances, err := d.accountBalances(companyID, branchID, time.Time{}, column.To, false)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, columnBalances)
+	}
+
+	statement := &FinancialStatement{Type: "balance_sheet", CompanyID: companyID, BranchID: branchID, Columns: columns}
+	b := &statementBuilder{statement: statement}
+
+	b.heading("assets", "Assets", "الأصول")
+	assets := b.accounts("assets", accounts["asset"], balances, 1)
+	b.total("assets", "Total assets", "إجمالي الأصول", assets)
+
+	b.heading("liabilities", "Liabilities", "الخصوم")
+	liabilities := b.accounts("liabilities", accounts["liability"], balances, -1)
+	b.total("liabilities", "Total liabilities", "إجمالي الخصوم", liabilities)
+
+	b.heading("equity", "Equity", "حقوق الملكية")
+	equity := b.accounts("equity", accounts["equity"], balances, -1)
+
+	// Revenue and expense accounts that have not been closed yet belong to the owners
+	var profitAccounts []Account
+	for _, accountType := range []string{"revenue", "cost_of_sales", "expense"} {
+		profitAccounts = append(profitAccounts, accounts[accountType]...)
+	}
+	earnings := accountTotals(profitAccounts, balances, -1)
+	b.statement.Lines = append(b.statement.Lines, FinancialStatementLine{Kind: "account", Section: "equity", Label: "Current earnings", LabelArabic: "الأرباح الحالية", Amounts: earnings})
+	equity = combine(equity, 1, earnings)
+	b.total("equity", "Total equity", "إجمالي حقوق الملكية", equity)
+
+	b.total("liabilities_and_equity", "Total liabilities and equity", "إجمالي الخصوم وحقوق الملكية", combine(liabilities, 1, equity))
+
+	return statement, nil
+}
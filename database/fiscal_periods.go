@@ -0,0 +1,503 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Fiscal period states
+const (
+	FiscalPeriodOpen   = "open"
+	FiscalPeriodClosed = "closed"
+	FiscalPeriodLocked = "locked"
+)
+
+// yearEndCloseSource is the source type of the journal entries that close a fiscal year
+const yearEndCloseSource = "year_end_close"
+
+// fiscalPeriodAt returns the period of a company that contains a date; ok is false outside any fiscal year
+func fiscalPeriodAt(exec execer, companyID int, date time.Time) (name, status string, ok bool, err error) {
+	err = exec.QueryRow(`
+		SELECT name, status FROM fiscal_periods
+		WHERE company_id = ? AND DATE(start_date) <= DATE(?) AND DATE(end_date) >= DATE(?)
+		LIMIT 1`, companyID, dateOnly(date), dateOnly(date)).Scan(&name, &status)
+	if err == sql.ErrNoRows {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	return name, status, true, nil
+}
+
+// checkPostingDate refuses journal entries in locked periods, and in closed periods unless they are manual
+// adjustments. Entries closing a fiscal year may be posted in any period.
+func checkPostingDate(exec execer, companyID int, date time.Time, sourceType string) error {
+	if sourceType == yearEndCloseSource {
+		return nil
+	}
+	name, status, ok, err := fiscalPeriodAt(exec, companyID, date)
+	if err != nil || !ok {
+		return err
+	}
+	switch {
+	case status == FiscalPeriodLocked:
+		return fmt.Errorf("cannot post on %s: fiscal period %s is locked", dateOnly(date), name)
+	case status == FiscalPeriodClosed && sourceType != "manual":
+		return fmt.Errorf("cannot post on %s: fiscal period %s is closed", dateOnly(date), name)
+	}
+	return nil
+}
+
+// checkDocumentPeriod refuses creating, changing or deleting a document dated in a closed or locked period
+func checkDocumentPeriod(exec execer, companyID int, dates ...time.Time) error {
+	for _, date := range dates {
+		if date.IsZero() {
+			continue
+		}
+		name, status, ok, err := fiscalPeriodAt(exec, companyID, date)
+		if err != nil {
+			return err
+		}
+		if ok && status != FiscalPeriodOpen {
+			return fmt.Errorf("the document date %s is in fiscal period %s, which is %s", dateOnly(date), name, status)
+		}
+	}
+	return nil
+}
+
+// checkStoredDocumentPeriod checks the stored date of a document together with the dates it is changed to.
+// A document that does not exist passes.
+func checkStoredDocumentPeriod(exec execer, table, dateColumn string, id int, newDates ...time.Time) error {
+	var companyID int
+	var stored time.Time
+	err := exec.QueryRow(fmt.Sprintf(`SELECT COALESCE(company_id, 1), %s FROM %s WHERE id = ?`, dateColumn, table), id).Scan(&companyID, &stored)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return checkDocumentPeriod(exec, companyID, append([]time.Time{stored}, newDates...)...)
+}
+
+// CreateFiscalYear creates a fiscal year with a period per calendar month. An empty end date makes a year
+// of twelve months and an empty name uses the years it covers.
+func (d *Database) CreateFiscalYear(year *FiscalYear) error {
+	// Default to company 1 for backward compatibility
+	if year.CompanyID == 0 {
+		year.CompanyID = 1
+	}
+	if year.StartDate.IsZero() {
+		return fmt.Errorf("a fiscal year needs a start date")
+	}
+	year.StartDate = time.Date(year.StartDate.Year(), year.StartDate.Month(), year.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+	if year.EndDate.IsZero() {
+		year.EndDate = year.StartDate.AddDate(1, 0, -1)
+	}
+	year.EndDate = time.Date(year.EndDate.Year(), year.EndDate.Month(), year.EndDate.Day(), 0, 0, 0, 0, time.UTC)
+	if !year.EndDate.After(year.StartDate) {
+		return fmt.Errorf("the fiscal year must end after it starts")
+	}
+	if year.EndDate.After(year.StartDate.AddDate(2, 0, -1)) {
+		return fmt.Errorf("a fiscal year cannot be longer than 24 months")
+	}
+	if year.Name == "" {
+		year.Name = fmt.Sprintf("FY %d", year.StartDate.Year())
+		if year.EndDate.Year() != year.StartDate.Year() {
+			year.Name = fmt.Sprintf("FY %d/%d", year.StartDate.Year(), year.EndDate.Year())
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertFiscalYear(tx, year); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertFiscalYear inserts a validated fiscal year and its monthly periods
+func insertFiscalYear(exec execer, year *FiscalYear) error {
+	var overlapping string
+	err := exec.QueryRow(`
+		SELECT name FROM fiscal_years
+		WHERE company_id = ? AND DATE(start_date) <= DATE(?) AND DATE(end_date) >= DATE(?)
+		LIMIT 1`, year.CompanyID, dateOnly(year.EndDate), dateOnly(year.StartDate)).Scan(&overlapping)
+	if err == nil {
+		return fmt.Errorf("the fiscal year overlaps %s", overlapping)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	year.Status = FiscalPeriodOpen
+	result, err := exec.Exec(`INSERT INTO fiscal_years (company_id, name, start_date, end_date, status) VALUES (?, ?, ?, ?, ?)`,
+		year.CompanyID, year.Name, year.StartDate, year.EndDate, year.Status)
+	if err != nil {
+		return fmt.Errorf("error creating fiscal year: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	year.ID = int(id)
+
+	year.Periods = nil
+	for start, number := year.StartDate, 1; !start.After(year.EndDate); number++ {
+		end := monthEnd(start)
+		if end.After(year.EndDate) {
+			end = year.EndDate
+		}
+		period := FiscalPeriod{
+			FiscalYearID: year.ID,
+			CompanyID:    year.CompanyID,
+			PeriodNumber: number,
+			Name:         start.Format("January 2006"),
+			StartDate:    start,
+			EndDate:      end,
+			Status:       FiscalPeriodOpen,
+		}
+		result, err := exec.Exec(`
+			INSERT INTO fiscal_periods (fiscal_year_id, company_id, period_number, name, start_date, end_date, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			period.FiscalYearID, period.CompanyID, period.PeriodNumber, period.Name, period.StartDate, period.EndDate, period.Status)
+		if err != nil {
+			return fmt.Errorf("error creating fiscal period %s: %v", period.Name, err)
+		}
+		periodID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		period.ID = int(periodID)
+		year.Periods = append(year.Periods, period)
+		start = end.AddDate(0, 0, 1)
+	}
+	return nil
+}
+
+// GetFiscalYearsByCompany retrieves the fiscal years of a company with their periods, latest first
+func (d *Database) GetFiscalYearsByCompany(companyID int) ([]FiscalYear, error) {
+	years, err := queryFiscalYears(d.db, "WHERE company_id = ? ORDER BY start_date DESC", companyID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range years {
+		if years[i].Periods, err = getFiscalPeriods(d.db, years[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return years, nil
+}
+
+// GetFiscalYearByID retrieves a fiscal year with its periods
+func (d *Database) GetFiscalYearByID(id int) (*FiscalYear, error) {
+	return getFiscalYear(d.db, id)
+}
+
+func getFiscalYear(exec execer, id int) (*FiscalYear, error) {
+	years, err := queryFiscalYears(exec, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(years) == 0 {
+		return nil, fmt.Errorf("fiscal year %d not found", id)
+	}
+	year := &years[0]
+	if year.Periods, err = getFiscalPeriods(exec, year.ID); err != nil {
+		return nil, err
+	}
+	return year, nil
+}
+
+func queryFiscalYears(exec execer, where string, args ...interface{}) ([]FiscalYear, error) {
+	rows, err := exec.Query(`
+		SELECT id, company_id, name, start_date, end_date, status, closed_at, closed_by, created_at, updated_at
+		FROM fiscal_years `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var years []FiscalYear
+	for rows.Next() {
+		var y FiscalYear
+		if err := rows.Scan(&y.ID, &y.CompanyID, &y.Name, &y.StartDate, &y.EndDate, &y.Status, &y.ClosedAt, &y.ClosedBy,
+			&y.CreatedAt, &y.UpdatedAt); err != nil {
+			return nil, err
+		}
+		years = append(years, y)
+	}
+	return years, rows.Err()
+}
+
+func getFiscalPeriods(exec execer, yearID int) ([]FiscalPeriod, error) {
+	rows, err := exec.Query(`
+		SELECT id, fiscal_year_id, company_id, period_number, name, start_date, end_date, status, updated_by, updated_at
+		FROM fiscal_periods WHERE fiscal_year_id = ? ORDER BY period_number`, yearID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var periods []FiscalPeriod
+	for rows.Next() {
+		var p FiscalPeriod
+		if err := rows.Scan(&p.ID, &p.FiscalYearID, &p.CompanyID, &p.PeriodNumber, &p.Name, &p.StartDate, &p.EndDate, &p.Status,
+			&p.UpdatedBy, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		periods = append(periods, p)
+	}
+	return periods, rows.Err()
+}
+
+// DeleteFiscalYear deletes a fiscal year whose periods are all still open
+func (d *Database) DeleteFiscalYear(id int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	year, err := getFiscalYear(tx, id)
+	if err != nil {
+		return err
+	}
+	if year.Status != FiscalPeriodOpen {
+		return fmt.Errorf("fiscal year %s is closed; reopen it before deleting it", year.Name)
+	}
+	for _, period := range year.Periods {
+		if period.Status != FiscalPeriodOpen {
+			return fmt.Errorf("fiscal period %s is %s; only fiscal years with open periods can be deleted", period.Name, period.Status)
+		}
+	}
+
+	if _, err = tx.Exec(`DELETE FROM fiscal_periods WHERE fiscal_year_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM fiscal_years WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetFiscalPeriodStatus opens, closes or locks a period. Locking is final, and periods of a closed
+// fiscal year cannot be reopened.
+func (d *Database) SetFiscalPeriodStatus(periodID int, status string, userID *int) error {
+	switch status {
+	case FiscalPeriodOpen, FiscalPeriodClosed, FiscalPeriodLocked:
+	default:
+		return fmt.Errorf("invalid fiscal period status %q", status)
+	}
+
+	var name, current, yearStatus string
+	err := d.db.QueryRow(`
+		SELECT fp.name, fp.status, fy.status FROM fiscal_periods fp
+		JOIN fiscal_years fy ON fp.fiscal_year_id = fy.id
+		WHERE fp.id = ?`, periodID).Scan(&name, &current, &yearStatus)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("fiscal period %d not found", periodID)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case current == status:
+		return nil
+	case current == FiscalPeriodLocked:
+		return fmt.Errorf("fiscal period %s is locked and cannot be changed", name)
+	case status == FiscalPeriodOpen && yearStatus != FiscalPeriodOpen:
+		return fmt.Errorf("the fiscal year of %s is closed; reopen the year first", name)
+	}
+
+	_, err = d.db.Exec(`UPDATE fiscal_periods SET status = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, status, userID, periodID)
+	return err
+}
+
+// LockFiscalPeriodsThrough locks every period of a company ending on or before a date, e.g. after filing
+// the VAT return for it. It returns the number of periods locked.
+func (d *Database) LockFiscalPeriodsThrough(companyID int, date time.Time, userID *int) (int, error) {
+	result, err := d.db.Exec(`
+		UPDATE fiscal_periods SET status = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE company_id = ? AND status != ? AND DATE(end_date) <= DATE(?)`,
+		FiscalPeriodLocked, userID, companyID, FiscalPeriodLocked, dateOnly(date))
+	if err != nil {
+		return 0, err
+	}
+	locked, err := result.RowsAffected()
+	return int(locked), err
+}
+
+// CloseFiscalYear closes a fiscal year: the balances of revenue and expense accounts are moved to retained
+// earnings per branch, every open period is closed and the next fiscal year is created when missing, so that
+// balance sheet accounts carry forward into it. Earlier fiscal years must be closed first.
+func (d *Database) CloseFiscalYear(id int, userID *int) (*FiscalYear, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	year, err := getFiscalYear(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if year.Status != FiscalPeriodOpen {
+		return nil, fmt.Errorf("fiscal year %s is already closed", year.Name)
+	}
+	var openEarlier int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM fiscal_years WHERE company_id = ? AND DATE(end_date) < DATE(?) AND status = ?`,
+		year.CompanyID, dateOnly(year.StartDate), FiscalPeriodOpen).Scan(&openEarlier)
+	if err != nil {
+		return nil, err
+	}
+	if openEarlier > 0 {
+		return nil, fmt.Errorf("close the earlier fiscal years first")
+	}
+
+	retainedEarnings, err := resolveAccount(tx, year.CompanyID, "default", 0, AccountRoleRetainedEarnings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything up to the year end is closed, including postings from before the first fiscal year
+	rows, err := tx.Query(`
+		SELECT COALESCE(je.branch_id, 0), jl.account_id, SUM(jl.debit - jl.credit)
+		FROM journal_lines jl
+		JOIN journal_entries je ON jl.entry_id = je.id
+		JOIN accounts a ON jl.account_id = a.id
+		WHERE je.company_id = ? AND a.type IN ('revenue', 'expense') AND DATE(je.entry_date) <= DATE(?)
+		GROUP BY COALESCE(je.branch_id, 0), jl.account_id
+		ORDER BY COALESCE(je.branch_id, 0), jl.account_id`, year.CompanyID, dateOnly(year.EndDate))
+	if err != nil {
+		return nil, err
+	}
+	builders := make(map[int]*entryBuilder)
+	var branches []int
+	for rows.Next() {
+		var branchID, accountID int
+		var balance float64
+		if err := rows.Scan(&branchID, &accountID, &balance); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if _, ok := builders[branchID]; !ok {
+			builders[branchID] = &entryBuilder{}
+			branches = append(branches, branchID)
+		}
+		builders[branchID].post(accountID, -balance)
+		builders[branchID].post(retainedEarnings, balance)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, branchID := range branches {
+		entry := builders[branchID].entry(year.CompanyID, year.EndDate,
+			fmt.Sprintf("Year-end close %s", year.Name), fmt.Sprintf("إقفال السنة المالية %s", year.Name), year.Name)
+		if entry == nil {
+			continue
+		}
+		if branchID > 0 {
+			branch := branchID
+			entry.BranchID = &branch
+		}
+		entry.SourceType = yearEndCloseSource
+		entry.SourceID = &year.ID
+		entry.CreatedBy = userID
+		if err := insertJournalEntry(tx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = tx.Exec(`UPDATE fiscal_periods SET status = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP WHERE fiscal_year_id = ? AND status = ?`,
+		FiscalPeriodClosed, userID, year.ID, FiscalPeriodOpen); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(`UPDATE fiscal_years SET status = ?, closed_at = CURRENT_TIMESTAMP, closed_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		FiscalPeriodClosed, userID, year.ID); err != nil {
+		return nil, err
+	}
+
+	var following int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM fiscal_years WHERE company_id = ? AND DATE(start_date) > DATE(?)`,
+		year.CompanyID, dateOnly(year.EndDate)).Scan(&following); err != nil {
+		return nil, err
+	}
+	if following == 0 {
+		next := &FiscalYear{CompanyID: year.CompanyID, StartDate: year.EndDate.AddDate(0, 0, 1)}
+		next.EndDate = next.StartDate.AddDate(1, 0, -1)
+		next.Name = fmt.Sprintf("FY %d", next.StartDate.Year())
+		if next.EndDate.Year() != next.StartDate.Year() {
+			next.Name = fmt.Sprintf("FY %d/%d", next.StartDate.Year(), next.EndDate.Year())
+		}
+		if err = insertFiscalYear(tx, next); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return d.GetFiscalYearByID(id)
+}
+
+// ReopenFiscalYear reverses the closing entries of the latest closed fiscal year. Its periods stay closed
+// until they are reopened one by one; a year with locked periods cannot be reopened.
+func (d *Database) ReopenFiscalYear(id int, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	year, err := getFiscalYear(tx, id)
+	if err != nil {
+		return err
+	}
+	if year.Status != FiscalPeriodClosed {
+		return fmt.Errorf("fiscal year %s is not closed", year.Name)
+	}
+	for _, period := range year.Periods {
+		if period.Status == FiscalPeriodLocked {
+			return fmt.Errorf("fiscal period %s is locked, so fiscal year %s cannot be reopened", period.Name, year.Name)
+		}
+	}
+	var closedLater int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM fiscal_years WHERE company_id = ? AND DATE(start_date) > DATE(?) AND status = ?`,
+		year.CompanyID, dateOnly(year.EndDate), FiscalPeriodClosed).Scan(&closedLater)
+	if err != nil {
+		return err
+	}
+	if closedLater > 0 {
+		return fmt.Errorf("reopen the later fiscal years first")
+	}
+
+	entries, err := queryJournalEntries(tx, "WHERE je.source_type = ? AND je.source_id = ? AND je.reverses_id IS NULL AND je.reversed_by_id IS NULL",
+		yearEndCloseSource, year.ID)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if entries[i].Lines, err = getJournalLines(tx, entries[i].ID); err != nil {
+			return err
+		}
+		if _, err = reverseJournalEntry(tx, &entries[i], year.EndDate, userID); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(`UPDATE fiscal_years SET status = ?, closed_at = NULL, closed_by = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		FiscalPeriodOpen, year.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,507 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Records that can be imported in bulk
+const (
+	ImportProducts  = "products"
+	ImportCustomers = "customers"
+	ImportSuppliers = "suppliers"
+)
+
+// importFields are the fields a column of an import file can be mapped to. Products are matched on SKU,
+// customers and suppliers on VAT number, to decide between inserting and updating.
+var importFields = map[string][]ImportField{
+	ImportProducts: {
+		{Key: "sku", Label: "SKU", Match: true},
+		{Key: "name", Label: "Name", Required: true},
+		{Key: "name_arabic", Label: "Name (Arabic)"},
+		{Key: "description", Label: "Description"},
+		{Key: "description_arabic", Label: "Description (Arabic)"},
+		{Key: "category", Label: "Category"},
+		{Key: "unit_price", Label: "Unit Price", Required: true},
+		{Key: "vat_rate", Label: "VAT Rate"},
+		{Key: "unit", Label: "Unit"},
+		{Key: "barcode", Label: "Barcode"},
+		{Key: "stock", Label: "Stock"},
+		{Key: "min_stock", Label: "Minimum Stock"},
+		{Key: "is_active", Label: "Active"},
+	},
+	ImportCustomers: {
+		{Key: "vat_number", Label: "VAT Number", Match: true},
+		{Key: "name", Label: "Name", Required: true},
+		{Key: "name_arabic", Label: "Name (Arabic)"},
+		{Key: "email", Label: "Email"},
+		{Key: "phone", Label: "Phone"},
+		{Key: "address", Label: "Address"},
+		{Key: "address_arabic", Label: "Address (Arabic)"},
+		{Key: "city", Label: "City"},
+		{Key: "city_arabic", Label: "City (Arabic)"},
+		{Key: "country", Label: "Country"},
+		{Key: "country_arabic", Label: "Country (Arabic)"},
+	},
+	ImportSuppliers: {
+		{Key: "vat_number", Label: "VAT Number", Match: true},
+		{Key: "company_name", Label: "Company Name", Required: true},
+		{Key: "company_name_arabic", Label: "Company Name (Arabic)"},
+		{Key: "contact_person", Label: "Contact Person"},
+		{Key: "contact_person_arabic", Label: "Contact Person (Arabic)"},
+		{Key: "email", Label: "Email"},
+		{Key: "phone", Label: "Phone"},
+		{Key: "address", Label: "Address"},
+		{Key: "address_arabic", Label: "Address (Arabic)"},
+		{Key: "city", Label: "City"},
+		{Key: "city_arabic", Label: "City (Arabic)"},
+		{Key: "country", Label: "Country"},
+		{Key: "country_arabic", Label: "Country (Arabic)"},
+		{Key: "payment_terms", Label: "Payment Terms"},
+		{Key: "active", Label: "Active"},
+	},
+}
+
+// GetImportFields returns the fields the columns of an import file can be mapped to
+func GetImportFields(entity string) ([]ImportField, error) {
+	fields, ok := importFields[entity]
+	if !ok {
+		return nil, fmt.Errorf("unknown import %q", entity)
+	}
+	return fields, nil
+}
+
+// vatNumberPattern is a Saudi VAT registration number: 15 digits starting and ending with 3
+var vatNumberPattern = regexp.MustCompile(`^3\d{13}3$`)
+
+var supplierPaymentTerms = []string{"net_15", "net_30", "net_45", "net_60", "cash_on_delivery", "advance_payment"}
+
+// importRow reads and validates the values of one row, collecting an error per invalid field
+type importRow struct {
+	ImportRow
+	errors []ImportRowError
+}
+
+func (r *importRow) has(key string) bool {
+	_, ok := r.Values[key]
+	return ok
+}
+
+func (r *importRow) text(key string) string {
+	return strings.TrimSpace(r.Values[key])
+}
+
+func (r *importRow) fail(key, message string) {
+	r.errors = append(r.errors, ImportRowError{Row: r.Line, Field: key, Value: r.Values[key], Message: message})
+}
+
+// number parses a number written with a decimal point and optional thousands separators
+func (r *importRow) number(key string, fallback float64) float64 {
+	value := strings.ReplaceAll(r.text(key), ",", "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.fail(key, "not a number")
+		return fallback
+	}
+	return n
+}
+
+func (r *importRow) integer(key string, fallback int) int {
+	n := r.number(key, float64(fallback))
+	if n != float64(int(n)) {
+		r.fail(key, "not a whole number")
+	}
+	return int(n)
+}
+
+func (r *importRow) boolean(key string, fallback bool) bool {
+	switch strings.ToLower(r.text(key)) {
+	case "":
+		return fallback
+	case "1", "true", "yes", "y", "active", "نعم":
+		return true
+	case "0", "false", "no", "n", "inactive", "لا":
+		return false
+	}
+	r.fail(key, "not yes or no")
+	return fallback
+}
+
+// ImportRecords validates the rows of an import file and, unless it is a dry run, saves the valid rows in a
+// single transaction. Rows hold the values of the mapped fields; only mapped fields are changed on update.
+// Invalid rows are reported and left out; a dry run reports what would be inserted and updated.
+func (d *Database) ImportRecords(companyID int, entity string, rows []ImportRow, dryRun bool) (*ImportResult, error) {
+	var importOne func(tx *sql.Tx, companyID int, row *importRow, seen map[string]int) (bool, error)
+	switch entity {
+	case ImportProducts:
+		importOne = importProduct
+	case ImportCustomers:
+		importOne = importCustomer
+	case ImportSuppliers:
+		importOne = importSupplier
+	default:
+		return nil, fmt.Errorf("unknown import %q", entity)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{Entity: entity, DryRun: dryRun, TotalRows: len(rows), Errors: []ImportRowError{}}
+	seen := make(map[string]int)
+	for _, values := range rows {
+		row := &importRow{ImportRow: values}
+		updated, err := importOne(tx, companyID, row, seen)
+		if err != nil {
+			return nil, fmt.Errorf("error importing row %d: %v", row.Line, err)
+		}
+		switch {
+		case len(row.errors) > 0:
+			result.Failed++
+			result.Errors = append(result.Errors, row.errors...)
+		case updated:
+			result.Updated++
+		default:
+			result.Inserted++
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// claim records a unique value of a row, failing the row when an earlier row of the file has it
+func (r *importRow) claim(seen map[string]int, key, value string) bool {
+	if value == "" {
+		return false
+	}
+	id := key + "\x00" + strings.ToLower(value)
+	if line, ok := seen[id]; ok {
+		r.fail(key, fmt.Sprintf("duplicate of row %d", line))
+		return false
+	}
+	seen[id] = r.Line
+	return true
+}
+
+// matchRecord finds the record of a company with a value, failing the row when several records have it
+func (r *importRow) matchRecord(exec execer, query, key string, args ...interface{}) (int, error) {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) > 1 {
+		r.fail(key, fmt.Sprintf("matches %d existing records", len(ids)))
+		return 0, nil
+	}
+	if len(ids) == 1 {
+		return ids[0], nil
+	}
+	return 0, nil
+}
+
+func importProduct(tx *sql.Tx, companyID int, row *importRow, seen map[string]int) (bool, error) {
+	product := Product{CompanyID: companyID, VATRate: 15, Unit: "pcs", UnitArabic: "قطعة", IsActive: true}
+	sku := row.text("sku")
+	row.claim(seen, "sku", sku)
+	if sku != "" {
+		id, err := row.matchRecord(tx, `SELECT id FROM products WHERE COALESCE(company_id, 1) = ? AND sku = ? COLLATE NOCASE AND deleted_at IS NULL`, "sku", companyID, sku)
+		if err != nil {
+			return false, err
+		}
+		if id > 0 {
+			existing, err := scanProduct(tx.QueryRow(`
+				SELECT id, name, COALESCE(name_arabic, ''), COALESCE(description, ''), COALESCE(description_arabic, ''), COALESCE(category_id, 0),
+					unit_price, COALESCE(vat_rate, 15), COALESCE(unit, ''), COALESCE(unit_arabic, ''), COALESCE(sku, ''), COALESCE(barcode, ''),
+					COALESCE(stock, 0), COALESCE(min_stock, 0), COALESCE(is_active, 1)
+				FROM products WHERE id = ?`, id))
+			if err != nil {
+				return false, err
+			}
+			product = *existing
+		}
+	}
+
+	if row.has("name") || product.ID == 0 {
+		product.Name = row.text("name")
+		if product.Name == "" {
+			row.fail("name", "is required")
+		}
+	}
+	if row.has("name_arabic") {
+		product.NameArabic = row.text("name_arabic")
+	}
+	if row.has("description") {
+		product.Description = row.text("description")
+	}
+	if row.has("description_arabic") {
+		product.DescriptionArabic = row.text("description_arabic")
+	}
+	if row.has("unit_price") || product.ID == 0 {
+		if row.text("unit_price") == "" {
+			row.fail("unit_price", "is required")
+		}
+		product.UnitPrice = row.number("unit_price", product.UnitPrice)
+		if product.UnitPrice < 0 {
+			row.fail("unit_price", "cannot be negative")
+		}
+	}
+	if row.has("vat_rate") {
+		product.VATRate = row.number("vat_rate", product.VATRate)
+		if product.VATRate < 0 || product.VATRate > 100 {
+			row.fail("vat_rate", "must be between 0 and 100")
+		}
+	}
+	if row.has("stock") {
+		product.Stock = row.integer("stock", product.Stock)
+	}
+	if row.has("min_stock") {
+		product.MinStock = row.integer("min_stock", product.MinStock)
+	}
+	if row.has("is_active") {
+		product.IsActive = row.boolean("is_active", product.IsActive)
+	}
+
+	if category := row.text("category"); category != "" {
+		err := tx.QueryRow(`SELECT id FROM product_categories WHERE COALESCE(company_id, 1) = ? AND (name = ? COLLATE NOCASE OR name_arabic = ?) AND deleted_at IS NULL ORDER BY id LIMIT 1`,
+			companyID, category, category).Scan(&product.CategoryID)
+		if err == sql.ErrNoRows {
+			row.fail("category", "unknown category")
+		} else if err != nil {
+			return false, err
+		}
+	} else if row.has("category") {
+		product.CategoryID = 0
+	}
+
+	if unit := row.text("unit"); unit != "" {
+		var arabic string
+		err := tx.QueryRow(`
+			SELECT value, COALESCE(arabic, '') FROM units_of_measurement
+			WHERE COALESCE(company_id, 1) = ? AND COALESCE(is_active, 1) = 1 AND (value = ? COLLATE NOCASE OR label = ? COLLATE NOCASE OR arabic = ?)
+			ORDER BY id LIMIT 1`, companyID, unit, unit, unit).Scan(&product.Unit, &arabic)
+		if err == sql.ErrNoRows {
+			row.fail("unit", "unknown unit")
+		} else if err != nil {
+			return false, err
+		} else {
+			product.UnitArabic = arabic
+		}
+	}
+
+	if row.has("barcode") {
+		product.Barcode = row.text("barcode")
+	}
+	if row.claim(seen, "barcode", product.Barcode) {
+		var count int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM products WHERE COALESCE(company_id, 1) = ? AND barcode = ? AND id != ? AND deleted_at IS NULL`, companyID, product.Barcode, product.ID).Scan(&count)
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			row.fail("barcode", "already used by another product")
+		}
+	}
+	product.SKU = sku
+
+	if len(row.errors) > 0 {
+		return product.ID > 0, nil
+	}
+	if product.ID > 0 {
+		_, err := tx.Exec(`
+			UPDATE products SET name = ?, name_arabic = ?, description = ?, description_arabic = ?, category_id = ?, unit_price = ?, vat_rate = ?,
+				unit = ?, unit_arabic = ?, barcode = ?, stock = ?, min_stock = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`,
+			product.Name, product.NameArabic, product.Description, product.DescriptionArabic, product.CategoryID, product.UnitPrice, product.VATRate,
+			product.Unit, product.UnitArabic, product.Barcode, product.Stock, product.MinStock, product.IsActive, product.ID)
+		return true, err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO products (company_id, name, name_arabic, description, description_arabic, category_id, unit_price, vat_rate, unit, unit_arabic, sku, barcode, stock, min_stock, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		companyID, product.Name, product.NameArabic, product.Description, product.DescriptionArabic, product.CategoryID, product.UnitPrice, product.VATRate,
+		product.Unit, product.UnitArabic, product.SKU, product.Barcode, product.Stock, product.MinStock, product.IsActive)
+	return false, err
+}
+
+func scanProduct(row *sql.Row) (*Product, error) {
+	var p Product
+	err := row.Scan(&p.ID, &p.Name, &p.NameArabic, &p.Description, &p.DescriptionArabic, &p.CategoryID, &p.UnitPrice, &p.VATRate,
+		&p.Unit, &p.UnitArabic, &p.SKU, &p.Barcode, &p.Stock, &p.MinStock, &p.IsActive)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// importVATNumber validates the VAT number of a row and claims it within the file
+func importVATNumber(row *importRow, seen map[string]int) string {
+	vatNumber := strings.ReplaceAll(row.text("vat_number"), " ", "")
+	if vatNumber != "" && !vatNumberPattern.MatchString(vatNumber) {
+		row.fail("vat_number", "must be 15 digits starting and ending with 3")
+	}
+	row.claim(seen, "vat_number", vatNumber)
+	return vatNumber
+}
+
+func importEmail(row *importRow) {
+	if email := row.text("email"); email != "" && (!strings.Contains(email, "@") || strings.ContainsAny(email, " ,;")) {
+		row.fail("email", "not a valid email address")
+	}
+}
+
+// importText sets the mapped text fields of a row on a record
+func importText(row *importRow, fields map[string]*string) {
+	for key, field := range fields {
+		if row.has(key) {
+			*field = row.text(key)
+		}
+	}
+}
+
+func importCustomer(tx *sql.Tx, companyID int, row *importRow, seen map[string]int) (bool, error) {
+	customer := Customer{CompanyID: companyID}
+	customer.VATNumber = importVATNumber(row, seen)
+	if customer.VATNumber != "" {
+		id, err := row.matchRecord(tx, `SELECT id FROM customers WHERE COALESCE(company_id, 1) = ? AND REPLACE(vat_number, ' ', '') = ? AND deleted_at IS NULL`, "vat_number", companyID, customer.VATNumber)
+		if err != nil {
+			return false, err
+		}
+		if id > 0 {
+			err := tx.QueryRow(`
+				SELECT id, name, COALESCE(name_arabic, ''), COALESCE(email, ''), COALESCE(phone, ''), COALESCE(address, ''), COALESCE(address_arabic, ''),
+					COALESCE(city, ''), COALESCE(city_arabic, ''), COALESCE(country, ''), COALESCE(country_arabic, '')
+				FROM customers WHERE id = ?`, id).
+				Scan(&customer.ID, &customer.Name, &customer.NameArabic, &customer.Email, &customer.Phone, &customer.Address, &customer.AddressArabic,
+					&customer.City, &customer.CityArabic, &customer.Country, &customer.CountryArabic)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	importText(row, map[string]*string{
+		"name": &customer.Name, "name_arabic": &customer.NameArabic, "email": &customer.Email, "phone": &customer.Phone,
+		"address": &customer.Address, "address_arabic": &customer.AddressArabic, "city": &customer.City, "city_arabic": &customer.CityArabic,
+		"country": &customer.Country, "country_arabic": &customer.CountryArabic,
+	})
+	if customer.Name == "" {
+		row.fail("name", "is required")
+	}
+	importEmail(row)
+
+	if len(row.errors) > 0 {
+		return customer.ID > 0, nil
+	}
+	if customer.ID > 0 {
+		_, err := tx.Exec(`
+			UPDATE customers SET name = ?, name_arabic = ?, email = ?, phone = ?, address = ?, address_arabic = ?, city = ?, city_arabic = ?,
+				country = ?, country_arabic = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`,
+			customer.Name, customer.NameArabic, customer.Email, customer.Phone, customer.Address, customer.AddressArabic, customer.City, customer.CityArabic,
+			customer.Country, customer.CountryArabic, customer.ID)
+		return true, err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO customers (name, name_arabic, vat_number, email, phone, address, address_arabic, city, city_arabic, country, country_arabic, company_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		customer.Name, customer.NameArabic, customer.VATNumber, customer.Email, customer.Phone, customer.Address, customer.AddressArabic,
+		customer.City, customer.CityArabic, customer.Country, customer.CountryArabic, companyID)
+	return false, err
+}
+
+func importSupplier(tx *sql.Tx, companyID int, row *importRow, seen map[string]int) (bool, error) {
+	supplier := Supplier{CompanyID: companyID, PaymentTerms: "net_30", Active: true}
+	supplier.VATNumber = importVATNumber(row, seen)
+	if supplier.VATNumber != "" {
+		id, err := row.matchRecord(tx, `SELECT id FROM suppliers WHERE COALESCE(company_id, 1) = ? AND REPLACE(vat_number, ' ', '') = ? AND deleted_at IS NULL`, "vat_number", companyID, supplier.VATNumber)
+		if err != nil {
+			return false, err
+		}
+		if id > 0 {
+			err := tx.QueryRow(`
+				SELECT id, company_name, COALESCE(company_name_arabic, ''), COALESCE(contact_person, ''), COALESCE(contact_person_arabic, ''),
+					COALESCE(email, ''), COALESCE(phone, ''), COALESCE(address, ''), COALESCE(address_arabic, ''), COALESCE(city, ''), COALESCE(city_arabic, ''),
+					COALESCE(country, ''), COALESCE(country_arabic, ''), COALESCE(payment_terms, 'net_30'), COALESCE(active, 1)
+				FROM suppliers WHERE id = ?`, id).
+				Scan(&supplier.ID, &supplier.CompanyName, &supplier.CompanyNameArabic, &supplier.ContactPerson, &supplier.ContactPersonArabic,
+					&supplier.Email, &supplier.Phone, &supplier.Address, &supplier.AddressArabic, &supplier.City, &supplier.CityArabic,
+					&supplier.Country, &supplier.CountryArabic, &supplier.PaymentTerms, &supplier.Active)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	importText(row, map[string]*string{
+		"company_name": &supplier.CompanyName, "company_name_arabic": &supplier.CompanyNameArabic,
+		"contact_person": &supplier.ContactPerson, "contact_person_arabic": &supplier.ContactPersonArabic,
+		"email": &supplier.Email, "phone": &supplier.Phone, "address": &supplier.Address, "address_arabic": &supplier.AddressArabic,
+		"city": &supplier.City, "city_arabic": &supplier.CityArabic, "country": &supplier.Country, "country_arabic": &supplier.CountryArabic,
+	})
+	if supplier.CompanyName == "" {
+		row.fail("company_name", "is required")
+	}
+	importEmail(row)
+	if terms := strings.ToLower(strings.ReplaceAll(row.text("payment_terms"), " ", "_")); terms != "" {
+		valid := false
+		for _, t := range supplierPaymentTerms {
+			valid = valid || t == terms
+		}
+		if valid {
+			supplier.PaymentTerms = terms
+		} else {
+			row.fail("payment_terms", "must be one of "+strings.Join(supplierPaymentTerms, ", "))
+		}
+	}
+	if row.has("active") {
+		supplier.Active = row.boolean("active", supplier.Active)
+	}
+
+	if len(row.errors) > 0 {
+		return supplier.ID > 0, nil
+	}
+	if supplier.ID > 0 {
+		_, err := tx.Exec(`
+			UPDATE suppliers SET company_name = ?, company_name_arabic = ?, contact_person = ?, contact_person_arabic = ?, email = ?, phone = ?,
+				address = ?, address_arabic = ?, city = ?, city_arabic = ?, country = ?, country_arabic = ?, payment_terms = ?, active = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`,
+			supplier.CompanyName, supplier.CompanyNameArabic, supplier.ContactPerson, supplier.ContactPersonArabic, supplier.Email, supplier.Phone,
+			supplier.Address, supplier.AddressArabic, supplier.City, supplier.CityArabic, supplier.Country, supplier.CountryArabic,
+			supplier.PaymentTerms, supplier.Active, supplier.ID)
+		return true, err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO suppliers (company_id, company_name, company_name_arabic, contact_person, contact_person_arabic, vat_number, email, phone,
+			address, address_arabic, city, city_arabic, country, country_arabic, payment_terms, active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		companyID, supplier.CompanyName, supplier.CompanyNameArabic, supplier.ContactPerson, supplier.ContactPersonArabic, supplier.VATNumber,
+		supplier.Email, supplier.Phone, supplier.Address, supplier.AddressArabic, supplier.City, supplier.CityArabic,
+		supplier.Country, supplier.CountryArabic, supplier.PaymentTerms, supplier.Active)
+	return false, err
+}
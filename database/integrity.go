@@ -0,0 +1,455 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The checks of an integrity check, which name the check of each issue
+const (
+	IntegrityCorruption = "corruption"
+	IntegrityReference  = "reference"
+	IntegrityCompany    = "company"
+	IntegrityTotals     = "totals"
+	IntegrityQRCode     = "qr_code"
+	IntegrityFile       = "file"
+)
+
+// Fixes of references to missing rows
+const (
+	fixDelete  = "delete"  // Delete the row, which means nothing without the missing row
+	fixClear   = "clear"   // Clear the reference, which is optional
+	fixRecycle = "recycle" // Move the row to the recycle bin, as deleting it from the application does
+)
+
+// referenceFixes are the fixes of references to missing rows, besides the owner column of a table, whose
+// rows are deleted with their owner. Other references are reported, to be resolved by hand.
+var referenceFixes = map[string]string{
+	"bank_statement_lines.statement_id": fixDelete,
+	"fiscal_periods.fiscal_year_id":     fixDelete,
+	"payments.invoice_id":               fixRecycle,
+	"products.category_id":              fixClear,
+	"accounts.parent_id":                fixClear,
+	"sales_invoices.branch_id":          fixClear,
+	"purchase_invoices.branch_id":       fixClear,
+	"journal_entries.branch_id":         fixClear,
+	"expenses.branch_id":                fixClear,
+	"recurring_expenses.branch_id":      fixClear,
+}
+
+// integrityReference is a column of a table holding the ids of rows of parent
+type integrityReference struct {
+	table     string
+	column    string
+	parent    string
+	condition string // Restricts the rows of table to check, for columns whose parent depends on another column
+	fix       string
+}
+
+// totalledDocument is a document whose totals are the sums of its items
+type totalledDocument struct {
+	table      string
+	number     string
+	items      string
+	owner      string // Column of the items holding the id of the document
+	correction string // How an issued document with wrong totals is corrected
+	post       func(tx *sql.Tx, id int) error
+}
+
+var totalledDocuments = []totalledDocument{
+	{"sales_invoices", "invoice_number", "sales_invoice_items", "invoice_id", "issue a credit note against it", func(tx *sql.Tx, id int) error {
+		return postSalesInvoice(tx, id)
+	}},
+	{"purchase_invoices", "invoice_number", "purchase_invoice_items", "invoice_id", "record the credit note of the supplier against it", func(tx *sql.Tx, id int) error {
+		if err := refreshPurchaseInvoiceStatus(tx, id); err != nil {
+			return err
+		}
+		return postPurchaseInvoice(tx, id)
+	}},
+	{"credit_notes", "credit_note_number", "credit_note_items", "credit_note_id", "cancel it and issue a new credit note", func(tx *sql.Tx, id int) error {
+		return postDocument(tx, "credit_note", id)
+	}},
+}
+
+// Add adds an issue to a report with the function that fixes it, or nil when it has to be resolved by hand.
+// A fix checks the issue again before it changes anything, so it can run after the data changed.
+func (r *IntegrityReport) Add(issue IntegrityIssue, repair func(userID *int) error) {
+	issue.Key = fmt.Sprintf("%s:%s:%d:%s", issue.Check, issue.Table, issue.RecordID, issue.Column)
+	if repair == nil {
+		issue.Fix = ""
+	} else {
+		if r.repairs == nil {
+			r.repairs = make(map[string]func(userID *int) error)
+		}
+		r.repairs[issue.Key] = repair
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// Repair fixes the issues of a report with the given keys, or every issue that can be fixed when no key is
+// given, in the order they were found. Each fix that fails is recorded and the others still run.
+func (r *IntegrityReport) Repair(keys []string, userID *int) {
+	selected := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		selected[key] = true
+	}
+	for _, issue := range r.Issues {
+		repair, ok := r.repairs[issue.Key]
+		if !ok || (len(keys) > 0 && !selected[issue.Key]) {
+			continue
+		}
+		if err := repair(userID); err != nil {
+			r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", issue.Message, err))
+			continue
+		}
+		r.Fixed = append(r.Fixed, issue)
+	}
+}
+
+// CheckIntegrity checks the database for corruption, for references to rows that do not exist, for rows
+// without a valid company and for documents whose totals differ from the sums of their items. A corrupt
+// database is reported without the other checks, which read it.
+func (d *Database) CheckIntegrity() (*IntegrityReport, error) {
+	report := &IntegrityReport{CheckedAt: time.Now(), Issues: []IntegrityIssue{}, Fixed: []IntegrityIssue{}, Errors: []string{}}
+
+	problems, err := integrityProblems(d.db)
+	if err != nil {
+		return nil, fmt.Errorf("error checking the database: %v", err)
+	}
+	for i, problem := range problems {
+		report.Add(IntegrityIssue{Check: IntegrityCorruption, RecordID: i + 1, Message: problem}, nil)
+	}
+	if len(problems) > 0 {
+		return report, nil
+	}
+
+	for _, check := range []func(*IntegrityReport) error{d.checkReferences, d.checkCompanies, d.checkTotals} {
+		if err := check(report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// RowExists reports whether a table has a row with an id, in or out of the recycle bin
+func (d *Database) RowExists(table string, id int) (bool, error) {
+	var exists bool
+	if err := d.db.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE id = ?)", table), id).Scan(&exists); err != nil {
+		return false, fmt.Errorf("error reading %s %d: %v", table, id, err)
+	}
+	return exists, nil
+}
+
+func isRecycledTable(table string) bool {
+	for _, t := range recycledTables {
+		if t.table == table {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkReferences finds rows referring to rows that do not exist, following the references of the tables
+// of a company. NULL and 0 refer to nothing. The users who created or changed a row are not checked, nor
+// are rows in the recycle bin. Payments must also not belong to an invoice in the recycle bin.
+func (d *Database) checkReferences(report *IntegrityReport) error {
+	for _, spec := range archiveTables {
+		for _, column := range sortedKeys(spec.refs) {
+			ref := integrityReference{table: spec.name, column: column, parent: spec.refs[column],
+				fix: referenceFixes[spec.name+"."+column]}
+			if ref.parent == "users" {
+				continue
+			}
+			if column == spec.owner {
+				ref.fix = fixDelete
+			}
+			if err := d.checkReference(report, ref); err != nil {
+				return err
+			}
+		}
+		for _, column := range sortedKeys(spec.typed) {
+			typed := spec.typed[column]
+			for _, value := range sortedKeys(typed.tables) {
+				ref := integrityReference{table: spec.name, column: column, parent: typed.tables[value],
+					condition: fmt.Sprintf("t.%s = '%s'", typed.typeColumn, value)}
+				if err := d.checkReference(report, ref); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// missingParentSQL is the condition that the row a reference of row t holds is missing
+func (ref integrityReference) missingParentSQL() string {
+	condition := fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s p WHERE p.id = t.%s", ref.parent, ref.column)
+	if ref.fix == fixRecycle {
+		condition += " AND p.deleted_at IS NULL"
+	}
+	return condition + ")"
+}
+
+func (d *Database) checkReference(report *IntegrityReport, ref integrityReference) error {
+	conditions := []string{fmt.Sprintf("t.%[1]s IS NOT NULL AND t.%[1]s != 0", ref.column), ref.missingParentSQL()}
+	if isRecycledTable(ref.table) {
+		conditions = append(conditions, "t.deleted_at IS NULL")
+	}
+	if ref.condition != "" {
+		conditions = append(conditions, ref.condition)
+	}
+	rows, err := d.db.Query(fmt.Sprintf(`SELECT t.id, t.%[1]s, EXISTS (SELECT 1 FROM %[2]s p WHERE p.id = t.%[1]s)
+		FROM %[3]s t WHERE %[4]s ORDER BY t.id`, ref.column, ref.parent, ref.table, strings.Join(conditions, " AND ")))
+	if err != nil {
+		return fmt.Errorf("error checking %s.%s: %v", ref.table, ref.column, err)
+	}
+	type orphan struct {
+		id, parentID int
+		recycled     bool
+	}
+	var orphans []orphan
+	for rows.Next() {
+		var o orphan
+		if err := rows.Scan(&o.id, &o.parentID, &o.recycled); err != nil {
+			rows.Close()
+			return err
+		}
+		orphans = append(orphans, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range orphans {
+		issue := IntegrityIssue{Check: IntegrityReference, Table: ref.table, RecordID: o.id, Column: ref.column,
+			Message: fmt.Sprintf("%s %d refers to %s %d, which does not exist", ref.table, o.id, ref.parent, o.parentID)}
+		if o.recycled {
+			issue.Message = fmt.Sprintf("%s %d refers to %s %d, which is in the recycle bin", ref.table, o.id, ref.parent, o.parentID)
+		}
+		switch ref.fix {
+		case fixDelete:
+			issue.Fix = fmt.Sprintf("Delete %s %d", ref.table, o.id)
+		case fixClear:
+			issue.Fix = fmt.Sprintf("Clear %s of %s %d", ref.column, ref.table, o.id)
+		case fixRecycle:
+			issue.Fix = fmt.Sprintf("Move %s %d to the recycle bin", ref.table, o.id)
+		default:
+			report.Add(issue, nil)
+			continue
+		}
+		id := o.id
+		report.Add(issue, func(userID *int) error {
+			return d.repairReference(ref, id, userID)
+		})
+	}
+	return nil
+}
+
+// repairReference fixes a reference of a row to a missing row, if the row is still missing
+func (d *Database) repairReference(ref integrityReference, id int, userID *int) error {
+	if ref.fix == fixRecycle {
+		// Payments are the only rows recycled, which reverses what they posted
+		var missing bool
+		err := d.db.QueryRow(fmt.Sprintf(`SELECT %s FROM %s t WHERE t.id = ? AND t.deleted_at IS NULL`,
+			ref.missingParentSQL(), ref.table), id).Scan(&missing)
+		if err == sql.ErrNoRows || !missing {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return d.DeletePayment(id, userID)
+	}
+
+	statement := fmt.Sprintf(`DELETE FROM %s AS t WHERE t.id = ? AND %s`, ref.table, ref.missingParentSQL())
+	if ref.fix == fixClear {
+		statement = fmt.Sprintf(`UPDATE %s AS t SET %s = NULL WHERE t.id = ? AND %s`, ref.table, ref.column, ref.missingParentSQL())
+	}
+	if _, err := d.db.Exec(statement, id); err != nil {
+		return fmt.Errorf("error repairing %s %d: %v", ref.table, id, err)
+	}
+	return nil
+}
+
+// checkCompanies finds rows of a company that does not exist, like company 0, and business records without
+// a company. The fix moves such a row to the company of a row it refers to, like the invoice of a payment,
+// or to the only company when there is just one.
+func (d *Database) checkCompanies(report *IntegrityReport) error {
+	companyTables := make(map[string]bool)
+	for _, spec := range archiveTables {
+		if spec.owner == "" && spec.name != "companies" {
+			companyTables[spec.name] = true
+		}
+	}
+
+	for _, spec := range archiveTables {
+		if !companyTables[spec.name] {
+			continue
+		}
+		condition := "t.company_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM companies c WHERE c.id = t.company_id)"
+		if isRecycledTable(spec.name) {
+			condition = "(t.company_id IS NULL OR NOT EXISTS (SELECT 1 FROM companies c WHERE c.id = t.company_id))"
+		}
+		var candidates []string
+		for _, column := range sortedKeys(spec.refs) {
+			if parent := spec.refs[column]; companyTables[parent] {
+				candidates = append(candidates, fmt.Sprintf(
+					"(SELECT p.company_id FROM %s p JOIN companies c ON c.id = p.company_id WHERE p.id = t.%s)", parent, column))
+			}
+		}
+		candidates = append(candidates, "(SELECT MIN(id) FROM companies HAVING COUNT(*) = 1)")
+		inferred := candidates[0]
+		if len(candidates) > 1 {
+			inferred = "COALESCE(" + strings.Join(candidates, ", ") + ")"
+		}
+
+		rows, err := d.db.Query(fmt.Sprintf(`SELECT t.id, t.company_id, %s FROM %s t WHERE %s ORDER BY t.id`,
+			inferred, spec.name, condition))
+		if err != nil {
+			return fmt.Errorf("error checking the companies of %s: %v", spec.name, err)
+		}
+		type stray struct {
+			id                  int
+			companyID, inferred sql.NullInt64
+		}
+		var strays []stray
+		for rows.Next() {
+			var s stray
+			if err := rows.Scan(&s.id, &s.companyID, &s.inferred); err != nil {
+				rows.Close()
+				return err
+			}
+			strays = append(strays, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, s := range strays {
+			issue := IntegrityIssue{Check: IntegrityCompany, Table: spec.name, RecordID: s.id, Column: "company_id",
+				Message: fmt.Sprintf("%s %d has no company", spec.name, s.id)}
+			if s.companyID.Valid {
+				issue.Message = fmt.Sprintf("%s %d belongs to company %d, which does not exist", spec.name, s.id, s.companyID.Int64)
+			}
+			if !s.inferred.Valid {
+				report.Add(issue, nil)
+				continue
+			}
+			issue.Fix = fmt.Sprintf("Move %s %d to company %d", spec.name, s.id, s.inferred.Int64)
+			table, id, companyID := spec.name, s.id, s.inferred.Int64
+			report.Add(issue, func(userID *int) error {
+				_, err := d.db.Exec(fmt.Sprintf(`UPDATE %s AS t SET company_id = ? WHERE t.id = ? AND %s`, table, condition), companyID, id)
+				if err != nil {
+					return fmt.Errorf("error moving %s %d to company %d: %v", table, id, companyID, err)
+				}
+				return nil
+			})
+		}
+	}
+	return nil
+}
+
+// checkTotals finds documents whose sub total, VAT or total differ by a halala or more from the sums of
+// their items. Documents without items, and documents in the recycle bin, are not checked. Only drafts are
+// fixed; an issued document is reported with how to correct it.
+func (d *Database) checkTotals(report *IntegrityReport) error {
+	for _, doc := range totalledDocuments {
+		rows, err := d.db.Query(fmt.Sprintf(`
+			SELECT t.id, t.%[2]s, COALESCE(t.status, 'draft'), t.sub_total, t.vat_amount, t.total_amount,
+				SUM(i.quantity * i.unit_price), SUM(i.vat_amount), SUM(i.total_amount)
+			FROM %[1]s t
+			JOIN %[3]s i ON i.%[4]s = t.id
+			WHERE t.deleted_at IS NULL
+			GROUP BY t.id
+			HAVING ABS(t.sub_total - SUM(i.quantity * i.unit_price)) >= 0.005 OR ABS(t.vat_amount - SUM(i.vat_amount)) >= 0.005
+				OR ABS(t.total_amount - SUM(i.total_amount)) >= 0.005
+			ORDER BY t.id`, doc.table, doc.number, doc.items, doc.owner))
+		if err != nil {
+			return fmt.Errorf("error checking the totals of %s: %v", doc.table, err)
+		}
+		var issues []IntegrityIssue
+		for rows.Next() {
+			var id int
+			var number, status string
+			var subTotal, vatAmount, totalAmount, itemsSubTotal, itemsVAT, itemsTotal float64
+			if err := rows.Scan(&id, &number, &status, &subTotal, &vatAmount, &totalAmount, &itemsSubTotal, &itemsVAT, &itemsTotal); err != nil {
+				rows.Close()
+				return err
+			}
+			issue := IntegrityIssue{Check: IntegrityTotals, Table: doc.table, RecordID: id,
+				Message: fmt.Sprintf("%s %s totals %.2f (%.2f + VAT %.2f), but its items total %.2f (%.2f + VAT %.2f)",
+					doc.table, number, totalAmount, subTotal, vatAmount, itemsTotal, itemsSubTotal, itemsVAT)}
+			if status == "draft" {
+				issue.Fix = fmt.Sprintf("Set the totals of %s to those of its items", number)
+			} else {
+				// An issued document is what the other party holds, so it is corrected with another document
+				issue.Message += fmt.Sprintf(". %s is %s and cannot be changed; %s", number, status, doc.correction)
+			}
+			issues = append(issues, issue)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, issue := range issues {
+			if issue.Fix == "" {
+				report.Add(issue, nil)
+				continue
+			}
+			doc, id := doc, issue.RecordID
+			report.Add(issue, func(userID *int) error {
+				return d.repairTotals(doc, id, userID)
+			})
+		}
+	}
+	return nil
+}
+
+// repairTotals sets the totals of a draft document to the sums of its items. A document issued since it
+// was checked is refused, as are documents in a closed period.
+func (d *Database) repairTotals(doc totalledDocument, id int, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkStoredDocumentPeriod(tx, doc.table, "issue_date", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec(fmt.Sprintf(`
+		UPDATE %[1]s SET (sub_total, vat_amount, total_amount) = (
+			SELECT SUM(i.quantity * i.unit_price), SUM(i.vat_amount), SUM(i.total_amount) FROM %[2]s i WHERE i.%[3]s = %[1]s.id
+		), updated_by = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND deleted_at IS NULL AND COALESCE(status, 'draft') = 'draft'
+			AND EXISTS (SELECT 1 FROM %[2]s i WHERE i.%[3]s = %[1]s.id)`,
+		doc.table, doc.items, doc.owner), userID, id)
+	if err != nil {
+		return fmt.Errorf("error setting the totals of %s %d: %v", doc.table, id, err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s %d is no longer a draft", doc.table, id)
+	}
+	// Drafts are not posted, so this only clears an entry left from before the document was a draft
+	if err = doc.post(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
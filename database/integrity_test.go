@@ -0,0 +1,108 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckAndRepairIntegrity(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	if err := seedSalesInvoices(d.db, 1, 2, 4); err != nil {
+		t.Fatal(err)
+	}
+	report, err := d.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 {
+		t.Fatalf("a new database has issues: %+v", report.Issues)
+	}
+
+	statements := []string{
+		// Invoice 1, which was sent, totals 1.15 but its item totals 115
+		`INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
+			VALUES (1, 1, 2, 50, 15, 15, 115)`,
+		// As does draft invoice 4, which totals 4.60
+		`UPDATE sales_invoices SET status = 'draft' WHERE id = 4`,
+		`INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
+			VALUES (4, 1, 2, 50, 15, 15, 115)`,
+		// A payment of invoice 2, which was then deleted
+		`INSERT INTO payments (company_id, invoice_id, payment_type_id, amount, payment_date, status)
+			VALUES (1, 2, 1, 2.3, '2020-01-03', 'completed')`,
+		`UPDATE sales_invoices SET deleted_at = CURRENT_TIMESTAMP WHERE id = 2`,
+		// The item of an invoice that no longer exists
+		`INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
+			VALUES (99, 1, 1, 10, 15, 1.5, 11.5)`,
+		`UPDATE customers SET company_id = 0 WHERE id = 1`,
+		// A customer that no longer exists, which cannot be fixed
+		`UPDATE sales_invoices SET customer_id = 99 WHERE id = 3`,
+	}
+	for _, statement := range statements {
+		if _, err := d.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err = d.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"totals:sales_invoices:1:":                   false,
+		"totals:sales_invoices:4:":                   true,
+		"reference:payments:1:invoice_id":            true,
+		"reference:sales_invoice_items:3:invoice_id": true,
+		"company:customers:1:company_id":             true,
+		"reference:sales_invoices:3:customer_id":     false,
+	}
+	for _, issue := range report.Issues {
+		fixable, ok := want[issue.Key]
+		if !ok {
+			t.Errorf("unexpected issue %s: %s", issue.Key, issue.Message)
+			continue
+		}
+		if fixable != (issue.Fix != "") {
+			t.Errorf("issue %s has fix %q", issue.Key, issue.Fix)
+		}
+		delete(want, issue.Key)
+	}
+	for key := range want {
+		t.Errorf("issue %s was not found", key)
+	}
+
+	report.Repair(nil, nil)
+	if len(report.Errors) != 0 || len(report.Fixed) != 4 {
+		t.Fatalf("repair fixed %d issues with errors %v, want 4", len(report.Fixed), report.Errors)
+	}
+	after, err := d.CheckIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, issue := range after.Issues {
+		left = append(left, issue.Key)
+	}
+	if len(left) != 2 || left[0] != "reference:sales_invoices:3:customer_id" || left[1] != "totals:sales_invoices:1:" {
+		t.Errorf("issues after the repair: %v", left)
+	}
+
+	// An issued invoice keeps the totals it was sent with
+	invoice, err := d.GetSalesInvoiceByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.TotalAmount != 1.15 || invoice.VATAmount != 0.15 || invoice.SubTotal != 1 {
+		t.Errorf("sent invoice totals %.2f (%.2f + VAT %.2f), want it unchanged at 1.15", invoice.TotalAmount, invoice.SubTotal, invoice.VATAmount)
+	}
+	invoice, err = d.GetSalesInvoiceByID(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.TotalAmount != 115 || invoice.VATAmount != 15 || invoice.SubTotal != 100 {
+		t.Errorf("repaired draft totals %.2f (%.2f + VAT %.2f), want 115", invoice.TotalAmount, invoice.SubTotal, invoice.VATAmount)
+	}
+	var deleted bool
+	if err := d.db.QueryRow(`SELECT deleted_at IS NOT NULL FROM payments WHERE id = 1`).Scan(&deleted); err != nil || !deleted {
+		t.Errorf("the payment of the deleted invoice is not in the recycle bin: %v", err)
+	}
+}
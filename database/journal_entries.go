@@ -0,0 +1,348 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// generateJournalEntryNumber returns the next entry number of a company, counted within the posting transaction
+func generateJournalEntryNumber(exec execer, companyID int) (string, error) {
+	var count int
+	if err := exec.QueryRow(`SELECT COUNT(*) FROM journal_entries WHERE company_id = ?`, companyID).Scan(&count); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("JE-%06d", count+1), nil
+}
+
+// insertJournalEntry validates that an entry balances and saves it with its lines
+func insertJournalEntry(exec execer, entry *JournalEntry) error {
+	// Default to company 1 for backward compatibility
+	if entry.CompanyID == 0 {
+		entry.CompanyID = 1
+	}
+	if entry.SourceType == "" {
+		entry.SourceType = "manual"
+	}
+	if entry.BranchID != nil && *entry.BranchID == 0 {
+		entry.BranchID = nil
+	}
+	if err := checkBranch(exec, entry.CompanyID, entry.BranchID); err != nil {
+		return err
+	}
+	if entry.EntryDate.IsZero() {
+		return fmt.Errorf("a journal entry needs a date")
+	}
+	if err := checkPostingDate(exec, entry.CompanyID, entry.EntryDate, entry.SourceType); err != nil {
+		return err
+	}
+	if len(entry.Lines) < 2 {
+		return fmt.Errorf("a journal entry needs at least two lines")
+	}
+
+	var totalDebit, totalCredit float64
+	for i := range entry.Lines {
+		line := &entry.Lines[i]
+		line.Debit = roundAmount(line.Debit)
+		line.Credit = roundAmount(line.Credit)
+		if line.Debit < 0 || line.Credit < 0 {
+			return fmt.Errorf("line %d: debit and credit cannot be negative", i+1)
+		}
+		if (line.Debit == 0) == (line.Credit == 0) {
+			return fmt.Errorf("line %d: enter either a debit or a credit", i+1)
+		}
+
+		var accountCompanyID int
+		err := exec.QueryRow(`SELECT company_id FROM accounts WHERE id = ?`, line.AccountID).Scan(&accountCompanyID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("line %d: account %d not found", i+1, line.AccountID)
+		}
+		if err != nil {
+			return err
+		}
+		if accountCompanyID != entry.CompanyID {
+			return fmt.Errorf("line %d: the account belongs to a different company", i+1)
+		}
+
+		totalDebit += line.Debit
+		totalCredit += line.Credit
+	}
+	entry.TotalDebit = roundAmount(totalDebit)
+	entry.TotalCredit = roundAmount(totalCredit)
+	if math.Abs(entry.TotalDebit-entry.TotalCredit) >= 0.005 {
+		return fmt.Errorf("the journal entry does not balance: debits %.2f, credits %.2f", entry.TotalDebit, entry.TotalCredit)
+	}
+
+	number, err := generateJournalEntryNumber(exec, entry.CompanyID)
+	if err != nil {
+		return err
+	}
+	entry.EntryNumber = number
+
+	query := `
+		INSERT INTO journal_entries (company_id, branch_id, entry_number, entry_date, description, description_arabic, reference,
+			source_type, source_id, reverses_id, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := exec.Exec(query, entry.CompanyID, entry.BranchID, entry.EntryNumber, entry.EntryDate, entry.Description, entry.DescriptionArabic,
+		entry.Reference, entry.SourceType, entry.SourceID, entry.ReversesID, entry.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("error creating journal entry: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = int(id)
+
+	for i := range entry.Lines {
+		line := &entry.Lines[i]
+		line.EntryID = entry.ID
+		result, err := exec.Exec(`INSERT INTO journal_lines (entry_id, account_id, debit, credit, description) VALUES (?, ?, ?, ?, ?)`,
+			line.EntryID, line.AccountID, line.Debit, line.Credit, line.Description)
+		if err != nil {
+			return fmt.Errorf("error creating journal line: %v", err)
+		}
+		lineID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		line.ID = int(lineID)
+	}
+
+	if entry.ReversesID != nil {
+		if _, err := exec.Exec(`UPDATE journal_entries SET reversed_by_id = ? WHERE id = ?`, entry.ID, *entry.ReversesID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseJournalEntry posts the mirror image of an entry on the given date and links the two
+func reverseJournalEntry(exec execer, original *JournalEntry, date time.Time, createdBy *int) (*JournalEntry, error) {
+	if original.ReversedByID != nil {
+		return nil, fmt.Errorf("journal entry %s has already been reversed", original.EntryNumber)
+	}
+	if original.ReversesID != nil {
+		return nil, fmt.Errorf("journal entry %s is itself a reversal", original.EntryNumber)
+	}
+
+	reversal := &JournalEntry{
+		CompanyID:         original.CompanyID,
+		BranchID:          original.BranchID,
+		EntryDate:         date,
+		Description:       fmt.Sprintf("Reversal of %s", original.EntryNumber),
+		DescriptionArabic: fmt.Sprintf("عكس القيد %s", original.EntryNumber),
+		Reference:         original.Reference,
+		SourceType:        original.SourceType,
+		SourceID:          original.SourceID,
+		ReversesID:        &original.ID,
+		CreatedBy:         createdBy,
+	}
+	for _, line := range original.Lines {
+		reversal.Lines = append(reversal.Lines, JournalLine{
+			AccountID:   line.AccountID,
+			Debit:       line.Credit,
+			Credit:      line.Debit,
+			Description: line.Description,
+		})
+	}
+
+	if err := insertJournalEntry(exec, reversal); err != nil {
+		return nil, err
+	}
+	return reversal, nil
+}
+
+// CreateManualJournalEntry posts a manual journal entry
+func (d *Database) CreateManualJournalEntry(entry *JournalEntry) error {
+	entry.SourceType = "manual"
+	entry.SourceID = nil
+	entry.ReversesID = nil
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertJournalEntry(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReverseJournalEntry reverses a manual journal entry. Entries posted from documents are reversed by
+// changing, cancelling or deleting the document instead.
+func (d *Database) ReverseJournalEntry(id int, date time.Time, userID *int) (*JournalEntry, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	original, err := getJournalEntry(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if original.SourceType != "manual" {
+		return nil, fmt.Errorf("journal entry %s was posted from a %s; change the document instead", original.EntryNumber, original.SourceType)
+	}
+	if date.IsZero() {
+		date = original.EntryDate
+	}
+
+	reversal, err := reverseJournalEntry(tx, original, date, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return reversal, nil
+}
+
+// GetJournalEntries retrieves the journal entries of a company posted within a period, without lines
+func (d *Database) GetJournalEntries(companyID int, from, to time.Time) ([]JournalEntry, error) {
+	return queryJournalEntries(d.db, `WHERE je.company_id = ? AND DATE(je.entry_date) >= DATE(?) AND DATE(je.entry_date) <= DATE(?)`,
+		companyID, dateOnly(from), dateOnly(to))
+}
+
+// GetJournalEntriesBySource retrieves all entries posted for a document, including reversals
+func (d *Database) GetJournalEntriesBySource(sourceType string, sourceID int) ([]JournalEntry, error) {
+	entries, err := queryJournalEntries(d.db, `WHERE je.source_type = ? AND je.source_id = ?`, sourceType, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].Lines, err = getJournalLines(d.db, entries[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// GetJournalEntryByID retrieves a journal entry with its lines
+func (d *Database) GetJournalEntryByID(id int) (*JournalEntry, error) {
+	return getJournalEntry(d.db, id)
+}
+
+func getJournalEntry(exec execer, id int) (*JournalEntry, error) {
+	entries, err := queryJournalEntries(exec, `WHERE je.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	entry := entries[0]
+	if entry.Lines, err = getJournalLines(exec, id); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func queryJournalEntries(exec execer, where string, args ...interface{}) ([]JournalEntry, error) {
+	query := `
+		SELECT je.id, je.company_id, je.branch_id, je.entry_number, je.entry_date, COALESCE(je.description, ''), COALESCE(je.description_arabic, ''),
+			COALESCE(je.reference, ''), je.source_type, je.source_id, je.reverses_id, je.reversed_by_id,
+			COALESCE((SELECT SUM(debit) FROM journal_lines WHERE entry_id = je.id), 0),
+			COALESCE((SELECT SUM(credit) FROM journal_lines WHERE entry_id = je.id), 0),
+			je.created_by, je.created_at
+		FROM journal_entries je
+		` + where + `
+		ORDER BY je.entry_date, je.id`
+
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []JournalEntry
+	for rows.Next() {
+		var e JournalEntry
+		err := rows.Scan(&e.ID, &e.CompanyID, &e.BranchID, &e.EntryNumber, &e.EntryDate, &e.Description, &e.DescriptionArabic,
+			&e.Reference, &e.SourceType, &e.SourceID, &e.ReversesID, &e.ReversedByID,
+			&e.TotalDebit, &e.TotalCredit, &e.CreatedBy, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func getJournalLines(exec execer, entryID int) ([]JournalLine, error) {
+	rows, err := exec.Query(`
+		SELECT jl.id, jl.entry_id, jl.account_id, a.code, a.name, COALESCE(a.name_arabic, ''), jl.debit, jl.credit, COALESCE(jl.description, '')
+		FROM journal_lines jl
+		JOIN accounts a ON jl.account_id = a.id
+		WHERE jl.entry_id = ?
+		ORDER BY jl.id`, entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []JournalLine
+	for rows.Next() {
+		var l JournalLine
+		if err := rows.Scan(&l.ID, &l.EntryID, &l.AccountID, &l.AccountCode, &l.AccountName, &l.AccountNameArabic,
+			&l.Debit, &l.Credit, &l.Description); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// GetAccountLedger retrieves the postings to an account within a period with opening, running and closing balances
+func (d *Database) GetAccountLedger(accountID int, from, to time.Time) (*AccountLedger, error) {
+	account, err := d.GetAccountByID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := &AccountLedger{Account: *account, From: from, To: to, Entries: []AccountLedgerEntry{}}
+
+	err = d.db.QueryRow(`
+		SELECT COALESCE(SUM(jl.debit - jl.credit), 0)
+		FROM journal_lines jl
+		JOIN journal_entries je ON jl.entry_id = je.id
+		WHERE jl.account_id = ? AND DATE(je.entry_date) < DATE(?)`, accountID, dateOnly(from)).Scan(&ledger.OpeningBalance)
+	if err != nil {
+		return nil, err
+	}
+	ledger.OpeningBalance = roundAmount(ledger.OpeningBalance)
+
+	rows, err := d.db.Query(`
+		SELECT je.id, je.entry_number, je.entry_date, COALESCE(NULLIF(jl.description, ''), je.description, ''), je.source_type, je.source_id,
+			jl.debit, jl.credit
+		FROM journal_lines jl
+		JOIN journal_entries je ON jl.entry_id = je.id
+		WHERE jl.account_id = ? AND DATE(je.entry_date) >= DATE(?) AND DATE(je.entry_date) <= DATE(?)
+		ORDER BY je.entry_date, je.id, jl.id`, accountID, dateOnly(from), dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balance := ledger.OpeningBalance
+	for rows.Next() {
+		var e AccountLedgerEntry
+		if err := rows.Scan(&e.EntryID, &e.EntryNumber, &e.EntryDate, &e.Description, &e.SourceType, &e.SourceID, &e.Debit, &e.Credit); err != nil {
+			return nil, err
+		}
+		balance = roundAmount(balance + e.Debit - e.Credit)
+		e.Balance = balance
+		ledger.Entries = append(ledger.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ledger.ClosingBalance = balance
+
+	return ledger, nil
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 1000
+	// Searches matching fewer rows read the matches and sort them, rather than walk the sorted list
+	fewSearchMatches = 1000
+)
+
+// listSpec describes how the rows of a list are filtered, searched and sorted in SQL
+type listSpec struct {
+	table   string // The listed table, aliased as alias in the query of the list
+	alias   string
+	entity  string            // Entity of the table in search_index; lists without one search their search columns
+	search  []string          // Columns matched against the words searched with LIKE
+	date    string            // Date column From and To apply to
+	filters map[string]string // Filters by name and the column each compares
+	sorts   map[string]string // Sort orders by name and the NOT NULL column each sorts by
+	sort    string            // Default sort order
+	desc    bool
+}
+
+// listCursor is where the next page of a list starts: after the row with this sort value and id. It carries
+// the row count of the first page, so that only the first page counts the rows.
+type listCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+	Total int    `json:"n"`
+}
+
+// listPage retrieves a page of a list and counts the rows on all its pages. query selects rows of the list
+// with the WHERE, ORDER BY and LIMIT clauses it is given, and idOf returns the id of a row.
+//
+// Pages are read with keyset pagination: the cursor holds the sort value and id of the last row of a page,
+// and the next page starts after it, so reading a page costs the same however deep it is. The total count
+// is the count when the first page was read. Searches read every match, so a word found in most rows of a
+// large list, like INV in invoice numbers, is slower to list than the list itself.
+func listPage[T any](d *Database, spec listSpec, q ListQuery, query func(where string, args ...interface{}) ([]T, error), idOf func(T) int) ([]T, int, string, error) {
+	conditions := []string{spec.alias + ".deleted_at IS NULL", spec.alias + ".company_id = ?"}
+	args := []interface{}{q.CompanyID}
+
+	names := make([]string, 0, len(q.Filters))
+	for name := range q.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		column, ok := spec.filters[name]
+		if !ok {
+			return nil, 0, "", fmt.Errorf("%s cannot be filtered by %s", spec.table, name)
+		}
+		conditions = append(conditions, column+" = ?")
+		args = append(args, q.Filters[name])
+	}
+
+	if spec.date != "" && !q.From.IsZero() {
+		conditions = append(conditions, spec.date+" >= ?")
+		args = append(args, dateOnly(q.From))
+	}
+	if spec.date != "" && !q.To.IsZero() {
+		conditions = append(conditions, spec.date+" < ?")
+		args = append(args, dateOnly(q.To.AddDate(0, 0, 1)))
+	}
+
+	if spec.entity != "" {
+		if words := strings.Fields(normalizeSearchText(q.Search)); len(words) > 0 {
+			matchArgs := []interface{}{searchMatch(words)}
+			// The entity is read from the rowid, which FTS5 has without reading the indexed row
+			code := 0
+			for _, t := range searchedTables {
+				if t.entity == spec.entity {
+					code = t.code
+				}
+			}
+			matches := fmt.Sprintf("SELECT rowid / %d FROM search_index WHERE rowid %% %d = %d AND search_index MATCH ?", searchCodes, searchCodes, code)
+			var matched int
+			if err := d.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM (%s LIMIT %d)", matches, fewSearchMatches), matchArgs...).Scan(&matched); err != nil {
+				return nil, 0, "", fmt.Errorf("error searching %s: %v", spec.table, err)
+			}
+			if matched < fewSearchMatches {
+				// The unary + keeps SQLite from walking the rows of the company in an index to check each
+				// against the few matches, so that it reads the matching rows by id instead
+				conditions[1] = "+" + conditions[1]
+			}
+			conditions = append(conditions, fmt.Sprintf("%s.id IN (%s)", spec.alias, matches))
+			args = append(args, matchArgs...)
+		}
+	} else {
+		for _, word := range strings.Fields(q.Search) {
+			matches := make([]string, len(spec.search))
+			for i, column := range spec.search {
+				matches[i] = column + ` LIKE ? ESCAPE '\'`
+				args = append(args, "%"+escapeLike(word)+"%")
+			}
+			conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+		}
+	}
+
+	where := "WHERE " + strings.Join(conditions, " AND ")
+	sortBy, desc := spec.sort, spec.desc
+	if q.SortBy != "" {
+		sortBy, desc = q.SortBy, q.SortDesc
+	}
+	column, ok := spec.sorts[sortBy]
+	if !ok {
+		return nil, 0, "", fmt.Errorf("%s cannot be sorted by %s", spec.table, sortBy)
+	}
+	direction, after := "ASC", ">"
+	if desc {
+		direction, after = "DESC", "<"
+	}
+
+	var total int
+	if q.Cursor != "" {
+		cursor, err := decodeListCursor(q.Cursor)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		total = cursor.Total
+		where += fmt.Sprintf(" AND (%s, %s.id) %s (?, ?)", column, spec.alias, after)
+		args = append(args, cursor.Value, cursor.ID)
+	} else if err := d.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s %s %s", spec.table, spec.alias, where), args...).Scan(&total); err != nil {
+		return nil, 0, "", fmt.Errorf("error counting %s: %v", spec.table, err)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	items, err := query(fmt.Sprintf("%s ORDER BY %s %s, %s.id %s LIMIT ?", where, column, direction, spec.alias, direction), append(args, limit+1)...)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if items == nil {
+		items = []T{}
+	}
+	if len(items) <= limit {
+		return items, total, "", nil
+	}
+
+	items = items[:limit]
+	last := listCursor{ID: idOf(items[limit-1]), Total: total}
+	// The value is read as text, which SQLite compares with the column as it orders it
+	if err := d.db.QueryRow(fmt.Sprintf("SELECT %s || '' FROM %s %s WHERE %s.id = ?", column, spec.table, spec.alias, spec.alias), last.ID).Scan(&last.Value); err != nil {
+		return nil, 0, "", fmt.Errorf("error reading the cursor of %s: %v", spec.table, err)
+	}
+	next, err := last.encode()
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return items, total, next, nil
+}
+
+func (c listCursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeListCursor(value string) (listCursor, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return cursor, fmt.Errorf("invalid cursor %q", value)
+	}
+	return cursor, nil
+}
+
+// listIndexes support the sort orders of each list and its common filters. They only cover the rows
+// outside the recycle bin, which are the rows lists show.
+var listIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_customers_list ON customers(company_id, name) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_suppliers_list ON suppliers(company_id, company_name) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_products_list ON products(company_id, name) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_products_list_price ON products(company_id, unit_price) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_products_list_sku ON products(company_id, sku, name) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_products_list_barcode ON products(company_id, barcode, name) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_sales_invoices_list ON sales_invoices(company_id, issue_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_sales_invoices_list_status ON sales_invoices(company_id, status, issue_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_sales_invoices_list_total ON sales_invoices(company_id, total_amount) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_sales_invoices_list_customer ON sales_invoices(company_id, customer_id, issue_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_purchase_invoices_list ON purchase_invoices(company_id, issue_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_purchase_invoices_list_total ON purchase_invoices(company_id, total_amount) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_purchase_invoices_list_supplier ON purchase_invoices(company_id, supplier_id, issue_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_payments_list ON payments(company_id, payment_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_payments_list_amount ON payments(company_id, amount) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_payments_invoice_id ON payments(invoice_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_supplier_payments_list ON supplier_payments(company_id, payment_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_supplier_payments_list_amount ON supplier_payments(company_id, amount) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_credit_notes_list ON credit_notes(company_id, issue_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_credit_notes_list_total ON credit_notes(company_id, total_amount) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_expenses_list ON expenses(company_id, expense_date) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_expenses_list_total ON expenses(company_id, total_amount) WHERE deleted_at IS NULL`,
+}
+
+// replacedCompanyIndexes are the indexes on the company of the listed tables, which the list indexes
+// replace. They also cover deleted rows, so SQLite counting a list through them read every row for its
+// deleted_at.
+var replacedCompanyIndexes = []string{
+	"idx_customers_company_id", "idx_suppliers_company_id", "idx_products_company_id",
+	"idx_sales_invoices_company_id", "idx_purchase_invoices_company_id", "idx_payments_company_id",
+	"idx_supplier_payments_company_id", "idx_credit_notes_company_id", "idx_expenses_company_date",
+}
+
+// createListIndexes adds the indexes of the lists in place of the company indexes. Payments recorded
+// before payments had a company take the company of their invoice, so that they are listed with it.
+func createListIndexes(tx *sql.Tx) error {
+	if _, err := tx.Exec(`UPDATE payments SET company_id = COALESCE((SELECT company_id FROM sales_invoices WHERE id = payments.invoice_id), 1) WHERE company_id IS NULL`); err != nil {
+		return fmt.Errorf("error setting the company of payments: %v", err)
+	}
+	for _, index := range listIndexes {
+		if _, err := tx.Exec(index); err != nil {
+			return fmt.Errorf("error creating list index: %v", err)
+		}
+	}
+	for _, index := range replacedCompanyIndexes {
+		if _, err := tx.Exec("DROP INDEX IF EXISTS " + index); err != nil {
+			return fmt.Errorf("error dropping index %s: %v", index, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// seedSalesInvoices adds customers, and as many sales invoices as products, to a company with SQL, which is
+// much faster than creating them one by one. Every fourth invoice is paid and the dates spread over 2000
+// days from 2020.
+func seedSalesInvoices(db *sql.DB, companyID, customers, invoices int) error {
+	statements := []string{
+		`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?2)
+		INSERT INTO customers (name, name_arabic, vat_number, email, phone, address, address_arabic, city, city_arabic,
+			country, country_arabic, company_id)
+		SELECT 'Customer ' || i, 'عميل ' || i, printf('3%013d3', i), '', '', '', '', 'Riyadh', 'الرياض',
+			'Saudi Arabia', 'السعودية', ?1 FROM n`,
+		`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?3)
+		INSERT INTO sales_invoices (invoice_number, customer_id, sales_category_id, issue_date, due_date,
+			sub_total, vat_amount, total_amount, status, notes, notes_arabic, qr_code, company_id)
+		SELECT printf('INV-%07d', i), (SELECT MIN(id) FROM customers WHERE company_id = ?1) + i % ?2,
+			(SELECT MIN(id) FROM sales_categories), date('2020-01-01', '+' || (i % 2000) || ' days'),
+			date('2020-01-31', '+' || (i % 2000) || ' days'), i % 1000, (i % 1000) * 0.15, (i % 1000) * 1.15,
+			CASE i % 4 WHEN 0 THEN 'paid' ELSE 'sent' END, '', '', '', ?1
+		FROM n`,
+		`INSERT INTO product_categories (name, name_arabic, company_id) SELECT 'General', 'عام', ?1 WHERE ?2 > 0`,
+		`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?3)
+		INSERT INTO products (name, name_arabic, description, description_arabic, category_id, sku, barcode, unit_price, company_id)
+		SELECT 'Product ' || i, 'منتج ' || i, '', '', (SELECT MAX(id) FROM product_categories WHERE company_id = ?1),
+			printf('SKU-%07d', i), printf('628%010d', i), i % 500, ?1 FROM n`,
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, companyID, customers, invoices); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func TestListSalesInvoicesPages(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	if err := seedSalesInvoices(d.db, 1, 10, 120); err != nil {
+		t.Fatal(err)
+	}
+	if err := seedSalesInvoices(d.db, 2, 5, 30); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(`UPDATE sales_invoices SET deleted_at = CURRENT_TIMESTAMP WHERE company_id = 1 AND invoice_number = 'INV-0000007'`); err != nil {
+		t.Fatal(err)
+	}
+
+	// readAll follows the cursors of a list to its last page
+	readAll := func(q ListQuery) []SalesInvoice {
+		t.Helper()
+		var invoices []SalesInvoice
+		for pages := 0; ; pages++ {
+			page, err := d.ListSalesInvoices(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) > q.Limit {
+				t.Fatalf("page of %d invoices, want at most %d", len(page.Items), q.Limit)
+			}
+			invoices = append(invoices, page.Items...)
+			if page.NextCursor == "" {
+				if page.TotalCount != len(invoices) {
+					t.Errorf("total count %d, read %d invoices", page.TotalCount, len(invoices))
+				}
+				return invoices
+			}
+			if pages > 100 {
+				t.Fatal("the cursor does not advance")
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+
+	invoices := readAll(ListQuery{CompanyID: 1, Limit: 25})
+	seen := make(map[int]bool)
+	for i, invoice := range invoices {
+		if seen[invoice.ID] {
+			t.Errorf("invoice %s listed twice", invoice.InvoiceNumber)
+		}
+		seen[invoice.ID] = true
+		if i > 0 && invoice.IssueDate.After(invoices[i-1].IssueDate.Time) {
+			t.Errorf("invoice %s is listed after an earlier invoice", invoice.InvoiceNumber)
+		}
+	}
+	if len(invoices) != 119 {
+		t.Errorf("listed %d invoices of company 1, want 119", len(invoices))
+	}
+
+	byTotal := readAll(ListQuery{CompanyID: 1, SortBy: "total_amount", Limit: 7})
+	for i := 1; i < len(byTotal); i++ {
+		if byTotal[i].TotalAmount < byTotal[i-1].TotalAmount {
+			t.Fatalf("invoices by total are out of order at %d: %.2f after %.2f", i, byTotal[i].TotalAmount, byTotal[i-1].TotalAmount)
+		}
+	}
+	if len(byTotal) != 119 {
+		t.Errorf("listed %d invoices by total, want 119", len(byTotal))
+	}
+
+	tests := []struct {
+		name  string
+		query ListQuery
+		want  int
+	}{
+		{"other company", ListQuery{CompanyID: 2}, 30},
+		{"paid", ListQuery{CompanyID: 1, Filters: map[string]string{"status": "paid"}}, 30},
+		{"customer", ListQuery{CompanyID: 1, Filters: map[string]string{"customer_id": "1"}}, 12},
+		{"number", ListQuery{CompanyID: 1, Search: "INV-0000012"}, 1},
+		{"deleted number", ListQuery{CompanyID: 1, Search: "INV-0000007"}, 0},
+		{"dates", ListQuery{CompanyID: 1, From: time.Date(2020, 1, 11, 0, 0, 0, 0, time.UTC), To: time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)}, 10},
+	}
+	for _, test := range tests {
+		test.query.Limit = 1000
+		page, err := d.ListSalesInvoices(test.query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if page.TotalCount != test.want || len(page.Items) != test.want {
+			t.Errorf("%s: %d invoices of %d, want %d", test.name, len(page.Items), page.TotalCount, test.want)
+		}
+	}
+
+	if _, err := d.ListSalesInvoices(ListQuery{CompanyID: 1, Filters: map[string]string{"notes": "x"}}); err == nil {
+		t.Error("listed invoices filtered by a column that is not a filter")
+	}
+	if _, err := d.ListSalesInvoices(ListQuery{CompanyID: 1, SortBy: "notes"}); err == nil {
+		t.Error("listed invoices sorted by a column that is not a sort order")
+	}
+	if _, err := d.ListSalesInvoices(ListQuery{CompanyID: 1, Cursor: "not a cursor"}); err == nil {
+		t.Error("listed invoices after an invalid cursor")
+	}
+}
+
+// The benchmarks list a company with a million sales invoices and a million products, which is seeded once
+// and shared by them.
+const benchmarkRows = 1000000
+
+var (
+	benchmarkOnce sync.Once
+	benchmarkDir  string
+	benchmarkDB   *Database
+	benchmarkErr  error
+)
+
+func benchmarkDatabase(b *testing.B) *Database {
+	b.Helper()
+	benchmarkOnce.Do(func() {
+		if benchmarkDir, benchmarkErr = os.MkdirTemp("", "dijibill-bench"); benchmarkErr != nil {
+			return
+		}
+		if benchmarkDB, benchmarkErr = NewDatabase(filepath.Join(benchmarkDir, "dijibill.db")); benchmarkErr != nil {
+			return
+		}
+		start := time.Now()
+		benchmarkErr = seedSalesInvoices(benchmarkDB.db, 1, 10000, benchmarkRows)
+		fmt.Printf("seeded %d sales invoices and products in %v\n", benchmarkRows, time.Since(start).Round(time.Second))
+	})
+	if benchmarkErr != nil {
+		b.Fatal(benchmarkErr)
+	}
+	return benchmarkDB
+}
+
+// TestMain refuses to run the tests with a SQLite built without the full-text search every database needs
+func TestMain(m *testing.M) {
+	if err := checkSQLiteFTS5(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	if benchmarkDB != nil {
+		benchmarkDB.Close()
+	}
+	if benchmarkDir != "" {
+		os.RemoveAll(benchmarkDir)
+	}
+	os.Exit(code)
+}
+
+// checkSQLiteFTS5 fails with ErrNoFTS5 unless the tests were built with the SQLite the database needs
+func checkSQLiteFTS5() error {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return checkFTS5(db)
+}
+
+func benchmarkSalesInvoices(b *testing.B, q ListQuery) {
+	d := benchmarkDatabase(b)
+	q.CompanyID = 1
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page, err := d.ListSalesInvoices(q)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(page.Items) == 0 {
+			b.Fatal("empty page")
+		}
+	}
+}
+
+func BenchmarkListSalesInvoicesFirstPage(b *testing.B) {
+	benchmarkSalesInvoices(b, ListQuery{})
+}
+
+func BenchmarkListSalesInvoicesDeepPage(b *testing.B) {
+	d := benchmarkDatabase(b)
+	// Start after the 500000th invoice
+	var cursor listCursor
+	if err := d.db.QueryRow(`SELECT issue_date || '', id FROM sales_invoices ORDER BY issue_date DESC, id DESC LIMIT 1 OFFSET 499999`).Scan(&cursor.Value, &cursor.ID); err != nil {
+		b.Fatal(err)
+	}
+	next, err := cursor.encode()
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkSalesInvoices(b, ListQuery{Cursor: next})
+}
+
+func BenchmarkListSalesInvoicesByStatusAndDate(b *testing.B) {
+	benchmarkSalesInvoices(b, ListQuery{Filters: map[string]string{"status": "paid"},
+		From: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2022, 3, 31, 0, 0, 0, 0, time.UTC)})
+}
+
+func BenchmarkListSalesInvoicesSortedByTotal(b *testing.B) {
+	benchmarkSalesInvoices(b, ListQuery{SortBy: "total_amount", SortDesc: true})
+}
+
+func BenchmarkListSalesInvoicesSearch(b *testing.B) {
+	// Searches the digits of an invoice number, as "INV" is in every number
+	benchmarkSalesInvoices(b, ListQuery{Search: "012345"})
+}
+
+func BenchmarkListProductsFirstPage(b *testing.B) {
+	d := benchmarkDatabase(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.ListProducts(ListQuery{CompanyID: 1}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListProductsByBarcode(b *testing.B) {
+	d := benchmarkDatabase(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page, err := d.ListProducts(ListQuery{CompanyID: 1, Filters: map[string]string{"barcode": "6280000654321"}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(page.Items) != 1 {
+			b.Fatalf("found %d products", len(page.Items))
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// migration is a numbered change to the schema. Each migration runs once, in its own transaction, and is
+// recorded in schema_migrations with the version it brings the database to.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations are the schema changes in the order they are applied. Append new migrations with the next
+// version number and never edit or renumber one that has been released.
+//
+// Migrations 1 to 13 predate schema_migrations. Databases created before it was introduced may already have
+// any of their changes, so they check before they alter and are safe to run again.
+var migrations = []migration{
+	{1, "baseline_schema", createTables},
+	{2, "purchase_invoice_vat_options", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "purchase_invoices", "vat_rate", "REAL DEFAULT 15.0"); err != nil {
+			return err
+		}
+		return addColumn(tx, "purchase_invoices", "vat_inclusive", "BOOLEAN DEFAULT 0")
+	}},
+	{3, "sales_invoice_table_number", func(tx *sql.Tx) error {
+		return addColumn(tx, "sales_invoices", "table_number", "TEXT")
+	}},
+	{4, "multi_tenant", migrateMultiTenant},
+	{5, "user_intro_viewed", func(tx *sql.Tx) error {
+		return addColumn(tx, "users", "intro_viewed", "BOOLEAN DEFAULT 0")
+	}},
+	{6, "invoice_user_tracking", func(tx *sql.Tx) error {
+		for _, table := range []string{"sales_invoices", "purchase_invoices"} {
+			for _, column := range []string{"created_by", "updated_by"} {
+				if err := addColumn(tx, table, column, "INTEGER"); err != nil {
+					return err
+				}
+				query := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_%s ON %s(%s)", table, column, table, column)
+				if _, err := tx.Exec(query); err != nil {
+					return fmt.Errorf("error creating %s index on %s: %v", column, table, err)
+				}
+			}
+		}
+		return nil
+	}},
+	{7, "company_logo_file", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "companies", "logo_file_id", "INTEGER"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_companies_logo_file_id ON companies(logo_file_id)"); err != nil {
+			return fmt.Errorf("error creating index for logo_file_id: %v", err)
+		}
+		return nil
+	}},
+	{8, "payment_tendered_amounts", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "payments", "tendered_amount", "REAL DEFAULT 0"); err != nil {
+			return err
+		}
+		return addColumn(tx, "payments", "change_amount", "REAL DEFAULT 0")
+	}},
+	{9, "sales_invoice_type", func(tx *sql.Tx) error {
+		return addColumn(tx, "sales_invoices", "invoice_type", "TEXT DEFAULT 'standard'")
+	}},
+	{10, "vat_categories", func(tx *sql.Tx) error {
+		for _, table := range []string{"tax_rates", "sales_invoice_items", "purchase_invoice_items", "credit_note_items"} {
+			if err := addColumn(tx, table, "vat_category", "TEXT"); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`UPDATE tax_rates SET vat_category = CASE WHEN rate > 0 THEN 'standard' WHEN LOWER(name) LIKE '%exempt%' THEN 'exempt' ELSE 'zero_rated' END
+			WHERE vat_category IS NULL OR vat_category = ''`)
+		if err != nil {
+			return fmt.Errorf("error setting tax rate VAT categories: %v", err)
+		}
+		return nil
+	}},
+	{11, "branch_columns", func(tx *sql.Tx) error {
+		for _, table := range []string{"sales_invoices", "purchase_invoices", "journal_entries"} {
+			if err := addColumn(tx, table, "branch_id", "INTEGER REFERENCES branches(id)"); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_journal_entries_branch_id ON journal_entries(branch_id)`); err != nil {
+			return fmt.Errorf("error creating journal entry branch index: %v", err)
+		}
+		return nil
+	}},
+	{12, "company_costing_method", func(tx *sql.Tx) error {
+		return addColumn(tx, "companies", "costing_method", "TEXT DEFAULT 'weighted_average'")
+	}},
+	{13, "sales_item_costs", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "sales_invoice_items", "unit_cost", "REAL DEFAULT 0"); err != nil {
+			return err
+		}
+		return addColumn(tx, "sales_invoice_items", "cost_amount", "REAL DEFAULT 0")
+	}},
+	{14, "system_settings_backup_options", func(tx *sql.Tx) error {
+		if _, err := tx.Exec("ALTER TABLE system_settings ADD COLUMN backup_directory TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+		_, err := tx.Exec("ALTER TABLE system_settings ADD COLUMN backup_retention INTEGER DEFAULT 7")
+		return err
+	}},
+	{15, "company_scoped_unique_numbers", func(tx *sql.Tx) error {
+		// Document numbers and codes were unique across all companies, so two companies could not both
+		// have an INV-0001 or a CASH payment type
+		for _, c := range []struct{ table, column string }{
+			{"sales_invoices", "invoice_number"},
+			{"purchase_invoices", "invoice_number"},
+			{"credit_notes", "credit_note_number"},
+			{"supplier_payments", "payment_number"},
+			{"payment_types", "code"},
+			{"sales_categories", "code"},
+		} {
+			if err := scopeUniqueToCompany(tx, c.table, c.column); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{16, "soft_delete", func(tx *sql.Tx) error {
+		// Deleted master data and documents are kept and marked, so old documents keep their customers and
+		// products and a deleted record can be restored from the recycle bin
+		for _, table := range []string{"customers", "suppliers", "products", "product_categories", "sales_invoices",
+			"purchase_invoices", "credit_notes", "payments", "supplier_payments", "expenses"} {
+			for _, statement := range []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN deleted_at DATETIME", table),
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN deleted_by INTEGER REFERENCES users(id)", table),
+				fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_deleted_at ON %s(deleted_at)", table, table),
+			} {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}},
+	{17, "audit_log", createAuditLog},
+	{18, "search_index", createSearchIndex},
+	{19, "list_indexes", createListIndexes},
+	{20, "record_versions", addRecordVersions},
+	{21, "search_index_fts5", rebuildSearchIndex},
+}
+
+// SchemaTooNewError is returned when a database was last opened by a newer version of the application
+// that has applied migrations this version does not know
+type SchemaTooNewError struct {
+	DatabaseVersion  int
+	SupportedVersion int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the version %d supported by this application; please update the application",
+		e.DatabaseVersion, e.SupportedVersion)
+}
+
+// LatestSchemaVersion returns the schema version of a database with every migration applied
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the highest migration version applied to the database
+func (d *Database) SchemaVersion() (int, error) {
+	return schemaVersion(d.db)
+}
+
+func schemaVersion(exec execer) (int, error) {
+	var version int
+	if err := exec.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return version, nil
+}
+
+// migrate applies the migrations that have not been applied yet, in version order and each in its own
+// transaction. It refuses a database whose schema is newer than the newest migration.
+func (d *Database) migrate(migrations []migration) error {
+	latest := 0
+	for _, m := range migrations {
+		if m.version <= latest {
+			return fmt.Errorf("migration %d (%s) is out of order", m.version, m.name)
+		}
+		latest = m.version
+	}
+
+	_, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %v", err)
+	}
+
+	current, err := schemaVersion(d.db)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return &SchemaTooNewError{DatabaseVersion: current, SupportedVersion: latest}
+	}
+
+	applied := make(map[int]bool)
+	versions, err := queryIDs(d.db, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("error reading applied migrations: %v", err)
+	}
+	for _, version := range versions {
+		applied[version] = true
+	}
+
+	changed := false
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := d.applyMigration(m); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d (%s)", m.version, m.name)
+		changed = true
+	}
+
+	// Audit triggers list the columns of their tables, so they follow every schema change
+	if changed {
+		return d.refreshAuditTriggers()
+	}
+	return nil
+}
+
+// applyMigration runs a migration and records it in one transaction, so a failed migration leaves no trace
+func (d *Database) applyMigration(m migration) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %v", m.version, m.name, err)
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+		return fmt.Errorf("error recording migration %d: %v", m.version, err)
+	}
+
+	return tx.Commit()
+}
+
+// addColumn adds a column to a table unless the table already has it
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var columnExists bool
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column).Scan(&columnExists)
+	if err != nil {
+		return err
+	}
+	if columnExists {
+		return nil
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("error adding %s column to %s: %v", column, table, err)
+	}
+	log.Printf("Added %s column to %s table", column, table)
+	return nil
+}
+
+// scopeUniqueToCompany replaces the UNIQUE constraint on a column with a unique index on the company and the
+// column. SQLite cannot drop a constraint, so the table is rebuilt from its own definition without it; its
+// indexes, triggers and the views over it are recreated and its AUTOINCREMENT sequence is kept.
+func scopeUniqueToCompany(tx *sql.Tx, table, column string) error {
+	var definition string
+	if err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&definition); err != nil {
+		return fmt.Errorf("error reading the definition of %s: %v", table, err)
+	}
+	constraint := regexp.MustCompile(`(?i)(\b` + column + `\s+TEXT)\s+UNIQUE\b`)
+	header := regexp.MustCompile(`(?is)^CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?["` + "`" + `]?` + table + `["` + "`" + `]?\s*\(`)
+	if !constraint.MatchString(definition) || !header.MatchString(definition) {
+		return fmt.Errorf("unexpected definition of %s: %s", table, definition)
+	}
+	rebuild := table + "_rebuild"
+	definition = header.ReplaceAllString(constraint.ReplaceAllString(definition, "$1"), "CREATE TABLE "+rebuild+" (")
+
+	type dependent struct{ kind, name, sql string }
+	var dependents []dependent
+	rows, err := tx.Query(`SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL
+		AND ((type IN ('index', 'trigger') AND tbl_name = ?) OR type = 'view') ORDER BY type DESC`, table)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var d dependent
+		if err := rows.Scan(&d.kind, &d.name, &d.sql); err != nil {
+			rows.Close()
+			return err
+		}
+		if d.kind != "view" || strings.Contains(d.sql, table) {
+			dependents = append(dependents, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var sequence sql.NullInt64
+	if err := tx.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = ?", table).Scan(&sequence); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Views are dropped first, as renaming the rebuilt table checks that every view in the schema resolves
+	statements := []string{}
+	for _, d := range dependents {
+		if d.kind == "view" {
+			statements = append(statements, fmt.Sprintf("DROP VIEW %s", d.name))
+		}
+	}
+	statements = append(statements,
+		definition,
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", rebuild, table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rebuild, table),
+	)
+	for _, d := range dependents {
+		statements = append(statements, d.sql)
+	}
+	statements = append(statements,
+		fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS idx_%s_company_%s ON %s(company_id, %s)", table, column, table, column))
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("error rebuilding %s: %v", table, err)
+		}
+	}
+
+	if sequence.Valid {
+		if _, err := tx.Exec("UPDATE sqlite_sequence SET seq = ? WHERE name = ? AND seq < ?", sequence.Int64, table, sequence.Int64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
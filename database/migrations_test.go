@@ -0,0 +1,231 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openFixture loads a SQL dump from testdata into a new database file and returns its path
+func openFixture(t *testing.T, name string) string {
+	t.Helper()
+	dump, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dijibill.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(dump)); err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return path
+}
+
+func openDatabase(t *testing.T, path string) *Database {
+	t.Helper()
+	d, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("opening %s: %v", filepath.Base(path), err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func appliedMigrations(t *testing.T, d *Database) int {
+	t.Helper()
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func hasColumn(t *testing.T, d *Database, table, column string) bool {
+	t.Helper()
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func TestNewDatabaseAppliesAllMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dijibill.db")
+	d := openDatabase(t, path)
+
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("schema version = %d, want %d", version, LatestSchemaVersion())
+	}
+	if n := appliedMigrations(t, d); n != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", n, len(migrations))
+	}
+	d.Close()
+
+	reopened := openDatabase(t, path)
+	if n := appliedMigrations(t, reopened); n != len(migrations) {
+		t.Errorf("reopening recorded %d migrations, want %d", n, len(migrations))
+	}
+}
+
+func TestUpgradeLegacyDatabases(t *testing.T) {
+	fixtures := []string{
+		"legacy_single_tenant.sql", // Before users, company_id and purchase VAT options
+		"legacy_multi_tenant.sql",  // Multi-tenant, before schema_migrations and VAT categories
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture, func(t *testing.T) {
+			d := openDatabase(t, openFixture(t, fixture))
+
+			version, err := d.SchemaVersion()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != LatestSchemaVersion() {
+				t.Errorf("schema version = %d, want %d", version, LatestSchemaVersion())
+			}
+
+			columns := map[string][]string{
+				"customers":           {"company_id", "deleted_at", "version"},
+				"purchase_invoices":   {"vat_rate", "vat_inclusive", "created_by", "branch_id", "deleted_at"},
+				"sales_invoices":      {"table_number", "invoice_type", "branch_id", "deleted_at", "deleted_by"},
+				"sales_invoice_items": {"vat_category", "unit_cost", "cost_amount"},
+				"users":               {"intro_viewed"},
+				"companies":           {"logo_file_id", "costing_method"},
+				"payments":            {"tendered_amount", "change_amount", "deleted_at"},
+				"stock_cost_layers":   {"remaining_quantity"},
+			}
+			for table, names := range columns {
+				for _, column := range names {
+					if !hasColumn(t, d, table, column) {
+						t.Errorf("%s has no %s column", table, column)
+					}
+				}
+			}
+
+			company, err := d.GetCompanyByID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if company.Name != "Dijibill Trading" || company.CostingMethod != CostingWeightedAverage {
+				t.Errorf("company = %q costed by %q", company.Name, company.CostingMethod)
+			}
+			customers, err := d.GetCustomersByCompany(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(customers) != 1 || customers[0].NameArabic != "النور للتجارة" {
+				t.Errorf("customers of company 1 = %+v", customers)
+			}
+			invoice, err := d.GetSalesInvoiceByID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if invoice.TotalAmount != 103.5 || len(invoice.Items) != 1 {
+				t.Errorf("sales invoice total %.2f with %d items", invoice.TotalAmount, len(invoice.Items))
+			}
+			purchase, err := d.GetPurchaseInvoiceByID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purchase.VATRate != 15 {
+				t.Errorf("purchase invoice VAT rate = %v, want 15", purchase.VATRate)
+			}
+
+			// Invoice numbers are unique per company once rebuilt, and the invoices view still resolves
+			insert := `INSERT INTO sales_invoices (invoice_number, customer_id, sales_category_id, issue_date, due_date, sub_total, vat_amount, total_amount, status, company_id)
+				SELECT invoice_number, customer_id, sales_category_id, issue_date, due_date, sub_total, vat_amount, total_amount, status, ? FROM sales_invoices WHERE id = 1`
+			if _, err := d.db.Exec(insert, 2); err != nil {
+				t.Errorf("another company cannot reuse INV-0001: %v", err)
+			}
+			if _, err := d.db.Exec(insert, 1); err == nil {
+				t.Error("the same company could reuse INV-0001")
+			}
+			var viewed int
+			if err := d.db.QueryRow("SELECT COUNT(*) FROM invoices").Scan(&viewed); err != nil || viewed != 2 {
+				t.Errorf("invoices view has %d rows: %v", viewed, err)
+			}
+
+			// Tables rebuilt by the migrations are audited like the rest
+			var audited int
+			if err := d.db.QueryRow("SELECT COUNT(*) FROM audit_log WHERE entity_type = 'sales_invoices' AND action = 'create' AND company_id = 2").Scan(&audited); err != nil || audited != 1 {
+				t.Errorf("audit log has %d sales invoices created in company 2: %v", audited, err)
+			}
+			if _, err := d.db.Exec("DELETE FROM audit_log"); err == nil {
+				t.Error("the audit log could be deleted")
+			}
+		})
+	}
+}
+
+func TestRefusesNewerDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dijibill.db")
+	d := openDatabase(t, path)
+	newer := LatestSchemaVersion() + 1
+	if _, err := d.db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from_the_future')", newer); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	_, err := NewDatabase(path)
+	var tooNew *SchemaTooNewError
+	if !errors.As(err, &tooNew) {
+		t.Fatalf("NewDatabase error = %v, want SchemaTooNewError", err)
+	}
+	if tooNew.DatabaseVersion != newer || tooNew.SupportedVersion != LatestSchemaVersion() {
+		t.Errorf("error = %+v", tooNew)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+
+	broken := append(append([]migration(nil), migrations...), migration{
+		version: LatestSchemaVersion() + 1,
+		name:    "broken",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE half_done (id INTEGER)"); err != nil {
+				return err
+			}
+			return fmt.Errorf("something went wrong")
+		},
+	})
+	if err := d.migrate(broken); err == nil {
+		t.Fatal("migrate succeeded with a failing migration")
+	}
+
+	var tables int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("the failed migration's table was kept")
+	}
+	version, err := d.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("schema version = %d after a failed migration, want %d", version, LatestSchemaVersion())
+	}
+}
+
+func TestMigrationsOutOfOrder(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	reordered := []migration{migrations[1], migrations[0]}
+	if err := d.migrate(reordered); err == nil {
+		t.Error("migrate accepted migrations out of order")
+	}
+}
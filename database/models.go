@@ -68,6 +68,7 @@ type Customer struct {
 	CountryArabic string    `json:"country_arabic"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
+	Version       int       `json:"version"`
 }
 
 // Supplier represents a supplier in the system
@@ -91,6 +92,7 @@ type Supplier struct {
 	Active              bool      `json:"active"`
 	CreatedAt           time.Time `json:"created_at"`
 	UpdatedAt           time.Time `json:"updated_at"`
+	Version             int       `json:"version"`
 }
 
 // ProductCategory represents a category for products
@@ -103,6 +105,7 @@ type ProductCategory struct {
 	DescriptionArabic string    `json:"description_arabic"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
+	Version           int       `json:"version"`
 }
 
 // ProductCategoryWithCount represents a product category with product count
@@ -136,12 +139,14 @@ type Product struct {
 	ServiceNotUsingStock   bool      `json:"service_not_using_stock"` // Whether this is a service that doesn't use stock
 	CreatedAt              time.Time `json:"created_at"`
 	UpdatedAt              time.Time `json:"updated_at"`
+	Version                int       `json:"version"`
 }
 
 // SalesInvoice represents a sales invoice (to customers)
 type SalesInvoice struct {
 	ID               int                `json:"id"`
 	CompanyID        int                `json:"company_id"`  // Invoice belongs to a company
+	BranchID         *int               `json:"branch_id,omitempty"`
 	InvoiceNumber    string             `json:"invoice_number"`
 	CustomerID       int                `json:"customer_id"`
 	Customer         *Customer          `json:"customer,omitempty"`
@@ -154,6 +159,7 @@ type SalesInvoice struct {
 	VATAmount        float64            `json:"vat_amount"`
 	TotalAmount      float64            `json:"total_amount"`
 	Status           string             `json:"status"` // draft, sent, paid, cancelled
+	InvoiceType      string             `json:"invoice_type"` // standard, prepayment (ZATCA advance payment invoice)
 	Notes            string             `json:"notes"`
 	NotesArabic      string             `json:"notes_arabic"`
 	QRCode           string             `json:"qr_code"`
@@ -162,6 +168,7 @@ type SalesInvoice struct {
 	UpdatedBy        *int               `json:"updated_by,omitempty"`  // User who last updated the invoice
 	CreatedAt        time.Time          `json:"created_at"`
 	UpdatedAt        time.Time          `json:"updated_at"`
+	Version          int                `json:"version"`
 }
 
 // SalesInvoiceItem represents an item in a sales invoice
@@ -175,6 +182,9 @@ type SalesInvoiceItem struct {
 	VATRate     float64  `json:"vat_rate"`
 	VATAmount   float64  `json:"vat_amount"`
 	TotalAmount float64  `json:"total_amount"`
+	VATCategory string   `json:"vat_category,omitempty"` // Overrides the category of the tax rate, e.g. exempt
+	UnitCost    float64  `json:"unit_cost"`              // Cost of goods sold per unit, stamped when the invoice is issued
+	CostAmount  float64  `json:"cost_amount"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -182,6 +192,7 @@ type SalesInvoiceItem struct {
 type PurchaseInvoice struct {
 	ID               int                   `json:"id"`
 	CompanyID        int                   `json:"company_id"`  // Invoice belongs to a company
+	BranchID         *int                  `json:"branch_id,omitempty"`
 	InvoiceNumber    string                `json:"invoice_number"`
 	SupplierID       int                   `json:"supplier_id"`
 	Supplier         *Supplier             `json:"supplier,omitempty"`
@@ -192,7 +203,8 @@ type PurchaseInvoice struct {
 	VATRate          float64               `json:"vat_rate"`
 	VATInclusive     bool                  `json:"vat_inclusive"`
 	TotalAmount      float64               `json:"total_amount"`
-	Status           string                `json:"status"` // draft, received, paid, cancelled
+	PaidAmount       float64               `json:"paid_amount"` // Sum of completed supplier payment allocations
+	Status           string                `json:"status"` // draft, received, partially_paid, paid, cancelled
 	Notes            string                `json:"notes"`
 	NotesArabic      string                `json:"notes_arabic"`
 	Items            []PurchaseInvoiceItem `json:"items,omitempty"`
@@ -200,6 +212,7 @@ type PurchaseInvoice struct {
 	UpdatedBy        *int                  `json:"updated_by,omitempty"`  // User who last updated the invoice
 	CreatedAt        time.Time             `json:"created_at"`
 	UpdatedAt        time.Time             `json:"updated_at"`
+	Version          int                   `json:"version"`
 }
 
 // PurchaseInvoiceItem represents an item in a purchase invoice
@@ -213,6 +226,7 @@ type PurchaseInvoiceItem struct {
 	VATRate     float64  `json:"vat_rate"`
 	VATAmount   float64  `json:"vat_amount"`
 	TotalAmount float64  `json:"total_amount"`
+	VATCategory string   `json:"vat_category,omitempty"` // Overrides the category of the tax rate, e.g. exempt
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -237,6 +251,7 @@ type Company struct {
 	CountryArabic string `json:"country_arabic"`
 	Logo          string `json:"logo"`          // Legacy field for backward compatibility
 	LogoFileID    *int   `json:"logo_file_id"` // New field for file ID reference
+	CostingMethod string `json:"costing_method"` // weighted_average or fifo, used to cost stock when it is sold
 }
 
 // PaymentType represents different payment methods
@@ -253,22 +268,27 @@ type PaymentType struct {
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+
 // Payment represents a payment made against an invoice
 type Payment struct {
-	ID            int          `json:"id"`
-	CompanyID     int          `json:"company_id"`  // Payment belongs to a company
-	InvoiceID     int          `json:"invoice_id"`
-	Invoice       *Invoice     `json:"invoice,omitempty"`
-	PaymentTypeID int          `json:"payment_type_id"`
-	PaymentType   *PaymentType `json:"payment_type,omitempty"`
-	Amount        float64      `json:"amount"`
-	PaymentDate   time.Time    `json:"payment_date"`
-	Reference     string       `json:"reference"`     // Check number, transaction ID, etc.
-	Notes         string       `json:"notes"`
-	NotesArabic   string       `json:"notes_arabic"`
-	Status        string       `json:"status"`        // pending, completed, failed, cancelled
-	CreatedAt     time.Time    `json:"created_at"`
-	UpdatedAt     time.Time    `json:"updated_at"`
+	ID             int          `json:"id"`
+	CompanyID      int          `json:"company_id"` // Payment belongs to a company
+	InvoiceID      int          `json:"invoice_id"`
+	Invoice        *Invoice     `json:"invoice,omitempty"`
+	PaymentTypeID  int          `json:"payment_type_id"`
+	PaymentType    *PaymentType `json:"payment_type,omitempty"`
+	Amount         float64      `json:"amount"`          // Amount applied to the invoice
+	TenderedAmount float64      `json:"tendered_amount"` // Amount handed over by the customer (cash may exceed Amount)
+	ChangeAmount   float64      `json:"change_amount"`   // Change given back for this tender
+	PaymentDate    time.Time    `json:"payment_date"`
+	Reference      string       `json:"reference"` // Check number, transaction ID, etc.
+	Notes          string       `json:"notes"`
+	NotesArabic    string       `json:"notes_arabic"`
+	Status         string       `json:"status"` // pending, completed, failed, cancelled
+	Reconciled     bool         `json:"reconciled"` // Matched to a bank statement line
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+	Version        int          `json:"version"`
 }
 
 // SalesCategory represents categories for sales/invoices
@@ -293,6 +313,7 @@ type TaxRate struct {
 	Name        string    `json:"name"`
 	NameArabic  string    `json:"name_arabic"`
 	Rate        float64   `json:"rate"`
+	VATCategory string    `json:"vat_category"` // standard, zero_rated, exempt, out_of_scope
 	IsDefault   bool      `json:"is_default"`
 	IsActive    bool      `json:"is_active"`
 	Description string    `json:"description"`
@@ -397,6 +418,973 @@ type SystemSettings struct {
 	AutoBackup       bool       `json:"auto_backup"`
 	BackupFrequency  string     `json:"backup_frequency"`
 	LastBackupTime   *time.Time `json:"last_backup_time,omitempty"`
+	BackupDirectory  string     `json:"backup_directory"` // Empty for the default directory
+	BackupRetention  int        `json:"backup_retention"` // Number of backups kept
 	CreatedAt        time.Time  `json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
-}
\ No newline at end of file
+}
+// CreditNote represents a credit note issued to a customer, optionally against a sales invoice
+type CreditNote struct {
+	ID               int              `json:"id"`
+	CompanyID        int              `json:"company_id"`  // Credit note belongs to a company
+	CreditNoteNumber string           `json:"credit_note_number"`
+	InvoiceID        *int             `json:"invoice_id,omitempty"` // Original sales invoice being credited (optional)
+	CustomerID       int              `json:"customer_id"`
+	Customer         *Customer        `json:"customer,omitempty"`
+	IssueDate        Date             `json:"issue_date"`
+	Reason           string           `json:"reason"`
+	ReasonArabic     string           `json:"reason_arabic"`
+	SubTotal         float64          `json:"sub_total"`
+	VATAmount        float64          `json:"vat_amount"`
+	TotalAmount      float64          `json:"total_amount"`
+	Status           string           `json:"status"` // draft, issued, cancelled
+	Notes            string           `json:"notes"`
+	NotesArabic      string           `json:"notes_arabic"`
+	Items            []CreditNoteItem `json:"items,omitempty"`
+	CreatedBy        *int             `json:"created_by,omitempty"`
+	UpdatedBy        *int             `json:"updated_by,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+}
+
+// CreditNoteItem represents a line on a credit note
+type CreditNoteItem struct {
+	ID           int       `json:"id"`
+	CreditNoteID int       `json:"credit_note_id"`
+	ProductID    int       `json:"product_id"`
+	Product      *Product  `json:"product,omitempty"`
+	Description  string    `json:"description"`
+	Quantity     float64   `json:"quantity"`
+	UnitPrice    float64   `json:"unit_price"`
+	VATRate      float64   `json:"vat_rate"`
+	VATAmount    float64   `json:"vat_amount"`
+	TotalAmount  float64   `json:"total_amount"`
+	VATCategory  string    `json:"vat_category,omitempty"` // Overrides the category of the tax rate, e.g. exempt
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CustomerLedgerEntry represents one line of a customer ledger (invoice, credit note or payment)
+type CustomerLedgerEntry struct {
+	Date           time.Time  `json:"date"`
+	DueDate        *time.Time `json:"due_date,omitempty"`
+	DocumentType   string     `json:"document_type"` // invoice, credit_note, payment
+	DocumentID     int        `json:"document_id"`
+	DocumentNumber string     `json:"document_number"`
+	Reference      string     `json:"reference"`
+	Description    string     `json:"description"`
+	DescriptionAr  string     `json:"description_arabic"`
+	Debit          float64    `json:"debit"`   // Increases what the customer owes
+	Credit         float64    `json:"credit"`  // Decreases what the customer owes
+	Balance        float64    `json:"balance"` // Running balance after this entry
+}
+
+// AgingBuckets holds outstanding amounts grouped by days past due
+type AgingBuckets struct {
+	Current    float64 `json:"current"` // Not yet due
+	Days1To30  float64 `json:"days_1_30"`
+	Days31To60 float64 `json:"days_31_60"`
+	Days61To90 float64 `json:"days_61_90"`
+	Over90     float64 `json:"over_90"`
+	Total      float64 `json:"total"`
+}
+
+// Add places an amount into the bucket matching the number of days past due
+func (b *AgingBuckets) Add(daysPastDue int, amount float64) {
+	switch {
+	case daysPastDue <= 0:
+		b.Current += amount
+	case daysPastDue <= 30:
+		b.Days1To30 += amount
+	case daysPastDue <= 60:
+		b.Days31To60 += amount
+	case daysPastDue <= 90:
+		b.Days61To90 += amount
+	default:
+		b.Over90 += amount
+	}
+	b.Total += amount
+}
+
+// Merge adds all amounts of another set of buckets
+func (b *AgingBuckets) Merge(other AgingBuckets) {
+	b.Current += other.Current
+	b.Days1To30 += other.Days1To30
+	b.Days31To60 += other.Days31To60
+	b.Days61To90 += other.Days61To90
+	b.Over90 += other.Over90
+	b.Total += other.Total
+}
+
+// CustomerStatement represents a statement of account for a customer over a period
+type CustomerStatement struct {
+	Customer       *Customer             `json:"customer"`
+	PeriodStart    *time.Time            `json:"period_start,omitempty"`
+	PeriodEnd      time.Time             `json:"period_end"`
+	OpeningBalance float64               `json:"opening_balance"`
+	Entries        []CustomerLedgerEntry `json:"entries"`
+	TotalDebits    float64               `json:"total_debits"`
+	TotalCredits   float64               `json:"total_credits"`
+	ClosingBalance float64               `json:"closing_balance"`
+	Aging          AgingBuckets          `json:"aging"`
+}
+
+// ReceivableAgingRow represents the outstanding balance of one customer split into aging buckets
+type ReceivableAgingRow struct {
+	CustomerID         int          `json:"customer_id"`
+	CustomerName       string       `json:"customer_name"`
+	CustomerNameArabic string       `json:"customer_name_arabic"`
+	OpenInvoices       int          `json:"open_invoices"`
+	UnappliedCredits   float64      `json:"unapplied_credits"` // Credit balance: advances, overpayments and unallocated credit notes
+	Buckets            AgingBuckets `json:"buckets"`
+	NetBalance         float64      `json:"net_balance"` // Buckets total less unapplied credits
+}
+
+// ReceivablesAgingReport represents accounts receivable aging as of a date
+type ReceivablesAgingReport struct {
+	AsOf             time.Time            `json:"as_of"`
+	Rows             []ReceivableAgingRow `json:"rows"`
+	Totals           AgingBuckets         `json:"totals"`
+	UnappliedCredits float64              `json:"unapplied_credits"`
+	NetBalance       float64              `json:"net_balance"`
+}
+
+// SupplierPayment represents money paid to a supplier, allocated across purchase invoices
+type SupplierPayment struct {
+	ID                int                         `json:"id"`
+	CompanyID         int                         `json:"company_id"` // Payment belongs to a company
+	PaymentNumber     string                      `json:"payment_number"`
+	SupplierID        int                         `json:"supplier_id"`
+	Supplier          *Supplier                   `json:"supplier,omitempty"`
+	PaymentTypeID     int                         `json:"payment_type_id"`
+	PaymentType       *PaymentType                `json:"payment_type,omitempty"`
+	Amount            float64                     `json:"amount"`
+	UnallocatedAmount float64                     `json:"unallocated_amount"` // Amount not yet allocated to any invoice (supplier advance)
+	PaymentDate       time.Time                   `json:"payment_date"`
+	Reference         string                      `json:"reference"` // Check number, transfer ID, etc.
+	Notes             string                      `json:"notes"`
+	NotesArabic       string                      `json:"notes_arabic"`
+	Status            string                      `json:"status"` // completed, cancelled
+	Reconciled        bool                        `json:"reconciled"` // Matched to a bank statement line
+	Allocations       []SupplierPaymentAllocation `json:"allocations,omitempty"`
+	CreatedBy         *int                        `json:"created_by,omitempty"`
+	UpdatedBy         *int                        `json:"updated_by,omitempty"`
+	CreatedAt         time.Time                   `json:"created_at"`
+	UpdatedAt         time.Time                   `json:"updated_at"`
+}
+
+// SupplierPaymentAllocation represents the part of a supplier payment applied to one purchase invoice
+type SupplierPaymentAllocation struct {
+	ID                int       `json:"id"`
+	SupplierPaymentID int       `json:"supplier_payment_id"`
+	PurchaseInvoiceID int       `json:"purchase_invoice_id"`
+	InvoiceNumber     string    `json:"invoice_number"`
+	Amount            float64   `json:"amount"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+
+// PayableAgingRow represents what is owed to one supplier split into aging buckets
+type PayableAgingRow struct {
+	SupplierID          int          `json:"supplier_id"`
+	SupplierName        string       `json:"supplier_name"`
+	SupplierNameArabic  string       `json:"supplier_name_arabic"`
+	PaymentTerms        string       `json:"payment_terms"`
+	OpenInvoices        int          `json:"open_invoices"`
+	UnallocatedPayments float64      `json:"unallocated_payments"` // Advances paid but not allocated to invoices
+	Buckets             AgingBuckets `json:"buckets"`
+	NetBalance          float64      `json:"net_balance"` // Buckets total less unallocated payments
+}
+
+// PayablesAgingReport represents accounts payable aging as of a date
+type PayablesAgingReport struct {
+	AsOf                time.Time         `json:"as_of"`
+	Rows                []PayableAgingRow `json:"rows"`
+	Totals              AgingBuckets      `json:"totals"`
+	UnallocatedPayments float64           `json:"unallocated_payments"`
+	NetBalance          float64           `json:"net_balance"`
+}
+
+// PayableForecastEntry represents an open purchase invoice expected to be paid on its due date
+type PayableForecastEntry struct {
+	PurchaseInvoiceID int       `json:"purchase_invoice_id"`
+	InvoiceNumber     string    `json:"invoice_number"`
+	SupplierID        int       `json:"supplier_id"`
+	SupplierName      string    `json:"supplier_name"`
+	SupplierNameAr    string    `json:"supplier_name_arabic"`
+	PaymentTerms      string    `json:"payment_terms"`
+	IssueDate         time.Time `json:"issue_date"`
+	DueDate           time.Time `json:"due_date"`
+	Outstanding       float64   `json:"outstanding"`
+	DaysUntilDue      int       `json:"days_until_due"` // Negative when overdue
+}
+
+// PayableForecastPeriod sums the payments falling due in one week of the forecast
+type PayableForecastPeriod struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Amount float64   `json:"amount"`
+}
+
+// PayablesForecast lists the supplier payments falling due between two dates
+type PayablesForecast struct {
+	From         time.Time               `json:"from"`
+	To           time.Time               `json:"to"`
+	OverdueTotal float64                 `json:"overdue_total"` // Already past due on the from date
+	DueTotal     float64                 `json:"due_total"`     // Falling due within the period
+	Entries      []PayableForecastEntry  `json:"entries"`
+	Periods      []PayableForecastPeriod `json:"periods"`
+}
+
+// CheckoutTender represents one way a customer pays at checkout, e.g. part cash and part card
+type CheckoutTender struct {
+	PaymentTypeID int     `json:"payment_type_id"`
+	Amount        float64 `json:"amount"`    // Amount tendered; cash may exceed what is due
+	Reference     string  `json:"reference"` // Card approval code, transfer ID, etc.
+}
+
+// CheckoutResult is the outcome of a checkout: the invoice, one payment per tender and the change due
+type CheckoutResult struct {
+	Invoice       SalesInvoice `json:"invoice"`
+	Payments      []Payment    `json:"payments"`
+	TotalTendered float64      `json:"total_tendered"`
+	ChangeDue     float64      `json:"change_due"`
+}
+
+// CustomerCreditTransaction is one entry of a customer's credit balance. Entries are never changed or
+// deleted; mistakes are corrected by a reversal entry so the history stays auditable.
+type CustomerCreditTransaction struct {
+	ID                  int       `json:"id"`
+	CompanyID           int       `json:"company_id"`
+	CustomerID          int       `json:"customer_id"`
+	TransactionDate     time.Time `json:"transaction_date"`
+	Type                string    `json:"type"`    // advance, overpayment, credit_note, applied, refund, reversal
+	Amount              float64   `json:"amount"`  // Positive adds to the credit balance, negative uses it
+	Balance             float64   `json:"balance"` // Running credit balance after this entry
+	PaymentTypeID       *int      `json:"payment_type_id,omitempty"`       // How an advance was received or a refund paid out
+	PaymentID           *int      `json:"payment_id,omitempty"`            // Payment that caused an advance or overpayment
+	InvoiceID           *int      `json:"invoice_id,omitempty"`            // Invoice the credit came from or was applied to
+	InvoiceNumber       string    `json:"invoice_number"`
+	CreditNoteID        *int      `json:"credit_note_id,omitempty"`
+	PrepaymentInvoiceID *int      `json:"prepayment_invoice_id,omitempty"` // Prepayment invoice whose advance is used
+	ReversesID          *int      `json:"reverses_id,omitempty"`           // Entry undone by a reversal
+	Reference           string    `json:"reference"`
+	Notes               string    `json:"notes"`
+	NotesArabic         string    `json:"notes_arabic"`
+	CreatedBy           *int      `json:"created_by,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// CustomerAdvance is a deposit received from a customer before an invoice is issued
+type CustomerAdvance struct {
+	CompanyID     int     `json:"company_id"`
+	CustomerID    int     `json:"customer_id"`
+	PaymentTypeID int     `json:"payment_type_id"`
+	Amount        float64 `json:"amount"`   // VAT inclusive
+	VATRate       float64 `json:"vat_rate"` // VAT due on the advance, charged on the prepayment invoice
+	Date          Date    `json:"date"`
+	Reference     string  `json:"reference"`
+	Notes         string  `json:"notes"`
+	NotesArabic   string  `json:"notes_arabic"`
+	CreatedBy     *int    `json:"created_by,omitempty"`
+}
+
+// PrepaymentDeduction is an advance deducted on a final invoice, with the prepayment invoice it was charged on
+type PrepaymentDeduction struct {
+	PrepaymentInvoiceID int       `json:"prepayment_invoice_id"`
+	InvoiceNumber       string    `json:"invoice_number"`
+	IssueDate           time.Time `json:"issue_date"`
+	SubTotal            float64   `json:"sub_total"`
+	VATRate             float64   `json:"vat_rate"`
+	VATAmount           float64   `json:"vat_amount"`
+	Amount              float64   `json:"amount"`
+}
+
+// BankStatement represents an imported bank statement
+type BankStatement struct {
+	ID              int                 `json:"id"`
+	CompanyID       int                 `json:"company_id"`
+	Format          string              `json:"format"` // csv, mt940, camt053
+	AccountNumber   string              `json:"account_number"`
+	Currency        string              `json:"currency"`
+	StatementNumber string              `json:"statement_number"`
+	StatementDate   time.Time           `json:"statement_date"`
+	OpeningBalance  float64             `json:"opening_balance"`
+	ClosingBalance  float64             `json:"closing_balance"`
+	FileName        string              `json:"file_name"`
+	LineCount       int                 `json:"line_count"`
+	ReconciledCount int                 `json:"reconciled_count"`
+	Lines           []BankStatementLine `json:"lines,omitempty"`
+	CreatedBy       *int                `json:"created_by,omitempty"`
+	CreatedAt       time.Time           `json:"created_at"`
+}
+
+// BankStatementLine represents one booking on a bank statement and what it was matched to
+type BankStatementLine struct {
+	ID                  int        `json:"id"`
+	StatementID         int        `json:"statement_id"`
+	CompanyID           int        `json:"company_id"`
+	LineNumber          int        `json:"line_number"`
+	BookingDate         time.Time  `json:"booking_date"`
+	ValueDate           time.Time  `json:"value_date"`
+	Amount              float64    `json:"amount"` // Positive for money received, negative for money paid out
+	Reference           string     `json:"reference"`
+	Description         string     `json:"description"`
+	CounterpartyName    string     `json:"counterparty_name"`
+	CounterpartyAccount string     `json:"counterparty_account"`
+	BankReference       string     `json:"bank_reference"`
+	Status              string     `json:"status"`     // unmatched, suggested, reconciled, ignored
+	MatchType           string     `json:"match_type"` // payment, invoice, supplier_payment, purchase_invoice
+	PaymentID           *int       `json:"payment_id,omitempty"`
+	SupplierPaymentID   *int       `json:"supplier_payment_id,omitempty"`
+	InvoiceID           *int       `json:"invoice_id,omitempty"`
+	PurchaseInvoiceID   *int       `json:"purchase_invoice_id,omitempty"`
+	MatchScore          int        `json:"match_score"`
+	MatchDescription    string     `json:"match_description"` // Number of the matched payment or invoice
+	ReconciledBy        *int       `json:"reconciled_by,omitempty"`
+	ReconciledAt        *time.Time `json:"reconciled_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// BankMatchCandidate is a payment or open invoice that may correspond to a bank statement line
+type BankMatchCandidate struct {
+	MatchType   string    `json:"match_type"` // payment, invoice, supplier_payment, purchase_invoice
+	ID          int       `json:"id"`
+	Number      string    `json:"number"` // Invoice or payment number
+	PartyName   string    `json:"party_name"`
+	PartyNameAr string    `json:"party_name_ar"`
+	Date        time.Time `json:"date"`
+	Amount      float64   `json:"amount"` // Payment amount or invoice outstanding
+	Reference   string    `json:"reference"`
+	Score       int       `json:"score"`
+	Reasons     []string  `json:"reasons"` // amount, reference, date
+}
+
+// BankLinePayment describes the payment to record for an unmatched bank statement line. Money received
+// is paid against a sales invoice; money paid out goes to a supplier, optionally against one purchase invoice.
+type BankLinePayment struct {
+	InvoiceID         int    `json:"invoice_id"`
+	PurchaseInvoiceID int    `json:"purchase_invoice_id"`
+	SupplierID        int    `json:"supplier_id"`
+	PaymentTypeID     int    `json:"payment_type_id"` // Defaults to bank transfer
+	Notes             string `json:"notes"`
+}
+
+// Account represents an account in a company's chart of accounts
+type Account struct {
+	ID         int       `json:"id"`
+	CompanyID  int       `json:"company_id"`
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	NameArabic string    `json:"name_arabic"`
+	Type       string    `json:"type"` // asset, liability, equity, revenue, expense
+	ParentID   *int      `json:"parent_id,omitempty"`
+	IsSystem   bool      `json:"is_system"` // Part of the default chart; cannot be deleted
+	IsActive   bool      `json:"is_active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Branch is a location of a company that documents and journal entries can be reported by
+type Branch struct {
+	ID            int       `json:"id"`
+	CompanyID     int       `json:"company_id"`
+	Code          string    `json:"code"`
+	Name          string    `json:"name"`
+	NameArabic    string    `json:"name_arabic"`
+	Address       string    `json:"address"`
+	AddressArabic string    `json:"address_arabic"`
+	IsActive      bool      `json:"is_active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// AccountMapping assigns the account used for one role when posting documents. Mappings for a sales
+// category, tax rate, payment type or expense category override the company default for that role.
+type AccountMapping struct {
+	ID          int    `json:"id"`
+	CompanyID   int    `json:"company_id"`
+	EntityType  string `json:"entity_type"` // default, sales_category, tax_rate, payment_type, expense_category
+	EntityID    int    `json:"entity_id"`   // 0 for company defaults
+	Role        string `json:"role"`        // receivable, revenue, output_vat, input_vat, cash, ...
+	AccountID   int    `json:"account_id"`
+	AccountCode string `json:"account_code"`
+	AccountName string `json:"account_name"`
+}
+
+// JournalEntry represents a balanced double-entry journal entry
+type JournalEntry struct {
+	ID                int           `json:"id"`
+	CompanyID         int           `json:"company_id"`
+	BranchID          *int          `json:"branch_id,omitempty"`
+	EntryNumber       string        `json:"entry_number"`
+	EntryDate         time.Time     `json:"entry_date"`
+	Description       string        `json:"description"`
+	DescriptionArabic string        `json:"description_arabic"`
+	Reference         string        `json:"reference"`
+	SourceType        string        `json:"source_type"` // manual, sales_invoice, purchase_invoice, payment, supplier_payment, credit_note, stock_receipt, customer_credit
+	SourceID          *int          `json:"source_id,omitempty"`
+	ReversesID        *int          `json:"reverses_id,omitempty"`     // Entry this one reverses
+	ReversedByID      *int          `json:"reversed_by_id,omitempty"`  // Reversal of this entry
+	TotalDebit        float64       `json:"total_debit"`
+	TotalCredit       float64       `json:"total_credit"`
+	Lines             []JournalLine `json:"lines,omitempty"`
+	CreatedBy         *int          `json:"created_by,omitempty"`
+	CreatedAt         time.Time     `json:"created_at"`
+}
+
+// JournalLine represents one debit or credit of a journal entry
+type JournalLine struct {
+	ID                int     `json:"id"`
+	EntryID           int     `json:"entry_id"`
+	AccountID         int     `json:"account_id"`
+	AccountCode       string  `json:"account_code"`
+	AccountName       string  `json:"account_name"`
+	AccountNameArabic string  `json:"account_name_arabic"`
+	Debit             float64 `json:"debit"`
+	Credit            float64 `json:"credit"`
+	Description       string  `json:"description"`
+}
+
+// AccountLedgerEntry represents one posting to an account with the running balance
+type AccountLedgerEntry struct {
+	EntryID     int       `json:"entry_id"`
+	EntryNumber string    `json:"entry_number"`
+	EntryDate   time.Time `json:"entry_date"`
+	Description string    `json:"description"`
+	SourceType  string    `json:"source_type"`
+	SourceID    *int      `json:"source_id,omitempty"`
+	Debit       float64   `json:"debit"`
+	Credit      float64   `json:"credit"`
+	Balance     float64   `json:"balance"` // Debit balance; negative for a credit balance
+}
+
+// AccountLedger represents the postings to an account over a period
+type AccountLedger struct {
+	Account        Account              `json:"account"`
+	From           time.Time            `json:"from"`
+	To             time.Time            `json:"to"`
+	OpeningBalance float64              `json:"opening_balance"`
+	Entries        []AccountLedgerEntry `json:"entries"`
+	ClosingBalance float64              `json:"closing_balance"`
+}
+
+// VATReturnBox represents one box of the ZATCA VAT return. Adjustments hold credit notes, returns and
+// advances deducted in the period; the VAT amount covers both columns.
+type VATReturnBox struct {
+	Box           int     `json:"box"`
+	Label         string  `json:"label"`
+	LabelArabic   string  `json:"label_arabic"`
+	Amount        float64 `json:"amount"`
+	Adjustment    float64 `json:"adjustment"`
+	VATAmount     float64 `json:"vat_amount"`
+	DocumentCount int     `json:"document_count"`
+}
+
+// VATReturn represents the ZATCA VAT return of a company for a period
+type VATReturn struct {
+	CompanyID            int            `json:"company_id"`
+	From                 time.Time      `json:"from"`
+	To                   time.Time      `json:"to"`
+	Sales                []VATReturnBox `json:"sales"`                  // Boxes 1-6
+	Purchases            []VATReturnBox `json:"purchases"`              // Boxes 7-12
+	ReverseChargeVAT     float64        `json:"reverse_charge_vat"`     // Self-assessed on box 9, due as well as recoverable
+	TotalVATDue          float64        `json:"total_vat_due"`          // Box 13
+	Corrections          float64        `json:"corrections"`            // Box 14: corrections from previous periods
+	CreditCarriedForward float64        `json:"credit_carried_forward"` // Box 15
+	NetVATDue            float64        `json:"net_vat_due"`            // Box 16; negative is a refundable credit
+}
+
+// VATReturnDocument represents the part of a document reported in one box of the VAT return
+type VATReturnDocument struct {
+	Box            int       `json:"box"`
+	DocumentType   string    `json:"document_type"` // sales_invoice, credit_note, advance_deduction, advance_refund, purchase_invoice, expense
+	DocumentID     int       `json:"document_id"`
+	DocumentNumber string    `json:"document_number"`
+	Date           time.Time `json:"date"`
+	PartyName      string    `json:"party_name"`
+	VATNumber      string    `json:"vat_number"`
+	IsAdjustment   bool      `json:"is_adjustment"`
+	Amount         float64   `json:"amount"` // Excluding VAT; negative for adjustments
+	VATAmount      float64   `json:"vat_amount"`
+}
+
+// FinancialReportColumn is a period shown as a column of a financial statement
+type FinancialReportColumn struct {
+	Key         string    `json:"key"` // current, previous_period, previous_year
+	Label       string    `json:"label"`
+	LabelArabic string    `json:"label_arabic"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+}
+
+// TrialBalanceLine is the balance of one account; balances are positive for debits
+type TrialBalanceLine struct {
+	AccountID         int       `json:"account_id"`
+	AccountCode       string    `json:"account_code"`
+	AccountName       string    `json:"account_name"`
+	AccountNameArabic string    `json:"account_name_arabic"`
+	AccountType       string    `json:"account_type"`
+	OpeningBalance    float64   `json:"opening_balance"`
+	Debit             float64   `json:"debit"`  // Movements in the current period
+	Credit            float64   `json:"credit"` // Movements in the current period
+	ClosingDebit      float64   `json:"closing_debit"`
+	ClosingCredit     float64   `json:"closing_credit"`
+	Comparisons       []float64 `json:"comparisons"` // Closing balance at the end of each comparison column
+}
+
+// TrialBalance lists the balances of all accounts with postings for a period
+type TrialBalance struct {
+	CompanyID          int                     `json:"company_id"`
+	BranchID           int                     `json:"branch_id"` // 0 for all branches
+	Columns            []FinancialReportColumn `json:"columns"`   // The current period followed by the comparisons
+	Lines              []TrialBalanceLine      `json:"lines"`
+	TotalDebit         float64                 `json:"total_debit"`
+	TotalCredit        float64                 `json:"total_credit"`
+	TotalClosingDebit  float64                 `json:"total_closing_debit"`
+	TotalClosingCredit float64                 `json:"total_closing_credit"`
+}
+
+// FinancialStatementLine is a heading, account or total of an income statement or balance sheet
+type FinancialStatementLine struct {
+	Kind        string    `json:"kind"`    // heading, account, total
+	Section     string    `json:"section"` // revenue, cost_of_sales, expenses, assets, liabilities, equity
+	AccountID   int       `json:"account_id,omitempty"`
+	AccountCode string    `json:"account_code,omitempty"`
+	Label       string    `json:"label"`
+	LabelArabic string    `json:"label_arabic"`
+	Amounts     []float64 `json:"amounts"` // One per column
+}
+
+// FinancialStatement is an income statement or balance sheet with a column per period
+type FinancialStatement struct {
+	Type      string                   `json:"type"` // profit_and_loss, balance_sheet
+	CompanyID int                      `json:"company_id"`
+	BranchID  int                      `json:"branch_id"` // 0 for all branches
+	Columns   []FinancialReportColumn  `json:"columns"`   // The current period followed by the comparisons
+	Lines     []FinancialStatementLine `json:"lines"`
+}
+
+// FiscalYear is a financial year of a company, split into periods
+type FiscalYear struct {
+	ID        int            `json:"id"`
+	CompanyID int            `json:"company_id"`
+	Name      string         `json:"name"`
+	StartDate time.Time      `json:"start_date"`
+	EndDate   time.Time      `json:"end_date"`
+	Status    string         `json:"status"` // open, closed
+	ClosedAt  *time.Time     `json:"closed_at,omitempty"`
+	ClosedBy  *int           `json:"closed_by,omitempty"`
+	Periods   []FiscalPeriod `json:"periods,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// FiscalPeriod is a month of a fiscal year. Closed periods only accept manual journal entries;
+// locked periods accept no changes at all.
+type FiscalPeriod struct {
+	ID           int       `json:"id"`
+	FiscalYearID int       `json:"fiscal_year_id"`
+	CompanyID    int       `json:"company_id"`
+	PeriodNumber int       `json:"period_number"`
+	Name         string    `json:"name"`
+	StartDate    time.Time `json:"start_date"`
+	EndDate      time.Time `json:"end_date"`
+	Status       string    `json:"status"` // open, closed, locked
+	UpdatedBy    *int      `json:"updated_by,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ExpenseCategory groups expenses such as rent, utilities or salaries. Its expenses post to the account
+// mapped to the category, or to the company's general expenses account.
+type ExpenseCategory struct {
+	ID          int       `json:"id"`
+	CompanyID   int       `json:"company_id"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	NameArabic  string    `json:"name_arabic"`
+	VATCategory string    `json:"vat_category"` // Default for its expenses, e.g. out_of_scope for salaries
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Expense is spending outside inventory purchases, paid to a supplier or another payee
+type Expense struct {
+	ID                 int              `json:"id"`
+	CompanyID          int              `json:"company_id"`
+	BranchID           *int             `json:"branch_id,omitempty"`
+	ExpenseNumber      string           `json:"expense_number"`
+	CategoryID         int              `json:"category_id"`
+	Category           *ExpenseCategory `json:"category,omitempty"`
+	SupplierID         *int             `json:"supplier_id,omitempty"`
+	PayeeName          string           `json:"payee_name"` // The supplier's name when a supplier is set
+	ExpenseDate        Date             `json:"expense_date"`
+	Description        string           `json:"description"`
+	DescriptionArabic  string           `json:"description_arabic"`
+	Amount             float64          `json:"amount"` // Excluding VAT
+	VATRate            float64          `json:"vat_rate"`
+	VATAmount          float64          `json:"vat_amount"`
+	VATCategory        string           `json:"vat_category"`
+	VATRecoverable     bool             `json:"vat_recoverable"` // Non-recoverable VAT is part of the expense
+	TotalAmount        float64          `json:"total_amount"`
+	PaymentTypeID      int              `json:"payment_type_id"`
+	Reference          string           `json:"reference"`
+	Notes              string           `json:"notes"`
+	NotesArabic        string           `json:"notes_arabic"`
+	Status             string           `json:"status"` // draft, recorded, cancelled
+	RecurringExpenseID *int             `json:"recurring_expense_id,omitempty"`
+	CreatedBy          *int             `json:"created_by,omitempty"`
+	UpdatedBy          *int             `json:"updated_by,omitempty"`
+	CreatedAt          time.Time        `json:"created_at"`
+	UpdatedAt          time.Time        `json:"updated_at"`
+	Version            int              `json:"version"`
+}
+
+// RecurringExpense records an expense every week, month, quarter or year from its start date
+type RecurringExpense struct {
+	ID                int       `json:"id"`
+	CompanyID         int       `json:"company_id"`
+	BranchID          *int      `json:"branch_id,omitempty"`
+	CategoryID        int       `json:"category_id"`
+	SupplierID        *int      `json:"supplier_id,omitempty"`
+	PayeeName         string    `json:"payee_name"`
+	Description       string    `json:"description"`
+	DescriptionArabic string    `json:"description_arabic"`
+	Amount            float64   `json:"amount"` // Excluding VAT
+	VATRate           float64   `json:"vat_rate"`
+	VATRecoverable    bool      `json:"vat_recoverable"`
+	PaymentTypeID     int       `json:"payment_type_id"`
+	Frequency         string    `json:"frequency"` // weekly, monthly, quarterly, yearly
+	StartDate         Date      `json:"start_date"`
+	EndDate           *Date     `json:"end_date,omitempty"`
+	NextDate          Date      `json:"next_date"`
+	Occurrences       int       `json:"occurrences"` // Expenses recorded so far
+	IsActive          bool      `json:"is_active"`
+	CreatedBy         *int      `json:"created_by,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// SalesAnalyticsTotals sums sales over a period. Issued credit notes count as returns; drafts, cancelled
+// documents and prepayment invoices are left out.
+type SalesAnalyticsTotals struct {
+	InvoiceCount    int     `json:"invoice_count"`
+	CreditNoteCount int     `json:"credit_note_count"`
+	Quantity        float64 `json:"quantity"`     // Items sold less items returned
+	GrossSales      float64 `json:"gross_sales"`  // Invoiced, excluding VAT
+	Returns         float64 `json:"returns"`      // Credited, excluding VAT
+	NetSales        float64 `json:"net_sales"`    // Gross sales less returns
+	VATAmount       float64 `json:"vat_amount"`
+	TotalAmount     float64 `json:"total_amount"` // Net sales including VAT
+}
+
+// SalesAnalyticsPeriod is the sales of one day, week or month
+type SalesAnalyticsPeriod struct {
+	Label string    `json:"label"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	SalesAnalyticsTotals
+}
+
+// SalesBreakdownRow is the sales of one product, category, customer, sales category, user or payment type.
+// Payment type rows show the payments received instead of sales.
+type SalesBreakdownRow struct {
+	ID           int     `json:"id"` // 0 for sales without one, e.g. walk-in customers
+	Label        string  `json:"label"`
+	LabelArabic  string  `json:"label_arabic"`
+	InvoiceCount int     `json:"invoice_count"`
+	Quantity     float64 `json:"quantity"`
+	NetSales     float64 `json:"net_sales"`
+	VATAmount    float64 `json:"vat_amount"`
+	TotalAmount  float64 `json:"total_amount"`
+	Share        float64 `json:"share"` // Percentage of the total net sales, or of the payments received
+}
+
+// SalesAnalytics summarises the sales of a company over a date range, over time and by dimension
+type SalesAnalytics struct {
+	CompanyID         int                    `json:"company_id"`
+	From              time.Time              `json:"from"`
+	To                time.Time              `json:"to"`
+	GroupBy           string                 `json:"group_by"` // day, week, month
+	Totals            SalesAnalyticsTotals   `json:"totals"`
+	Periods           []SalesAnalyticsPeriod `json:"periods"`
+	ByProduct         []SalesBreakdownRow    `json:"by_product"`
+	ByProductCategory []SalesBreakdownRow    `json:"by_product_category"`
+	ByCustomer        []SalesBreakdownRow    `json:"by_customer"`
+	BySalesCategory   []SalesBreakdownRow    `json:"by_sales_category"`
+	ByUser            []SalesBreakdownRow    `json:"by_user"`
+	ByPaymentType     []SalesBreakdownRow    `json:"by_payment_type"`
+}
+
+// MarginRow is the revenue, cost of goods sold and gross margin of one product, product category, invoice
+// or period
+type MarginRow struct {
+	ID            int        `json:"id"` // 0 for periods and for sales without one, e.g. items without a product
+	Label         string     `json:"label"`
+	LabelArabic   string     `json:"label_arabic"`
+	Date          *time.Time `json:"date,omitempty"` // Issue date of an invoice or start of a period
+	InvoiceCount  int        `json:"invoice_count"`
+	Quantity      float64    `json:"quantity"`
+	Revenue       float64    `json:"revenue"` // Excluding VAT
+	Cost          float64    `json:"cost"`
+	GrossMargin   float64    `json:"gross_margin"`
+	MarginPercent float64    `json:"margin_percent"` // Gross margin as a percentage of revenue
+}
+
+// GrossMarginReport is the gross margin of the sales invoices of a company over a date range
+type GrossMarginReport struct {
+	CompanyID     int         `json:"company_id"`
+	From          time.Time   `json:"from"`
+	To            time.Time   `json:"to"`
+	Dimension     string      `json:"dimension"`      // product, product_category, invoice, day, week, month
+	CostingMethod string      `json:"costing_method"` // weighted_average, fifo
+	Totals        MarginRow   `json:"totals"`
+	Rows          []MarginRow `json:"rows"`
+}
+
+// StockCostRecalculation reports the stock costs of a company calculated again
+type StockCostRecalculation struct {
+	Recosted int                  `json:"recosted"` // Sales invoices costed again
+	Skipped  []SkippedCostInvoice `json:"skipped"`  // Issued sales invoices left as they were
+}
+
+// SkippedCostInvoice is a sales invoice whose cost was not calculated again because it is dated in a closed
+// or locked fiscal period
+type SkippedCostInvoice struct {
+	ID            int       `json:"id"`
+	InvoiceNumber string    `json:"invoice_number"`
+	IssueDate     time.Time `json:"issue_date"`
+	Period        string    `json:"period"`
+	PeriodStatus  string    `json:"period_status"` // closed or locked
+}
+
+// ImportField is a field a column of an import file can be mapped to
+type ImportField struct {
+	Key      string `json:"key"`
+	Label    string `json:"label"`
+	Required bool   `json:"required"` // Required for new records
+	Match    bool   `json:"match"`    // Existing records with the same value are updated instead of duplicated
+}
+
+// ImportRow is a row of an import file with the values of its mapped fields by field key
+type ImportRow struct {
+	Line   int               `json:"line"` // Line in the file, counting the header row
+	Values map[string]string `json:"values"`
+}
+
+// ImportRowError is a value of an import row that failed validation
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// ImportResult reports what an import saved, or would save on a dry run, and why rows were left out
+type ImportResult struct {
+	Entity    string           `json:"entity"` // products, customers, suppliers
+	DryRun    bool             `json:"dry_run"`
+	TotalRows int              `json:"total_rows"`
+	Inserted  int              `json:"inserted"`
+	Updated   int              `json:"updated"`
+	Failed    int              `json:"failed"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+// BackupCompany is a company found in a backup
+type BackupCompany struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	NameArabic string `json:"name_arabic"`
+}
+
+// BackupSummary describes what a backup holds, shown before it is restored
+type BackupSummary struct {
+	Path                 string          `json:"path"`
+	SchemaVersion        int             `json:"schema_version"` // 0 for backups from before versioned migrations
+	Companies            []BackupCompany `json:"companies"`
+	CustomerCount        int             `json:"customer_count"`
+	ProductCount         int             `json:"product_count"`
+	SalesInvoiceCount    int             `json:"sales_invoice_count"`
+	PurchaseInvoiceCount int             `json:"purchase_invoice_count"`
+	FirstDocumentDate    *time.Time      `json:"first_document_date,omitempty"` // Earliest sales or purchase invoice
+	LastDocumentDate     *time.Time      `json:"last_document_date,omitempty"`
+}
+
+// EncryptionStatus describes whether the database is encrypted at rest and with which key
+type EncryptionStatus struct {
+	Enabled            bool       `json:"enabled"`
+	Unlocked           bool       `json:"unlocked"`
+	ActiveKeyID        string     `json:"active_key_id,omitempty"`
+	ActiveKeyCreatedAt *time.Time `json:"active_key_created_at,omitempty"`
+	RetiredKeys        int        `json:"retired_keys"` // Kept to read backups taken before a rotation
+}
+
+// CompanyArchiveTable holds the rows of one table of an exported company, with values as SQLite stores them
+type CompanyArchiveTable struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// CompanyImportResult reports a company imported from an archive
+type CompanyImportResult struct {
+	CompanyID    int                    `json:"company_id"`
+	CompanyName  string                 `json:"company_name"`
+	Rows         map[string]int         `json:"rows"`          // Rows imported per table
+	SkippedUsers []string               `json:"skipped_users"` // Users left out because their username or email is taken
+	Files        int                    `json:"files"`
+	IDs          map[string]map[int]int `json:"-"` // New ids by table and archived id
+}
+
+// RecycledRecord is a deleted customer, supplier, product, category or document kept in the recycle bin
+type RecycledRecord struct {
+	EntityType    string    `json:"entity_type"` // customer, supplier, product, product_category, sales_invoice, ...
+	ID            int       `json:"id"`
+	Name          string    `json:"name"` // Name or document number
+	NameArabic    string    `json:"name_arabic"`
+	Amount        *float64  `json:"amount,omitempty"` // Total of a document
+	DeletedAt     time.Time `json:"deleted_at"`
+	DeletedBy     *int      `json:"deleted_by"`
+	DeletedByName string    `json:"deleted_by_name"`
+}
+
+// AuditLogEntry is a change to a record: who made it, when, and the columns it changed
+type AuditLogEntry struct {
+	ID            int                    `json:"id"`
+	CompanyID     *int                   `json:"company_id"`
+	UserID        *int                   `json:"user_id"`
+	Username      string                 `json:"username"`
+	EntityType    string                 `json:"entity_type"` // The table changed, e.g. sales_invoices
+	EntityID      int                    `json:"entity_id"`
+	Action        string                 `json:"action"` // create, update, delete or restore
+	Changes       map[string]AuditChange `json:"changes"`
+	ChangeSummary string                 `json:"change_summary"`
+	CreatedAt     time.Time              `json:"created_at"`
+}
+
+// AuditChange is the value of a column before and after a change. Created records have no before value
+// and deleted records no after value.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditLogFilter selects audit log entries. Zero values match everything.
+type AuditLogFilter struct {
+	CompanyID  int       `json:"company_id"`
+	EntityType string    `json:"entity_type"`
+	EntityID   int       `json:"entity_id"`
+	UserID     int       `json:"user_id"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Limit      int       `json:"limit"`
+	Offset     int       `json:"offset"`
+}
+
+// SearchResult is a customer, supplier, product or invoice found by Search
+type SearchResult struct {
+	EntityType string `json:"entity_type"` // customer, supplier, product, sales_invoice or purchase_invoice
+	ID         int    `json:"id"`
+	Title      string `json:"title"`    // Name or invoice number
+	Subtitle   string `json:"subtitle"` // Arabic name or invoice status
+}
+
+// ListQuery selects a page of a list. Zero values leave a list unfiltered and in its default order.
+type ListQuery struct {
+	CompanyID int               `json:"company_id"`
+	Search    string            `json:"search"`  // Matched against the names, numbers and notes of the list
+	Filters   map[string]string `json:"filters"` // Values of the filters of the list by name, e.g. status: paid
+	From      time.Time         `json:"from"`    // Date of the documents, for lists of documents
+	To        time.Time         `json:"to"`
+	SortBy    string            `json:"sort_by"` // A sort order of the list; its default order when empty
+	SortDesc  bool              `json:"sort_desc"`
+	Limit     int               `json:"limit"`  // Rows per page, 50 when 0 and at most 1000
+	Cursor    string            `json:"cursor"` // NextCursor of the previous page, empty for the first page
+}
+
+// CustomerPage is a page of customers. TotalCount counts the customers on all pages and NextCursor is
+// empty on the last page. The other pages are alike.
+type CustomerPage struct {
+	Items      []Customer `json:"items"`
+	TotalCount int        `json:"total_count"`
+	NextCursor string     `json:"next_cursor"`
+}
+
+// SupplierPage is a page of suppliers
+type SupplierPage struct {
+	Items      []Supplier `json:"items"`
+	TotalCount int        `json:"total_count"`
+	NextCursor string     `json:"next_cursor"`
+}
+
+// ProductPage is a page of products
+type ProductPage struct {
+	Items      []Product `json:"items"`
+	TotalCount int       `json:"total_count"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// SalesInvoicePage is a page of sales invoices
+type SalesInvoicePage struct {
+	Items      []SalesInvoice `json:"items"`
+	TotalCount int            `json:"total_count"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+// PurchaseInvoicePage is a page of purchase invoices
+type PurchaseInvoicePage struct {
+	Items      []PurchaseInvoice `json:"items"`
+	TotalCount int               `json:"total_count"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+// PaymentPage is a page of customer payments
+type PaymentPage struct {
+	Items      []Payment `json:"items"`
+	TotalCount int       `json:"total_count"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// SupplierPaymentPage is a page of supplier payments
+type SupplierPaymentPage struct {
+	Items      []SupplierPayment `json:"items"`
+	TotalCount int               `json:"total_count"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+// CreditNotePage is a page of credit notes
+type CreditNotePage struct {
+	Items      []CreditNote `json:"items"`
+	TotalCount int          `json:"total_count"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+// ExpensePage is a page of expenses
+type ExpensePage struct {
+	Items      []Expense `json:"items"`
+	TotalCount int       `json:"total_count"`
+	NextCursor string    `json:"next_cursor"`
+}
+
+// IntegrityIssue is a problem found by an integrity check of the data
+type IntegrityIssue struct {
+	Key      string `json:"key"`   // Identifies the issue when it is repaired
+	Check    string `json:"check"` // corruption, reference, company, totals, qr_code or file
+	Table    string `json:"table"`
+	RecordID int    `json:"record_id"`
+	Column   string `json:"column,omitempty"`
+	Message  string `json:"message"`
+	Fix      string `json:"fix"` // What the automatic fix does, empty when the issue has to be resolved by hand
+}
+
+// IntegrityReport lists the issues found by an integrity check. After a repair it lists the issues that
+// are left, along with the fixes that were made and those that failed.
+type IntegrityReport struct {
+	CheckedAt time.Time        `json:"checked_at"`
+	Issues    []IntegrityIssue `json:"issues"`
+	Fixed     []IntegrityIssue `json:"fixed"`
+	Errors    []string         `json:"errors"` // Fixes that failed, with the reason
+
+	repairs map[string]func(userID *int) error
+}
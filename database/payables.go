@@ -0,0 +1,172 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// openPayables returns the unpaid purchase invoices of a company issued up to a date, counting
+// the payments made up to that date. Invoices without a due date fall due after the supplier's
+// payment terms.
+func (d *Database) openPayables(companyID, supplierID int, asOf time.Time) ([]PayableForecastEntry, error) {
+	query := `
+		SELECT pi.id, pi.invoice_number, pi.supplier_id, COALESCE(s.company_name, ''), COALESCE(s.company_name_arabic, ''),
+			COALESCE(s.payment_terms, ''), pi.issue_date, pi.due_date, pi.total_amount,
+			COALESCE((SELECT SUM(spa.amount) FROM supplier_payment_allocations spa
+				JOIN supplier_payments sp ON spa.supplier_payment_id = sp.id
+				WHERE spa.purchase_invoice_id = pi.id AND sp.status = 'completed' AND sp.deleted_at IS NULL AND DATE(sp.payment_date) <= DATE(?)), 0)
+		FROM purchase_invoices pi
+		LEFT JOIN suppliers s ON pi.supplier_id = s.id
+		WHERE pi.company_id = ? AND pi.status NOT IN ('draft', 'cancelled') AND DATE(pi.issue_date) <= DATE(?)
+			AND (? = 0 OR pi.supplier_id = ?)`
+
+	asOfDate := dateOnly(asOf)
+	rows, err := d.db.Query(query, asOfDate, companyID, asOfDate, supplierID, supplierID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payables []PayableForecastEntry
+	for rows.Next() {
+		var p PayableForecastEntry
+		var total, paid float64
+		var dueDate time.Time
+		if err := rows.Scan(&p.PurchaseInvoiceID, &p.InvoiceNumber, &p.SupplierID, &p.SupplierName, &p.SupplierNameAr,
+			&p.PaymentTerms, &p.IssueDate, &dueDate, &total, &paid); err != nil {
+			return nil, err
+		}
+
+		p.Outstanding = total - paid
+		if p.Outstanding < 0.005 {
+			continue
+		}
+
+		if dueDate.IsZero() {
+			dueDate = p.IssueDate.AddDate(0, 0, PaymentTermsDays(p.PaymentTerms))
+		}
+		p.DueDate = dueDate
+		p.DaysUntilDue = daysBetween(asOf, dueDate)
+
+		payables = append(payables, p)
+	}
+	return payables, rows.Err()
+}
+
+// GetPayablesAging returns what is owed to every supplier of a company, split by days past due as of the given date
+func (d *Database) GetPayablesAging(companyID int, asOf time.Time) (*PayablesAgingReport, error) {
+	payables, err := d.openPayables(companyID, 0, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsBySupplier := make(map[int]*PayableAgingRow)
+	getRow := func(id int, name, nameArabic, terms string) *PayableAgingRow {
+		row, ok := rowsBySupplier[id]
+		if !ok {
+			row = &PayableAgingRow{SupplierID: id, SupplierName: name, SupplierNameArabic: nameArabic, PaymentTerms: terms}
+			rowsBySupplier[id] = row
+		}
+		return row
+	}
+
+	for _, p := range payables {
+		row := getRow(p.SupplierID, p.SupplierName, p.SupplierNameAr, p.PaymentTerms)
+		row.Buckets.Add(-p.DaysUntilDue, p.Outstanding)
+		row.OpenInvoices++
+	}
+
+	// Payments not allocated to any invoice are advances held by the supplier
+	advanceRows, err := d.db.Query(`
+		SELECT sp.supplier_id, COALESCE(s.company_name, ''), COALESCE(s.company_name_arabic, ''), COALESCE(s.payment_terms, ''),
+			SUM(sp.amount - COALESCE((SELECT SUM(amount) FROM supplier_payment_allocations WHERE supplier_payment_id = sp.id), 0))
+		FROM supplier_payments sp
+		LEFT JOIN suppliers s ON sp.supplier_id = s.id
+		WHERE sp.company_id = ? AND sp.status = 'completed' AND sp.deleted_at IS NULL AND DATE(sp.payment_date) <= DATE(?)
+		GROUP BY sp.supplier_id`, companyID, dateOnly(asOf))
+	if err != nil {
+		return nil, err
+	}
+	defer advanceRows.Close()
+
+	for advanceRows.Next() {
+		var id int
+		var name, nameArabic, terms string
+		var amount float64
+		if err := advanceRows.Scan(&id, &name, &nameArabic, &terms, &amount); err != nil {
+			return nil, err
+		}
+		if amount < 0.005 {
+			continue
+		}
+		getRow(id, name, nameArabic, terms).UnallocatedPayments += amount
+	}
+	if err := advanceRows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &PayablesAgingReport{AsOf: asOf, Rows: []PayableAgingRow{}}
+	for _, row := range rowsBySupplier {
+		row.NetBalance = row.Buckets.Total - row.UnallocatedPayments
+		report.Totals.Merge(row.Buckets)
+		report.UnallocatedPayments += row.UnallocatedPayments
+		report.Rows = append(report.Rows, *row)
+	}
+	report.NetBalance = report.Totals.Total - report.UnallocatedPayments
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		return report.Rows[i].SupplierName < report.Rows[j].SupplierName
+	})
+
+	return report, nil
+}
+
+// GetPayablesForecast lists the purchase invoices falling due up to the to date, grouped by week
+// starting at the from date. Invoices already overdue on the from date are included and totalled separately.
+func (d *Database) GetPayablesForecast(companyID int, from, to time.Time) (*PayablesForecast, error) {
+	payables, err := d.openPayables(companyID, 0, to)
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := &PayablesForecast{
+		From:    from,
+		To:      to,
+		Entries: []PayableForecastEntry{},
+		Periods: []PayableForecastPeriod{},
+	}
+
+	for start := from; daysBetween(start, to) >= 0; start = start.AddDate(0, 0, 7) {
+		end := start.AddDate(0, 0, 6)
+		if daysBetween(end, to) < 0 {
+			end = to
+		}
+		forecast.Periods = append(forecast.Periods, PayableForecastPeriod{Start: start, End: end})
+	}
+
+	for _, p := range payables {
+		daysFromStart := daysBetween(from, p.DueDate)
+		if daysBetween(p.DueDate, to) < 0 {
+			continue
+		}
+
+		p.DaysUntilDue = daysFromStart
+		forecast.Entries = append(forecast.Entries, p)
+
+		if daysFromStart < 0 {
+			forecast.OverdueTotal += p.Outstanding
+			continue
+		}
+		forecast.DueTotal += p.Outstanding
+		forecast.Periods[daysFromStart/7].Amount += p.Outstanding
+	}
+
+	sort.SliceStable(forecast.Entries, func(i, j int) bool {
+		if !forecast.Entries[i].DueDate.Equal(forecast.Entries[j].DueDate) {
+			return forecast.Entries[i].DueDate.Before(forecast.Entries[j].DueDate)
+		}
+		return forecast.Entries[i].PurchaseInvoiceID < forecast.Entries[j].PurchaseInvoiceID
+	})
+
+	return forecast, nil
+}
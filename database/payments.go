@@ -5,88 +5,111 @@ import (
 	"time"
 )
 
-// CreatePayment creates a new payment record
+// CreatePayment creates a new payment record. Whatever it pays beyond the invoice
+// outstanding is kept as customer credit.
 func (d *Database) CreatePayment(payment Payment) (Payment, error) {
-	query := `
-		INSERT INTO payments (invoice_id, payment_type_id, amount, payment_date, reference, notes, notes_arabic, status, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	
-	now := time.Now()
-	result, err := d.db.Exec(query, payment.InvoiceID, payment.PaymentTypeID, payment.Amount, payment.PaymentDate, payment.Reference, payment.Notes, payment.NotesArabic, payment.Status, now, now)
+	tx, err := d.db.Begin()
 	if err != nil {
 		return Payment{}, err
 	}
+	defer tx.Rollback()
 
-	id, err := result.LastInsertId()
-	if err != nil {
+	if err = insertPayment(tx, &payment); err != nil {
+		return Payment{}, err
+	}
+	if err = recordOverpayment(tx, &payment); err != nil {
 		return Payment{}, err
 	}
 
-	payment.ID = int(id)
-	payment.CreatedAt = now
-	payment.UpdatedAt = now
-
+	if err = tx.Commit(); err != nil {
+		return Payment{}, err
+	}
 	return payment, nil
 }
 
-// GetPayments retrieves all payments with optional filtering
-func (d *Database) GetPayments() ([]Payment, error) {
+// insertPayment inserts a payment using either the database or an open transaction
+func insertPayment(exec execer, payment *Payment) error {
 	query := `
-		SELECT p.id, p.invoice_id, p.payment_type_id, p.amount, p.payment_date, p.reference, p.notes, p.notes_arabic, p.status, p.created_at, p.updated_at,
-			   pt.name as payment_type_name, pt.name_arabic as payment_type_name_arabic, pt.code as payment_type_code
-		FROM payments p
-		LEFT JOIN payment_types pt ON p.payment_type_id = pt.id
-		ORDER BY p.payment_date DESC
+		INSERT INTO payments (company_id, invoice_id, payment_type_id, amount, tendered_amount, change_amount, payment_date, reference, notes, notes_arabic, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	rows, err := d.db.Query(query)
-	if err != nil {
-		return nil, err
+	// Default to company 1 for backward compatibility
+	if payment.CompanyID == 0 {
+		payment.CompanyID = 1
 	}
-	defer rows.Close()
 
-	var payments []Payment
-	for rows.Next() {
-		var payment Payment
-		var paymentType PaymentType
-		var paymentTypeName, paymentTypeNameArabic, paymentTypeCode sql.NullString
+	if err := checkDocumentPeriod(exec, payment.CompanyID, payment.PaymentDate); err != nil {
+		return err
+	}
 
-		err := rows.Scan(
-			&payment.ID, &payment.InvoiceID, &payment.PaymentTypeID, &payment.Amount, &payment.PaymentDate,
-			&payment.Reference, &payment.Notes, &payment.NotesArabic, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt,
-			&paymentTypeName, &paymentTypeNameArabic, &paymentTypeCode,
-		)
-		if err != nil {
-			return nil, err
-		}
+	// A plain payment tenders exactly what it pays
+	if payment.TenderedAmount == 0 {
+		payment.TenderedAmount = payment.Amount
+	}
 
-		if paymentTypeName.Valid {
-			paymentType.ID = payment.PaymentTypeID
-			paymentType.Name = paymentTypeName.String
-			paymentType.NameArabic = paymentTypeNameArabic.String
-			paymentType.Code = paymentTypeCode.String
-			payment.PaymentType = &paymentType
-		}
+	now := time.Now()
+	result, err := exec.Exec(query, payment.CompanyID, payment.InvoiceID, payment.PaymentTypeID, payment.Amount, payment.TenderedAmount, payment.ChangeAmount,
+		payment.PaymentDate, payment.Reference, payment.Notes, payment.NotesArabic, payment.Status, now, now)
+	if err != nil {
+		return err
+	}
 
-		payments = append(payments, payment)
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
 	}
 
-	return payments, nil
+	payment.ID = int(id)
+	payment.CreatedAt = now
+	payment.UpdatedAt = now
+
+	return postDocument(exec, "payment", payment.ID)
+}
+
+// GetPayments retrieves all payments with optional filtering
+func (d *Database) GetPayments() ([]Payment, error) {
+	return d.queryPayments(`WHERE p.deleted_at IS NULL ORDER BY p.payment_date DESC`)
 }
 
 // GetPaymentsByInvoiceID retrieves all payments for a specific invoice
 func (d *Database) GetPaymentsByInvoiceID(invoiceID int) ([]Payment, error) {
+	return d.queryPayments(`WHERE p.invoice_id = ? AND p.deleted_at IS NULL ORDER BY p.payment_date DESC, p.id DESC`, invoiceID)
+}
+
+var paymentList = listSpec{
+	table:   "payments",
+	alias:   "p",
+	search:  []string{"p.reference", "p.notes", "p.notes_arabic"},
+	date:    "p.payment_date",
+	filters: map[string]string{"invoice_id": "p.invoice_id", "payment_type_id": "p.payment_type_id", "status": "p.status"},
+	sorts:   map[string]string{"payment_date": "p.payment_date", "amount": "p.amount"},
+	sort:    "payment_date",
+	desc:    true,
+}
+
+// ListPayments retrieves a page of the customer payments of a company, latest first by default. They can be
+// filtered by invoice_id, payment_type_id and status and sorted by payment_date or amount.
+func (d *Database) ListPayments(q ListQuery) (*PaymentPage, error) {
+	page := &PaymentPage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, paymentList, q, d.queryPayments, func(p Payment) int { return p.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Database) queryPayments(where string, args ...interface{}) ([]Payment, error) {
 	query := `
-		SELECT p.id, p.invoice_id, p.payment_type_id, p.amount, p.payment_date, p.reference, p.notes, p.notes_arabic, p.status, p.created_at, p.updated_at,
-			   pt.name as payment_type_name, pt.name_arabic as payment_type_name_arabic, pt.code as payment_type_code
+		SELECT p.id, p.invoice_id, p.payment_type_id, p.amount, CASE WHEN p.tendered_amount > 0 THEN p.tendered_amount ELSE p.amount END, COALESCE(p.change_amount, 0), p.payment_date, p.reference, p.notes, p.notes_arabic, p.status, p.created_at, p.updated_at, p.version,
+			   pt.name as payment_type_name, pt.name_arabic as payment_type_name_arabic, pt.code as payment_type_code,
+			   EXISTS(SELECT 1 FROM bank_statement_lines bsl WHERE bsl.payment_id = p.id AND bsl.status = 'reconciled')
 		FROM payments p
 		LEFT JOIN payment_types pt ON p.payment_type_id = pt.id
-		WHERE p.invoice_id = ?
-		ORDER BY p.payment_date DESC
-	`
+		` + where
 
-	rows, err := d.db.Query(query, invoiceID)
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -99,9 +122,9 @@ func (d *Database) GetPaymentsByInvoiceID(invoiceID int) ([]Payment, error) {
 		var paymentTypeName, paymentTypeNameArabic, paymentTypeCode sql.NullString
 
 		err := rows.Scan(
-			&payment.ID, &payment.InvoiceID, &payment.PaymentTypeID, &payment.Amount, &payment.PaymentDate,
-			&payment.Reference, &payment.Notes, &payment.NotesArabic, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt,
-			&paymentTypeName, &paymentTypeNameArabic, &paymentTypeCode,
+			&payment.ID, &payment.InvoiceID, &payment.PaymentTypeID, &payment.Amount, &payment.TenderedAmount, &payment.ChangeAmount, &payment.PaymentDate,
+			&payment.Reference, &payment.Notes, &payment.NotesArabic, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt, &payment.Version,
+			&paymentTypeName, &paymentTypeNameArabic, &paymentTypeCode, &payment.Reconciled,
 		)
 		if err != nil {
 			return nil, err
@@ -124,8 +147,9 @@ func (d *Database) GetPaymentsByInvoiceID(invoiceID int) ([]Payment, error) {
 // GetPaymentByID retrieves a payment by its ID
 func (d *Database) GetPaymentByID(id int) (Payment, error) {
 	query := `
-		SELECT p.id, p.invoice_id, p.payment_type_id, p.amount, p.payment_date, p.reference, p.notes, p.notes_arabic, p.status, p.created_at, p.updated_at,
-			   pt.name as payment_type_name, pt.name_arabic as payment_type_name_arabic, pt.code as payment_type_code
+		SELECT p.id, p.invoice_id, p.payment_type_id, p.amount, CASE WHEN p.tendered_amount > 0 THEN p.tendered_amount ELSE p.amount END, COALESCE(p.change_amount, 0), p.payment_date, p.reference, p.notes, p.notes_arabic, p.status, p.created_at, p.updated_at, p.version,
+			   pt.name as payment_type_name, pt.name_arabic as payment_type_name_arabic, pt.code as payment_type_code,
+			   EXISTS(SELECT 1 FROM bank_statement_lines bsl WHERE bsl.payment_id = p.id AND bsl.status = 'reconciled')
 		FROM payments p
 		LEFT JOIN payment_types pt ON p.payment_type_id = pt.id
 		WHERE p.id = ?
@@ -136,9 +160,9 @@ func (d *Database) GetPaymentByID(id int) (Payment, error) {
 	var paymentTypeName, paymentTypeNameArabic, paymentTypeCode sql.NullString
 
 	err := d.db.QueryRow(query, id).Scan(
-		&payment.ID, &payment.InvoiceID, &payment.PaymentTypeID, &payment.Amount, &payment.PaymentDate,
-		&payment.Reference, &payment.Notes, &payment.NotesArabic, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt,
-		&paymentTypeName, &paymentTypeNameArabic, &paymentTypeCode,
+		&payment.ID, &payment.InvoiceID, &payment.PaymentTypeID, &payment.Amount, &payment.TenderedAmount, &payment.ChangeAmount, &payment.PaymentDate,
+		&payment.Reference, &payment.Notes, &payment.NotesArabic, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt, &payment.Version,
+		&paymentTypeName, &paymentTypeNameArabic, &paymentTypeCode, &payment.Reconciled,
 	)
 	if err != nil {
 		return Payment{}, err
@@ -156,20 +180,66 @@ func (d *Database) GetPaymentByID(id int) (Payment, error) {
 }
 
 // UpdatePayment updates an existing payment
-func (d *Database) UpdatePayment(payment Payment) error {
+func (d *Database) UpdatePayment(payment *Payment) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkStoredDocumentPeriod(tx, "payments", "payment_date", payment.ID, payment.PaymentDate); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE payments 
 		SET payment_type_id = ?, amount = ?, payment_date = ?, reference = ?, notes = ?, notes_arabic = ?, status = ?, updated_at = ?
-		WHERE id = ?
-	`
+		WHERE id = ? AND ` + versionCondition
 
-	_, err := d.db.Exec(query, payment.PaymentTypeID, payment.Amount, payment.PaymentDate, payment.Reference, payment.Notes, payment.NotesArabic, payment.Status, time.Now(), payment.ID)
-	return err
+	result, err := tx.Exec(query, payment.PaymentTypeID, payment.Amount, payment.PaymentDate, payment.Reference, payment.Notes, payment.NotesArabic, payment.Status, time.Now(), payment.ID, payment.Version)
+	if err != nil {
+		return err
+	}
+	version, err := checkVersion(tx, "payments", payment.ID, payment.Version, result,
+		func() (interface{}, error) { return d.GetPaymentByID(payment.ID) })
+	if err != nil {
+		return err
+	}
+	if err = postDocument(tx, "payment", payment.ID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	payment.Version = version
+	return nil
 }
 
-// DeletePayment deletes a payment by its ID
-func (d *Database) DeletePayment(id int) error {
-	query := `DELETE FROM payments WHERE id = ?`
-	_, err := d.db.Exec(query, id)
-	return err
+// DeletePayment moves a payment to the recycle bin, reversing its posting and any customer credit it created
+func (d *Database) DeletePayment(id int, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkStoredDocumentPeriod(tx, "payments", "payment_date", id); err != nil {
+		return err
+	}
+	if err = refuseReconciledPayment(tx, "payment_id", id); err != nil {
+		return err
+	}
+	if err = reverseCreditTransactions(tx, "payment_id = ?", id); err != nil {
+		return err
+	}
+
+	if err = softDelete(tx, "payments", id, userID); err != nil {
+		return err
+	}
+	if err = postDocument(tx, "payment", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
\ No newline at end of file
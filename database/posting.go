@@ -0,0 +1,769 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// journalSources builds the journal entry a document should currently have in the general ledger.
+// A builder returns nil when the document does not post, e.g. a draft or a deleted document.
+var journalSources = map[string]func(exec execer, id int) (*JournalEntry, error){
+	"sales_invoice":    salesInvoiceJournal,
+	"sales_cost":       salesCostJournal,
+	"payment":          paymentJournal,
+	"credit_note":      creditNoteJournal,
+	"purchase_invoice": purchaseInvoiceJournal,
+	"stock_receipt":    stockReceiptJournal,
+	"supplier_payment": supplierPaymentJournal,
+	"customer_credit":  customerCreditJournal,
+	"expense":          expenseJournal,
+}
+
+// journalSourceBranches select the branch a document's journal entry is reported under
+var journalSourceBranches = map[string]string{
+	"sales_invoice":    `SELECT branch_id FROM sales_invoices WHERE id = ?`,
+	"sales_cost":       `SELECT branch_id FROM sales_invoices WHERE id = ?`,
+	"payment":          `SELECT si.branch_id FROM payments p JOIN sales_invoices si ON p.invoice_id = si.id WHERE p.id = ?`,
+	"credit_note":      `SELECT si.branch_id FROM credit_notes cn JOIN sales_invoices si ON cn.invoice_id = si.id WHERE cn.id = ?`,
+	"purchase_invoice": `SELECT branch_id FROM purchase_invoices WHERE id = ?`,
+	"stock_receipt":    `SELECT branch_id FROM purchase_invoices WHERE id = ?`,
+	"expense":          `SELECT branch_id FROM expenses WHERE id = ?`,
+	"customer_credit": `SELECT si.branch_id FROM customer_credit_transactions cct
+		JOIN sales_invoices si ON si.id = COALESCE(cct.invoice_id, cct.prepayment_invoice_id) WHERE cct.id = ?`,
+}
+
+// postDocument brings the general ledger in line with a document after it was created, changed or deleted.
+// Posted entries are never edited: a changed document gets its entry reversed and a new one posted.
+func postDocument(exec execer, sourceType string, sourceID int) error {
+	build, ok := journalSources[sourceType]
+	if !ok {
+		return fmt.Errorf("unknown journal source %q", sourceType)
+	}
+	desired, err := build(exec, sourceID)
+	if err != nil {
+		return fmt.Errorf("error posting %s %d: %v", sourceType, sourceID, err)
+	}
+	if query, ok := journalSourceBranches[sourceType]; ok && desired != nil {
+		var branchID sql.NullInt64
+		if err := exec.QueryRow(query, sourceID).Scan(&branchID); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("error posting %s %d: %v", sourceType, sourceID, err)
+		}
+		if branchID.Valid && branchID.Int64 > 0 {
+			id := int(branchID.Int64)
+			desired.BranchID = &id
+		}
+	}
+	if err := syncJournalEntry(exec, sourceType, sourceID, desired); err != nil {
+		return fmt.Errorf("error posting %s %d: %v", sourceType, sourceID, err)
+	}
+	return nil
+}
+
+// postDocuments posts every document returned by a query selecting ids
+func postDocuments(exec execer, sourceType, query string, args ...interface{}) error {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := postDocument(exec, sourceType, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postSalesInvoice posts a sales invoice and the cost of the stock it sold
+func postSalesInvoice(exec execer, invoiceID int) error {
+	if err := postDocument(exec, "sales_invoice", invoiceID); err != nil {
+		return err
+	}
+	if err := costSalesInvoice(exec, invoiceID); err != nil {
+		return err
+	}
+	return postDocument(exec, "sales_cost", invoiceID)
+}
+
+// postPurchaseInvoice posts a purchase invoice and the receipt of its stock
+func postPurchaseInvoice(exec execer, invoiceID int) error {
+	if err := syncStockCostLayers(exec, invoiceID); err != nil {
+		return err
+	}
+	if err := postDocument(exec, "purchase_invoice", invoiceID); err != nil {
+		return err
+	}
+	return postDocument(exec, "stock_receipt", invoiceID)
+}
+
+// syncJournalEntry replaces the active entry of a document when it differs from the desired one
+func syncJournalEntry(exec execer, sourceType string, sourceID int, desired *JournalEntry) error {
+	var activeID int
+	err := exec.QueryRow(`
+		SELECT id FROM journal_entries
+		WHERE source_type = ? AND source_id = ? AND reverses_id IS NULL AND reversed_by_id IS NULL
+		ORDER BY id DESC LIMIT 1`, sourceType, sourceID).Scan(&activeID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var active *JournalEntry
+	if activeID != 0 {
+		if active, err = getJournalEntry(exec, activeID); err != nil {
+			return err
+		}
+	}
+
+	if desired != nil && desired.EntryDate.IsZero() && active != nil {
+		desired.EntryDate = active.EntryDate
+	}
+	if active != nil && desired != nil && sameJournalEntry(active, desired) {
+		return nil
+	}
+
+	if active != nil {
+		// The reversal is dated with the original so that reports for that date reflect the document as it now is
+		if _, err := reverseJournalEntry(exec, active, active.EntryDate, nil); err != nil {
+			return err
+		}
+	}
+	if desired == nil {
+		return nil
+	}
+
+	desired.SourceType = sourceType
+	desired.SourceID = &sourceID
+	return insertJournalEntry(exec, desired)
+}
+
+// sameJournalEntry reports whether two entries post the same amounts to the same accounts on the same day and branch
+func sameJournalEntry(a, b *JournalEntry) bool {
+	if dateOnly(a.EntryDate) != dateOnly(b.EntryDate) || len(a.Lines) != len(b.Lines) {
+		return false
+	}
+	if (a.BranchID == nil) != (b.BranchID == nil) || (a.BranchID != nil && *a.BranchID != *b.BranchID) {
+		return false
+	}
+	key := func(l JournalLine) string {
+		return fmt.Sprintf("%d|%.2f|%.2f", l.AccountID, roundAmount(l.Debit), roundAmount(l.Credit))
+	}
+	counts := make(map[string]int)
+	for _, line := range a.Lines {
+		counts[key(line)]++
+	}
+	for _, line := range b.Lines {
+		counts[key(line)]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// entryBuilder collects postings per account; positive amounts are debits and negative amounts credits
+type entryBuilder struct {
+	order   []int
+	amounts map[int]float64
+}
+
+func (b *entryBuilder) post(accountID int, amount float64) {
+	if b.amounts == nil {
+		b.amounts = make(map[int]float64)
+	}
+	if _, ok := b.amounts[accountID]; !ok {
+		b.order = append(b.order, accountID)
+	}
+	b.amounts[accountID] += amount
+}
+
+func (b *entryBuilder) debit(accountID int, amount float64)  { b.post(accountID, amount) }
+func (b *entryBuilder) credit(accountID int, amount float64) { b.post(accountID, -amount) }
+
+// entry returns the journal entry, or nil when nothing is posted
+func (b *entryBuilder) entry(companyID int, date time.Time, description, descriptionArabic, reference string) *JournalEntry {
+	entry := &JournalEntry{
+		CompanyID:         companyID,
+		EntryDate:         date,
+		Description:       description,
+		DescriptionArabic: descriptionArabic,
+		Reference:         reference,
+	}
+	for _, accountID := range b.order {
+		amount := roundAmount(b.amounts[accountID])
+		switch {
+		case amount > 0:
+			entry.Lines = append(entry.Lines, JournalLine{AccountID: accountID, Debit: amount})
+		case amount < 0:
+			entry.Lines = append(entry.Lines, JournalLine{AccountID: accountID, Credit: -amount})
+		}
+	}
+	if len(entry.Lines) < 2 {
+		return nil
+	}
+	return entry
+}
+
+// vatPart is the VAT of a document at one rate
+type vatPart struct {
+	Rate   float64
+	Amount float64
+}
+
+// vatByRate splits the VAT of a document by the rates of its items. Any rounding difference against the
+// document's VAT total goes to the largest part so the entry balances.
+func vatByRate(exec execer, itemsQuery string, documentID int, totalVAT, fallbackRate float64) ([]vatPart, error) {
+	rows, err := exec.Query(itemsQuery, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []vatPart
+	var sum float64
+	for rows.Next() {
+		var part vatPart
+		if err := rows.Scan(&part.Rate, &part.Amount); err != nil {
+			return nil, err
+		}
+		part.Amount = roundAmount(part.Amount)
+		sum += part.Amount
+		parts = append(parts, part)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(parts) == 0 {
+		return []vatPart{{Rate: fallbackRate, Amount: roundAmount(totalVAT)}}, nil
+	}
+	if diff := roundAmount(totalVAT - sum); diff != 0 {
+		sort.SliceStable(parts, func(i, j int) bool { return math.Abs(parts[i].Amount) > math.Abs(parts[j].Amount) })
+		parts[0].Amount = roundAmount(parts[0].Amount + diff)
+	}
+	return parts, nil
+}
+
+func salesInvoiceJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID, salesCategoryID int
+	var number, status, invoiceType string
+	var issueDate time.Time
+	var vatAmount, totalAmount float64
+	err := exec.QueryRow(`
+		SELECT company_id, invoice_number, sales_category_id, issue_date, vat_amount, total_amount, COALESCE(status, 'draft'), COALESCE(invoice_type, 'standard')
+		FROM sales_invoices WHERE id = ? AND deleted_at IS NULL`, id).
+		Scan(&companyID, &number, &salesCategoryID, &issueDate, &vatAmount, &totalAmount, &status, &invoiceType)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == "draft" || status == "cancelled" {
+		return nil, nil
+	}
+
+	var b entryBuilder
+	receivable, err := resolveAccount(exec, companyID, "default", 0, AccountRoleReceivable)
+	if err != nil {
+		return nil, err
+	}
+	b.debit(receivable, totalAmount)
+
+	// Advances are a liability until the goods or services are delivered
+	incomeRole := AccountRoleRevenue
+	if invoiceType == "prepayment" {
+		incomeRole = AccountRoleCustomerAdvances
+	}
+	income, err := resolveAccount(exec, companyID, "sales_category", salesCategoryID, incomeRole)
+	if err != nil {
+		return nil, err
+	}
+	b.credit(income, totalAmount-vatAmount)
+
+	parts, err := vatByRate(exec, `SELECT vat_rate, SUM(vat_amount) FROM sales_invoice_items WHERE invoice_id = ? GROUP BY vat_rate ORDER BY vat_rate`, id, vatAmount, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		account, err := resolveTaxAccount(exec, companyID, part.Rate, AccountRoleOutputVAT)
+		if err != nil {
+			return nil, err
+		}
+		b.credit(account, part.Amount)
+	}
+
+	return b.entry(companyID, issueDate, "Sales invoice "+number, "فاتورة مبيعات "+number, number), nil
+}
+
+// salesCostJournal moves the cost stamped on the items of an issued sales invoice from inventory to the
+// cost of goods sold
+func salesCostJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID int
+	var number, status string
+	var issueDate time.Time
+	err := exec.QueryRow(`SELECT company_id, invoice_number, issue_date, COALESCE(status, 'draft') FROM sales_invoices WHERE id = ? AND deleted_at IS NULL`, id).
+		Scan(&companyID, &number, &issueDate, &status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == "draft" || status == "cancelled" {
+		return nil, nil
+	}
+
+	var cost float64
+	err = exec.QueryRow(`SELECT COALESCE(SUM(cost_amount), 0) FROM sales_invoice_items WHERE invoice_id = ?`, id).Scan(&cost)
+	if err != nil {
+		return nil, err
+	}
+
+	var b entryBuilder
+	cogs, err := resolveAccount(exec, companyID, "default", 0, AccountRoleCOGS)
+	if err != nil {
+		return nil, err
+	}
+	inventory, err := resolveAccount(exec, companyID, "default", 0, AccountRoleInventory)
+	if err != nil {
+		return nil, err
+	}
+	b.debit(cogs, cost)
+	b.credit(inventory, cost)
+
+	return b.entry(companyID, issueDate, "Cost of sales invoice "+number, "تكلفة فاتورة المبيعات "+number, number), nil
+}
+
+func paymentJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID, paymentTypeID int
+	var amount float64
+	var paymentDate time.Time
+	var status, invoiceNumber, reference string
+	err := exec.QueryRow(`
+		SELECT COALESCE(p.company_id, si.company_id, 1), p.payment_type_id, p.amount, p.payment_date, COALESCE(p.status, 'completed'),
+			COALESCE(si.invoice_number, ''), COALESCE(p.reference, '')
+		FROM payments p
+		LEFT JOIN sales_invoices si ON p.invoice_id = si.id
+		WHERE p.id = ? AND p.deleted_at IS NULL`, id).
+		Scan(&companyID, &paymentTypeID, &amount, &paymentDate, &status, &invoiceNumber, &reference)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "completed" {
+		return nil, nil
+	}
+
+	var b entryBuilder
+	account, err := resolvePaymentAccount(exec, companyID, paymentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	receivable, err := resolveAccount(exec, companyID, "default", 0, AccountRoleReceivable)
+	if err != nil {
+		return nil, err
+	}
+	b.debit(account, amount)
+	b.credit(receivable, amount)
+
+	if reference == "" {
+		reference = invoiceNumber
+	}
+	return b.entry(companyID, paymentDate, "Payment for invoice "+invoiceNumber, "دفعة للفاتورة "+invoiceNumber, reference), nil
+}
+
+func creditNoteJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID, salesCategoryID int
+	var number, status string
+	var issueDate time.Time
+	var vatAmount, totalAmount float64
+	err := exec.QueryRow(`
+		SELECT cn.company_id, cn.credit_note_number, COALESCE(si.sales_category_id, 0), cn.issue_date, cn.vat_amount, cn.total_amount, COALESCE(cn.status, 'issued')
+		FROM credit_notes cn
+		LEFT JOIN sales_invoices si ON cn.invoice_id = si.id
+		WHERE cn.id = ? AND cn.deleted_at IS NULL`, id).
+		Scan(&companyID, &number, &salesCategoryID, &issueDate, &vatAmount, &totalAmount, &status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "issued" {
+		return nil, nil
+	}
+
+	var b entryBuilder
+	revenue, err := resolveAccount(exec, companyID, "sales_category", salesCategoryID, AccountRoleRevenue)
+	if err != nil {
+		return nil, err
+	}
+	b.debit(revenue, totalAmount-vatAmount)
+
+	parts, err := vatByRate(exec, `SELECT vat_rate, SUM(vat_amount) FROM credit_note_items WHERE credit_note_id = ? GROUP BY vat_rate ORDER BY vat_rate`, id, vatAmount, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		account, err := resolveTaxAccount(exec, companyID, part.Rate, AccountRoleOutputVAT)
+		if err != nil {
+			return nil, err
+		}
+		b.debit(account, part.Amount)
+	}
+
+	receivable, err := resolveAccount(exec, companyID, "default", 0, AccountRoleReceivable)
+	if err != nil {
+		return nil, err
+	}
+	b.credit(receivable, totalAmount)
+
+	return b.entry(companyID, issueDate, "Credit note "+number, "إشعار دائن "+number, number), nil
+}
+
+func purchaseInvoiceJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID int
+	var number, status string
+	var issueDate time.Time
+	var vatAmount, vatRate, totalAmount float64
+	err := exec.QueryRow(`
+		SELECT company_id, invoice_number, issue_date, vat_amount, COALESCE(vat_rate, 15), total_amount, COALESCE(status, 'draft')
+		FROM purchase_invoices WHERE id = ? AND deleted_at IS NULL`, id).
+		Scan(&companyID, &number, &issueDate, &vatAmount, &vatRate, &totalAmount, &status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == "draft" || status == "cancelled" {
+		return nil, nil
+	}
+
+	var b entryBuilder
+	purchases, err := resolveAccount(exec, companyID, "default", 0, AccountRolePurchases)
+	if err != nil {
+		return nil, err
+	}
+	b.debit(purchases, totalAmount-vatAmount)
+
+	parts, err := vatByRate(exec, `SELECT vat_rate, SUM(vat_amount) FROM purchase_invoice_items WHERE invoice_id = ? GROUP BY vat_rate ORDER BY vat_rate`, id, vatAmount, vatRate)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		account, err := resolveTaxAccount(exec, companyID, part.Rate, AccountRoleInputVAT)
+		if err != nil {
+			return nil, err
+		}
+		b.debit(account, part.Amount)
+	}
+
+	payable, err := resolveAccount(exec, companyID, "default", 0, AccountRolePayable)
+	if err != nil {
+		return nil, err
+	}
+	b.credit(payable, totalAmount)
+
+	return b.entry(companyID, issueDate, "Purchase invoice "+number, "فاتورة مشتريات "+number, number), nil
+}
+
+// stockReceiptJournal moves the cost of stocked products received on a purchase invoice from purchases
+// into inventory. The entry keeps the date it was first posted on.
+func stockReceiptJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID int
+	var number, status string
+	var receivedAt time.Time
+	err := exec.QueryRow(`SELECT company_id, invoice_number, COALESCE(status, 'draft'), updated_at FROM purchase_invoices WHERE id = ? AND deleted_at IS NULL`, id).
+		Scan(&companyID, &number, &status, &receivedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "received" && status != "partially_paid" && status != "paid" {
+		return nil, nil
+	}
+
+	var cost float64
+	err = exec.QueryRow(`SELECT COALESCE(SUM(total_amount - vat_amount), 0) FROM purchase_invoice_items WHERE invoice_id = ? AND product_id > 0`, id).Scan(&cost)
+	if err != nil {
+		return nil, err
+	}
+
+	var b entryBuilder
+	inventory, err := resolveAccount(exec, companyID, "default", 0, AccountRoleInventory)
+	if err != nil {
+		return nil, err
+	}
+	purchases, err := resolveAccount(exec, companyID, "default", 0, AccountRolePurchases)
+	if err != nil {
+		return nil, err
+	}
+	b.debit(inventory, cost)
+	b.credit(purchases, cost)
+
+	entry := b.entry(companyID, time.Time{}, "Stock received on purchase invoice "+number, "استلام مخزون فاتورة المشتريات "+number, number)
+	if entry != nil && !hasActiveJournalEntry(exec, "stock_receipt", id) {
+		entry.EntryDate = receivedAt
+	}
+	return entry, nil
+}
+
+// hasActiveJournalEntry reports whether a document currently has an entry in the ledger
+func hasActiveJournalEntry(exec execer, sourceType string, sourceID int) bool {
+	var count int
+	exec.QueryRow(`SELECT COUNT(*) FROM journal_entries WHERE source_type = ? AND source_id = ? AND reverses_id IS NULL AND reversed_by_id IS NULL`,
+		sourceType, sourceID).Scan(&count)
+	return count > 0
+}
+
+func supplierPaymentJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID, paymentTypeID int
+	var number, status, reference string
+	var amount float64
+	var paymentDate time.Time
+	err := exec.QueryRow(`
+		SELECT company_id, payment_number, payment_type_id, amount, payment_date, COALESCE(status, 'completed'), COALESCE(reference, '')
+		FROM supplier_payments WHERE id = ? AND deleted_at IS NULL`, id).
+		Scan(&companyID, &number, &paymentTypeID, &amount, &paymentDate, &status, &reference)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "completed" {
+		return nil, nil
+	}
+
+	var b entryBuilder
+	payable, err := resolveAccount(exec, companyID, "default", 0, AccountRolePayable)
+	if err != nil {
+		return nil, err
+	}
+	account, err := resolvePaymentAccount(exec, companyID, paymentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	b.debit(payable, amount)
+	b.credit(account, amount)
+
+	if reference == "" {
+		reference = number
+	}
+	return b.entry(companyID, paymentDate, "Supplier payment "+number, "دفعة للمورد "+number, reference), nil
+}
+
+// expenseJournal posts an expense to the account of its category, claiming its VAT as input VAT when it is
+// recoverable and adding it to the expense otherwise
+func expenseJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID, categoryID, paymentTypeID int
+	var number, status, reference string
+	var expenseDate time.Time
+	var amount, vatRate, vatAmount, totalAmount float64
+	var vatRecoverable bool
+	err := exec.QueryRow(`
+		SELECT company_id, expense_number, category_id, payment_type_id, expense_date, amount, vat_rate, vat_amount, total_amount, vat_recoverable,
+			status, COALESCE(reference, '')
+		FROM expenses WHERE id = ? AND deleted_at IS NULL`, id).
+		Scan(&companyID, &number, &categoryID, &paymentTypeID, &expenseDate, &amount, &vatRate, &vatAmount, &totalAmount, &vatRecoverable,
+			&status, &reference)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "recorded" {
+		return nil, nil
+	}
+
+	var b entryBuilder
+	expenses, err := resolveAccount(exec, companyID, "expense_category", categoryID, AccountRoleExpenses)
+	if err != nil {
+		return nil, err
+	}
+	if vatRecoverable {
+		b.debit(expenses, amount)
+		inputVAT, err := resolveTaxAccount(exec, companyID, vatRate, AccountRoleInputVAT)
+		if err != nil {
+			return nil, err
+		}
+		b.debit(inputVAT, vatAmount)
+	} else {
+		b.debit(expenses, totalAmount)
+	}
+
+	account, err := resolvePaymentAccount(exec, companyID, paymentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	b.credit(account, totalAmount)
+
+	if reference == "" {
+		reference = number
+	}
+	return b.entry(companyID, expenseDate, "Expense "+number, "مصروف "+number, reference), nil
+}
+
+// customerCreditJournal posts the uses of customer credit that move money between accounts. Advances,
+// overpayments and credit notes are posted by their own documents, and applying an overpayment or credit
+// note only moves the credit between invoices of the same receivable account.
+func customerCreditJournal(exec execer, id int) (*JournalEntry, error) {
+	var companyID int
+	var creditType, reference string
+	var amount float64
+	var date time.Time
+	var paymentTypeID, prepaymentInvoiceID *int
+	err := exec.QueryRow(`
+		SELECT company_id, type, amount, transaction_date, payment_type_id, prepayment_invoice_id, COALESCE(reference, '')
+		FROM customer_credit_transactions WHERE id = ?`, id).
+		Scan(&companyID, &creditType, &amount, &date, &paymentTypeID, &prepaymentInvoiceID, &reference)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if creditType != "applied" && creditType != "refund" {
+		return nil, nil
+	}
+	if creditType == "applied" && prepaymentInvoiceID == nil {
+		return nil, nil
+	}
+	amount = -amount
+
+	var b entryBuilder
+	receivable, err := resolveAccount(exec, companyID, "default", 0, AccountRoleReceivable)
+	if err != nil {
+		return nil, err
+	}
+
+	// Credit drawn from an advance releases the advance and the VAT charged on its prepayment invoice
+	if prepaymentInvoiceID != nil {
+		var salesCategoryID int
+		var total, vat, rate float64
+		err := exec.QueryRow(`
+			SELECT si.sales_category_id, si.total_amount, si.vat_amount, COALESCE((SELECT vat_rate FROM sales_invoice_items WHERE invoice_id = si.id LIMIT 1), -1)
+			FROM sales_invoices si WHERE si.id = ?`, *prepaymentInvoiceID).Scan(&salesCategoryID, &total, &vat, &rate)
+		if err != nil {
+			return nil, err
+		}
+		var vatPart float64
+		if total != 0 {
+			vatPart = roundAmount(amount * vat / total)
+		}
+
+		advances, err := resolveAccount(exec, companyID, "sales_category", salesCategoryID, AccountRoleCustomerAdvances)
+		if err != nil {
+			return nil, err
+		}
+		outputVAT, err := resolveTaxAccount(exec, companyID, rate, AccountRoleOutputVAT)
+		if err != nil {
+			return nil, err
+		}
+		b.debit(advances, amount-vatPart)
+		b.debit(outputVAT, vatPart)
+	} else {
+		b.debit(receivable, amount)
+	}
+
+	if creditType == "applied" {
+		b.credit(receivable, amount)
+		return b.entry(companyID, date, "Advance deducted", "خصم دفعة مقدمة", reference), nil
+	}
+
+	paymentType := 0
+	if paymentTypeID != nil {
+		paymentType = *paymentTypeID
+	}
+	account, err := resolvePaymentAccount(exec, companyID, paymentType)
+	if err != nil {
+		return nil, err
+	}
+	b.credit(account, amount)
+	return b.entry(companyID, date, "Customer credit refund", "رد رصيد دائن للعميل", reference), nil
+}
+
+// SyncGeneralLedger posts every document of a company that is missing from the general ledger or changed
+// since it was posted, and reverses the entries of documents that no longer exist. It is used to build the
+// ledger from data recorded before the ledger existed.
+func (d *Database) SyncGeneralLedger(companyID int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := ensureChartOfAccounts(tx, companyID); err != nil {
+		return err
+	}
+
+	sources := []struct{ sourceType, query string }{
+		{"sales_invoice", `SELECT id FROM sales_invoices WHERE company_id = ? ORDER BY id`},
+		{"sales_cost", `SELECT id FROM sales_invoices WHERE company_id = ? ORDER BY id`},
+		{"payment", `SELECT p.id FROM payments p LEFT JOIN sales_invoices si ON p.invoice_id = si.id WHERE COALESCE(p.company_id, si.company_id, 1) = ? ORDER BY p.id`},
+		{"credit_note", `SELECT id FROM credit_notes WHERE company_id = ? ORDER BY id`},
+		{"purchase_invoice", `SELECT id FROM purchase_invoices WHERE company_id = ? ORDER BY id`},
+		{"stock_receipt", `SELECT id FROM purchase_invoices WHERE company_id = ? ORDER BY id`},
+		{"supplier_payment", `SELECT id FROM supplier_payments WHERE company_id = ? ORDER BY id`},
+		{"customer_credit", `SELECT id FROM customer_credit_transactions WHERE company_id = ? AND type IN ('applied', 'refund') ORDER BY id`},
+		{"expense", `SELECT id FROM expenses WHERE company_id = ? ORDER BY id`},
+	}
+	for _, source := range sources {
+		if err := postDocuments(tx, source.sourceType, source.query, companyID); err != nil {
+			return err
+		}
+	}
+
+	// Documents deleted since they were posted
+	rows, err := tx.Query(`
+		SELECT source_type, source_id FROM journal_entries
+		WHERE company_id = ? AND source_type NOT IN ('manual', 'year_end_close') AND source_id IS NOT NULL AND reverses_id IS NULL AND reversed_by_id IS NULL`, companyID)
+	if err != nil {
+		return err
+	}
+	type source struct {
+		sourceType string
+		id         int
+	}
+	var active []source
+	for rows.Next() {
+		var s source
+		if err := rows.Scan(&s.sourceType, &s.id); err != nil {
+			rows.Close()
+			return err
+		}
+		active = append(active, s)
+	}
+	rows.Close()
+	for _, s := range active {
+		if err := postDocument(tx, s.sourceType, s.id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -33,8 +33,9 @@ func (d *Database) CreateProductCategory(category *ProductCategory) error {
 // GetProductCategories retrieves all product categories
 func (d *Database) GetProductCategories() ([]ProductCategory, error) {
 	query := `
-		SELECT id, name, name_arabic, description, description_arabic, created_at, updated_at
+		SELECT id, name, name_arabic, description, description_arabic, created_at, updated_at, version
 		FROM product_categories
+		WHERE deleted_at IS NULL
 		ORDER BY name
 	`
 	
@@ -55,6 +56,7 @@ func (d *Database) GetProductCategories() ([]ProductCategory, error) {
 			&category.DescriptionArabic,
 			&category.CreatedAt,
 			&category.UpdatedAt,
+			&category.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -68,7 +70,7 @@ func (d *Database) GetProductCategories() ([]ProductCategory, error) {
 // GetProductCategoryByID retrieves a product category by ID
 func (d *Database) GetProductCategoryByID(id int) (*ProductCategory, error) {
 	query := `
-		SELECT id, name, name_arabic, description, description_arabic, created_at, updated_at
+		SELECT id, name, name_arabic, description, description_arabic, created_at, updated_at, version
 		FROM product_categories
 		WHERE id = ?
 	`
@@ -82,6 +84,7 @@ func (d *Database) GetProductCategoryByID(id int) (*ProductCategory, error) {
 		&category.DescriptionArabic,
 		&category.CreatedAt,
 		&category.UpdatedAt,
+		&category.Version,
 	)
 	
 	if err != nil {
@@ -99,11 +102,15 @@ func (d *Database) UpdateProductCategory(category *ProductCategory) error {
 	query := `
 		UPDATE product_categories
 		SET name = ?, name_arabic = ?, description = ?, description_arabic = ?, updated_at = ?
-		WHERE id = ?
-	`
+		WHERE id = ? AND ` + versionCondition
 	
 	now := time.Now()
-	_, err := d.db.Exec(query, category.Name, category.NameArabic, category.Description, category.DescriptionArabic, now, category.ID)
+	result, err := d.db.Exec(query, category.Name, category.NameArabic, category.Description, category.DescriptionArabic, now, category.ID, category.Version)
+	if err != nil {
+		return err
+	}
+	category.Version, err = checkVersion(d.db, "product_categories", category.ID, category.Version, result,
+		func() (interface{}, error) { return d.GetProductCategoryByID(category.ID) })
 	if err != nil {
 		return err
 	}
@@ -112,11 +119,11 @@ func (d *Database) UpdateProductCategory(category *ProductCategory) error {
 	return nil
 }
 
-// DeleteProductCategory deletes a product category
-func (d *Database) DeleteProductCategory(id int) error {
+// DeleteProductCategory moves a product category to the recycle bin
+func (d *Database) DeleteProductCategory(id int, userID *int) error {
 	// First check if there are any products using this category
 	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM products WHERE category_id = ?", id).Scan(&count)
+	err := d.db.QueryRow("SELECT COUNT(*) FROM products WHERE category_id = ? AND deleted_at IS NULL", id).Scan(&count)
 	if err != nil {
 		return err
 	}
@@ -125,9 +132,7 @@ func (d *Database) DeleteProductCategory(id int) error {
 		return sql.ErrConnDone // Return an error indicating category is in use
 	}
 	
-	query := "DELETE FROM product_categories WHERE id = ?"
-	_, err = d.db.Exec(query, id)
-	return err
+	return softDelete(d.db, "product_categories", id, userID)
 }
 
 // GetProductCategoriesWithProductCount retrieves all product categories with their product counts
@@ -135,11 +140,12 @@ func (d *Database) GetProductCategoriesWithProductCount() ([]ProductCategoryWith
 	query := `
 		SELECT 
 			pc.id, pc.name, pc.name_arabic, pc.description, pc.description_arabic, 
-			pc.created_at, pc.updated_at,
+			pc.created_at, pc.updated_at, pc.version,
 			COALESCE(COUNT(p.id), 0) as product_count
 		FROM product_categories pc
-		LEFT JOIN products p ON pc.id = p.category_id
-		GROUP BY pc.id, pc.name, pc.name_arabic, pc.description, pc.description_arabic, pc.created_at, pc.updated_at
+		LEFT JOIN products p ON pc.id = p.category_id AND p.deleted_at IS NULL
+		WHERE pc.deleted_at IS NULL
+		GROUP BY pc.id, pc.name, pc.name_arabic, pc.description, pc.description_arabic, pc.created_at, pc.updated_at, pc.version
 		ORDER BY pc.name
 	`
 	
@@ -160,6 +166,7 @@ func (d *Database) GetProductCategoriesWithProductCount() ([]ProductCategoryWith
 			&category.DescriptionArabic,
 			&category.CreatedAt,
 			&category.UpdatedAt,
+			&category.Version,
 			&category.ProductCount,
 		)
 		if err != nil {
@@ -26,27 +26,58 @@ func (d *Database) UpdateProduct(product *Product) error {
 		UPDATE products SET name = ?, name_arabic = ?, description = ?, description_arabic = ?, 
 		category_id = ?, unit_price = ?, vat_rate = ?, unit = ?, unit_arabic = ?, 
 		sku = ?, barcode = ?, stock = ?, min_stock = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`
+		WHERE id = ? AND ` + versionCondition
 
-	_, err := d.db.Exec(query, product.Name, product.NameArabic, product.Description, product.DescriptionArabic,
+	result, err := d.db.Exec(query, product.Name, product.NameArabic, product.Description, product.DescriptionArabic,
 		product.CategoryID, product.UnitPrice, product.VATRate, product.Unit, product.UnitArabic,
-		product.SKU, product.Barcode, product.Stock, product.MinStock, product.IsActive, product.ID)
+		product.SKU, product.Barcode, product.Stock, product.MinStock, product.IsActive, product.ID, product.Version)
+	if err != nil {
+		return err
+	}
+	product.Version, err = checkVersion(d.db, "products", product.ID, product.Version, result,
+		func() (interface{}, error) { return d.GetProductByID(product.ID) })
 	return err
 }
 
 func (d *Database) GetProducts() ([]Product, error) {
+	return d.queryProducts(`WHERE p.deleted_at IS NULL ORDER BY p.name`)
+}
+
+var productList = listSpec{
+	table:  "products",
+	alias:  "p",
+	entity: "product",
+	filters: map[string]string{"category_id": "p.category_id", "is_active": "p.is_active", "sku": "p.sku",
+		"barcode": "p.barcode"},
+	sorts: map[string]string{"name": "p.name", "unit_price": "p.unit_price", "id": "p.id"},
+	sort:  "name",
+}
+
+// ListProducts retrieves a page of the products of a company. They can be filtered by category_id,
+// is_active, sku and barcode and sorted by name, unit_price or id, the order they were added in.
+func (d *Database) ListProducts(q ListQuery) (*ProductPage, error) {
+	page := &ProductPage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, productList, q, d.queryProducts, func(p Product) int { return p.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Database) queryProducts(where string, args ...interface{}) ([]Product, error) {
 	query := `
 		SELECT 
 			p.id, p.name, p.name_arabic, p.description, p.description_arabic, 
 			p.category_id, COALESCE(pc.name, '') as category_name,
 			p.unit_price, p.vat_rate, p.unit, p.unit_arabic, 
 			p.sku, p.barcode, p.stock, p.min_stock, p.is_active, 
-			p.created_at, p.updated_at
+			p.created_at, p.updated_at, p.version
 		FROM products p
 		LEFT JOIN product_categories pc ON p.category_id = pc.id
-		ORDER BY p.name`
+		` + where
 
-	rows, err := d.db.Query(query)
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +88,7 @@ func (d *Database) GetProducts() ([]Product, error) {
 		var product Product
 		err := rows.Scan(&product.ID, &product.Name, &product.NameArabic, &product.Description, &product.DescriptionArabic,
 			&product.CategoryID, &product.CategoryName, &product.UnitPrice, &product.VATRate, &product.Unit, &product.UnitArabic,
-			&product.SKU, &product.Barcode, &product.Stock, &product.MinStock, &product.IsActive, &product.CreatedAt, &product.UpdatedAt)
+			&product.SKU, &product.Barcode, &product.Stock, &product.MinStock, &product.IsActive, &product.CreatedAt, &product.UpdatedAt, &product.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -79,7 +110,7 @@ func (d *Database) GetProductByID(id int) (*Product, error) {
 			p.category_id, COALESCE(pc.name, '') as category_name,
 			p.unit_price, p.vat_rate, p.unit, p.unit_arabic, 
 			p.sku, p.barcode, p.stock, p.min_stock, p.is_active, 
-			p.created_at, p.updated_at
+			p.created_at, p.updated_at, p.version
 		FROM products p
 		LEFT JOIN product_categories pc ON p.category_id = pc.id
 		WHERE p.id = ?`
@@ -87,7 +118,7 @@ func (d *Database) GetProductByID(id int) (*Product, error) {
 	var product Product
 	err := d.db.QueryRow(query, id).Scan(&product.ID, &product.Name, &product.NameArabic, &product.Description, &product.DescriptionArabic,
 		&product.CategoryID, &product.CategoryName, &product.UnitPrice, &product.VATRate, &product.Unit, &product.UnitArabic,
-		&product.SKU, &product.Barcode, &product.Stock, &product.MinStock, &product.IsActive, &product.CreatedAt, &product.UpdatedAt)
+		&product.SKU, &product.Barcode, &product.Stock, &product.MinStock, &product.IsActive, &product.CreatedAt, &product.UpdatedAt, &product.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +131,7 @@ func (d *Database) GetProductByID(id int) (*Product, error) {
 	return &product, nil
 }
 
-func (d *Database) DeleteProduct(id int) error {
-	query := `DELETE FROM products WHERE id = ?`
-	_, err := d.db.Exec(query, id)
-	return err
+// DeleteProduct moves a product to the recycle bin. Invoice items keep referring to it.
+func (d *Database) DeleteProduct(id int, userID *int) error {
+	return softDelete(d.db, "products", id, userID)
 }
\ No newline at end of file
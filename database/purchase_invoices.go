@@ -13,17 +13,29 @@ func (d *Database) CreatePurchaseInvoice(invoice *PurchaseInvoice) error {
 	}
 	defer tx.Rollback()
 
+	// Insert purchase invoice
+	query := `
+		INSERT INTO purchase_invoices (company_id, branch_id, invoice_number, supplier_id, issue_date, due_date, sub_total, vat_amount, vat_rate, vat_inclusive, total_amount, status, notes, notes_arabic, created_by, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// Default to company 1 for backward compatibility
+	if invoice.CompanyID == 0 {
+		invoice.CompanyID = 1
+	}
+
 	// Generate invoice number if not provided
 	if invoice.InvoiceNumber == "" {
-		invoice.InvoiceNumber = d.generatePurchaseInvoiceNumber()
+		invoice.InvoiceNumber, err = nextDocumentNumber(tx, "purchase_invoices", "invoice_number", "PI-", invoice.CompanyID)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Insert purchase invoice
-	query := `
-		INSERT INTO purchase_invoices (invoice_number, supplier_id, issue_date, due_date, sub_total, vat_amount, vat_rate, vat_inclusive, total_amount, status, notes, notes_arabic, created_by, updated_by)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if err = checkDocumentPeriod(tx, invoice.CompanyID, invoice.IssueDate.Time); err != nil {
+		return err
+	}
 
-	result, err := tx.Exec(query, invoice.InvoiceNumber, invoice.SupplierID, invoice.IssueDate.Time, invoice.DueDate.Time,
+	result, err := tx.Exec(query, invoice.CompanyID, invoice.BranchID, invoice.InvoiceNumber, invoice.SupplierID, invoice.IssueDate.Time, invoice.DueDate.Time,
 		invoice.SubTotal, invoice.VATAmount, invoice.VATRate, invoice.VATInclusive, invoice.TotalAmount, invoice.Status, invoice.Notes, invoice.NotesArabic, invoice.CreatedBy, invoice.CreatedBy)
 	if err != nil {
 		return err
@@ -38,30 +50,63 @@ func (d *Database) CreatePurchaseInvoice(invoice *PurchaseInvoice) error {
 	// Insert purchase invoice items
 	for _, item := range invoice.Items {
 		itemQuery := `
-			INSERT INTO purchase_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
-			VALUES (?, ?, ?, ?, ?, ?, ?)`
+			INSERT INTO purchase_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, vat_category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-		_, execErr := tx.Exec(itemQuery, invoiceID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount)
+		_, execErr := tx.Exec(itemQuery, invoiceID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount, item.VATCategory)
 		if execErr != nil {
 			return execErr
 		}
 	}
 
+	if err = postPurchaseInvoice(tx, invoice.ID); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
 func (d *Database) GetPurchaseInvoices() ([]PurchaseInvoice, error) {
+	return d.queryPurchaseInvoices(`WHERE pi.deleted_at IS NULL ORDER BY pi.created_at DESC`)
+}
+
+var purchaseInvoiceList = listSpec{
+	table:   "purchase_invoices",
+	alias:   "pi",
+	entity:  "purchase_invoice",
+	date:    "pi.issue_date",
+	filters: map[string]string{"status": "pi.status", "supplier_id": "pi.supplier_id", "branch_id": "pi.branch_id"},
+	sorts: map[string]string{"issue_date": "pi.issue_date", "invoice_number": "pi.invoice_number",
+		"total_amount": "pi.total_amount"},
+	sort: "issue_date",
+	desc: true,
+}
+
+// ListPurchaseInvoices retrieves a page of the purchase invoices of a company, latest first by default.
+// They can be filtered by status, supplier_id and branch_id and sorted by issue_date, invoice_number or
+// total_amount.
+func (d *Database) ListPurchaseInvoices(q ListQuery) (*PurchaseInvoicePage, error) {
+	page := &PurchaseInvoicePage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, purchaseInvoiceList, q, d.queryPurchaseInvoices, func(inv PurchaseInvoice) int { return inv.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Database) queryPurchaseInvoices(where string, args ...interface{}) ([]PurchaseInvoice, error) {
 	query := `
 		SELECT 
-			pi.id, pi.invoice_number, pi.supplier_id, pi.issue_date, pi.due_date, 
-			pi.sub_total, pi.vat_amount, pi.vat_rate, pi.vat_inclusive, pi.total_amount, pi.status, pi.notes, pi.notes_arabic, 
-			pi.created_at, pi.updated_at, pi.created_by, pi.updated_by,
+			pi.id, pi.company_id, pi.branch_id, pi.invoice_number, pi.supplier_id, pi.issue_date, pi.due_date, 
+			pi.sub_total, pi.vat_amount, pi.vat_rate, pi.vat_inclusive, pi.total_amount, ` + purchaseInvoicePaidAmountSQL + `, pi.status, pi.notes, pi.notes_arabic, 
+			pi.created_at, pi.updated_at, pi.created_by, pi.updated_by, pi.version,
 			s.id, s.company_name, s.contact_person, s.email, s.phone, s.address, s.vat_number
 		FROM purchase_invoices pi
 		LEFT JOIN suppliers s ON pi.supplier_id = s.id
-		ORDER BY pi.created_at DESC`
+		` + where
 
-	rows, err := d.db.Query(query)
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -76,9 +121,9 @@ func (d *Database) GetPurchaseInvoices() ([]PurchaseInvoice, error) {
 		var vatAmount float64
 		
 		scanErr := rows.Scan(
-			&inv.ID, &inv.InvoiceNumber, &inv.SupplierID, 
-			&issueDate, &dueDate, &inv.SubTotal, &vatAmount, &inv.VATRate, &inv.VATInclusive, &inv.TotalAmount, 
-			&inv.Status, &inv.Notes, &inv.NotesArabic, &inv.CreatedAt, &inv.UpdatedAt, &inv.CreatedBy, &inv.UpdatedBy,
+			&inv.ID, &inv.CompanyID, &inv.BranchID, &inv.InvoiceNumber, &inv.SupplierID, 
+			&issueDate, &dueDate, &inv.SubTotal, &vatAmount, &inv.VATRate, &inv.VATInclusive, &inv.TotalAmount, &inv.PaidAmount, 
+			&inv.Status, &inv.Notes, &inv.NotesArabic, &inv.CreatedAt, &inv.UpdatedAt, &inv.CreatedBy, &inv.UpdatedBy, &inv.Version,
 			&supplierID, &supplier.CompanyName, &supplier.ContactPerson, &supplier.Email, &supplier.Phone, 
 			&supplier.Address, &supplier.VATNumber)
 		if scanErr != nil {
@@ -104,14 +149,14 @@ func (d *Database) GetPurchaseInvoices() ([]PurchaseInvoice, error) {
 }
 
 func (d *Database) GetPurchaseInvoiceByID(id int) (*PurchaseInvoice, error) {
-	query := `SELECT id, invoice_number, supplier_id, issue_date, due_date, sub_total, vat_amount, vat_rate, vat_inclusive, total_amount, status, notes, notes_arabic, created_at, updated_at, created_by, updated_by FROM purchase_invoices WHERE id = ?`
+	query := `SELECT pi.id, pi.company_id, pi.branch_id, pi.invoice_number, pi.supplier_id, pi.issue_date, pi.due_date, pi.sub_total, pi.vat_amount, pi.vat_rate, pi.vat_inclusive, pi.total_amount, ` + purchaseInvoicePaidAmountSQL + `, pi.status, pi.notes, pi.notes_arabic, pi.created_at, pi.updated_at, pi.created_by, pi.updated_by, pi.version FROM purchase_invoices pi WHERE pi.id = ?`
 
 	var inv PurchaseInvoice
 	var issueDate, dueDate time.Time
 	var vatAmount float64
-	err := d.db.QueryRow(query, id).Scan(&inv.ID, &inv.InvoiceNumber, &inv.SupplierID, &issueDate, &dueDate,
-		&inv.SubTotal, &vatAmount, &inv.VATRate, &inv.VATInclusive, &inv.TotalAmount, &inv.Status, &inv.Notes, &inv.NotesArabic,
-		&inv.CreatedAt, &inv.UpdatedAt, &inv.CreatedBy, &inv.UpdatedBy)
+	err := d.db.QueryRow(query, id).Scan(&inv.ID, &inv.CompanyID, &inv.BranchID, &inv.InvoiceNumber, &inv.SupplierID, &issueDate, &dueDate,
+		&inv.SubTotal, &vatAmount, &inv.VATRate, &inv.VATInclusive, &inv.TotalAmount, &inv.PaidAmount, &inv.Status, &inv.Notes, &inv.NotesArabic,
+		&inv.CreatedAt, &inv.UpdatedAt, &inv.CreatedBy, &inv.UpdatedBy, &inv.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -148,15 +193,24 @@ func (d *Database) UpdatePurchaseInvoice(invoice *PurchaseInvoice) error {
 	}
 	defer tx.Rollback()
 
+	if err = checkStoredDocumentPeriod(tx, "purchase_invoices", "issue_date", invoice.ID, invoice.IssueDate.Time); err != nil {
+		return err
+	}
+
 	// Update purchase invoice
 	query := `
 		UPDATE purchase_invoices 
-		SET invoice_number = ?, supplier_id = ?, issue_date = ?, due_date = ?, 
+		SET branch_id = ?, invoice_number = ?, supplier_id = ?, issue_date = ?, due_date = ?, 
 		    sub_total = ?, vat_amount = ?, vat_rate = ?, vat_inclusive = ?, total_amount = ?, status = ?, notes = ?, notes_arabic = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`
+		WHERE id = ? AND ` + versionCondition
 
-	_, err = tx.Exec(query, invoice.InvoiceNumber, invoice.SupplierID, invoice.IssueDate.Time, invoice.DueDate.Time,
-		invoice.SubTotal, invoice.VATAmount, invoice.VATRate, invoice.VATInclusive, invoice.TotalAmount, invoice.Status, invoice.Notes, invoice.NotesArabic, invoice.UpdatedBy, invoice.ID)
+	result, err := tx.Exec(query, invoice.BranchID, invoice.InvoiceNumber, invoice.SupplierID, invoice.IssueDate.Time, invoice.DueDate.Time,
+		invoice.SubTotal, invoice.VATAmount, invoice.VATRate, invoice.VATInclusive, invoice.TotalAmount, invoice.Status, invoice.Notes, invoice.NotesArabic, invoice.UpdatedBy, invoice.ID, invoice.Version)
+	if err != nil {
+		return err
+	}
+	version, err := checkVersion(tx, "purchase_invoices", invoice.ID, invoice.Version, result,
+		func() (interface{}, error) { return d.GetPurchaseInvoiceByID(invoice.ID) })
 	if err != nil {
 		return err
 	}
@@ -170,34 +224,72 @@ func (d *Database) UpdatePurchaseInvoice(invoice *PurchaseInvoice) error {
 	// Insert updated items
 	for _, item := range invoice.Items {
 		itemQuery := `
-			INSERT INTO purchase_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
-			VALUES (?, ?, ?, ?, ?, ?, ?)`
+			INSERT INTO purchase_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, vat_category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-		_, execErr := tx.Exec(itemQuery, invoice.ID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount)
+		_, execErr := tx.Exec(itemQuery, invoice.ID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount, item.VATCategory)
 		if execErr != nil {
 			return execErr
 		}
 	}
 
-	return tx.Commit()
+	// The total may have changed, so the paid status has to follow the allocations again
+	if err = refreshPurchaseInvoiceStatus(tx, invoice.ID); err != nil {
+		return err
+	}
+
+	if err = postPurchaseInvoice(tx, invoice.ID); err != nil {
+		return err
+	}
+
+	// A change of the paid status raises the version again
+	if version > 0 {
+		if err = tx.QueryRow("SELECT version FROM purchase_invoices WHERE id = ?", invoice.ID).Scan(&version); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	invoice.Version = version
+	return nil
 }
 
-func (d *Database) DeletePurchaseInvoice(id int) error {
+// DeletePurchaseInvoice moves a draft purchase invoice to the recycle bin. Received invoices must be
+// cancelled instead.
+func (d *Database) DeletePurchaseInvoice(id int, userID *int) error {
+	var status string
+	if err := d.db.QueryRow(`SELECT COALESCE(status, 'draft') FROM purchase_invoices WHERE id = ? AND deleted_at IS NULL`, id).Scan(&status); err != nil {
+		return err
+	}
+	if status != "draft" {
+		return fmt.Errorf("only draft purchase invoices can be deleted; cancel received invoices instead")
+	}
+
+	var allocations int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM supplier_payment_allocations spa JOIN supplier_payments sp ON spa.supplier_payment_id = sp.id
+		WHERE spa.purchase_invoice_id = ? AND sp.deleted_at IS NULL`, id).Scan(&allocations)
+	if err != nil {
+		return err
+	}
+	if allocations > 0 {
+		return fmt.Errorf("purchase invoice has supplier payments allocated to it; delete those payments first")
+	}
+
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Delete purchase invoice items first (due to foreign key constraint)
-	_, err = tx.Exec("DELETE FROM purchase_invoice_items WHERE invoice_id = ?", id)
-	if err != nil {
+	if err = checkStoredDocumentPeriod(tx, "purchase_invoices", "issue_date", id); err != nil {
+		return err
+	}
+	if err = softDelete(tx, "purchase_invoices", id, userID); err != nil {
 		return err
 	}
 
-	// Delete purchase invoice
-	_, err = tx.Exec("DELETE FROM purchase_invoices WHERE id = ?", id)
-	if err != nil {
+	if err = postPurchaseInvoice(tx, id); err != nil {
 		return err
 	}
 
@@ -205,7 +297,7 @@ func (d *Database) DeletePurchaseInvoice(id int) error {
 }
 
 func (d *Database) GetPurchaseInvoiceItems(invoiceID int) ([]PurchaseInvoiceItem, error) {
-	query := `SELECT id, invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, created_at FROM purchase_invoice_items WHERE invoice_id = ?`
+	query := `SELECT id, invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, COALESCE(vat_category, ''), created_at FROM purchase_invoice_items WHERE invoice_id = ?`
 
 	rows, err := d.db.Query(query, invoiceID)
 	if err != nil {
@@ -217,7 +309,7 @@ func (d *Database) GetPurchaseInvoiceItems(invoiceID int) ([]PurchaseInvoiceItem
 	for rows.Next() {
 		var item PurchaseInvoiceItem
 		scanErr := rows.Scan(&item.ID, &item.InvoiceID, &item.ProductID, &item.Quantity, &item.UnitPrice,
-			&item.VATRate, &item.VATAmount, &item.TotalAmount, &item.CreatedAt)
+			&item.VATRate, &item.VATAmount, &item.TotalAmount, &item.VATCategory, &item.CreatedAt)
 		if scanErr != nil {
 			return nil, scanErr
 		}
@@ -225,9 +317,3 @@ func (d *Database) GetPurchaseInvoiceItems(invoiceID int) ([]PurchaseInvoiceItem
 	}
 	return items, nil
 }
-
-func (d *Database) generatePurchaseInvoiceNumber() string {
-	var count int
-	d.db.QueryRow("SELECT COUNT(*) FROM purchase_invoices").Scan(&count)
-	return fmt.Sprintf("PI-%06d", count+1)
-}
\ No newline at end of file
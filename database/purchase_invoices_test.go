@@ -0,0 +1,56 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdatePurchaseInvoiceKeepsStatus(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	statements := []string{
+		`INSERT INTO suppliers (id, company_name, contact_person, company_id) VALUES (1, 'Supplier', 'Sami', 1)`,
+		`INSERT INTO purchase_invoices (id, company_id, invoice_number, supplier_id, issue_date, due_date, sub_total, vat_amount, total_amount, status, notes, notes_arabic)
+			VALUES (1, 1, 'PENDING', 1, '2024-01-02', '2024-02-01', 100, 0, 100, 'pending', '', ''),
+				(2, 1, 'APPROVED', 1, '2024-01-02', '2024-02-01', 100, 0, 100, 'approved', '', ''),
+				(3, 1, 'OVERDUE', 1, '2024-01-02', '2024-02-01', 100, 0, 100, 'overdue', '', ''),
+				(4, 1, 'RECEIVED', 1, '2024-01-02', '2024-02-01', 100, 0, 100, 'received', '', ''),
+				(5, 1, 'PAID', 1, '2024-01-02', '2024-02-01', 100, 0, 100, 'paid', '', '')`,
+		`INSERT INTO supplier_payments (id, company_id, payment_number, supplier_id, payment_type_id, amount, payment_date)
+			VALUES (1, 1, 'SP-000001', 1, 1, 150, '2024-01-05')`,
+		`INSERT INTO supplier_payment_allocations (supplier_payment_id, purchase_invoice_id, amount) VALUES (1, 3, 50), (1, 5, 100)`,
+	}
+	for _, statement := range statements {
+		if _, err := d.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only received invoices follow their payments; the total of each is raised so that none is paid in full
+	tests := []struct {
+		id   int
+		want string
+	}{
+		{1, "pending"},
+		{2, "approved"},
+		{3, "overdue"},
+		{4, "received"},
+		{5, "partially_paid"},
+	}
+	for _, test := range tests {
+		invoice, err := d.GetPurchaseInvoiceByID(test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		invoice.SubTotal, invoice.TotalAmount = 150, 150
+		if err := d.UpdatePurchaseInvoice(invoice); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := d.GetPurchaseInvoiceByID(test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != test.want {
+			t.Errorf("invoice %s is %s after an update, want %s", stored.InvoiceNumber, stored.Status, test.want)
+		}
+	}
+}
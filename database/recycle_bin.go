@@ -0,0 +1,252 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// recycledTable is a table whose deleted rows are kept and listed in the recycle bin
+type recycledTable struct {
+	entity     string
+	table      string
+	name       string // SQL for the name or number of a row of t
+	nameArabic string
+	amount     string // SQL for the total of a document, empty for master data
+	company    string // SQL for the company of a row, when company_id alone is not enough
+	dateColumn string // Date of a document, checked against closed periods when it is restored
+}
+
+var recycledTables = []recycledTable{
+	{entity: "customer", table: "customers", name: "t.name", nameArabic: "COALESCE(t.name_arabic, '')"},
+	{entity: "supplier", table: "suppliers", name: "t.company_name", nameArabic: "COALESCE(t.company_name_arabic, '')"},
+	{entity: "product", table: "products", name: "t.name", nameArabic: "COALESCE(t.name_arabic, '')"},
+	{entity: "product_category", table: "product_categories", name: "t.name", nameArabic: "COALESCE(t.name_arabic, '')"},
+	{entity: "sales_invoice", table: "sales_invoices", name: "t.invoice_number", amount: "t.total_amount", dateColumn: "issue_date"},
+	{entity: "purchase_invoice", table: "purchase_invoices", name: "t.invoice_number", amount: "t.total_amount", dateColumn: "issue_date"},
+	{entity: "credit_note", table: "credit_notes", name: "t.credit_note_number", amount: "t.total_amount", dateColumn: "issue_date"},
+	{entity: "payment", table: "payments", name: "COALESCE((SELECT invoice_number FROM sales_invoices WHERE id = t.invoice_id), '')",
+		amount: "t.amount", company: "COALESCE(t.company_id, (SELECT company_id FROM sales_invoices WHERE id = t.invoice_id), 1)",
+		dateColumn: "payment_date"},
+	{entity: "supplier_payment", table: "supplier_payments", name: "t.payment_number", amount: "t.amount", dateColumn: "payment_date"},
+	{entity: "expense", table: "expenses", name: "t.expense_number", nameArabic: "COALESCE(t.description_arabic, '')",
+		amount: "t.total_amount", dateColumn: "expense_date"},
+}
+
+func recycledTableOf(entityType string) (recycledTable, error) {
+	for _, table := range recycledTables {
+		if table.entity == entityType {
+			return table, nil
+		}
+	}
+	return recycledTable{}, fmt.Errorf("unknown record type %s", entityType)
+}
+
+// softDelete moves a row to the recycle bin
+func softDelete(exec execer, table string, id int, userID *int) error {
+	result, err := exec.Exec(fmt.Sprintf(`UPDATE %s SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ? AND deleted_at IS NULL`, table), userID, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRecycleBin retrieves the deleted records of a company, most recently deleted first
+func (d *Database) GetRecycleBin(companyID int) ([]RecycledRecord, error) {
+	records := []RecycledRecord{}
+	for _, table := range recycledTables {
+		nameArabic, amount, company := table.nameArabic, table.amount, table.company
+		if nameArabic == "" {
+			nameArabic = "''"
+		}
+		if amount == "" {
+			amount = "NULL"
+		}
+		if company == "" {
+			company = "COALESCE(t.company_id, 1)"
+		}
+		rows, err := d.db.Query(fmt.Sprintf(`
+			SELECT t.id, %s, %s, %s, t.deleted_at, t.deleted_by, COALESCE(u.first_name || ' ' || u.last_name, '')
+			FROM %s t
+			LEFT JOIN users u ON t.deleted_by = u.id
+			WHERE t.deleted_at IS NOT NULL AND %s = ?`, table.name, nameArabic, amount, table.table, company), companyID)
+		if err != nil {
+			return nil, fmt.Errorf("error reading deleted %s: %v", table.table, err)
+		}
+		for rows.Next() {
+			record := RecycledRecord{EntityType: table.entity}
+			var amount sql.NullFloat64
+			var deletedBy sql.NullInt64
+			if err := rows.Scan(&record.ID, &record.Name, &record.NameArabic, &amount, &record.DeletedAt, &deletedBy, &record.DeletedByName); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if amount.Valid {
+				record.Amount = &amount.Float64
+			}
+			if deletedBy.Valid {
+				id := int(deletedBy.Int64)
+				record.DeletedBy = &id
+			}
+			records = append(records, record)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].DeletedAt.After(records[j].DeletedAt) })
+	return records, nil
+}
+
+// RestoreRecord takes a deleted record out of the recycle bin. A restored document is posted again, so it
+// cannot be restored into a closed period.
+func (d *Database) RestoreRecord(entityType string, id int) error {
+	table, err := recycledTableOf(entityType)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deleted bool
+	err = tx.QueryRow(fmt.Sprintf(`SELECT deleted_at IS NOT NULL FROM %s WHERE id = ?`, table.table), id).Scan(&deleted)
+	if err == sql.ErrNoRows || (err == nil && !deleted) {
+		return fmt.Errorf("the %s is not in the recycle bin", entityType)
+	}
+	if err != nil {
+		return err
+	}
+	if table.dateColumn != "" {
+		if err = checkStoredDocumentPeriod(tx, table.table, table.dateColumn, id); err != nil {
+			return err
+		}
+	}
+
+	var restore func() error
+	switch entityType {
+	case "sales_invoice":
+		restore = func() error { return postSalesInvoice(tx, id) }
+	case "purchase_invoice":
+		restore = func() error { return postPurchaseInvoice(tx, id) }
+	case "credit_note", "expense":
+		restore = func() error { return postDocument(tx, entityType, id) }
+	case "payment":
+		restore, err = restorePayment(tx, id)
+	case "supplier_payment":
+		restore, err = restoreSupplierPayment(tx, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET deleted_at = NULL, deleted_by = NULL WHERE id = ?`, table.table), id); err != nil {
+		return err
+	}
+	if restore != nil {
+		if err = restore(); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// restorePayment checks that a deleted payment can be restored and returns what restoring it involves:
+// posting it and keeping any overpayment as customer credit again
+func restorePayment(tx *sql.Tx, id int) (func() error, error) {
+	var payment Payment
+	var invoiceDeleted bool
+	err := tx.QueryRow(`
+		SELECT COALESCE(p.company_id, si.company_id, 1), p.invoice_id, p.payment_type_id, p.amount, p.payment_date,
+			COALESCE(p.reference, ''), COALESCE(p.status, 'completed'), si.deleted_at IS NOT NULL
+		FROM payments p
+		JOIN sales_invoices si ON p.invoice_id = si.id
+		WHERE p.id = ?`, id).
+		Scan(&payment.CompanyID, &payment.InvoiceID, &payment.PaymentTypeID, &payment.Amount, &payment.PaymentDate,
+			&payment.Reference, &payment.Status, &invoiceDeleted)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("the invoice of the payment no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invoiceDeleted {
+		return nil, fmt.Errorf("the invoice of the payment is deleted; restore the invoice first")
+	}
+
+	var advances int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM customer_credit_transactions WHERE payment_id = ? AND type = 'advance'`, id).Scan(&advances); err != nil {
+		return nil, err
+	}
+	if advances > 0 {
+		return nil, fmt.Errorf("the payment of a customer advance cannot be restored; record the advance again")
+	}
+
+	payment.ID = id
+	return func() error {
+		if err := recordOverpayment(tx, &payment); err != nil {
+			return err
+		}
+		return postDocument(tx, "payment", id)
+	}, nil
+}
+
+// restoreSupplierPayment checks that a deleted supplier payment can be restored and returns what restoring
+// it involves: posting it and updating the invoices it pays. The invoices must not have been paid by other
+// payments in the meantime.
+func restoreSupplierPayment(tx *sql.Tx, id int) (func() error, error) {
+	rows, err := tx.Query(`
+		SELECT pi.id, pi.invoice_number, pi.status, pi.deleted_at IS NOT NULL, pi.total_amount - `+purchaseInvoicePaidAmountSQL+`, spa.amount
+		FROM supplier_payment_allocations spa
+		JOIN purchase_invoices pi ON spa.purchase_invoice_id = pi.id
+		WHERE spa.supplier_payment_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	var invoiceIDs []int
+	for rows.Next() {
+		var invoiceID int
+		var number, status string
+		var deleted bool
+		var outstanding, allocated float64
+		if err := rows.Scan(&invoiceID, &number, &status, &deleted, &outstanding, &allocated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if deleted || status == "draft" || status == "cancelled" {
+			rows.Close()
+			return nil, fmt.Errorf("purchase invoice %s paid by the payment is no longer open", number)
+		}
+		if allocated > outstanding+0.005 {
+			rows.Close()
+			return nil, fmt.Errorf("purchase invoice %s has been paid since; only %.2f of the %.2f allocated to it is outstanding", number, outstanding, allocated)
+		}
+		invoiceIDs = append(invoiceIDs, invoiceID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		if err := postDocument(tx, "supplier_payment", id); err != nil {
+			return err
+		}
+		for _, invoiceID := range invoiceIDs {
+			if err := refreshPurchaseInvoiceStatus(tx, invoiceID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
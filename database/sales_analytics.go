@@ -0,0 +1,345 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sales analytics groupings and breakdowns
+const (
+	GroupByDay   = "day"
+	GroupByWeek  = "week"
+	GroupByMonth = "month"
+
+	SalesByProduct         = "product"
+	SalesByProductCategory = "product_category"
+	SalesByCustomer        = "customer"
+	SalesByCategory        = "sales_category"
+	SalesByUser            = "user"
+	SalesByPaymentType     = "payment_type"
+)
+
+// salesLinesSQL selects the lines of the invoices and issued credit notes of a company in a period, credit
+// notes negative, with the dimensions they are analysed by. It takes the company, from and to dates four times.
+const salesLinesSQL = `
+	SELECT 'sales_invoice' AS document_type, si.id AS document_id, si.issue_date AS document_date,
+		COALESCE(si.customer_id, 0) AS customer_id, COALESCE(si.sales_category_id, 0) AS sales_category_id, COALESCE(si.created_by, 0) AS user_id,
+		COALESCE(sii.product_id, 0) AS product_id, sii.quantity AS quantity, sii.total_amount - sii.vat_amount AS net_amount, sii.vat_amount AS vat_amount
+	FROM sales_invoices si
+	JOIN sales_invoice_items sii ON sii.invoice_id = si.id
+	WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+		AND DATE(si.issue_date) >= DATE(?) AND DATE(si.issue_date) <= DATE(?)
+	UNION ALL
+	SELECT 'sales_invoice', si.id, si.issue_date, COALESCE(si.customer_id, 0), COALESCE(si.sales_category_id, 0), COALESCE(si.created_by, 0),
+		0, 0, si.total_amount - si.vat_amount, si.vat_amount
+	FROM sales_invoices si
+	WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+		AND DATE(si.issue_date) >= DATE(?) AND DATE(si.issue_date) <= DATE(?)
+		AND NOT EXISTS (SELECT 1 FROM sales_invoice_items WHERE invoice_id = si.id)
+	UNION ALL
+	SELECT 'credit_note', cn.id, cn.issue_date, COALESCE(cn.customer_id, 0), COALESCE(si.sales_category_id, 0), COALESCE(cn.created_by, 0),
+		COALESCE(cni.product_id, 0), -cni.quantity, -(cni.total_amount - cni.vat_amount), -cni.vat_amount
+	FROM credit_notes cn
+	JOIN credit_note_items cni ON cni.credit_note_id = cn.id
+	LEFT JOIN sales_invoices si ON cn.invoice_id = si.id
+	WHERE cn.company_id = ? AND cn.status = 'issued' AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+		AND DATE(cn.issue_date) >= DATE(?) AND DATE(cn.issue_date) <= DATE(?)
+	UNION ALL
+	SELECT 'credit_note', cn.id, cn.issue_date, COALESCE(cn.customer_id, 0), COALESCE(si.sales_category_id, 0), COALESCE(cn.created_by, 0),
+		0, 0, -(cn.total_amount - cn.vat_amount), -cn.vat_amount
+	FROM credit_notes cn
+	LEFT JOIN sales_invoices si ON cn.invoice_id = si.id
+	WHERE cn.company_id = ? AND cn.status = 'issued' AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+		AND DATE(cn.issue_date) >= DATE(?) AND DATE(cn.issue_date) <= DATE(?)
+		AND NOT EXISTS (SELECT 1 FROM credit_note_items WHERE credit_note_id = cn.id)`
+
+// salesTotalsSQL aggregates sales lines aliased l into the columns scanned by scanSalesTotals
+const salesTotalsSQL = `
+	COUNT(DISTINCT CASE WHEN l.document_type = 'sales_invoice' THEN l.document_id END),
+	COUNT(DISTINCT CASE WHEN l.document_type = 'credit_note' THEN l.document_id END),
+	COALESCE(SUM(l.quantity), 0),
+	COALESCE(SUM(CASE WHEN l.document_type = 'sales_invoice' THEN l.net_amount ELSE 0 END), 0),
+	COALESCE(-SUM(CASE WHEN l.document_type = 'credit_note' THEN l.net_amount ELSE 0 END), 0),
+	COALESCE(SUM(l.vat_amount), 0)`
+
+// salesBreakdowns describe how sales lines aliased l are grouped for each breakdown: the grouping key,
+// the joins for its name, the name columns and the label of sales without one
+var salesBreakdowns = map[string]struct {
+	key, joins, label, labelArabic string
+	fallback, fallbackArabic       string
+}{
+	SalesByProduct: {"l.product_id", "LEFT JOIN products x ON x.id = l.product_id", "x.name", "x.name_arabic",
+		"Other items", "بنود أخرى"},
+	SalesByProductCategory: {"COALESCE(p.category_id, 0)",
+		"LEFT JOIN products p ON p.id = l.product_id LEFT JOIN product_categories x ON x.id = p.category_id", "x.name", "x.name_arabic",
+		"Uncategorised", "غير مصنف"},
+	SalesByCustomer: {"l.customer_id", "LEFT JOIN customers x ON x.id = l.customer_id", "x.name", "x.name_arabic",
+		"Walk-in customers", "عملاء نقديون"},
+	SalesByCategory: {"l.sales_category_id", "LEFT JOIN sales_categories x ON x.id = l.sales_category_id", "x.name", "x.name_arabic",
+		"No sales category", "بدون فئة مبيعات"},
+	SalesByUser: {"l.user_id", "LEFT JOIN users x ON x.id = l.user_id", "x.first_name || ' ' || x.last_name", "NULL",
+		"Unknown user", "مستخدم غير معروف"},
+}
+
+// salesLineArgs repeats the company and period for each part of salesLinesSQL
+func salesLineArgs(companyID int, from, to time.Time) []interface{} {
+	var args []interface{}
+	for i := 0; i < 4; i++ {
+		args = append(args, companyID, dateOnly(from), dateOnly(to))
+	}
+	return args
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSalesTotals scans the columns of salesTotalsSQL, preceded by any extra destinations
+func scanSalesTotals(row rowScanner, totals *SalesAnalyticsTotals, extra ...interface{}) error {
+	var vatAmount float64
+	dest := append(extra, &totals.InvoiceCount, &totals.CreditNoteCount, &totals.Quantity, &totals.GrossSales, &totals.Returns, &vatAmount)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	totals.GrossSales = roundAmount(totals.GrossSales)
+	totals.Returns = roundAmount(totals.Returns)
+	totals.NetSales = roundAmount(totals.GrossSales - totals.Returns)
+	totals.VATAmount = roundAmount(vatAmount)
+	totals.TotalAmount = roundAmount(totals.NetSales + totals.VATAmount)
+	return nil
+}
+
+// salesPeriodStart returns the start of the day, week (from Monday) or month a date falls in
+func salesPeriodStart(date time.Time, groupBy string) time.Time {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	switch groupBy {
+	case GroupByWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case GroupByMonth:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// salesPeriods returns the days, weeks or months covering a date range, the first and last cut to the range
+func salesPeriods(from, to time.Time, groupBy string) []SalesAnalyticsPeriod {
+	from = salesPeriodStart(from, GroupByDay)
+	to = salesPeriodStart(to, GroupByDay)
+
+	var periods []SalesAnalyticsPeriod
+	for start := salesPeriodStart(from, groupBy); !start.After(to); {
+		var next time.Time
+		var label string
+		switch groupBy {
+		case GroupByWeek:
+			next = start.AddDate(0, 0, 7)
+			label = "Week of " + dateOnly(start)
+		case GroupByMonth:
+			next = start.AddDate(0, 1, 0)
+			label = start.Format("January 2006")
+		default:
+			next = start.AddDate(0, 0, 1)
+			label = dateOnly(start)
+		}
+		period := SalesAnalyticsPeriod{Label: label, Start: start, End: next.AddDate(0, 0, -1)}
+		if period.Start.Before(from) {
+			period.Start = from
+		}
+		if period.End.After(to) {
+			period.End = to
+		}
+		periods = append(periods, period)
+		start = next
+	}
+	return periods
+}
+
+// GetSalesAnalytics analyses the sales of a company between two dates, grouped by day, week or month and
+// broken down by product, product category, customer, sales category, user and payment type. Breakdowns are
+// ordered by net sales and cut to the limit when it is positive.
+func (d *Database) GetSalesAnalytics(companyID int, from, to time.Time, groupBy string, limit int) (*SalesAnalytics, error) {
+	switch groupBy {
+	case "":
+		groupBy = GroupByDay
+	case GroupByDay, GroupByWeek, GroupByMonth:
+	default:
+		return nil, fmt.Errorf("invalid grouping %q", groupBy)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("the end date is before the start date")
+	}
+	if groupBy == GroupByDay && to.Sub(from) > 3*366*24*time.Hour {
+		return nil, fmt.Errorf("ranges longer than three years must be grouped by week or month")
+	}
+
+	analytics := &SalesAnalytics{CompanyID: companyID, From: from, To: to, GroupBy: groupBy}
+	args := salesLineArgs(companyID, from, to)
+
+	row := d.db.QueryRow(`SELECT `+salesTotalsSQL+` FROM (`+salesLinesSQL+`) l`, args...)
+	if err := scanSalesTotals(row, &analytics.Totals); err != nil {
+		return nil, err
+	}
+
+	analytics.Periods = salesPeriods(from, to, groupBy)
+	rows, err := d.db.Query(`SELECT DATE(l.document_date), `+salesTotalsSQL+` FROM (`+salesLinesSQL+`) l GROUP BY DATE(l.document_date)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day string
+		var totals SalesAnalyticsTotals
+		if err := scanSalesTotals(rows, &totals, &day); err != nil {
+			return nil, err
+		}
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return nil, err
+		}
+		for i := range analytics.Periods {
+			period := &analytics.Periods[i]
+			if date.Before(period.Start) || date.After(period.End) {
+				continue
+			}
+			period.InvoiceCount += totals.InvoiceCount
+			period.CreditNoteCount += totals.CreditNoteCount
+			period.Quantity += totals.Quantity
+			period.GrossSales = roundAmount(period.GrossSales + totals.GrossSales)
+			period.Returns = roundAmount(period.Returns + totals.Returns)
+			period.NetSales = roundAmount(period.NetSales + totals.NetSales)
+			period.VATAmount = roundAmount(period.VATAmount + totals.VATAmount)
+			period.TotalAmount = roundAmount(period.TotalAmount + totals.TotalAmount)
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	breakdowns := []struct {
+		dimension string
+		rows      *[]SalesBreakdownRow
+	}{
+		{SalesByProduct, &analytics.ByProduct},
+		{SalesByProductCategory, &analytics.ByProductCategory},
+		{SalesByCustomer, &analytics.ByCustomer},
+		{SalesByCategory, &analytics.BySalesCategory},
+		{SalesByUser, &analytics.ByUser},
+		{SalesByPaymentType, &analytics.ByPaymentType},
+	}
+	for _, breakdown := range breakdowns {
+		if *breakdown.rows, err = d.GetSalesBreakdown(companyID, from, to, breakdown.dimension, limit); err != nil {
+			return nil, err
+		}
+	}
+	return analytics, nil
+}
+
+// GetSalesBreakdown returns the sales of a company between two dates by one dimension, ordered by net sales
+// and cut to the limit when it is positive. The payment type breakdown shows the payments received instead.
+func (d *Database) GetSalesBreakdown(companyID int, from, to time.Time, dimension string, limit int) ([]SalesBreakdownRow, error) {
+	if dimension == SalesByPaymentType {
+		return d.paymentTypeBreakdown(companyID, from, to, limit)
+	}
+	breakdown, ok := salesBreakdowns[dimension]
+	if !ok {
+		return nil, fmt.Errorf("invalid sales breakdown %q", dimension)
+	}
+
+	query := `
+		SELECT ` + breakdown.key + `, COALESCE(MAX(` + breakdown.label + `), ''), COALESCE(MAX(` + breakdown.labelArabic + `), ''),
+			COUNT(DISTINCT CASE WHEN l.document_type = 'sales_invoice' THEN l.document_id END),
+			COALESCE(SUM(l.quantity), 0), COALESCE(SUM(l.net_amount), 0), COALESCE(SUM(l.vat_amount), 0)
+		FROM (` + salesLinesSQL + `) l
+		` + breakdown.joins + `
+		GROUP BY ` + breakdown.key + `
+		ORDER BY SUM(l.net_amount) DESC`
+	args := salesLineArgs(companyID, from, to)
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []SalesBreakdownRow
+	var total float64
+	for rows.Next() {
+		var r SalesBreakdownRow
+		if err := rows.Scan(&r.ID, &r.Label, &r.LabelArabic, &r.InvoiceCount, &r.Quantity, &r.NetSales, &r.VATAmount); err != nil {
+			return nil, err
+		}
+		if r.ID == 0 || r.Label == "" {
+			r.Label, r.LabelArabic = breakdown.fallback, breakdown.fallbackArabic
+		}
+		r.NetSales = roundAmount(r.NetSales)
+		r.VATAmount = roundAmount(r.VATAmount)
+		r.TotalAmount = roundAmount(r.NetSales + r.VATAmount)
+		result = append(result, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Shares are of all net sales, not only of the rows within the limit
+	err = d.db.QueryRow(`SELECT COALESCE(SUM(l.net_amount), 0) FROM (`+salesLinesSQL+`) l`, salesLineArgs(companyID, from, to)...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		if total != 0 {
+			result[i].Share = roundAmount(result[i].NetSales * 100 / total)
+		}
+	}
+	return result, nil
+}
+
+// paymentTypeBreakdown returns the completed payments received on invoices between two dates by payment type
+func (d *Database) paymentTypeBreakdown(companyID int, from, to time.Time, limit int) ([]SalesBreakdownRow, error) {
+	query := `
+		SELECT p.payment_type_id, COALESCE(MAX(pt.name), ''), COALESCE(MAX(pt.name_arabic), ''), COUNT(DISTINCT p.invoice_id), SUM(p.amount)
+		FROM payments p
+		LEFT JOIN sales_invoices si ON p.invoice_id = si.id
+		LEFT JOIN payment_types pt ON p.payment_type_id = pt.id
+		WHERE COALESCE(p.company_id, si.company_id, 1) = ? AND COALESCE(p.status, 'completed') = 'completed' AND p.deleted_at IS NULL
+			AND (si.id IS NULL OR si.status NOT IN ('draft', 'cancelled'))
+			AND DATE(p.payment_date) >= DATE(?) AND DATE(p.payment_date) <= DATE(?)
+		GROUP BY p.payment_type_id
+		ORDER BY SUM(p.amount) DESC`
+	args := []interface{}{companyID, dateOnly(from), dateOnly(to)}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []SalesBreakdownRow
+	var total float64
+	for rows.Next() {
+		var r SalesBreakdownRow
+		if err := rows.Scan(&r.ID, &r.Label, &r.LabelArabic, &r.InvoiceCount, &r.TotalAmount); err != nil {
+			return nil, err
+		}
+		r.TotalAmount = roundAmount(r.TotalAmount)
+		total += r.TotalAmount
+		result = append(result, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range result {
+		if total != 0 {
+			result[i].Share = roundAmount(result[i].TotalAmount * 100 / total)
+		}
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
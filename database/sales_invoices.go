@@ -14,18 +14,44 @@ func (d *Database) CreateSalesInvoice(invoice *SalesInvoice) error {
 	}
 	defer tx.Rollback()
 
-	// Generate invoice number if not provided
-	if invoice.InvoiceNumber == "" {
-		invoice.InvoiceNumber = d.generateSalesInvoiceNumber()
+	if err = d.insertSalesInvoice(tx, invoice); err != nil {
+		return err
 	}
 
+	return tx.Commit()
+}
+
+// insertSalesInvoice inserts a sales invoice with its items inside a transaction
+func (d *Database) insertSalesInvoice(tx *sql.Tx, invoice *SalesInvoice) error {
 	// Insert sales invoice
 	query := `
-		INSERT INTO sales_invoices (invoice_number, customer_id, sales_category_id, table_number, issue_date, due_date, sub_total, vat_amount, total_amount, status, notes, notes_arabic, qr_code, created_by, updated_by)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		INSERT INTO sales_invoices (company_id, branch_id, invoice_number, customer_id, sales_category_id, table_number, issue_date, due_date, sub_total, vat_amount, total_amount, status, invoice_type, notes, notes_arabic, qr_code, created_by, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// Default to company 1 for backward compatibility
+	if invoice.CompanyID == 0 {
+		invoice.CompanyID = 1
+	}
 
-	result, err := tx.Exec(query, invoice.InvoiceNumber, invoice.CustomerID, invoice.SalesCategoryID, invoice.TableNumber, invoice.IssueDate.Time, invoice.DueDate.Time,
-		invoice.SubTotal, invoice.VATAmount, invoice.TotalAmount, invoice.Status, invoice.Notes, invoice.NotesArabic, invoice.QRCode, invoice.CreatedBy, invoice.CreatedBy)
+	// Generate invoice number if not provided
+	if invoice.InvoiceNumber == "" {
+		number, err := nextDocumentNumber(tx, "sales_invoices", "invoice_number", "SI-", invoice.CompanyID)
+		if err != nil {
+			return err
+		}
+		invoice.InvoiceNumber = number
+	}
+
+	if invoice.InvoiceType == "" {
+		invoice.InvoiceType = "standard"
+	}
+
+	if err := checkDocumentPeriod(tx, invoice.CompanyID, invoice.IssueDate.Time); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(query, invoice.CompanyID, invoice.BranchID, invoice.InvoiceNumber, invoice.CustomerID, invoice.SalesCategoryID, invoice.TableNumber, invoice.IssueDate.Time, invoice.DueDate.Time,
+		invoice.SubTotal, invoice.VATAmount, invoice.TotalAmount, invoice.Status, invoice.InvoiceType, invoice.Notes, invoice.NotesArabic, invoice.QRCode, invoice.CreatedBy, invoice.CreatedBy)
 	if err != nil {
 		return err
 	}
@@ -39,34 +65,64 @@ func (d *Database) CreateSalesInvoice(invoice *SalesInvoice) error {
 	// Insert sales invoice items
 	for _, item := range invoice.Items {
 		itemQuery := `
-			INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
-			VALUES (?, ?, ?, ?, ?, ?, ?)`
+			INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, vat_category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-		_, err = tx.Exec(itemQuery, invoiceID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount)
+		_, err = tx.Exec(itemQuery, invoiceID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount, item.VATCategory)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return postSalesInvoice(tx, invoice.ID)
 }
 
 func (d *Database) GetSalesInvoices() ([]SalesInvoice, error) {
+	return d.querySalesInvoices(`WHERE si.deleted_at IS NULL ORDER BY si.created_at DESC`)
+}
+
+var salesInvoiceList = listSpec{
+	table:  "sales_invoices",
+	alias:  "si",
+	entity: "sales_invoice",
+	date:   "si.issue_date",
+	filters: map[string]string{"status": "si.status", "customer_id": "si.customer_id", "branch_id": "si.branch_id",
+		"sales_category_id": "si.sales_category_id", "invoice_type": "si.invoice_type"},
+	sorts: map[string]string{"issue_date": "si.issue_date", "invoice_number": "si.invoice_number",
+		"total_amount": "si.total_amount"},
+	sort: "issue_date",
+	desc: true,
+}
+
+// ListSalesInvoices retrieves a page of the sales invoices of a company, latest first by default. They can
+// be filtered by status, customer_id, branch_id, sales_category_id and invoice_type and sorted by
+// issue_date, invoice_number or total_amount.
+func (d *Database) ListSalesInvoices(q ListQuery) (*SalesInvoicePage, error) {
+	page := &SalesInvoicePage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, salesInvoiceList, q, d.querySalesInvoices, func(inv SalesInvoice) int { return inv.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Database) querySalesInvoices(where string, args ...interface{}) ([]SalesInvoice, error) {
 	query := `
 		SELECT 
-			si.id, si.invoice_number, si.customer_id, si.sales_category_id, si.table_number,
+			si.id, si.branch_id, si.invoice_number, si.customer_id, si.sales_category_id, si.table_number,
 			si.issue_date, si.due_date, si.sub_total, si.vat_amount, si.total_amount, 
 			si.status, si.notes, si.notes_arabic, si.qr_code, si.created_at, si.updated_at,
-			si.created_by, si.updated_by,
+			si.created_by, si.updated_by, si.version,
 			c.id as customer_id, c.name as customer_name, c.email as customer_email, 
 			c.phone as customer_phone, c.address as customer_address, c.city as customer_city, 
 			c.country as customer_country, c.vat_number as customer_vat_number, 
 			c.created_at as customer_created_at, c.updated_at as customer_updated_at
 		FROM sales_invoices si
 		LEFT JOIN customers c ON si.customer_id = c.id
-		ORDER BY si.created_at DESC`
+		` + where
 
-	rows, err := d.db.Query(query)
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -82,10 +138,10 @@ func (d *Database) GetSalesInvoices() ([]SalesInvoice, error) {
 		var issueDate, dueDate time.Time
 		
 		scanErr := rows.Scan(
-			&inv.ID, &inv.InvoiceNumber, &inv.CustomerID, &inv.SalesCategoryID, &inv.TableNumber,
+			&inv.ID, &inv.BranchID, &inv.InvoiceNumber, &inv.CustomerID, &inv.SalesCategoryID, &inv.TableNumber,
 			&issueDate, &dueDate, &inv.SubTotal, &inv.VATAmount, &inv.TotalAmount, 
 			&inv.Status, &inv.Notes, &inv.NotesArabic, &inv.QRCode, &inv.CreatedAt, &inv.UpdatedAt,
-			&inv.CreatedBy, &inv.UpdatedBy,
+			&inv.CreatedBy, &inv.UpdatedBy, &inv.Version,
 			&customerID, &customerName, &customerEmail, &customerPhone, &customerAddress, 
 			&customerCity, &customerCountry, &customerVATNumber, 
 			&customerCreatedAt, &customerUpdatedAt)
@@ -121,91 +177,70 @@ func (d *Database) GetSalesInvoices() ([]SalesInvoice, error) {
 	return invoices, nil
 }
 
-// GetTodaysSales returns sales statistics for today
-func (d *Database) GetTodaysSales() (map[string]interface{}, error) {
-	today := time.Now().Format("2006-01-02")
-	
-	// Get today's sales count and total
-	salesQuery := `
-		SELECT 
-			COUNT(*) as sales_count,
-			COALESCE(SUM(total_amount), 0) as total_amount,
-			COALESCE(SUM(CASE WHEN status = 'paid' THEN total_amount ELSE 0 END), 0) as paid_amount
-		FROM sales_invoices 
-		WHERE DATE(created_at) = ?`
-	
-	var salesCount int
-	var totalAmount, paidAmount float64
-	err := d.db.QueryRow(salesQuery, today).Scan(&salesCount, &totalAmount, &paidAmount)
-	if err != nil {
+// GetTodaysSales returns the sales statistics of a company for today
+func (d *Database) GetTodaysSales(companyID int) (map[string]interface{}, error) {
+	today := time.Now()
+
+	var totals SalesAnalyticsTotals
+	row := d.db.QueryRow(`SELECT `+salesTotalsSQL+` FROM (`+salesLinesSQL+`) l`, salesLineArgs(companyID, today, today)...)
+	if err := scanSalesTotals(row, &totals); err != nil {
 		return nil, err
 	}
-	
-	// Get today's items sold
-	itemsQuery := `
-		SELECT COALESCE(SUM(quantity), 0) as items_sold
-		FROM sales_invoice_items sii
-		JOIN sales_invoices si ON sii.invoice_id = si.id
-		WHERE DATE(si.created_at) = ?`
-	
-	var itemsSold int
-	err = d.db.QueryRow(itemsQuery, today).Scan(&itemsSold)
+
+	var paidAmount float64
+	err := d.db.QueryRow(`
+		SELECT COALESCE(SUM(total_amount), 0) FROM sales_invoices
+		WHERE company_id = ? AND status = 'paid' AND COALESCE(invoice_type, 'standard') != 'prepayment' AND DATE(issue_date) = DATE(?)`,
+		companyID, dateOnly(today)).Scan(&paidAmount)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return map[string]interface{}{
-		"sales_count":   salesCount,
-		"total_amount":  totalAmount,
-		"paid_amount":   paidAmount,
-		"items_sold":    itemsSold,
-		"date":          today,
+		"sales_count":  totals.InvoiceCount,
+		"total_amount": totals.TotalAmount,
+		"paid_amount":  paidAmount,
+		"items_sold":   totals.Quantity,
+		"date":         dateOnly(today),
 	}, nil
 }
 
-// GetTopSellingProducts returns the top 5 selling products
-func (d *Database) GetTopSellingProducts() ([]map[string]interface{}, error) {
-	query := `
-		SELECT 
-			p.id, p.name, p.name_arabic,
-			COALESCE(SUM(sii.quantity), 0) as total_sold,
-			COALESCE(SUM(sii.total_amount), 0) as total_revenue
-		FROM products p
-		LEFT JOIN sales_invoice_items sii ON p.id = sii.product_id
-		LEFT JOIN sales_invoices si ON sii.invoice_id = si.id
-		WHERE p.is_active = 1
-		GROUP BY p.id, p.name, p.name_arabic
-		ORDER BY total_sold DESC
-		LIMIT 5`
-	
-	rows, err := d.db.Query(query)
+// GetTopSellingProducts returns the best-selling products of a company between two dates by quantity sold.
+// Zero dates leave the range open.
+func (d *Database) GetTopSellingProducts(companyID int, from, to time.Time, limit int) ([]map[string]interface{}, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	rows, err := d.db.Query(`
+		SELECT l.product_id, p.name, COALESCE(p.name_arabic, ''), SUM(l.quantity), SUM(l.net_amount + l.vat_amount)
+		FROM (`+salesLinesSQL+`) l
+		JOIN products p ON p.id = l.product_id
+		GROUP BY l.product_id
+		ORDER BY SUM(l.quantity) DESC
+		LIMIT ?`, append(salesLineArgs(companyID, from, to), limit)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
+
 	var products []map[string]interface{}
 	for rows.Next() {
 		var id int
 		var name, nameArabic string
-		var totalSold int
-		var totalRevenue float64
-		
-		err := rows.Scan(&id, &name, &nameArabic, &totalSold, &totalRevenue)
-		if err != nil {
+		var totalSold, totalRevenue float64
+		if err := rows.Scan(&id, &name, &nameArabic, &totalSold, &totalRevenue); err != nil {
 			return nil, err
 		}
-		
 		products = append(products, map[string]interface{}{
-			"id":            id,
-			"name":          name,
-			"name_arabic":   nameArabic,
-			"total_sold":    totalSold,
-			"total_revenue": totalRevenue,
+			"id":             id,
+			"name":           name,
+			"name_arabic":    nameArabic,
+			"total_sold":     totalSold,
+			"total_quantity": totalSold,
+			"total_revenue":  roundAmount(totalRevenue),
 		})
 	}
-	
-	return products, nil
+	return products, rows.Err()
 }
 
 func (d *Database) GetOpenSalesInvoices() ([]SalesInvoice, error) {
@@ -213,14 +248,14 @@ func (d *Database) GetOpenSalesInvoices() ([]SalesInvoice, error) {
 		SELECT 
 			si.id, si.invoice_number, si.customer_id, si.sales_category_id, si.table_number,
 			si.issue_date, si.due_date, si.sub_total, si.vat_amount, si.total_amount, 
-			si.status, si.notes, si.notes_arabic, si.qr_code, si.created_at, si.updated_at,
+			si.status, si.notes, si.notes_arabic, si.qr_code, si.created_at, si.updated_at, si.version,
 			c.id as customer_id, c.name as customer_name, c.email as customer_email, 
 			c.phone as customer_phone, c.address as customer_address, c.city as customer_city, 
 			c.country as customer_country, c.vat_number as customer_vat_number, 
 			c.created_at as customer_created_at, c.updated_at as customer_updated_at
 		FROM sales_invoices si
 		LEFT JOIN customers c ON si.customer_id = c.id
-		WHERE si.status IN ('draft', 'open', 'pending')
+		WHERE si.status IN ('draft', 'open', 'pending') AND si.deleted_at IS NULL
 		ORDER BY si.created_at DESC`
 
 	rows, err := d.db.Query(query)
@@ -241,7 +276,7 @@ func (d *Database) GetOpenSalesInvoices() ([]SalesInvoice, error) {
 		scanErr := rows.Scan(
 			&inv.ID, &inv.InvoiceNumber, &inv.CustomerID, &inv.SalesCategoryID, &inv.TableNumber,
 			&issueDate, &dueDate, &inv.SubTotal, &inv.VATAmount, &inv.TotalAmount, 
-			&inv.Status, &inv.Notes, &inv.NotesArabic, &inv.QRCode, &inv.CreatedAt, &inv.UpdatedAt,
+			&inv.Status, &inv.Notes, &inv.NotesArabic, &inv.QRCode, &inv.CreatedAt, &inv.UpdatedAt, &inv.Version,
 			&customerID, &customerName, &customerEmail, &customerPhone, &customerAddress, 
 			&customerCity, &customerCountry, &customerVATNumber, 
 			&customerCreatedAt, &customerUpdatedAt)
@@ -278,13 +313,13 @@ func (d *Database) GetOpenSalesInvoices() ([]SalesInvoice, error) {
 }
 
 func (d *Database) GetSalesInvoiceByID(id int) (*SalesInvoice, error) {
-	query := `SELECT id, invoice_number, customer_id, sales_category_id, table_number, issue_date, due_date, sub_total, vat_amount, total_amount, status, notes, notes_arabic, qr_code, created_at, updated_at, created_by, updated_by FROM sales_invoices WHERE id = ?`
+	query := `SELECT id, company_id, branch_id, invoice_number, customer_id, sales_category_id, table_number, issue_date, due_date, sub_total, vat_amount, total_amount, status, COALESCE(invoice_type, 'standard'), notes, notes_arabic, qr_code, created_at, updated_at, created_by, updated_by, version FROM sales_invoices WHERE id = ?`
 
 	var inv SalesInvoice
 	var issueDate, dueDate time.Time
-	err := d.db.QueryRow(query, id).Scan(&inv.ID, &inv.InvoiceNumber, &inv.CustomerID, &inv.SalesCategoryID, &inv.TableNumber, &issueDate, &dueDate,
-		&inv.SubTotal, &inv.VATAmount, &inv.TotalAmount, &inv.Status, &inv.Notes, &inv.NotesArabic,
-		&inv.QRCode, &inv.CreatedAt, &inv.UpdatedAt, &inv.CreatedBy, &inv.UpdatedBy)
+	err := d.db.QueryRow(query, id).Scan(&inv.ID, &inv.CompanyID, &inv.BranchID, &inv.InvoiceNumber, &inv.CustomerID, &inv.SalesCategoryID, &inv.TableNumber, &issueDate, &dueDate,
+		&inv.SubTotal, &inv.VATAmount, &inv.TotalAmount, &inv.Status, &inv.InvoiceType, &inv.Notes, &inv.NotesArabic,
+		&inv.QRCode, &inv.CreatedAt, &inv.UpdatedAt, &inv.CreatedBy, &inv.UpdatedBy, &inv.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -326,15 +361,24 @@ func (d *Database) UpdateSalesInvoice(invoice *SalesInvoice) error {
 	}
 	defer tx.Rollback()
 
+	if err = checkStoredDocumentPeriod(tx, "sales_invoices", "issue_date", invoice.ID, invoice.IssueDate.Time); err != nil {
+		return err
+	}
+
 	// Update sales invoice
 	query := `
 		UPDATE sales_invoices 
-		SET invoice_number = ?, customer_id = ?, sales_category_id = ?, table_number = ?, issue_date = ?, due_date = ?, 
+		SET branch_id = ?, invoice_number = ?, customer_id = ?, sales_category_id = ?, table_number = ?, issue_date = ?, due_date = ?, 
 		    sub_total = ?, vat_amount = ?, total_amount = ?, status = ?, notes = ?, notes_arabic = ?, qr_code = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`
+		WHERE id = ? AND ` + versionCondition
 
-	_, err = tx.Exec(query, invoice.InvoiceNumber, invoice.CustomerID, invoice.SalesCategoryID, invoice.TableNumber, invoice.IssueDate.Time, invoice.DueDate.Time,
-		invoice.SubTotal, invoice.VATAmount, invoice.TotalAmount, invoice.Status, invoice.Notes, invoice.NotesArabic, invoice.QRCode, invoice.UpdatedBy, invoice.ID)
+	result, err := tx.Exec(query, invoice.BranchID, invoice.InvoiceNumber, invoice.CustomerID, invoice.SalesCategoryID, invoice.TableNumber, invoice.IssueDate.Time, invoice.DueDate.Time,
+		invoice.SubTotal, invoice.VATAmount, invoice.TotalAmount, invoice.Status, invoice.Notes, invoice.NotesArabic, invoice.QRCode, invoice.UpdatedBy, invoice.ID, invoice.Version)
+	if err != nil {
+		return err
+	}
+	version, err := checkVersion(tx, "sales_invoices", invoice.ID, invoice.Version, result,
+		func() (interface{}, error) { return d.GetSalesInvoiceByID(invoice.ID) })
 	if err != nil {
 		return err
 	}
@@ -348,34 +392,49 @@ func (d *Database) UpdateSalesInvoice(invoice *SalesInvoice) error {
 	// Insert updated items
 	for _, item := range invoice.Items {
 		itemQuery := `
-			INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
-			VALUES (?, ?, ?, ?, ?, ?, ?)`
+			INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, vat_category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
-		_, execErr := tx.Exec(itemQuery, invoice.ID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount)
+		_, execErr := tx.Exec(itemQuery, invoice.ID, item.ProductID, item.Quantity, item.UnitPrice, item.VATRate, item.VATAmount, item.TotalAmount, item.VATCategory)
 		if execErr != nil {
 			return execErr
 		}
 	}
 
-	return tx.Commit()
+	if err = postSalesInvoice(tx, invoice.ID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	invoice.Version = version
+	return nil
 }
 
-func (d *Database) DeleteSalesInvoice(id int) error {
+// DeleteSalesInvoice moves a draft sales invoice to the recycle bin. Issued invoices must be cancelled instead.
+func (d *Database) DeleteSalesInvoice(id int, userID *int) error {
+	var status string
+	if err := d.db.QueryRow(`SELECT COALESCE(status, 'draft') FROM sales_invoices WHERE id = ? AND deleted_at IS NULL`, id).Scan(&status); err != nil {
+		return err
+	}
+	if status != "draft" {
+		return fmt.Errorf("only draft sales invoices can be deleted; cancel issued invoices instead")
+	}
+
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Delete sales invoice items first (due to foreign key constraint)
-	_, err = tx.Exec("DELETE FROM sales_invoice_items WHERE invoice_id = ?", id)
-	if err != nil {
+	if err = checkStoredDocumentPeriod(tx, "sales_invoices", "issue_date", id); err != nil {
 		return err
 	}
-
-	// Delete sales invoice
-	_, err = tx.Exec("DELETE FROM sales_invoices WHERE id = ?", id)
-	if err != nil {
+	if err = softDelete(tx, "sales_invoices", id, userID); err != nil {
+		return err
+	}
+	if err = postSalesInvoice(tx, id); err != nil {
 		return err
 	}
 
@@ -383,7 +442,8 @@ func (d *Database) DeleteSalesInvoice(id int) error {
 }
 
 func (d *Database) GetSalesInvoiceItems(invoiceID int) ([]SalesInvoiceItem, error) {
-	query := `SELECT id, invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, created_at FROM sales_invoice_items WHERE invoice_id = ?`
+	query := `SELECT id, invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount, COALESCE(vat_category, ''),
+		COALESCE(unit_cost, 0), COALESCE(cost_amount, 0), created_at FROM sales_invoice_items WHERE invoice_id = ?`
 
 	rows, err := d.db.Query(query, invoiceID)
 	if err != nil {
@@ -395,7 +455,7 @@ func (d *Database) GetSalesInvoiceItems(invoiceID int) ([]SalesInvoiceItem, erro
 	for rows.Next() {
 		var item SalesInvoiceItem
 		scanErr := rows.Scan(&item.ID, &item.InvoiceID, &item.ProductID, &item.Quantity, &item.UnitPrice,
-			&item.VATRate, &item.VATAmount, &item.TotalAmount, &item.CreatedAt)
+			&item.VATRate, &item.VATAmount, &item.TotalAmount, &item.VATCategory, &item.UnitCost, &item.CostAmount, &item.CreatedAt)
 		if scanErr != nil {
 			return nil, scanErr
 		}
@@ -404,10 +464,66 @@ func (d *Database) GetSalesInvoiceItems(invoiceID int) ([]SalesInvoiceItem, erro
 	return items, nil
 }
 
-func (d *Database) generateSalesInvoiceNumber() string {
-	var count int
-	d.db.QueryRow("SELECT COUNT(*) FROM sales_invoices").Scan(&count)
-	return fmt.Sprintf("SI-%06d", count+1)
+// GetSalesInvoiceIDsWithQRCode retrieves the ids of the sales invoices outside the recycle bin that store
+// the content of their QR code
+func (d *Database) GetSalesInvoiceIDsWithQRCode() ([]int, error) {
+	rows, err := d.db.Query(`SELECT id FROM sales_invoices WHERE COALESCE(qr_code, '') != '' AND deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetSalesInvoiceQRCode replaces the stored content of the QR code of a draft sales invoice. The QR code of
+// an issued invoice is what the customer holds, so it is never replaced.
+func (d *Database) SetSalesInvoiceQRCode(id int, qrCode string, userID *int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err = tx.QueryRow(`SELECT COALESCE(status, 'draft') FROM sales_invoices WHERE id = ? AND deleted_at IS NULL`, id).Scan(&status); err != nil {
+		return err
+	}
+	if status != "draft" {
+		return fmt.Errorf("the QR code of an issued sales invoice cannot be replaced")
+	}
+	if err = checkStoredDocumentPeriod(tx, "sales_invoices", "issue_date", id); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE sales_invoices SET qr_code = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, qrCode, userID, id)
+	if err != nil {
+		return fmt.Errorf("error setting the QR code of sales invoice %d: %v", id, err)
+	}
+	return tx.Commit()
+}
+
+// nextDocumentNumber returns the number following the highest number of a company's documents that is
+// prefix followed by digits. Deleted documents keep their numbers, so they count too. It reads inside the
+// transaction that inserts the document, so the number it returns is not taken before the insert.
+func nextDocumentNumber(tx execer, table, column, prefix string, companyID int) (string, error) {
+	var last int
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT COALESCE(MAX(CAST(SUBSTR(%[2]s, %[3]d) AS INTEGER)), 0) FROM %[1]s
+		WHERE company_id = ? AND SUBSTR(%[2]s, 1, %[4]d) = ? AND SUBSTR(%[2]s, %[3]d) <> ''
+			AND SUBSTR(%[2]s, %[3]d) NOT GLOB '*[^0-9]*'`, table, column, len(prefix)+1, len(prefix)),
+		companyID, prefix).Scan(&last)
+	if err != nil {
+		return "", fmt.Errorf("error numbering %s: %v", table, err)
+	}
+	return fmt.Sprintf("%s%06d", prefix, last+1), nil
 }
 
 // Legacy functions for backward compatibility
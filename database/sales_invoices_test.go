@@ -0,0 +1,71 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDocumentNumbersPerCompany(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	statements := []string{
+		`INSERT INTO companies (name, vat_number) VALUES ('Second Company', '300000000000010')`,
+		`INSERT INTO companies (name, vat_number) VALUES ('Third Company', '300000000000020')`,
+		`INSERT INTO customers (name, company_id) VALUES ('First', 1)`,
+		`INSERT INTO customers (name, company_id) VALUES ('Second', 2)`,
+		`INSERT INTO customers (name, company_id) VALUES ('Third', 3)`,
+		// Numbers that are not the prefix followed by digits are not followed, and a deleted invoice keeps
+		// its number
+		`INSERT INTO sales_invoices (company_id, invoice_number, customer_id, sales_category_id, issue_date, due_date,
+			sub_total, vat_amount, total_amount, status, deleted_at)
+		SELECT n.company_id, n.number, (SELECT id FROM customers WHERE company_id = n.company_id), 1, '2024-01-01', '2024-01-31', 100, 15, 115, 'sent', n.deleted_at
+		FROM (SELECT 1 AS company_id, 'SI-000007' AS number, NULL AS deleted_at
+			UNION ALL SELECT 1, 'SI-000009', CURRENT_TIMESTAMP
+			UNION ALL SELECT 1, 'SI-7000X', NULL
+			UNION ALL SELECT 1, 'INV-009000', NULL
+			UNION ALL SELECT 2, 'SI-000050', NULL) n`,
+	}
+	for _, statement := range statements {
+		if _, err := d.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		companyID int
+		want      string
+	}{
+		{1, "SI-000010"},
+		{2, "SI-000051"},
+		{3, "SI-000001"},
+		{1, "SI-000011"},
+	}
+	for _, test := range tests {
+		var customerID int
+		if err := d.db.QueryRow("SELECT id FROM customers WHERE company_id = ?", test.companyID).Scan(&customerID); err != nil {
+			t.Fatal(err)
+		}
+		invoice := &SalesInvoice{CompanyID: test.companyID, CustomerID: customerID, SalesCategoryID: 1, Status: "draft",
+			IssueDate: Date{Time: time.Now()}, DueDate: Date{Time: time.Now()}}
+		if err := d.CreateSalesInvoice(invoice); err != nil {
+			t.Fatal(err)
+		}
+		if invoice.InvoiceNumber != test.want {
+			t.Errorf("new invoice of company %d is %s, want %s", test.companyID, invoice.InvoiceNumber, test.want)
+		}
+	}
+
+	for companyID, want := range map[int]string{1: "CN-000001", 2: "CN-000001"} {
+		var customerID int
+		if err := d.db.QueryRow("SELECT id FROM customers WHERE company_id = ?", companyID).Scan(&customerID); err != nil {
+			t.Fatal(err)
+		}
+		note := &CreditNote{CompanyID: companyID, CustomerID: customerID, Status: "draft", IssueDate: Date{Time: time.Now()}}
+		if err := d.CreateCreditNote(note); err != nil {
+			t.Fatal(err)
+		}
+		if note.CreditNoteNumber != want {
+			t.Errorf("new credit note of company %d is %s, want %s", companyID, note.CreditNoteNumber, want)
+		}
+	}
+}
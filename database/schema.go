@@ -1,12 +1,13 @@
 package database
 
 import (
+	"database/sql"
 	"fmt"
-	"log"
 )
 
-// createTables creates all necessary tables
-func (d *Database) createTables() error {
+// createTables creates the tables of the baseline schema. Columns and tables added since are
+// migrations in migrations.go.
+func createTables(tx *sql.Tx) error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS companies (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -272,34 +273,378 @@ func (d *Database) createTables() error {
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS credit_notes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			credit_note_number TEXT UNIQUE NOT NULL,
+			invoice_id INTEGER,
+			customer_id INTEGER NOT NULL,
+			issue_date DATETIME NOT NULL,
+			reason TEXT,
+			reason_arabic TEXT,
+			sub_total REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			status TEXT DEFAULT 'issued',
+			notes TEXT,
+			notes_arabic TEXT,
+			created_by INTEGER,
+			updated_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (invoice_id) REFERENCES sales_invoices(id),
+			FOREIGN KEY (customer_id) REFERENCES customers(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS credit_note_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			credit_note_id INTEGER NOT NULL,
+			product_id INTEGER,
+			description TEXT,
+			quantity REAL NOT NULL,
+			unit_price REAL NOT NULL,
+			vat_rate REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (credit_note_id) REFERENCES credit_notes(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_credit_notes_company_id ON credit_notes(company_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_credit_notes_customer_id ON credit_notes(customer_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_credit_notes_invoice_id ON credit_notes(invoice_id)`,
+		`CREATE TABLE IF NOT EXISTS supplier_payments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			payment_number TEXT UNIQUE NOT NULL,
+			supplier_id INTEGER NOT NULL,
+			payment_type_id INTEGER NOT NULL,
+			amount REAL NOT NULL,
+			payment_date DATETIME NOT NULL,
+			reference TEXT,
+			notes TEXT,
+			notes_arabic TEXT,
+			status TEXT DEFAULT 'completed',
+			created_by INTEGER,
+			updated_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+			FOREIGN KEY (payment_type_id) REFERENCES payment_types(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS supplier_payment_allocations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			supplier_payment_id INTEGER NOT NULL,
+			purchase_invoice_id INTEGER NOT NULL,
+			amount REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (supplier_payment_id) REFERENCES supplier_payments(id) ON DELETE CASCADE,
+			FOREIGN KEY (purchase_invoice_id) REFERENCES purchase_invoices(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_supplier_payments_company_id ON supplier_payments(company_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_supplier_payments_supplier_id ON supplier_payments(supplier_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_supplier_payment_allocations_payment_id ON supplier_payment_allocations(supplier_payment_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_supplier_payment_allocations_invoice_id ON supplier_payment_allocations(purchase_invoice_id)`,
+		`CREATE TABLE IF NOT EXISTS customer_credit_transactions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			customer_id INTEGER NOT NULL,
+			transaction_date DATETIME NOT NULL,
+			type TEXT NOT NULL,
+			amount REAL NOT NULL,
+			payment_type_id INTEGER,
+			payment_id INTEGER,
+			invoice_id INTEGER,
+			credit_note_id INTEGER,
+			prepayment_invoice_id INTEGER,
+			reverses_id INTEGER,
+			reference TEXT,
+			notes TEXT,
+			notes_arabic TEXT,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (customer_id) REFERENCES customers(id),
+			FOREIGN KEY (payment_type_id) REFERENCES payment_types(id),
+			FOREIGN KEY (invoice_id) REFERENCES sales_invoices(id),
+			FOREIGN KEY (credit_note_id) REFERENCES credit_notes(id),
+			FOREIGN KEY (prepayment_invoice_id) REFERENCES sales_invoices(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_customer_credit_transactions_customer_id ON customer_credit_transactions(customer_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_customer_credit_transactions_invoice_id ON customer_credit_transactions(invoice_id)`,
+		`CREATE TABLE IF NOT EXISTS bank_statements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			format TEXT NOT NULL,
+			account_number TEXT,
+			currency TEXT,
+			statement_number TEXT,
+			statement_date DATETIME,
+			opening_balance REAL DEFAULT 0,
+			closing_balance REAL DEFAULT 0,
+			file_name TEXT,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (company_id) REFERENCES companies(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS bank_statement_lines (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			statement_id INTEGER NOT NULL,
+			company_id INTEGER DEFAULT 1,
+			line_number INTEGER NOT NULL,
+			booking_date DATETIME NOT NULL,
+			value_date DATETIME,
+			amount REAL NOT NULL,
+			reference TEXT,
+			description TEXT,
+			counterparty_name TEXT,
+			counterparty_account TEXT,
+			bank_reference TEXT,
+			status TEXT DEFAULT 'unmatched',
+			match_type TEXT,
+			payment_id INTEGER,
+			supplier_payment_id INTEGER,
+			invoice_id INTEGER,
+			purchase_invoice_id INTEGER,
+			match_score INTEGER DEFAULT 0,
+			reconciled_by INTEGER,
+			reconciled_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (statement_id) REFERENCES bank_statements(id) ON DELETE CASCADE,
+			FOREIGN KEY (payment_id) REFERENCES payments(id),
+			FOREIGN KEY (supplier_payment_id) REFERENCES supplier_payments(id),
+			FOREIGN KEY (invoice_id) REFERENCES sales_invoices(id),
+			FOREIGN KEY (purchase_invoice_id) REFERENCES purchase_invoices(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_statement_id ON bank_statement_lines(statement_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_payment_id ON bank_statement_lines(payment_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_bank_statement_lines_supplier_payment_id ON bank_statement_lines(supplier_payment_id)`,
+		`CREATE TABLE IF NOT EXISTS accounts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			code TEXT NOT NULL,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			type TEXT NOT NULL,
+			parent_id INTEGER,
+			is_system BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, code),
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (parent_id) REFERENCES accounts(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS account_mappings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER NOT NULL DEFAULT 0,
+			role TEXT NOT NULL,
+			account_id INTEGER NOT NULL,
+			UNIQUE (company_id, entity_type, entity_id, role),
+			FOREIGN KEY (account_id) REFERENCES accounts(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS journal_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			entry_number TEXT NOT NULL,
+			entry_date DATETIME NOT NULL,
+			description TEXT,
+			description_arabic TEXT,
+			reference TEXT,
+			source_type TEXT NOT NULL DEFAULT 'manual',
+			source_id INTEGER,
+			reverses_id INTEGER,
+			reversed_by_id INTEGER,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, entry_number),
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (reverses_id) REFERENCES journal_entries(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS journal_lines (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entry_id INTEGER NOT NULL,
+			account_id INTEGER NOT NULL,
+			debit REAL NOT NULL DEFAULT 0,
+			credit REAL NOT NULL DEFAULT 0,
+			description TEXT,
+			FOREIGN KEY (entry_id) REFERENCES journal_entries(id),
+			FOREIGN KEY (account_id) REFERENCES accounts(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_journal_entries_company_date ON journal_entries(company_id, entry_date)`,
+		`CREATE INDEX IF NOT EXISTS idx_journal_entries_source ON journal_entries(source_type, source_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_journal_lines_entry_id ON journal_lines(entry_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_journal_lines_account_id ON journal_lines(account_id)`,
+		`CREATE TABLE IF NOT EXISTS fiscal_years (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			name TEXT NOT NULL,
+			start_date DATETIME NOT NULL,
+			end_date DATETIME NOT NULL,
+			status TEXT NOT NULL DEFAULT 'open',
+			closed_at DATETIME,
+			closed_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (company_id) REFERENCES companies(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS fiscal_periods (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			fiscal_year_id INTEGER NOT NULL,
+			company_id INTEGER DEFAULT 1,
+			period_number INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			start_date DATETIME NOT NULL,
+			end_date DATETIME NOT NULL,
+			status TEXT NOT NULL DEFAULT 'open',
+			updated_by INTEGER,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (fiscal_year_id, period_number),
+			FOREIGN KEY (fiscal_year_id) REFERENCES fiscal_years(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_fiscal_periods_company_dates ON fiscal_periods(company_id, start_date, end_date)`,
+		`CREATE TABLE IF NOT EXISTS branches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			code TEXT NOT NULL,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			address TEXT,
+			address_arabic TEXT,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, code),
+			FOREIGN KEY (company_id) REFERENCES companies(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS expense_categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			code TEXT NOT NULL,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			vat_category TEXT,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, code),
+			FOREIGN KEY (company_id) REFERENCES companies(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS recurring_expenses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			branch_id INTEGER,
+			category_id INTEGER NOT NULL,
+			supplier_id INTEGER,
+			payee_name TEXT,
+			description TEXT NOT NULL,
+			description_arabic TEXT,
+			amount DECIMAL(10,2) NOT NULL,
+			vat_rate DECIMAL(5,2) DEFAULT 0,
+			vat_recoverable BOOLEAN DEFAULT 1,
+			payment_type_id INTEGER NOT NULL,
+			frequency TEXT NOT NULL,
+			start_date DATETIME NOT NULL,
+			end_date DATETIME,
+			next_date DATETIME NOT NULL,
+			occurrences INTEGER DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (branch_id) REFERENCES branches(id),
+			FOREIGN KEY (category_id) REFERENCES expense_categories(id),
+			FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+			FOREIGN KEY (payment_type_id) REFERENCES payment_types(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS expenses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			branch_id INTEGER,
+			expense_number TEXT NOT NULL,
+			category_id INTEGER NOT NULL,
+			supplier_id INTEGER,
+			payee_name TEXT,
+			expense_date DATETIME NOT NULL,
+			description TEXT NOT NULL,
+			description_arabic TEXT,
+			amount DECIMAL(10,2) NOT NULL,
+			vat_rate DECIMAL(5,2) DEFAULT 0,
+			vat_amount DECIMAL(10,2) DEFAULT 0,
+			vat_category TEXT,
+			vat_recoverable BOOLEAN DEFAULT 1,
+			total_amount DECIMAL(10,2) NOT NULL,
+			payment_type_id INTEGER NOT NULL,
+			reference TEXT,
+			notes TEXT,
+			notes_arabic TEXT,
+			status TEXT DEFAULT 'recorded',
+			recurring_expense_id INTEGER,
+			created_by INTEGER,
+			updated_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (company_id, expense_number),
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (branch_id) REFERENCES branches(id),
+			FOREIGN KEY (category_id) REFERENCES expense_categories(id),
+			FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+			FOREIGN KEY (payment_type_id) REFERENCES payment_types(id),
+			FOREIGN KEY (recurring_expense_id) REFERENCES recurring_expenses(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_expenses_company_date ON expenses(company_id, expense_date)`,
+		`CREATE TABLE IF NOT EXISTS stock_cost_layers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			product_id INTEGER NOT NULL,
+			purchase_invoice_id INTEGER NOT NULL,
+			received_date DATETIME NOT NULL,
+			quantity REAL NOT NULL,
+			remaining_quantity REAL NOT NULL,
+			unit_cost REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (purchase_invoice_id, product_id),
+			FOREIGN KEY (company_id) REFERENCES companies(id),
+			FOREIGN KEY (product_id) REFERENCES products(id),
+			FOREIGN KEY (purchase_invoice_id) REFERENCES purchase_invoices(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_stock_cost_layers_product ON stock_cost_layers(company_id, product_id, received_date)`,
+		`CREATE TABLE IF NOT EXISTS stock_cost_consumptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_id INTEGER DEFAULT 1,
+			sales_invoice_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			layer_id INTEGER,
+			quantity REAL NOT NULL,
+			unit_cost REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (sales_invoice_id) REFERENCES sales_invoices(id),
+			FOREIGN KEY (product_id) REFERENCES products(id),
+			FOREIGN KEY (layer_id) REFERENCES stock_cost_layers(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_stock_cost_consumptions_invoice ON stock_cost_consumptions(sales_invoice_id)`,
 	}
 
 	for _, query := range queries {
-		if _, err := d.db.Exec(query); err != nil {
+		if _, err := tx.Exec(query); err != nil {
 			return fmt.Errorf("error creating table: %v", err)
 		}
 	}
 
-	// Run migrations for existing databases
-	if err := d.runMigrations(); err != nil {
-		log.Printf("Warning: Could not run migrations: %v", err)
-	}
+	return nil
+}
 
-	// Insert default company if not exists
-	if err := d.insertDefaultCompany(); err != nil {
-		log.Printf("Warning: Could not insert default company: %v", err)
+// migrateMultiTenant applies the multi-tenant migration to databases that predate the users table
+func migrateMultiTenant(tx *sql.Tx) error {
+	var tableExists bool
+	err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='users'").Scan(&tableExists)
+	if err != nil {
+		return err
 	}
-
-	// Insert default settings data
-	if err := d.insertDefaultSettings(); err != nil {
-		log.Printf("Warning: Could not insert default settings: %v", err)
+	if tableExists {
+		return nil
 	}
 
-	return nil
-}
-
-// runMultiTenantMigration applies the multi-tenant migration
-func (d *Database) runMultiTenantMigration() error {
 	// Create users table
 	createUsersTable := `CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -317,7 +662,7 @@ func (d *Database) runMultiTenantMigration() error {
 		FOREIGN KEY (company_id) REFERENCES companies (id) ON DELETE CASCADE
 	)`
 
-	if _, err := d.db.Exec(createUsersTable); err != nil {
+	if _, err := tx.Exec(createUsersTable); err != nil {
 		return fmt.Errorf("error creating users table: %v", err)
 	}
 
@@ -333,14 +678,14 @@ func (d *Database) runMultiTenantMigration() error {
 	for _, table := range tables {
 		// Check if company_id column already exists
 		var columnExists bool
-		err := d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name='company_id'", table).Scan(&columnExists)
+		err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name='company_id'", table).Scan(&columnExists)
 		if err != nil {
 			return fmt.Errorf("error checking company_id column in %s: %v", table, err)
 		}
 
 		if !columnExists {
 			query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN company_id INTEGER DEFAULT 1", table)
-			if _, err := d.db.Exec(query); err != nil {
+			if _, err := tx.Exec(query); err != nil {
 				return fmt.Errorf("error adding company_id to %s: %v", table, err)
 			}
 		}
@@ -369,7 +714,7 @@ func (d *Database) runMultiTenantMigration() error {
 	}
 
 	for _, indexQuery := range indexes {
-		if _, err := d.db.Exec(indexQuery); err != nil {
+		if _, err := tx.Exec(indexQuery); err != nil {
 			return fmt.Errorf("error creating index: %v", err)
 		}
 	}
@@ -382,7 +727,7 @@ func (d *Database) runMultiTenantMigration() error {
 		'Admin', 'User', 'admin', 1, 1
 	)`
 
-	if _, err := d.db.Exec(createAdminUser); err != nil {
+	if _, err := tx.Exec(createAdminUser); err != nil {
 		return fmt.Errorf("error creating default admin user: %v", err)
 	}
 
@@ -406,173 +751,10 @@ func (d *Database) runMultiTenantMigration() error {
 	}
 
 	for _, updateQuery := range updateQueries {
-		if _, err := d.db.Exec(updateQuery); err != nil {
+		if _, err := tx.Exec(updateQuery); err != nil {
 			return fmt.Errorf("error updating existing data: %v", err)
 		}
 	}
 
 	return nil
 }
-
-// runMigrations handles database schema migrations for existing databases
-func (d *Database) runMigrations() error {
-	// Check if vat_rate column exists in purchase_invoices table
-	var columnExists bool
-	err := d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('purchase_invoices') WHERE name='vat_rate'").Scan(&columnExists)
-	if err != nil {
-		return err
-	}
-
-	// Add vat_rate column if it doesn't exist
-	if !columnExists {
-		_, err = d.db.Exec("ALTER TABLE purchase_invoices ADD COLUMN vat_rate REAL DEFAULT 15.0")
-		if err != nil {
-			return fmt.Errorf("error adding vat_rate column: %v", err)
-		}
-		log.Println("Added vat_rate column to purchase_invoices table")
-	}
-
-	// Check if vat_inclusive column exists in purchase_invoices table
-	columnExists = false
-	err = d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('purchase_invoices') WHERE name='vat_inclusive'").Scan(&columnExists)
-	if err != nil {
-		return err
-	}
-
-	// Add vat_inclusive column if it doesn't exist
-	if !columnExists {
-		_, err = d.db.Exec("ALTER TABLE purchase_invoices ADD COLUMN vat_inclusive BOOLEAN DEFAULT 0")
-		if err != nil {
-			return fmt.Errorf("error adding vat_inclusive column: %v", err)
-		}
-		log.Println("Added vat_inclusive column to purchase_invoices table")
-	}
-
-	// Check if table_number column exists in sales_invoices table
-	columnExists = false
-	err = d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('sales_invoices') WHERE name='table_number'").Scan(&columnExists)
-	if err != nil {
-		return err
-	}
-
-	// Add table_number column if it doesn't exist
-	if !columnExists {
-		_, err = d.db.Exec("ALTER TABLE sales_invoices ADD COLUMN table_number TEXT")
-		if err != nil {
-			return fmt.Errorf("error adding table_number column: %v", err)
-		}
-		log.Println("Added table_number column to sales_invoices table")
-	}
-
-	// Multi-tenant migration: Check if users table exists
-	var tableExists bool
-	err = d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='users'").Scan(&tableExists)
-	if err != nil {
-		return err
-	}
-
-	// Run multi-tenant migration if users table doesn't exist
-	if !tableExists {
-		if err := d.runMultiTenantMigration(); err != nil {
-			return fmt.Errorf("error running multi-tenant migration: %v", err)
-		}
-		log.Println("Successfully applied multi-tenant migration")
-	}
-
-	// Check if intro_viewed column exists in users table
-	columnExists = false
-	err = d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name='intro_viewed'").Scan(&columnExists)
-	if err != nil {
-		return err
-	}
-
-	// Add intro_viewed column if it doesn't exist
-	if !columnExists {
-		_, err = d.db.Exec("ALTER TABLE users ADD COLUMN intro_viewed BOOLEAN DEFAULT 0")
-		if err != nil {
-			return fmt.Errorf("error adding intro_viewed column: %v", err)
-		}
-		log.Println("Added intro_viewed column to users table")
-	}
-
-	// Check if created_by column exists in sales_invoices table
-	columnExists = false
-	err = d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('sales_invoices') WHERE name='created_by'").Scan(&columnExists)
-	if err != nil {
-		return err
-	}
-
-	// Add created_by and updated_by columns to sales_invoices if they don't exist
-	if !columnExists {
-		_, err = d.db.Exec("ALTER TABLE sales_invoices ADD COLUMN created_by INTEGER")
-		if err != nil {
-			return fmt.Errorf("error adding created_by column to sales_invoices: %v", err)
-		}
-		_, err = d.db.Exec("ALTER TABLE sales_invoices ADD COLUMN updated_by INTEGER")
-		if err != nil {
-			return fmt.Errorf("error adding updated_by column to sales_invoices: %v", err)
-		}
-		log.Println("Added created_by and updated_by columns to sales_invoices table")
-	}
-
-	// Check if created_by column exists in purchase_invoices table
-	columnExists = false
-	err = d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('purchase_invoices') WHERE name='created_by'").Scan(&columnExists)
-	if err != nil {
-		return err
-	}
-
-	// Add created_by and updated_by columns to purchase_invoices if they don't exist
-	if !columnExists {
-		_, err = d.db.Exec("ALTER TABLE purchase_invoices ADD COLUMN created_by INTEGER")
-		if err != nil {
-			return fmt.Errorf("error adding created_by column to purchase_invoices: %v", err)
-		}
-		_, err = d.db.Exec("ALTER TABLE purchase_invoices ADD COLUMN updated_by INTEGER")
-		if err != nil {
-			return fmt.Errorf("error adding updated_by column to purchase_invoices: %v", err)
-		}
-		log.Println("Added created_by and updated_by columns to purchase_invoices table")
-	}
-
-	// Create indexes for the new user tracking columns
-	indexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_sales_invoices_created_by ON sales_invoices(created_by)",
-		"CREATE INDEX IF NOT EXISTS idx_sales_invoices_updated_by ON sales_invoices(updated_by)",
-		"CREATE INDEX IF NOT EXISTS idx_purchase_invoices_created_by ON purchase_invoices(created_by)",
-		"CREATE INDEX IF NOT EXISTS idx_purchase_invoices_updated_by ON purchase_invoices(updated_by)",
-	}
-
-	for _, indexQuery := range indexes {
-		if _, err := d.db.Exec(indexQuery); err != nil {
-			log.Printf("Warning: Could not create index: %v", err)
-		}
-	}
-
-
-
-	// Check if logo_file_id column exists in companies table
-	columnExists = false
-	err = d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('companies') WHERE name='logo_file_id'").Scan(&columnExists)
-	if err != nil {
-		return err
-	}
-
-	// Add logo_file_id column if it doesn't exist
-	if !columnExists {
-		_, err = d.db.Exec("ALTER TABLE companies ADD COLUMN logo_file_id INTEGER")
-		if err != nil {
-			return fmt.Errorf("error adding logo_file_id column: %v", err)
-		}
-		
-		// Create index for logo_file_id
-		_, err = d.db.Exec("CREATE INDEX IF NOT EXISTS idx_companies_logo_file_id ON companies(logo_file_id)")
-		if err != nil {
-			return fmt.Errorf("error creating index for logo_file_id: %v", err)
-		}
-		
-		log.Println("Added logo_file_id column to companies table")
-	}
-
-	return nil
-}
\ No newline at end of file
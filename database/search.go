@@ -0,0 +1,217 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoFTS5 is returned when opening a database with a build whose SQLite has no full-text search. Search is
+// an FTS5 table, so such a build cannot use the database.
+var ErrNoFTS5 = errors.New("this build of DijiBill has no full-text search; build it with -tags sqlite_fts5")
+
+// checkFTS5 fails with ErrNoFTS5 unless SQLite was built with FTS5
+func checkFTS5(exec execer) error {
+	var enabled bool
+	if err := exec.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return fmt.Errorf("error checking SQLite for full-text search: %v", err)
+	}
+	if !enabled {
+		return ErrNoFTS5
+	}
+	return nil
+}
+
+// searchedTable is a table whose rows are found by Search. Its rows are kept in search_index by triggers,
+// under the rowid id * searchCodes + code so the rows of different tables do not collide.
+type searchedTable struct {
+	entity   string
+	table    string
+	code     int
+	title    string   // Column shown as the title of a result
+	subtitle string   // Column shown under the title
+	names    []string // Names and numbers, which rank above the other columns
+	content  []string
+}
+
+const searchCodes = 8
+
+var searchedTables = []searchedTable{
+	{entity: "customer", table: "customers", code: 1, title: "name", subtitle: "name_arabic",
+		names: []string{"name", "name_arabic"}, content: []string{"vat_number"}},
+	{entity: "supplier", table: "suppliers", code: 2, title: "company_name", subtitle: "company_name_arabic",
+		names: []string{"company_name", "company_name_arabic"}, content: []string{"contact_person", "contact_person_arabic", "vat_number"}},
+	{entity: "product", table: "products", code: 3, title: "name", subtitle: "name_arabic",
+		names: []string{"name", "name_arabic", "sku", "barcode"}, content: []string{"description", "description_arabic"}},
+	{entity: "sales_invoice", table: "sales_invoices", code: 4, title: "invoice_number", subtitle: "status",
+		names: []string{"invoice_number"}, content: []string{"notes", "notes_arabic"}},
+	{entity: "purchase_invoice", table: "purchase_invoices", code: 5, title: "invoice_number", subtitle: "status",
+		names: []string{"invoice_number"}, content: []string{"notes", "notes_arabic"}},
+}
+
+// arabicFolding lists the spellings of Arabic letters that search treats as the same letter, and the marks
+// it ignores: the alef forms, alef maqsura, taa marbuta, tatweel and the diacritics
+var arabicFolding = []string{
+	"أ", "ا", "إ", "ا", "آ", "ا", "ٱ", "ا",
+	"ى", "ي",
+	"ة", "ه",
+	"ـ", "",
+	"ً", "", "ٌ", "", "ٍ", "", "َ", "", "ُ", "",
+	"ِ", "", "ّ", "", "ْ", "", "ٰ", "",
+}
+
+var arabicNormalizer = strings.NewReplacer(arabicFolding...)
+
+// normalizeSearchText folds text the way search_index stores it. The Arabic definite article is split off
+// its word, so النور is found by searching نور.
+func normalizeSearchText(text string) string {
+	return strings.TrimSpace(strings.ReplaceAll(" "+strings.ToLower(arabicNormalizer.Replace(text)), " ال", " ال "))
+}
+
+// normalizeSearchSQL is the SQL equivalent of normalizeSearchText for an expression
+func normalizeSearchSQL(expr string) string {
+	for i := 0; i < len(arabicFolding); i += 2 {
+		expr = fmt.Sprintf("REPLACE(%s, '%s', '%s')", expr, arabicFolding[i], arabicFolding[i+1])
+	}
+	return fmt.Sprintf("TRIM(REPLACE(' ' || LOWER(%s), ' ال', ' ال '))", expr)
+}
+
+// searchTextSQL joins the columns of a row into the normalized text search_index matches
+func searchTextSQL(row string, columns []string) string {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		parts[i] = fmt.Sprintf("COALESCE(%s.%s, '')", row, column)
+	}
+	return normalizeSearchSQL(strings.Join(parts, " || ' ' || "))
+}
+
+// searchRowSQL selects the index row of a row of a searched table
+func (t searchedTable) searchRowSQL(row string) string {
+	return fmt.Sprintf("%[1]s.id * %[2]d + %[3]d, '%[4]s', %[1]s.id, %[1]s.company_id, COALESCE(%[1]s.%[5]s, ''), COALESCE(%[1]s.%[6]s, ''), %[7]s, %[8]s",
+		row, searchCodes, t.code, t.entity, t.title, t.subtitle, searchTextSQL(row, t.names), searchTextSQL(row, t.content))
+}
+
+const searchIndexColumns = "rowid, entity_type, entity_id, company_id, title, subtitle, names, content"
+
+// createSearchIndex creates search_index as an FTS5 table, fills it and installs the triggers that keep it
+// in sync
+func createSearchIndex(tx *sql.Tx) error {
+	if err := checkFTS5(tx); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`CREATE VIRTUAL TABLE search_index USING fts5(
+		entity_type UNINDEXED, entity_id UNINDEXED, company_id UNINDEXED, title UNINDEXED, subtitle UNINDEXED,
+		names, content, tokenize = 'unicode61')`)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range searchedTables {
+		key := fmt.Sprintf("OLD.id * %d + %d", searchCodes, t.code)
+		watched := []string{"company_id", "deleted_at"}
+		for _, column := range append(append([]string{t.title, t.subtitle}, t.names...), t.content...) {
+			if !containsString(watched, column) {
+				watched = append(watched, column)
+			}
+		}
+		statements := []string{
+			fmt.Sprintf(`INSERT INTO search_index (%s) SELECT %s FROM %s t WHERE t.deleted_at IS NULL`,
+				searchIndexColumns, t.searchRowSQL("t"), t.table),
+			fmt.Sprintf(`CREATE TRIGGER search_%[1]s_insert AFTER INSERT ON %[1]s WHEN NEW.deleted_at IS NULL BEGIN
+				INSERT INTO search_index (%[2]s) SELECT %[3]s;
+			END`, t.table, searchIndexColumns, t.searchRowSQL("NEW")),
+			fmt.Sprintf(`CREATE TRIGGER search_%[1]s_update AFTER UPDATE OF %[2]s ON %[1]s BEGIN
+				DELETE FROM search_index WHERE rowid = %[3]s;
+				INSERT INTO search_index (%[4]s) SELECT %[5]s WHERE NEW.deleted_at IS NULL;
+			END`, t.table, strings.Join(watched, ", "), key, searchIndexColumns, t.searchRowSQL("NEW")),
+			fmt.Sprintf(`CREATE TRIGGER search_%[1]s_delete AFTER DELETE ON %[1]s BEGIN
+				DELETE FROM search_index WHERE rowid = %[2]s;
+			END`, t.table, key),
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("error indexing %s for search: %v", t.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildSearchIndex replaces a search_index made as a plain table, by builds that searched with LIKE when
+// SQLite had no FTS5, with the FTS5 table
+func rebuildSearchIndex(tx *sql.Tx) error {
+	var plain bool
+	err := tx.QueryRow(`SELECT sql NOT LIKE 'CREATE VIRTUAL TABLE%' FROM sqlite_master WHERE name = 'search_index'`).Scan(&plain)
+	if err != nil || !plain {
+		return err
+	}
+	for _, t := range searchedTables {
+		for _, event := range []string{"insert", "update", "delete"} {
+			if _, err := tx.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS search_%s_%s", t.table, event)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE search_index"); err != nil {
+		return err
+	}
+	return createSearchIndex(tx)
+}
+
+// searchMatch returns the FTS5 query matching every word at the start of a word
+func searchMatch(words []string) string {
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search finds the customers, suppliers, products and invoices of a company that match every word of a
+// query, best matches first. Words match the start of words, and the spelling variants of Arabic letters
+// match each other.
+func (d *Database) Search(companyID int, query string, limit int) ([]SearchResult, error) {
+	results := []SearchResult{}
+	words := strings.Fields(normalizeSearchText(query))
+	if len(words) == 0 {
+		return results, nil
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+
+	// Names and numbers weigh ten times the other text
+	rows, err := d.db.Query(`
+		SELECT entity_type, entity_id, title, subtitle
+		FROM search_index
+		WHERE search_index MATCH ? AND company_id = ?
+		ORDER BY bm25(search_index, 0, 0, 0, 0, 0, 10, 1)
+		LIMIT ?`, searchMatch(words), companyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(&result.EntityType, &result.ID, &result.Title, &result.Subtitle); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeSearchText(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"Acme TRADING", "acme trading"},
+		{"أحمد", "احمد"},
+		{"إبراهيم", "ابراهيم"},
+		{"آمنة", "امنه"},
+		{"مصطفى", "مصطفي"},
+		{"مَدْرَسَة", "مدرسه"},
+		{"شـركة", "شركه"},
+		{"النور", "ال نور"},
+		{"مؤسسة الأمل", "مؤسسه ال امل"},
+		{"  جمال  ", "جمال"},
+	}
+	for _, test := range tests {
+		if got := normalizeSearchText(test.text); got != test.want {
+			t.Errorf("normalizeSearchText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+// searchTitles returns the titles of what a search finds, best match first
+func searchTitles(t *testing.T, d *Database, companyID int, query string) []string {
+	t.Helper()
+	results, err := d.Search(companyID, query, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, result := range results {
+		titles = append(titles, result.Title)
+	}
+	return titles
+}
+
+func TestSearch(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	statements := []string{
+		`INSERT INTO companies (name, vat_number) VALUES ('Other Company', '300000000000010')`,
+		`INSERT INTO customers (name, name_arabic, vat_number, company_id) VALUES ('Noor Trading', 'مؤسسة النور', '300000000000003', 1)`,
+		`INSERT INTO customers (name, name_arabic, company_id) VALUES ('Amal Stores', 'متاجر الأمل', 1)`,
+		`INSERT INTO customers (name, company_id) VALUES ('Noor Elsewhere', 2)`,
+		`INSERT INTO products (name, name_arabic, description, sku, unit_price, company_id) VALUES ('Dates', 'تمر', 'Boxed for Noor', 'DT-1', 20, 1)`,
+	}
+	for _, statement := range statements {
+		if _, err := d.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// A match in a name ranks above a match in the other text
+		{"noor", []string{"Noor Trading", "Dates"}},
+		{"NOO", []string{"Noor Trading", "Dates"}},
+		{"noor trad", []string{"Noor Trading"}},
+		{"نور", []string{"Noor Trading"}},
+		{"النور", []string{"Noor Trading"}},
+		{"الامل", []string{"Amal Stores"}},
+		{"مؤسسه", []string{"Noor Trading"}},
+		{"300000000000003", []string{"Noor Trading"}},
+		{"dt-1", []string{"Dates"}},
+		{`"noor`, []string{"Noor Trading", "Dates"}},
+		{"nothing", []string{}},
+		{"  ", []string{}},
+	}
+	for _, test := range tests {
+		got := searchTitles(t, d, 1, test.query)
+		if len(got) != len(test.want) {
+			t.Errorf("Search(%q) = %q, want %q", test.query, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("Search(%q) = %q, want %q", test.query, got, test.want)
+				break
+			}
+		}
+	}
+	if got := searchTitles(t, d, 2, "noor"); len(got) != 1 || got[0] != "Noor Elsewhere" {
+		t.Errorf("Search of company 2 = %q, want only its own customer", got)
+	}
+}
+
+func TestSearchIndexFollowsChanges(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	found := func(query string) bool {
+		return len(searchTitles(t, d, 1, query)) > 0
+	}
+	exec := func(statement string) {
+		t.Helper()
+		if _, err := d.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exec(`INSERT INTO customers (id, name, company_id) VALUES (500, 'Falcon Supplies', 1)`)
+	if !found("falcon") {
+		t.Error("a new customer is not found")
+	}
+	exec(`UPDATE customers SET name = 'Eagle Supplies' WHERE id = 500`)
+	if found("falcon") || !found("eagle") {
+		t.Error("a renamed customer is found by its old name or not by its new one")
+	}
+	exec(`UPDATE customers SET company_id = 2 WHERE id = 500`)
+	if found("eagle") {
+		t.Error("a customer moved to another company is still found in the first")
+	}
+	exec(`UPDATE customers SET company_id = 1, deleted_at = CURRENT_TIMESTAMP WHERE id = 500`)
+	if found("eagle") {
+		t.Error("a customer in the recycle bin is found")
+	}
+	exec(`UPDATE customers SET deleted_at = NULL WHERE id = 500`)
+	if !found("eagle") {
+		t.Error("a customer restored from the recycle bin is not found")
+	}
+	exec(`DELETE FROM customers WHERE id = 500`)
+	if found("eagle") {
+		t.Error("a deleted customer is found")
+	}
+
+	// Products and suppliers with the same id do not replace each other in the index
+	exec(`INSERT INTO products (id, name, unit_price, company_id) VALUES (500, 'Eagle Feed', 20, 1)`)
+	exec(`INSERT INTO suppliers (id, company_name, contact_person, company_id) VALUES (500, 'Eagle Farms', 'Sami', 1)`)
+	if got := searchTitles(t, d, 1, "eagle"); len(got) != 2 {
+		t.Errorf("Search(eagle) = %q, want the product and the supplier", got)
+	}
+}
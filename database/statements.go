@@ -0,0 +1,310 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ledgerDocumentOrder sorts documents of the same day: invoices first, then credit notes, payments and refunds
+var ledgerDocumentOrder = map[string]int{
+	"invoice":     0,
+	"credit_note": 1,
+	"payment":     2,
+	"refund":      3,
+}
+
+// dateOnly formats a time for comparison against DATE(...) in SQL
+func dateOnly(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// daysBetween returns the number of whole calendar days from one date to another
+func daysBetween(from, to time.Time) int {
+	fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDay.Sub(fromDay).Hours() / 24)
+}
+
+// GetCustomerLedger returns every invoice, credit note, payment and refund of a customer up to a date,
+// in date order with a running balance. Prepayment invoices are left out: the advance paid on them is
+// a credit until it is deducted on a later invoice.
+func (d *Database) GetCustomerLedger(customerID int, to time.Time) ([]CustomerLedgerEntry, error) {
+	var entries []CustomerLedgerEntry
+
+	invoiceRows, err := d.db.Query(`
+		SELECT id, invoice_number, issue_date, due_date, total_amount, COALESCE(notes, '')
+		FROM sales_invoices
+		WHERE customer_id = ? AND status NOT IN ('draft', 'cancelled') AND COALESCE(invoice_type, 'standard') != 'prepayment'
+			AND DATE(issue_date) <= DATE(?)`,
+		customerID, dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	for invoiceRows.Next() {
+		var entry CustomerLedgerEntry
+		var dueDate time.Time
+		if err := invoiceRows.Scan(&entry.DocumentID, &entry.DocumentNumber, &entry.Date, &dueDate, &entry.Debit, &entry.Reference); err != nil {
+			invoiceRows.Close()
+			return nil, err
+		}
+		entry.DocumentType = "invoice"
+		entry.Description = "Invoice " + entry.DocumentNumber
+		entry.DescriptionAr = "فاتورة " + entry.DocumentNumber
+		if !dueDate.IsZero() {
+			entry.DueDate = &dueDate
+		}
+		entries = append(entries, entry)
+	}
+	invoiceRows.Close()
+
+	creditRows, err := d.db.Query(`
+		SELECT cn.id, cn.credit_note_number, cn.issue_date, cn.total_amount, COALESCE(si.invoice_number, '')
+		FROM credit_notes cn
+		LEFT JOIN sales_invoices si ON cn.invoice_id = si.id
+		WHERE cn.customer_id = ? AND cn.status = 'issued' AND DATE(cn.issue_date) <= DATE(?)`,
+		customerID, dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	for creditRows.Next() {
+		var entry CustomerLedgerEntry
+		if err := creditRows.Scan(&entry.DocumentID, &entry.DocumentNumber, &entry.Date, &entry.Credit, &entry.Reference); err != nil {
+			creditRows.Close()
+			return nil, err
+		}
+		entry.DocumentType = "credit_note"
+		entry.Description = "Credit note " + entry.DocumentNumber
+		entry.DescriptionAr = "إشعار دائن " + entry.DocumentNumber
+		entries = append(entries, entry)
+	}
+	creditRows.Close()
+
+	paymentRows, err := d.db.Query(`
+		SELECT p.id, si.invoice_number, p.payment_date, p.amount, COALESCE(p.reference, ''),
+			COALESCE(pt.name, ''), COALESCE(pt.name_arabic, ''), COALESCE(si.invoice_type, 'standard')
+		FROM payments p
+		JOIN sales_invoices si ON p.invoice_id = si.id
+		LEFT JOIN payment_types pt ON p.payment_type_id = pt.id
+		WHERE si.customer_id = ? AND p.status = 'completed' AND p.deleted_at IS NULL AND DATE(p.payment_date) <= DATE(?)`,
+		customerID, dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	for paymentRows.Next() {
+		var entry CustomerLedgerEntry
+		var paymentType, paymentTypeArabic, invoiceType string
+		if err := paymentRows.Scan(&entry.DocumentID, &entry.DocumentNumber, &entry.Date, &entry.Credit, &entry.Reference, &paymentType, &paymentTypeArabic, &invoiceType); err != nil {
+			paymentRows.Close()
+			return nil, err
+		}
+		entry.DocumentType = "payment"
+		if invoiceType == "prepayment" {
+			entry.Description = fmt.Sprintf("Advance payment (%s), prepayment invoice %s", paymentType, entry.DocumentNumber)
+			entry.DescriptionAr = fmt.Sprintf("دفعة مقدمة (%s)، فاتورة الدفعة المقدمة %s", paymentTypeArabic, entry.DocumentNumber)
+		} else {
+			entry.Description = fmt.Sprintf("Payment (%s) for %s", paymentType, entry.DocumentNumber)
+			entry.DescriptionAr = fmt.Sprintf("دفعة (%s) للفاتورة %s", paymentTypeArabic, entry.DocumentNumber)
+		}
+		entries = append(entries, entry)
+	}
+	paymentRows.Close()
+
+	refundRows, err := d.db.Query(`
+		SELECT cct.id, cct.transaction_date, -SUM(cct.amount), COALESCE(cct.reference, ''), COALESCE(pt.name, ''), COALESCE(pt.name_arabic, '')
+		FROM customer_credit_transactions cct
+		LEFT JOIN payment_types pt ON cct.payment_type_id = pt.id
+		WHERE cct.customer_id = ? AND cct.type = 'refund' AND DATE(cct.transaction_date) <= DATE(?)
+		GROUP BY cct.transaction_date, cct.payment_type_id, cct.reference`,
+		customerID, dateOnly(to))
+	if err != nil {
+		return nil, err
+	}
+	for refundRows.Next() {
+		var entry CustomerLedgerEntry
+		var paymentType, paymentTypeArabic string
+		if err := refundRows.Scan(&entry.DocumentID, &entry.Date, &entry.Debit, &entry.Reference, &paymentType, &paymentTypeArabic); err != nil {
+			refundRows.Close()
+			return nil, err
+		}
+		entry.DocumentType = "refund"
+		entry.DocumentNumber = entry.Reference
+		entry.Description = fmt.Sprintf("Refund of credit (%s)", paymentType)
+		entry.DescriptionAr = fmt.Sprintf("استرداد رصيد دائن (%s)", paymentTypeArabic)
+		entries = append(entries, entry)
+	}
+	refundRows.Close()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Date.Equal(entries[j].Date) {
+			return entries[i].Date.Before(entries[j].Date)
+		}
+		if entries[i].DocumentType != entries[j].DocumentType {
+			return ledgerDocumentOrder[entries[i].DocumentType] < ledgerDocumentOrder[entries[j].DocumentType]
+		}
+		return entries[i].DocumentID < entries[j].DocumentID
+	})
+
+	var balance float64
+	for i := range entries {
+		balance += entries[i].Debit - entries[i].Credit
+		entries[i].Balance = balance
+	}
+
+	return entries, nil
+}
+
+// GetCustomerStatement builds a statement of account for a customer. A zero from date
+// starts the statement at the first document; the aging is calculated as of the to date.
+func (d *Database) GetCustomerStatement(customerID int, from, to time.Time) (*CustomerStatement, error) {
+	customer, err := d.GetCustomerByID(customerID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting customer: %v", err)
+	}
+
+	ledger, err := d.GetCustomerLedger(customerID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &CustomerStatement{
+		Customer:  customer,
+		PeriodEnd: to,
+		Entries:   []CustomerLedgerEntry{},
+	}
+	if !from.IsZero() {
+		statement.PeriodStart = &from
+	}
+
+	for _, entry := range ledger {
+		if !from.IsZero() && daysBetween(from, entry.Date) < 0 {
+			statement.OpeningBalance = entry.Balance
+			continue
+		}
+		statement.Entries = append(statement.Entries, entry)
+		statement.TotalDebits += entry.Debit
+		statement.TotalCredits += entry.Credit
+	}
+	statement.ClosingBalance = statement.OpeningBalance + statement.TotalDebits - statement.TotalCredits
+
+	aging, err := d.receivablesAging(customer.CompanyID, customerID, to)
+	if err != nil {
+		return nil, err
+	}
+	statement.Aging = aging.Totals
+
+	return statement, nil
+}
+
+// GetReceivablesAging returns the outstanding balance of every customer of a company,
+// split by days past due as of the given date
+func (d *Database) GetReceivablesAging(companyID int, asOf time.Time) (*ReceivablesAgingReport, error) {
+	return d.receivablesAging(companyID, 0, asOf)
+}
+
+// receivablesAging calculates receivables aging for a company, optionally limited to one customer.
+// Overpayments, unallocated credit notes and advances are reported as the customer's credit balance.
+func (d *Database) receivablesAging(companyID, customerID int, asOf time.Time) (*ReceivablesAgingReport, error) {
+	query := `
+		SELECT si.customer_id, COALESCE(c.name, ''), COALESCE(c.name_arabic, ''),
+			si.issue_date, si.due_date, si.total_amount,
+			COALESCE((SELECT SUM(p.amount) FROM payments p
+				WHERE p.invoice_id = si.id AND p.status = 'completed' AND p.deleted_at IS NULL AND DATE(p.payment_date) <= DATE(?)), 0),
+			COALESCE((SELECT SUM(cn.total_amount) FROM credit_notes cn
+				WHERE cn.invoice_id = si.id AND cn.status = 'issued' AND DATE(cn.issue_date) <= DATE(?)), 0)
+			- COALESCE((SELECT SUM(cct.amount) FROM customer_credit_transactions cct
+				WHERE cct.invoice_id = si.id AND cct.type = 'applied' AND DATE(cct.transaction_date) <= DATE(?)), 0)
+		FROM sales_invoices si
+		LEFT JOIN customers c ON si.customer_id = c.id
+		WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+			AND DATE(si.issue_date) <= DATE(?) AND (? = 0 OR si.customer_id = ?)`
+
+	asOfDate := dateOnly(asOf)
+	rows, err := d.db.Query(query, asOfDate, asOfDate, asOfDate, companyID, asOfDate, customerID, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rowsByCustomer := make(map[int]*ReceivableAgingRow)
+	getRow := func(id int, name, nameArabic string) *ReceivableAgingRow {
+		row, ok := rowsByCustomer[id]
+		if !ok {
+			if id == 0 {
+				name, nameArabic = "Walk-in Customer", "عميل عادي"
+			}
+			row = &ReceivableAgingRow{CustomerID: id, CustomerName: name, CustomerNameArabic: nameArabic}
+			rowsByCustomer[id] = row
+		}
+		return row
+	}
+
+	for rows.Next() {
+		var id int
+		var name, nameArabic string
+		var issueDate, dueDate time.Time
+		var total, paid, credited float64
+		if err := rows.Scan(&id, &name, &nameArabic, &issueDate, &dueDate, &total, &paid, &credited); err != nil {
+			return nil, err
+		}
+
+		// Anything paid or credited beyond the total is held in the customer's credit balance
+		outstanding := total - paid - credited
+		if outstanding < 0.005 {
+			continue
+		}
+
+		row := getRow(id, name, nameArabic)
+
+		if dueDate.IsZero() {
+			dueDate = issueDate
+		}
+		row.Buckets.Add(daysBetween(dueDate, asOf), outstanding)
+		row.OpenInvoices++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	creditRows, err := d.db.Query(`
+		SELECT cct.customer_id, COALESCE(c.name, ''), COALESCE(c.name_arabic, ''), SUM(cct.amount)
+		FROM customer_credit_transactions cct
+		LEFT JOIN customers c ON cct.customer_id = c.id
+		WHERE cct.company_id = ? AND DATE(cct.transaction_date) <= DATE(?) AND (? = 0 OR cct.customer_id = ?)
+		GROUP BY cct.customer_id`, companyID, asOfDate, customerID, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer creditRows.Close()
+
+	for creditRows.Next() {
+		var id int
+		var name, nameArabic string
+		var amount float64
+		if err := creditRows.Scan(&id, &name, &nameArabic, &amount); err != nil {
+			return nil, err
+		}
+		if amount < 0.005 {
+			continue
+		}
+		getRow(id, name, nameArabic).UnappliedCredits += amount
+	}
+	if err := creditRows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &ReceivablesAgingReport{AsOf: asOf, Rows: []ReceivableAgingRow{}}
+	for _, row := range rowsByCustomer {
+		row.NetBalance = row.Buckets.Total - row.UnappliedCredits
+		report.Totals.Merge(row.Buckets)
+		report.UnappliedCredits += row.UnappliedCredits
+		report.Rows = append(report.Rows, *row)
+	}
+	report.NetBalance = report.Totals.Total - report.UnappliedCredits
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		return report.Rows[i].CustomerName < report.Rows[j].CustomerName
+	})
+
+	return report, nil
+}
@@ -0,0 +1,581 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Costing methods of a company: how the stock sold on an invoice is costed from the purchase invoices it
+// was received on
+const (
+	CostingWeightedAverage = "weighted_average"
+	CostingFIFO            = "fifo"
+
+	MarginByInvoice = "invoice"
+)
+
+// quantityTolerance absorbs floating point noise when comparing stock quantities
+const quantityTolerance = 0.000001
+
+func validateCostingMethod(method string) error {
+	if method != CostingWeightedAverage && method != CostingFIFO {
+		return fmt.Errorf("invalid costing method %q", method)
+	}
+	return nil
+}
+
+func companyCostingMethod(exec execer, companyID int) (string, error) {
+	var method string
+	err := exec.QueryRow(`SELECT COALESCE(costing_method, 'weighted_average') FROM companies WHERE id = ?`, companyID).Scan(&method)
+	if err == sql.ErrNoRows {
+		return CostingWeightedAverage, nil
+	}
+	return method, err
+}
+
+// syncStockCostLayers keeps one cost layer per product received on a purchase invoice. A layer is added when
+// the invoice is received and removed when it is no longer received or deleted; stock already sold from a
+// layer cannot be taken away.
+func syncStockCostLayers(exec execer, purchaseInvoiceID int) error {
+	type layer struct {
+		id                  int
+		quantity, remaining float64
+	}
+	existing := make(map[int]layer)
+	rows, err := exec.Query(`SELECT id, product_id, quantity, remaining_quantity FROM stock_cost_layers WHERE purchase_invoice_id = ?`, purchaseInvoiceID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l layer
+		var productID int
+		if err := rows.Scan(&l.id, &productID, &l.quantity, &l.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[productID] = l
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var companyID int
+	var status string
+	var receivedAt time.Time
+	number := fmt.Sprint(purchaseInvoiceID) // Kept when the invoice was deleted
+	err = exec.QueryRow(`SELECT COALESCE(company_id, 1), invoice_number, COALESCE(status, 'draft'), updated_at FROM purchase_invoices WHERE id = ?`, purchaseInvoiceID).
+		Scan(&companyID, &number, &status, &receivedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	type receipt struct {
+		productID      int
+		quantity, cost float64
+	}
+	var received []receipt
+	if status == "received" || status == "partially_paid" || status == "paid" {
+		rows, err := exec.Query(`
+			SELECT product_id, SUM(quantity), SUM(total_amount - vat_amount) FROM purchase_invoice_items
+			WHERE invoice_id = ? AND product_id > 0 GROUP BY product_id`, purchaseInvoiceID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var r receipt
+			if err := rows.Scan(&r.productID, &r.quantity, &r.cost); err != nil {
+				rows.Close()
+				return err
+			}
+			if r.quantity > quantityTolerance {
+				received = append(received, r)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range received {
+		unitCost := r.cost / r.quantity
+		l, ok := existing[r.productID]
+		if !ok {
+			_, err := exec.Exec(`
+				INSERT INTO stock_cost_layers (company_id, product_id, purchase_invoice_id, received_date, quantity, remaining_quantity, unit_cost)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`, companyID, r.productID, purchaseInvoiceID, receivedAt, r.quantity, r.quantity, unitCost)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		delete(existing, r.productID)
+		sold := l.quantity - l.remaining
+		if r.quantity < sold-quantityTolerance {
+			return fmt.Errorf("%g of the stock received on purchase invoice %s has already been sold", sold, number)
+		}
+		_, err := exec.Exec(`UPDATE stock_cost_layers SET quantity = ?, remaining_quantity = ?, unit_cost = ? WHERE id = ?`,
+			r.quantity, r.quantity-sold, unitCost, l.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, l := range existing {
+		if l.quantity-l.remaining > quantityTolerance {
+			return fmt.Errorf("stock received on purchase invoice %s has already been sold", number)
+		}
+		if _, err := exec.Exec(`DELETE FROM stock_cost_layers WHERE id = ?`, l.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// costSalesInvoice takes the stock sold on an issued sales invoice from the cost layers and stamps its cost
+// on the invoice items. Products whose quantity did not change keep the cost they were first issued at.
+func costSalesInvoice(exec execer, invoiceID int) error {
+	var companyID int
+	var status, invoiceType string
+	err := exec.QueryRow(`SELECT COALESCE(company_id, 1), COALESCE(status, 'draft'), COALESCE(invoice_type, 'standard') FROM sales_invoices WHERE id = ?`, invoiceID).
+		Scan(&companyID, &status, &invoiceType)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	issued := err == nil && status != "draft" && status != "cancelled" && invoiceType != "prepayment"
+
+	sold := make(map[int]float64)
+	if issued {
+		rows, err := exec.Query(`SELECT product_id, SUM(quantity) FROM sales_invoice_items WHERE invoice_id = ? AND product_id > 0 GROUP BY product_id`, invoiceID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var productID int
+			var quantity float64
+			if err := rows.Scan(&productID, &quantity); err != nil {
+				rows.Close()
+				return err
+			}
+			if quantity > quantityTolerance {
+				sold[productID] = quantity
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	type consumed struct{ quantity, cost float64 }
+	costs := make(map[int]consumed)
+	rows, err := exec.Query(`SELECT product_id, SUM(quantity), SUM(quantity * unit_cost) FROM stock_cost_consumptions WHERE sales_invoice_id = ? GROUP BY product_id`, invoiceID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var productID int
+		var c consumed
+		if err := rows.Scan(&productID, &c.quantity, &c.cost); err != nil {
+			rows.Close()
+			return err
+		}
+		costs[productID] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var method string
+	for productID, c := range costs {
+		if quantity, ok := sold[productID]; ok && math.Abs(quantity-c.quantity) <= quantityTolerance {
+			continue
+		}
+		if err := releaseStockCost(exec, invoiceID, productID); err != nil {
+			return err
+		}
+		delete(costs, productID)
+	}
+	for productID, quantity := range sold {
+		if _, ok := costs[productID]; ok {
+			continue
+		}
+		if method == "" {
+			if method, err = companyCostingMethod(exec, companyID); err != nil {
+				return err
+			}
+		}
+		cost, err := consumeStockCost(exec, companyID, invoiceID, productID, quantity, method)
+		if err != nil {
+			return err
+		}
+		costs[productID] = consumed{quantity, cost}
+	}
+
+	if _, err := exec.Exec(`UPDATE sales_invoice_items SET unit_cost = 0, cost_amount = 0 WHERE invoice_id = ?`, invoiceID); err != nil {
+		return err
+	}
+	for productID, c := range costs {
+		unitCost := c.cost / c.quantity
+		_, err := exec.Exec(`UPDATE sales_invoice_items SET unit_cost = ?, cost_amount = ROUND(quantity * ?, 2) WHERE invoice_id = ? AND product_id = ?`,
+			unitCost, unitCost, invoiceID, productID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeStockCost takes the quantity of a product sold on an invoice from its cost layers and returns the
+// cost. FIFO takes the oldest layers first; weighted average takes from every layer in proportion to what
+// is left of it, so the average cost of the remaining stock does not change. Stock sold beyond what was
+// received is costed at the latest purchase cost.
+func consumeStockCost(exec execer, companyID, invoiceID, productID int, quantity float64, method string) (float64, error) {
+	type layer struct {
+		id                  int
+		remaining, unitCost float64
+	}
+	var layers []layer
+	rows, err := exec.Query(`
+		SELECT id, remaining_quantity, unit_cost FROM stock_cost_layers
+		WHERE company_id = ? AND product_id = ? AND remaining_quantity > ?
+		ORDER BY received_date, id`, companyID, productID, quantityTolerance)
+	if err != nil {
+		return 0, err
+	}
+	var available float64
+	for rows.Next() {
+		var l layer
+		if err := rows.Scan(&l.id, &l.remaining, &l.unitCost); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		available += l.remaining
+		layers = append(layers, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var cost float64
+	left := quantity
+	for _, l := range layers {
+		if left <= quantityTolerance {
+			break
+		}
+		take := math.Min(l.remaining, left)
+		if method == CostingWeightedAverage && available > quantity {
+			take = l.remaining * quantity / available
+		}
+		if _, err := exec.Exec(`UPDATE stock_cost_layers SET remaining_quantity = MAX(remaining_quantity - ?, 0) WHERE id = ?`, take, l.id); err != nil {
+			return 0, err
+		}
+		_, err := exec.Exec(`INSERT INTO stock_cost_consumptions (company_id, sales_invoice_id, product_id, layer_id, quantity, unit_cost) VALUES (?, ?, ?, ?, ?, ?)`,
+			companyID, invoiceID, productID, l.id, take, l.unitCost)
+		if err != nil {
+			return 0, err
+		}
+		cost += take * l.unitCost
+		left -= take
+	}
+
+	if left > quantityTolerance {
+		var unitCost float64
+		err := exec.QueryRow(`SELECT unit_cost FROM stock_cost_layers WHERE company_id = ? AND product_id = ? ORDER BY received_date DESC, id DESC LIMIT 1`,
+			companyID, productID).Scan(&unitCost)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, err
+		}
+		_, err = exec.Exec(`INSERT INTO stock_cost_consumptions (company_id, sales_invoice_id, product_id, layer_id, quantity, unit_cost) VALUES (?, ?, ?, NULL, ?, ?)`,
+			companyID, invoiceID, productID, left, unitCost)
+		if err != nil {
+			return 0, err
+		}
+		cost += left * unitCost
+	}
+	return cost, nil
+}
+
+// releaseStockCost returns the stock of a product taken by a sales invoice to the layers it came from
+func releaseStockCost(exec execer, invoiceID, productID int) error {
+	_, err := exec.Exec(`
+		UPDATE stock_cost_layers SET remaining_quantity = remaining_quantity + (
+			SELECT SUM(c.quantity) FROM stock_cost_consumptions c
+			WHERE c.layer_id = stock_cost_layers.id AND c.sales_invoice_id = ? AND c.product_id = ?)
+		WHERE id IN (SELECT layer_id FROM stock_cost_consumptions WHERE sales_invoice_id = ? AND product_id = ? AND layer_id IS NOT NULL)`,
+		invoiceID, productID, invoiceID, productID)
+	if err != nil {
+		return err
+	}
+	_, err = exec.Exec(`DELETE FROM stock_cost_consumptions WHERE sales_invoice_id = ? AND product_id = ?`, invoiceID, productID)
+	return err
+}
+
+// closedSalesInvoicesSQL selects the sales invoices of a company dated in a closed or locked fiscal period,
+// whose costs can no longer be posted. It takes the company.
+const closedSalesInvoicesSQL = `
+	SELECT si.id, si.invoice_number, si.issue_date, COALESCE(si.status, 'draft'), fp.name, fp.status
+	FROM sales_invoices si
+	JOIN fiscal_periods fp ON fp.company_id = si.company_id
+		AND DATE(fp.start_date) <= DATE(si.issue_date) AND DATE(fp.end_date) >= DATE(si.issue_date)
+	WHERE si.company_id = ? AND fp.status != 'open'`
+
+// RecalculateStockCosts rebuilds the cost layers of a company from its received purchase invoices and costs
+// every issued sales invoice again in the order they were issued, e.g. after changing the costing method or
+// for invoices issued before costs were tracked. Changed costs are posted to the general ledger.
+// Sales invoices dated in a closed or locked fiscal period keep their costs and the stock they took, and
+// the issued ones are reported as skipped.
+func (d *Database) RecalculateStockCosts(companyID int) (*StockCostRecalculation, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &StockCostRecalculation{Skipped: []SkippedCostInvoice{}}
+	closed := make(map[int]bool)
+	rows, err := tx.Query(closedSalesInvoicesSQL+` ORDER BY si.issue_date, si.id`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var invoice SkippedCostInvoice
+		var status string
+		if err := rows.Scan(&invoice.ID, &invoice.InvoiceNumber, &invoice.IssueDate, &status, &invoice.Period, &invoice.PeriodStatus); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		closed[invoice.ID] = true
+		if status != "draft" {
+			result.Skipped = append(result.Skipped, invoice)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM stock_cost_consumptions WHERE company_id = ?
+		AND sales_invoice_id NOT IN (SELECT id FROM (`+closedSalesInvoicesSQL+`))`, companyID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+		UPDATE stock_cost_layers SET remaining_quantity = quantity - COALESCE((
+			SELECT SUM(c.quantity) FROM stock_cost_consumptions c WHERE c.layer_id = stock_cost_layers.id), 0)
+		WHERE company_id = ?`, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	purchaseInvoices, err := queryIDs(tx, `SELECT id FROM purchase_invoices WHERE company_id = ? ORDER BY id`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range purchaseInvoices {
+		if err := syncStockCostLayers(tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	salesInvoices, err := queryIDs(tx, `SELECT id FROM sales_invoices WHERE company_id = ? ORDER BY issue_date, id`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range salesInvoices {
+		if closed[id] {
+			continue
+		}
+		if err := costSalesInvoice(tx, id); err != nil {
+			return nil, err
+		}
+		if err := postDocument(tx, "sales_cost", id); err != nil {
+			return nil, err
+		}
+		result.Recosted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// queryIDs returns the ids selected by a query
+func queryIDs(exec execer, query string, args ...interface{}) ([]int, error) {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// marginLinesSQL selects the items of the issued sales invoices of a company in a period with their revenue
+// and the cost stamped on them. It takes the company, from and to dates twice.
+const marginLinesSQL = `
+	SELECT si.id AS invoice_id, si.invoice_number AS invoice_number, si.issue_date AS issue_date, COALESCE(si.customer_id, 0) AS customer_id,
+		COALESCE(sii.product_id, 0) AS product_id, sii.quantity AS quantity, sii.total_amount - sii.vat_amount AS net_amount, COALESCE(sii.cost_amount, 0) AS cost_amount
+	FROM sales_invoices si
+	JOIN sales_invoice_items sii ON sii.invoice_id = si.id
+	WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+		AND DATE(si.issue_date) >= DATE(?) AND DATE(si.issue_date) <= DATE(?)
+	UNION ALL
+	SELECT si.id, si.invoice_number, si.issue_date, COALESCE(si.customer_id, 0), 0, 0, si.total_amount - si.vat_amount, 0
+	FROM sales_invoices si
+	WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND COALESCE(si.invoice_type, 'standard') != 'prepayment'
+		AND DATE(si.issue_date) >= DATE(?) AND DATE(si.issue_date) <= DATE(?)
+		AND NOT EXISTS (SELECT 1 FROM sales_invoice_items WHERE invoice_id = si.id)`
+
+const marginTotalsSQL = `
+	COUNT(DISTINCT l.invoice_id), COALESCE(SUM(l.quantity), 0), COALESCE(SUM(l.net_amount), 0), COALESCE(SUM(l.cost_amount), 0)`
+
+func marginLineArgs(companyID int, from, to time.Time) []interface{} {
+	return []interface{}{companyID, dateOnly(from), dateOnly(to), companyID, dateOnly(from), dateOnly(to)}
+}
+
+// finish rounds the revenue and cost of a margin row and works out its margin
+func (r *MarginRow) finish() {
+	r.Revenue = roundAmount(r.Revenue)
+	r.Cost = roundAmount(r.Cost)
+	r.GrossMargin = roundAmount(r.Revenue - r.Cost)
+	r.MarginPercent = 0
+	if r.Revenue != 0 {
+		r.MarginPercent = roundAmount(r.GrossMargin * 100 / r.Revenue)
+	}
+}
+
+// GetGrossMargin reports the revenue, cost of goods sold and gross margin of the sales invoices of a company
+// between two dates by product, product category, invoice, day, week or month. Credit notes are left out as
+// returned stock is not costed back.
+func (d *Database) GetGrossMargin(companyID int, from, to time.Time, dimension string) (*GrossMarginReport, error) {
+	method, err := companyCostingMethod(d.db, companyID)
+	if err != nil {
+		return nil, err
+	}
+	report := &GrossMarginReport{CompanyID: companyID, From: from, To: to, Dimension: dimension, CostingMethod: method, Rows: []MarginRow{}}
+
+	err = d.db.QueryRow(`SELECT `+marginTotalsSQL+` FROM (`+marginLinesSQL+`) l`, marginLineArgs(companyID, from, to)...).
+		Scan(&report.Totals.InvoiceCount, &report.Totals.Quantity, &report.Totals.Revenue, &report.Totals.Cost)
+	if err != nil {
+		return nil, err
+	}
+	report.Totals.Label, report.Totals.LabelArabic = "Total", "الإجمالي"
+	report.Totals.finish()
+
+	switch dimension {
+	case GroupByDay, GroupByWeek, GroupByMonth:
+		report.Rows, err = d.marginByPeriod(companyID, from, to, dimension)
+		return report, err
+	}
+
+	var key, joins, label, labelArabic, fallback, fallbackArabic, order string
+	switch dimension {
+	case SalesByProduct, SalesByProductCategory:
+		breakdown := salesBreakdowns[dimension]
+		key, joins, label, labelArabic = breakdown.key, breakdown.joins, breakdown.label, breakdown.labelArabic
+		fallback, fallbackArabic = breakdown.fallback, breakdown.fallbackArabic
+		order = `SUM(l.net_amount) - SUM(l.cost_amount) DESC`
+	case MarginByInvoice:
+		key, joins, label, labelArabic = "l.invoice_id", "LEFT JOIN customers x ON x.id = l.customer_id",
+			"l.invoice_number || COALESCE(' - ' || x.name, '')", "l.invoice_number || COALESCE(' - ' || x.name_arabic, '')"
+		order = `MAX(l.issue_date), l.invoice_id`
+	default:
+		return nil, fmt.Errorf("invalid gross margin breakdown %q", dimension)
+	}
+
+	rows, err := d.db.Query(`
+		SELECT `+key+`, COALESCE(MAX(`+label+`), ''), COALESCE(MAX(`+labelArabic+`), ''), DATE(MAX(l.issue_date)), `+marginTotalsSQL+`
+		FROM (`+marginLinesSQL+`) l
+		`+joins+`
+		GROUP BY `+key+`
+		ORDER BY `+order, marginLineArgs(companyID, from, to)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r MarginRow
+		var day string
+		if err := rows.Scan(&r.ID, &r.Label, &r.LabelArabic, &day, &r.InvoiceCount, &r.Quantity, &r.Revenue, &r.Cost); err != nil {
+			return nil, err
+		}
+		if dimension == MarginByInvoice {
+			date, err := time.Parse("2006-01-02", day)
+			if err != nil {
+				return nil, err
+			}
+			r.Date = &date
+		}
+		if r.ID == 0 || r.Label == "" {
+			r.Label, r.LabelArabic = fallback, fallbackArabic
+		}
+		r.finish()
+		report.Rows = append(report.Rows, r)
+	}
+	return report, rows.Err()
+}
+
+// marginByPeriod reports gross margin by day, week or month, including periods without sales
+func (d *Database) marginByPeriod(companyID int, from, to time.Time, groupBy string) ([]MarginRow, error) {
+	periods := salesPeriods(from, to, groupBy)
+	result := make([]MarginRow, len(periods))
+	index := make(map[time.Time]int, len(periods))
+	for i, p := range periods {
+		start := p.Start
+		result[i] = MarginRow{Label: p.Label, LabelArabic: p.Label, Date: &start}
+		index[salesPeriodStart(p.Start, groupBy)] = i
+	}
+
+	rows, err := d.db.Query(`
+		SELECT DATE(l.issue_date), `+marginTotalsSQL+`
+		FROM (`+marginLinesSQL+`) l
+		GROUP BY DATE(l.issue_date)`, marginLineArgs(companyID, from, to)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day string
+		var r MarginRow
+		if err := rows.Scan(&day, &r.InvoiceCount, &r.Quantity, &r.Revenue, &r.Cost); err != nil {
+			return nil, err
+		}
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[salesPeriodStart(date, groupBy)]
+		if !ok {
+			continue
+		}
+		result[i].InvoiceCount += r.InvoiceCount
+		result[i].Quantity += r.Quantity
+		result[i].Revenue += r.Revenue
+		result[i].Cost += r.Cost
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		result[i].finish()
+	}
+	return result, nil
+}
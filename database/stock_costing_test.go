@@ -0,0 +1,100 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// itemCosts returns the cost stamped on the items of each sales invoice by invoice number
+func itemCosts(t *testing.T, d *Database) map[string]float64 {
+	t.Helper()
+	rows, err := d.db.Query(`SELECT si.invoice_number, COALESCE(SUM(sii.cost_amount), 0) FROM sales_invoices si
+		JOIN sales_invoice_items sii ON sii.invoice_id = si.id GROUP BY si.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	costs := make(map[string]float64)
+	for rows.Next() {
+		var number string
+		var cost float64
+		if err := rows.Scan(&number, &cost); err != nil {
+			t.Fatal(err)
+		}
+		costs[number] = cost
+	}
+	return costs
+}
+
+func TestRecalculateStockCostsSkipsClosedPeriods(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	statements := []string{
+		`INSERT INTO suppliers (id, company_name, contact_person, company_id) VALUES (1, 'Supplier', 'Sami', 1)`,
+		`INSERT INTO customers (id, name, company_id) VALUES (1, 'Customer', 1)`,
+		`INSERT INTO products (id, name, unit_price, company_id) VALUES (1, 'Dates', 50, 1)`,
+		`INSERT INTO purchase_invoices (id, company_id, invoice_number, supplier_id, issue_date, sub_total, vat_amount, total_amount, status)
+			VALUES (1, 1, 'PI-1', 1, '2024-01-02', 100, 0, 100, 'received')`,
+		`INSERT INTO purchase_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
+			VALUES (1, 1, 10, 10, 0, 0, 100)`,
+		`INSERT INTO sales_invoices (id, company_id, invoice_number, customer_id, sales_category_id, issue_date, due_date, sub_total, vat_amount, total_amount, status)
+			VALUES (1, 1, 'JAN', 1, 1, '2024-01-10', '2024-01-10', 100, 0, 100, 'sent'),
+				(2, 1, 'MAR', 1, 1, '2024-03-10', '2024-03-10', 150, 0, 150, 'sent'),
+				(3, 1, 'DRAFT', 1, 1, '2024-01-20', '2024-01-20', 50, 0, 50, 'draft')`,
+		`INSERT INTO sales_invoice_items (invoice_id, product_id, quantity, unit_price, vat_rate, vat_amount, total_amount)
+			VALUES (1, 1, 2, 50, 0, 0, 100), (2, 1, 3, 50, 0, 0, 150), (3, 1, 1, 50, 0, 0, 50)`,
+	}
+	for _, statement := range statements {
+		if _, err := d.db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := d.RecalculateStockCosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Recosted != 3 || len(result.Skipped) != 0 {
+		t.Errorf("recalculation %+v, want every invoice costed", result)
+	}
+	if costs := itemCosts(t, d); costs["JAN"] != 20 || costs["MAR"] != 30 || costs["DRAFT"] != 0 {
+		t.Fatalf("costs %v, want JAN 20 and MAR 30", costs)
+	}
+
+	year := &FiscalYear{CompanyID: 1, StartDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := d.CreateFiscalYear(year); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetFiscalPeriodStatus(year.Periods[0].ID, FiscalPeriodClosed, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The stock turns out to have cost twice as much
+	if _, err := d.db.Exec(`UPDATE purchase_invoice_items SET unit_price = 20, total_amount = 200`); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = d.RecalculateStockCosts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Recosted != 1 || len(result.Skipped) != 1 {
+		t.Fatalf("recalculation %+v, want MAR costed and JAN skipped", result)
+	}
+	skipped := result.Skipped[0]
+	if skipped.InvoiceNumber != "JAN" || skipped.Period != year.Periods[0].Name || skipped.PeriodStatus != FiscalPeriodClosed ||
+		skipped.IssueDate.Format("2006-01-02") != "2024-01-10" {
+		t.Errorf("skipped %+v, want JAN in closed period %s", skipped, year.Periods[0].Name)
+	}
+	if costs := itemCosts(t, d); costs["JAN"] != 20 || costs["MAR"] != 60 {
+		t.Errorf("costs %v, want JAN kept at 20 and MAR at the new cost of 60", costs)
+	}
+
+	// The stock taken by the skipped invoice stays taken
+	var remaining float64
+	if err := d.db.QueryRow(`SELECT remaining_quantity FROM stock_cost_layers WHERE purchase_invoice_id = 1`).Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 5 {
+		t.Errorf("%g left in stock, want 5", remaining)
+	}
+}
@@ -0,0 +1,401 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// purchaseInvoicePaidAmountSQL sums the completed, undeleted supplier payments allocated to the purchase invoice aliased pi
+const purchaseInvoicePaidAmountSQL = `COALESCE((SELECT SUM(spa.amount) FROM supplier_payment_allocations spa
+	JOIN supplier_payments sp ON spa.supplier_payment_id = sp.id
+	WHERE spa.purchase_invoice_id = pi.id AND sp.status = 'completed' AND sp.deleted_at IS NULL), 0)`
+
+// PaymentTermsDays returns the number of days a supplier allows for payment, e.g. 30 for "net_30".
+// Cash on delivery, advance payment and unknown terms are due immediately.
+func PaymentTermsDays(terms string) int {
+	if strings.HasPrefix(terms, "net_") {
+		if days, err := strconv.Atoi(strings.TrimPrefix(terms, "net_")); err == nil && days > 0 {
+			return days
+		}
+	}
+	return 0
+}
+
+// refreshPurchaseInvoiceStatus derives the status of a received purchase invoice from its allocated payments,
+// moving it between received, partially paid and paid. Invoices in any other status keep it.
+func refreshPurchaseInvoiceStatus(tx *sql.Tx, invoiceID int) error {
+	var status string
+	var total, paid float64
+	err := tx.QueryRow(`SELECT pi.status, pi.total_amount, `+purchaseInvoicePaidAmountSQL+` FROM purchase_invoices pi WHERE pi.id = ?`, invoiceID).
+		Scan(&status, &total, &paid)
+	if err != nil {
+		return err
+	}
+
+	if status != "received" && status != "partially_paid" && status != "paid" {
+		return nil
+	}
+
+	newStatus := "received"
+	if paid > 0.005 {
+		newStatus = "partially_paid"
+		if paid >= total-0.005 {
+			newStatus = "paid"
+		}
+	}
+
+	if newStatus == status {
+		return nil
+	}
+	_, err = tx.Exec(`UPDATE purchase_invoices SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, newStatus, invoiceID)
+	return err
+}
+
+// CreateSupplierPayment records a payment to a supplier and allocates it to purchase invoices.
+// Allocations may not exceed the payment amount or what is left to pay on each invoice.
+func (d *Database) CreateSupplierPayment(payment *SupplierPayment) error {
+	if payment.Amount <= 0 {
+		return fmt.Errorf("payment amount must be greater than zero")
+	}
+
+	var allocated float64
+	for _, allocation := range payment.Allocations {
+		if allocation.Amount <= 0 {
+			return fmt.Errorf("allocation amounts must be greater than zero")
+		}
+		allocated += allocation.Amount
+	}
+	if allocated > payment.Amount+0.005 {
+		return fmt.Errorf("allocated amount %.2f exceeds the payment amount %.2f", allocated, payment.Amount)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = d.insertSupplierPayment(tx, payment); err != nil {
+		return err
+	}
+	payment.UnallocatedAmount = payment.Amount - allocated
+
+	return tx.Commit()
+}
+
+// insertSupplierPayment inserts a validated supplier payment and its allocations within a transaction
+func (d *Database) insertSupplierPayment(tx *sql.Tx, payment *SupplierPayment) error {
+	// Default to company 1 for backward compatibility
+	if payment.CompanyID == 0 {
+		payment.CompanyID = 1
+	}
+
+	if payment.PaymentNumber == "" {
+		number, err := nextDocumentNumber(tx, "supplier_payments", "payment_number", "SP-", payment.CompanyID)
+		if err != nil {
+			return err
+		}
+		payment.PaymentNumber = number
+	}
+
+	if payment.Status == "" {
+		payment.Status = "completed"
+	}
+
+	if err := checkDocumentPeriod(tx, payment.CompanyID, payment.PaymentDate); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO supplier_payments (company_id, payment_number, supplier_id, payment_type_id, amount, payment_date, reference, notes, notes_arabic, status, created_by, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(query, payment.CompanyID, payment.PaymentNumber, payment.SupplierID, payment.PaymentTypeID, payment.Amount, payment.PaymentDate,
+		payment.Reference, payment.Notes, payment.NotesArabic, payment.Status, payment.CreatedBy, payment.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	paymentID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	payment.ID = int(paymentID)
+
+	for i := range payment.Allocations {
+		allocation := &payment.Allocations[i]
+
+		var supplierID int
+		var status, invoiceNumber string
+		var total, paid float64
+		err = tx.QueryRow(`SELECT pi.supplier_id, pi.status, pi.invoice_number, pi.total_amount, `+purchaseInvoicePaidAmountSQL+`
+			FROM purchase_invoices pi WHERE pi.id = ?`, allocation.PurchaseInvoiceID).Scan(&supplierID, &status, &invoiceNumber, &total, &paid)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("purchase invoice %d not found", allocation.PurchaseInvoiceID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if supplierID != payment.SupplierID {
+			return fmt.Errorf("purchase invoice %s belongs to a different supplier", invoiceNumber)
+		}
+		if status == "draft" || status == "cancelled" {
+			return fmt.Errorf("cannot allocate a payment to %s purchase invoice %s", status, invoiceNumber)
+		}
+		if allocation.Amount > total-paid+0.005 {
+			return fmt.Errorf("allocation %.2f exceeds the outstanding amount %.2f of purchase invoice %s", allocation.Amount, total-paid, invoiceNumber)
+		}
+
+		result, err = tx.Exec(`INSERT INTO supplier_payment_allocations (supplier_payment_id, purchase_invoice_id, amount) VALUES (?, ?, ?)`,
+			paymentID, allocation.PurchaseInvoiceID, allocation.Amount)
+		if err != nil {
+			return err
+		}
+		allocationID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		allocation.ID = int(allocationID)
+		allocation.SupplierPaymentID = payment.ID
+		allocation.InvoiceNumber = invoiceNumber
+
+		if err = refreshPurchaseInvoiceStatus(tx, allocation.PurchaseInvoiceID); err != nil {
+			return err
+		}
+	}
+
+	return postDocument(tx, "supplier_payment", payment.ID)
+}
+
+// GetSupplierPaymentsByCompany retrieves all supplier payments for a company
+func (d *Database) GetSupplierPaymentsByCompany(companyID int) ([]SupplierPayment, error) {
+	return d.querySupplierPayments(`WHERE sp.company_id = ? AND sp.deleted_at IS NULL ORDER BY sp.payment_date DESC, sp.id DESC`, companyID)
+}
+
+// GetSupplierPaymentsBySupplierID retrieves all payments made to a supplier
+func (d *Database) GetSupplierPaymentsBySupplierID(supplierID int) ([]SupplierPayment, error) {
+	return d.querySupplierPayments(`WHERE sp.supplier_id = ? AND sp.deleted_at IS NULL ORDER BY sp.payment_date DESC, sp.id DESC`, supplierID)
+}
+
+// GetSupplierPaymentsByPurchaseInvoiceID retrieves the supplier payments allocated to a purchase invoice
+func (d *Database) GetSupplierPaymentsByPurchaseInvoiceID(invoiceID int) ([]SupplierPayment, error) {
+	return d.querySupplierPayments(`WHERE sp.id IN (SELECT supplier_payment_id FROM supplier_payment_allocations WHERE purchase_invoice_id = ?) AND sp.deleted_at IS NULL ORDER BY sp.payment_date DESC, sp.id DESC`, invoiceID)
+}
+
+var supplierPaymentList = listSpec{
+	table:   "supplier_payments",
+	alias:   "sp",
+	search:  []string{"sp.payment_number", "sp.reference", "sp.notes", "sp.notes_arabic"},
+	date:    "sp.payment_date",
+	filters: map[string]string{"supplier_id": "sp.supplier_id", "payment_type_id": "sp.payment_type_id", "status": "sp.status"},
+	sorts:   map[string]string{"payment_date": "sp.payment_date", "payment_number": "sp.payment_number", "amount": "sp.amount"},
+	sort:    "payment_date",
+	desc:    true,
+}
+
+// ListSupplierPayments retrieves a page of the supplier payments of a company, latest first by default. They
+// can be filtered by supplier_id, payment_type_id and status and sorted by payment_date, payment_number or
+// amount.
+func (d *Database) ListSupplierPayments(q ListQuery) (*SupplierPaymentPage, error) {
+	page := &SupplierPaymentPage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, supplierPaymentList, q, d.querySupplierPayments, func(p SupplierPayment) int { return p.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Database) querySupplierPayments(where string, args ...interface{}) ([]SupplierPayment, error) {
+	query := `
+		SELECT sp.id, sp.company_id, sp.payment_number, sp.supplier_id, sp.payment_type_id, sp.amount,
+			sp.amount - COALESCE((SELECT SUM(amount) FROM supplier_payment_allocations WHERE supplier_payment_id = sp.id), 0),
+			sp.payment_date, COALESCE(sp.reference, ''), COALESCE(sp.notes, ''), COALESCE(sp.notes_arabic, ''), sp.status,
+			sp.created_by, sp.updated_by, sp.created_at, sp.updated_at,
+			s.company_name, COALESCE(s.company_name_arabic, ''), pt.name, COALESCE(pt.name_arabic, ''),
+			EXISTS(SELECT 1 FROM bank_statement_lines bsl WHERE bsl.supplier_payment_id = sp.id AND bsl.status = 'reconciled')
+		FROM supplier_payments sp
+		LEFT JOIN suppliers s ON sp.supplier_id = s.id
+		LEFT JOIN payment_types pt ON sp.payment_type_id = pt.id
+		` + where
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []SupplierPayment
+	for rows.Next() {
+		var p SupplierPayment
+		var supplierName, supplierNameArabic, paymentTypeName, paymentTypeNameArabic sql.NullString
+
+		err := rows.Scan(&p.ID, &p.CompanyID, &p.PaymentNumber, &p.SupplierID, &p.PaymentTypeID, &p.Amount, &p.UnallocatedAmount,
+			&p.PaymentDate, &p.Reference, &p.Notes, &p.NotesArabic, &p.Status,
+			&p.CreatedBy, &p.UpdatedBy, &p.CreatedAt, &p.UpdatedAt,
+			&supplierName, &supplierNameArabic, &paymentTypeName, &paymentTypeNameArabic, &p.Reconciled)
+		if err != nil {
+			return nil, err
+		}
+
+		if supplierName.Valid {
+			p.Supplier = &Supplier{ID: p.SupplierID, CompanyName: supplierName.String, CompanyNameArabic: supplierNameArabic.String}
+		}
+		if paymentTypeName.Valid {
+			p.PaymentType = &PaymentType{ID: p.PaymentTypeID, Name: paymentTypeName.String, NameArabic: paymentTypeNameArabic.String}
+		}
+
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+// GetSupplierPaymentByID retrieves a supplier payment with its supplier and allocations
+func (d *Database) GetSupplierPaymentByID(id int) (*SupplierPayment, error) {
+	payments, err := d.querySupplierPayments(`WHERE sp.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	payment := payments[0]
+
+	if supplier, supplierErr := d.GetSupplierByID(payment.SupplierID); supplierErr == nil {
+		payment.Supplier = supplier
+	}
+
+	allocations, err := d.GetSupplierPaymentAllocations(payment.ID)
+	if err != nil {
+		return nil, err
+	}
+	payment.Allocations = allocations
+
+	return &payment, nil
+}
+
+// GetSupplierPaymentAllocations retrieves how a supplier payment was allocated to purchase invoices
+func (d *Database) GetSupplierPaymentAllocations(paymentID int) ([]SupplierPaymentAllocation, error) {
+	query := `
+		SELECT spa.id, spa.supplier_payment_id, spa.purchase_invoice_id, COALESCE(pi.invoice_number, ''), spa.amount, spa.created_at
+		FROM supplier_payment_allocations spa
+		LEFT JOIN purchase_invoices pi ON spa.purchase_invoice_id = pi.id
+		WHERE spa.supplier_payment_id = ?
+		ORDER BY spa.id`
+
+	rows, err := d.db.Query(query, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allocations []SupplierPaymentAllocation
+	for rows.Next() {
+		var a SupplierPaymentAllocation
+		if err := rows.Scan(&a.ID, &a.SupplierPaymentID, &a.PurchaseInvoiceID, &a.InvoiceNumber, &a.Amount, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
+
+// CancelSupplierPayment cancels a supplier payment and re-derives the status of the invoices it paid
+func (d *Database) CancelSupplierPayment(id int, userID *int) error {
+	return d.withSupplierPaymentInvoices(id, func(tx *sql.Tx) error {
+		if err := refuseReconciledPayment(tx, "supplier_payment_id", id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE supplier_payments SET status = 'cancelled', updated_by = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, userID, id)
+		return err
+	})
+}
+
+// DeleteSupplierPayment moves a supplier payment to the recycle bin. Its allocations are kept for a restore
+// but no longer count towards the invoices they paid.
+func (d *Database) DeleteSupplierPayment(id int, userID *int) error {
+	return d.withSupplierPaymentInvoices(id, func(tx *sql.Tx) error {
+		if err := refuseReconciledPayment(tx, "supplier_payment_id", id); err != nil {
+			return err
+		}
+		return softDelete(tx, "supplier_payments", id, userID)
+	})
+}
+
+// withSupplierPaymentInvoices runs a change to a supplier payment in a transaction and then
+// refreshes the status of every purchase invoice the payment was allocated to
+func (d *Database) withSupplierPaymentInvoices(paymentID int, change func(tx *sql.Tx) error) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = checkStoredDocumentPeriod(tx, "supplier_payments", "payment_date", paymentID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT DISTINCT purchase_invoice_id FROM supplier_payment_allocations WHERE supplier_payment_id = ?", paymentID)
+	if err != nil {
+		return err
+	}
+	var invoiceIDs []int
+	for rows.Next() {
+		var invoiceID int
+		if err := rows.Scan(&invoiceID); err != nil {
+			rows.Close()
+			return err
+		}
+		invoiceIDs = append(invoiceIDs, invoiceID)
+	}
+	rows.Close()
+
+	if err = change(tx); err != nil {
+		return err
+	}
+	if err = postDocument(tx, "supplier_payment", paymentID); err != nil {
+		return err
+	}
+
+	for _, invoiceID := range invoiceIDs {
+		if err = refreshPurchaseInvoiceStatus(tx, invoiceID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetOpenPurchaseInvoices retrieves the received purchase invoices of a supplier that are not fully paid
+func (d *Database) GetOpenPurchaseInvoices(supplierID int) ([]PurchaseInvoice, error) {
+	query := `
+		SELECT pi.id, pi.company_id, pi.invoice_number, pi.supplier_id, pi.issue_date, pi.due_date,
+			pi.sub_total, pi.vat_amount, pi.total_amount, ` + purchaseInvoicePaidAmountSQL + `, pi.status
+		FROM purchase_invoices pi
+		WHERE pi.supplier_id = ? AND pi.status IN ('received', 'partially_paid')
+		ORDER BY pi.due_date, pi.issue_date, pi.id`
+
+	rows, err := d.db.Query(query, supplierID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []PurchaseInvoice
+	for rows.Next() {
+		var inv PurchaseInvoice
+		var issueDate, dueDate time.Time
+		if err := rows.Scan(&inv.ID, &inv.CompanyID, &inv.InvoiceNumber, &inv.SupplierID, &issueDate, &dueDate,
+			&inv.SubTotal, &inv.VATAmount, &inv.TotalAmount, &inv.PaidAmount, &inv.Status); err != nil {
+			return nil, err
+		}
+		inv.IssueDate = Date{Time: issueDate}
+		inv.DueDate = Date{Time: dueDate}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
@@ -26,11 +26,37 @@ func (d *Database) CreateSupplier(supplier *Supplier) error {
 }
 
 func (d *Database) GetSuppliers() ([]Supplier, error) {
-	query := `SELECT id, company_name, company_name_arabic, contact_person, contact_person_arabic, 
-			  vat_number, email, phone, address, address_arabic, city, city_arabic, country, 
-			  country_arabic, payment_terms, active, created_at, updated_at FROM suppliers ORDER BY company_name`
+	return d.querySuppliers(`WHERE s.deleted_at IS NULL ORDER BY s.company_name`)
+}
+
+var supplierList = listSpec{
+	table:  "suppliers",
+	alias:  "s",
+	entity: "supplier",
+	filters: map[string]string{"active": "s.active", "city": "s.city", "country": "s.country",
+		"payment_terms": "s.payment_terms", "vat_number": "s.vat_number"},
+	sorts: map[string]string{"company_name": "s.company_name", "id": "s.id"},
+	sort:  "company_name",
+}
 
-	rows, err := d.db.Query(query)
+// ListSuppliers retrieves a page of the suppliers of a company. They can be filtered by active, city,
+// country, payment_terms and vat_number and sorted by company_name or id, the order they were added in.
+func (d *Database) ListSuppliers(q ListQuery) (*SupplierPage, error) {
+	page := &SupplierPage{}
+	var err error
+	page.Items, page.TotalCount, page.NextCursor, err = listPage(d, supplierList, q, d.querySuppliers, func(s Supplier) int { return s.ID })
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (d *Database) querySuppliers(where string, args ...interface{}) ([]Supplier, error) {
+	query := `SELECT s.id, s.company_name, s.company_name_arabic, s.contact_person, s.contact_person_arabic, 
+			  s.vat_number, s.email, s.phone, s.address, s.address_arabic, s.city, s.city_arabic, s.country, 
+			  s.country_arabic, s.payment_terms, s.active, s.created_at, s.updated_at, s.version FROM suppliers s ` + where
+
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +68,7 @@ func (d *Database) GetSuppliers() ([]Supplier, error) {
 		err := rows.Scan(&s.ID, &s.CompanyName, &s.CompanyNameArabic, &s.ContactPerson, 
 			&s.ContactPersonArabic, &s.VATNumber, &s.Email, &s.Phone, &s.Address, 
 			&s.AddressArabic, &s.City, &s.CityArabic, &s.Country, &s.CountryArabic,
-			&s.PaymentTerms, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+			&s.PaymentTerms, &s.Active, &s.CreatedAt, &s.UpdatedAt, &s.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -54,13 +80,13 @@ func (d *Database) GetSuppliers() ([]Supplier, error) {
 func (d *Database) GetSupplierByID(id int) (*Supplier, error) {
 	query := `SELECT id, company_name, company_name_arabic, contact_person, contact_person_arabic, 
 			  vat_number, email, phone, address, address_arabic, city, city_arabic, country, 
-			  country_arabic, payment_terms, active, created_at, updated_at FROM suppliers WHERE id = ?`
+			  country_arabic, payment_terms, active, created_at, updated_at, version FROM suppliers WHERE id = ?`
 
 	var s Supplier
 	err := d.db.QueryRow(query, id).Scan(&s.ID, &s.CompanyName, &s.CompanyNameArabic, 
 		&s.ContactPerson, &s.ContactPersonArabic, &s.VATNumber, &s.Email, &s.Phone, 
 		&s.Address, &s.AddressArabic, &s.City, &s.CityArabic, &s.Country, &s.CountryArabic,
-		&s.PaymentTerms, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+		&s.PaymentTerms, &s.Active, &s.CreatedAt, &s.UpdatedAt, &s.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -73,17 +99,22 @@ func (d *Database) UpdateSupplier(supplier *Supplier) error {
 		contact_person_arabic = ?, vat_number = ?, email = ?, phone = ?, address = ?, 
 		address_arabic = ?, city = ?, city_arabic = ?, country = ?, country_arabic = ?, 
 		payment_terms = ?, active = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`
+		WHERE id = ? AND ` + versionCondition
 
-	_, err := d.db.Exec(query, supplier.CompanyName, supplier.CompanyNameArabic,
+	result, err := d.db.Exec(query, supplier.CompanyName, supplier.CompanyNameArabic,
 		supplier.ContactPerson, supplier.ContactPersonArabic, supplier.VATNumber,
 		supplier.Email, supplier.Phone, supplier.Address, supplier.AddressArabic,
 		supplier.City, supplier.CityArabic, supplier.Country, supplier.CountryArabic,
-		supplier.PaymentTerms, supplier.Active, supplier.ID)
+		supplier.PaymentTerms, supplier.Active, supplier.ID, supplier.Version)
+	if err != nil {
+		return err
+	}
+	supplier.Version, err = checkVersion(d.db, "suppliers", supplier.ID, supplier.Version, result,
+		func() (interface{}, error) { return d.GetSupplierByID(supplier.ID) })
 	return err
 }
 
-func (d *Database) DeleteSupplier(id int) error {
-	_, err := d.db.Exec("DELETE FROM suppliers WHERE id = ?", id)
-	return err
+// DeleteSupplier moves a supplier to the recycle bin
+func (d *Database) DeleteSupplier(id int, userID *int) error {
+	return softDelete(d.db, "suppliers", id, userID)
 }
\ No newline at end of file
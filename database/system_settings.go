@@ -1,15 +1,18 @@
 package database
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // SystemSettings operations
 func (d *Database) GetSystemSettings() (*SystemSettings, error) {
 	query := `SELECT id, currency, language, timezone, date_format, invoice_language, zatca_enabled, auto_backup, backup_frequency, last_backup_time, 
-		created_at, updated_at FROM system_settings LIMIT 1`
+		COALESCE(backup_directory, ''), COALESCE(backup_retention, 7), created_at, updated_at FROM system_settings LIMIT 1`
 
 	var s SystemSettings
 	err := d.db.QueryRow(query).Scan(&s.ID, &s.Currency, &s.Language, &s.Timezone, &s.DateFormat, &s.InvoiceLanguage, &s.ZatcaEnabled, &s.AutoBackup, &s.BackupFrequency, &s.LastBackupTime, 
-		&s.CreatedAt, &s.UpdatedAt)
+		&s.BackupDirectory, &s.BackupRetention, &s.CreatedAt, &s.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -31,6 +34,17 @@ func (d *Database) UpdateLastBackupTime(backupTime time.Time) error {
 	return err
 }
 
+// UpdateBackupSettings sets where backups are written and how many are kept. An empty directory means the
+// default one.
+func (d *Database) UpdateBackupSettings(directory string, retention int) error {
+	if retention < 1 {
+		return fmt.Errorf("at least one backup must be kept")
+	}
+	query := `UPDATE system_settings SET backup_directory = ?, backup_retention = ?, updated_at = CURRENT_TIMESTAMP WHERE id = 1`
+	_, err := d.db.Exec(query, directory, retention)
+	return err
+}
+
 func (d *Database) CreateSystemSettings(settings *SystemSettings) error {
 	query := `INSERT INTO system_settings (currency, language, timezone, date_format, invoice_language, zatca_enabled, auto_backup, backup_frequency, last_backup_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
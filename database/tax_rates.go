@@ -1,10 +1,33 @@
 package database
 
+import "fmt"
+
+// normalizeVATCategory defaults the VAT category of a tax rate from its rate and validates it
+func normalizeVATCategory(taxRate *TaxRate) error {
+	if taxRate.VATCategory == "" {
+		taxRate.VATCategory = defaultVATCategory(taxRate.Rate)
+	}
+	if !vatCategories[taxRate.VATCategory] {
+		return fmt.Errorf("invalid VAT category %q", taxRate.VATCategory)
+	}
+	if taxRate.VATCategory == VATCategoryStandard && taxRate.Rate <= 0 {
+		return fmt.Errorf("a standard-rated tax rate needs a rate above zero")
+	}
+	if taxRate.VATCategory != VATCategoryStandard && taxRate.Rate != 0 {
+		return fmt.Errorf("only standard-rated tax rates can have a rate above zero")
+	}
+	return nil
+}
+
 // TaxRate operations
 func (d *Database) CreateTaxRate(taxRate *TaxRate) error {
-	query := `INSERT INTO tax_rates (name, name_arabic, rate, description, is_default, is_active) VALUES (?, ?, ?, ?, ?, ?)`
+	if err := normalizeVATCategory(taxRate); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO tax_rates (name, name_arabic, rate, vat_category, description, is_default, is_active) VALUES (?, ?, ?, ?, ?, ?, ?)`
 
-	result, err := d.db.Exec(query, taxRate.Name, taxRate.NameArabic, taxRate.Rate, taxRate.Description, taxRate.IsDefault, taxRate.IsActive)
+	result, err := d.db.Exec(query, taxRate.Name, taxRate.NameArabic, taxRate.Rate, taxRate.VATCategory, taxRate.Description, taxRate.IsDefault, taxRate.IsActive)
 	if err != nil {
 		return err
 	}
@@ -18,7 +41,7 @@ func (d *Database) CreateTaxRate(taxRate *TaxRate) error {
 }
 
 func (d *Database) GetTaxRates() ([]TaxRate, error) {
-	query := `SELECT id, name, name_arabic, rate, description, is_default, is_active, created_at, updated_at FROM tax_rates ORDER BY name`
+	query := `SELECT id, name, name_arabic, rate, COALESCE(vat_category, ''), description, is_default, is_active, created_at, updated_at FROM tax_rates ORDER BY name`
 
 	rows, err := d.db.Query(query)
 	if err != nil {
@@ -29,7 +52,7 @@ func (d *Database) GetTaxRates() ([]TaxRate, error) {
 	var taxRates []TaxRate
 	for rows.Next() {
 		var tr TaxRate
-		err := rows.Scan(&tr.ID, &tr.Name, &tr.NameArabic, &tr.Rate, &tr.Description, &tr.IsDefault, &tr.IsActive, &tr.CreatedAt, &tr.UpdatedAt)
+		err := rows.Scan(&tr.ID, &tr.Name, &tr.NameArabic, &tr.Rate, &tr.VATCategory, &tr.Description, &tr.IsDefault, &tr.IsActive, &tr.CreatedAt, &tr.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -39,9 +62,13 @@ func (d *Database) GetTaxRates() ([]TaxRate, error) {
 }
 
 func (d *Database) UpdateTaxRate(taxRate *TaxRate) error {
-	query := `UPDATE tax_rates SET name = ?, name_arabic = ?, rate = ?, description = ?, is_default = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	if err := normalizeVATCategory(taxRate); err != nil {
+		return err
+	}
+
+	query := `UPDATE tax_rates SET name = ?, name_arabic = ?, rate = ?, vat_category = ?, description = ?, is_default = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
 
-	_, err := d.db.Exec(query, taxRate.Name, taxRate.NameArabic, taxRate.Rate, taxRate.Description, taxRate.IsDefault, taxRate.IsActive, taxRate.ID)
+	_, err := d.db.Exec(query, taxRate.Name, taxRate.NameArabic, taxRate.Rate, taxRate.VATCategory, taxRate.Description, taxRate.IsDefault, taxRate.IsActive, taxRate.ID)
 	return err
 }
 
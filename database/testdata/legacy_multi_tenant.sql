@@ -0,0 +1,346 @@
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE companies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			vat_number TEXT NOT NULL,
+			cr_number TEXT,
+			email TEXT,
+			phone TEXT,
+			address TEXT,
+			address_arabic TEXT,
+			city TEXT,
+			city_arabic TEXT,
+			country TEXT,
+			country_arabic TEXT,
+			logo TEXT
+		, logo_file_id INTEGER);
+INSERT INTO companies VALUES(1,'Dijibill Trading','ديجيبل للتجارة','310000000000003','1010000000','info@dijibill.sa','+966112345678','King Fahd Road','طريق الملك فهد','Riyadh','الرياض','Saudi Arabia','المملكة العربية السعودية','',NULL);
+CREATE TABLE customers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			vat_number TEXT,
+			email TEXT,
+			phone TEXT,
+			address TEXT,
+			address_arabic TEXT,
+			city TEXT,
+			city_arabic TEXT,
+			country TEXT,
+			country_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO customers VALUES(1,'Al Noor Trading','النور للتجارة','300000000000003','info@alnoor.sa','','','','','','','','2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE suppliers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_name TEXT NOT NULL,
+			company_name_arabic TEXT,
+			contact_person TEXT NOT NULL,
+			contact_person_arabic TEXT,
+			vat_number TEXT,
+			email TEXT,
+			phone TEXT,
+			address TEXT,
+			address_arabic TEXT,
+			city TEXT,
+			city_arabic TEXT,
+			country TEXT,
+			country_arabic TEXT,
+			payment_terms TEXT DEFAULT 'net_30',
+			active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO suppliers VALUES(1,'Gulf Roasters','محمصة الخليج','Khalid','','300000000000013','','','','','','','','','net_30',1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE product_categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO product_categories VALUES(1,'Beverages','مشروبات','','','2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			category_id INTEGER,
+			unit_price REAL NOT NULL,
+			vat_rate REAL DEFAULT 15.0,
+			unit TEXT DEFAULT 'pcs',
+			unit_arabic TEXT DEFAULT 'قطعة',
+			sku TEXT,
+			barcode TEXT,
+			stock INTEGER DEFAULT 0,
+			min_stock INTEGER DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, company_id INTEGER DEFAULT 1,
+			FOREIGN KEY (category_id) REFERENCES product_categories(id)
+		);
+INSERT INTO products VALUES(1,'Coffee Beans','حبوب القهوة','','',1,45.0,15.0,'pcs','قطعة','COF-1','',20,0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE sales_invoices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_number TEXT UNIQUE NOT NULL,
+			customer_id INTEGER NOT NULL,
+			sales_category_id INTEGER NOT NULL,
+			issue_date DATETIME NOT NULL,
+			due_date DATETIME,
+			sub_total REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			status TEXT DEFAULT 'draft',
+			notes TEXT,
+			notes_arabic TEXT,
+			qr_code TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, table_number TEXT, company_id INTEGER DEFAULT 1, created_by INTEGER, updated_by INTEGER,
+			FOREIGN KEY (customer_id) REFERENCES customers(id),
+			FOREIGN KEY (sales_category_id) REFERENCES sales_categories(id)
+		);
+INSERT INTO sales_invoices VALUES(1,'INV-0001',1,1,'2023-03-01','2023-03-31',90.0,13.5,103.5,'paid','','','','2023-03-01 09:00:00','2023-03-01 09:00:00','',1,NULL,NULL);
+CREATE TABLE sales_invoice_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			quantity REAL NOT NULL,
+			unit_price REAL NOT NULL,
+			vat_rate REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (invoice_id) REFERENCES sales_invoices(id) ON DELETE CASCADE,
+			FOREIGN KEY (product_id) REFERENCES products(id)
+		);
+INSERT INTO sales_invoice_items VALUES(1,1,1,2.0,45.0,15.0,13.5,103.5,'2023-03-01 09:00:00');
+CREATE TABLE purchase_invoices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_number TEXT UNIQUE NOT NULL,
+			supplier_id INTEGER NOT NULL,
+			issue_date DATETIME NOT NULL,
+			due_date DATETIME,
+			sub_total REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			vat_rate REAL DEFAULT 15.0,
+			vat_inclusive BOOLEAN DEFAULT 0,
+			total_amount REAL NOT NULL,
+			status TEXT DEFAULT 'draft',
+			notes TEXT,
+			notes_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, company_id INTEGER DEFAULT 1, created_by INTEGER, updated_by INTEGER,
+			FOREIGN KEY (supplier_id) REFERENCES suppliers(id)
+		);
+INSERT INTO purchase_invoices VALUES(1,'PUR-0001',1,'2023-02-01','2023-03-01',100.0,15.0,15.0,0,115.0,'paid','','','2023-03-01 09:00:00','2023-03-01 09:00:00',1,NULL,NULL);
+CREATE TABLE purchase_invoice_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			quantity REAL NOT NULL,
+			unit_price REAL NOT NULL,
+			vat_rate REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (invoice_id) REFERENCES purchase_invoices(id) ON DELETE CASCADE,
+			FOREIGN KEY (product_id) REFERENCES products(id)
+		);
+CREATE TABLE payment_types (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			code TEXT UNIQUE,
+			description TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO payment_types VALUES(1,'Cash','نقدي','cash','Cash payment',1,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO payment_types VALUES(2,'Credit Card','بطاقة ائتمان','card','Credit card payment',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO payment_types VALUES(3,'Bank Transfer','تحويل بنكي','bank_transfer','Bank transfer payment',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE payments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			payment_type_id INTEGER NOT NULL,
+			amount REAL NOT NULL,
+			payment_date DATETIME NOT NULL,
+			reference TEXT,
+			notes TEXT,
+			notes_arabic TEXT,
+			status TEXT DEFAULT 'completed',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, company_id INTEGER DEFAULT 1,
+			FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE,
+			FOREIGN KEY (payment_type_id) REFERENCES payment_types(id)
+		);
+CREATE TABLE sales_categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			code TEXT UNIQUE,
+			description TEXT,
+			description_arabic TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO sales_categories VALUES(1,'Retail','تجزئة','retail','Retail sales','مبيعات التجزئة',1,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO sales_categories VALUES(2,'Wholesale','جملة','wholesale','Wholesale sales','مبيعات الجملة',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO sales_categories VALUES(3,'Service','خدمة','service','Service sales','مبيعات الخدمات',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE tax_rates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			rate REAL NOT NULL,
+			description TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO tax_rates VALUES(1,'Standard VAT','ضريبة القيمة المضافة',15.0,'Standard VAT rate',1,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO tax_rates VALUES(2,'Zero VAT','معفى من الضريبة',0.0,'Zero VAT rate',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO tax_rates VALUES(3,'Exempt','معفى',0.0,'VAT exempt',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE units_of_measurement (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			value TEXT NOT NULL,
+			label TEXT NOT NULL,
+			arabic TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO units_of_measurement VALUES(1,'pcs','Pieces','قطعة',1,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO units_of_measurement VALUES(2,'kg','Kilograms','كيلوغرام',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO units_of_measurement VALUES(3,'m','Meters','متر',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO units_of_measurement VALUES(4,'l','Liters','لتر',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO units_of_measurement VALUES(5,'box','Box','صندوق',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+INSERT INTO units_of_measurement VALUES(6,'pack','Pack','عبوة',0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE default_product_settings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			default_stock INTEGER DEFAULT 0,
+			default_tax_rate_id INTEGER,
+			default_unit_id INTEGER,
+			default_product_type TEXT DEFAULT 'product',
+			default_product_status BOOLEAN DEFAULT 1,
+			default_markup REAL DEFAULT 0.0,
+			default_price_includes_tax BOOLEAN DEFAULT 0,
+			default_price_change_allowed BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, company_id INTEGER DEFAULT 1,
+			FOREIGN KEY (default_tax_rate_id) REFERENCES tax_rates(id),
+			FOREIGN KEY (default_unit_id) REFERENCES units_of_measurement(id)
+		);
+INSERT INTO default_product_settings VALUES(1,1,1,1,'product',1,0.0,0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE purchase_product_categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+CREATE TABLE purchase_products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			category_id INTEGER,
+			unit_price REAL DEFAULT 0.0,
+			vat_rate REAL DEFAULT 15.0,
+			unit TEXT DEFAULT 'pcs',
+			unit_arabic TEXT DEFAULT 'قطعة',
+			sku TEXT,
+			barcode TEXT,
+			is_active BOOLEAN DEFAULT 1,
+			notes TEXT,
+			notes_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP, company_id INTEGER DEFAULT 1,
+			FOREIGN KEY (category_id) REFERENCES purchase_product_categories(id)
+		);
+CREATE TABLE system_settings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			currency TEXT DEFAULT 'SAR',
+			language TEXT DEFAULT 'en',
+			timezone TEXT DEFAULT 'Asia/Riyadh',
+			date_format TEXT DEFAULT 'DD/MM/YYYY',
+			invoice_language TEXT DEFAULT 'english',
+			zatca_enabled BOOLEAN DEFAULT 1,
+			auto_backup BOOLEAN DEFAULT 1,
+			backup_frequency TEXT DEFAULT 'daily',
+			last_backup_time DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		, company_id INTEGER DEFAULT 1);
+INSERT INTO system_settings VALUES(1,'SAR','en','Asia/Riyadh','DD/MM/YYYY','english',1,1,'daily',NULL,'2023-03-01 09:00:00','2023-03-01 09:00:00',1);
+CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL,
+		first_name TEXT NOT NULL,
+		last_name TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'user',
+		is_active BOOLEAN NOT NULL DEFAULT 1,
+		company_id INTEGER NOT NULL,
+		last_login DATETIME,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, intro_viewed BOOLEAN DEFAULT 0,
+		FOREIGN KEY (company_id) REFERENCES companies (id) ON DELETE CASCADE
+	);
+INSERT INTO users VALUES(1,'admin','admin@company.com','$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi','Admin','User','admin',1,1,NULL,'2023-03-01 09:00:00','2023-03-01 09:00:00',0);
+INSERT INTO sqlite_sequence VALUES('users',1);
+INSERT INTO sqlite_sequence VALUES('companies',1);
+INSERT INTO sqlite_sequence VALUES('payment_types',3);
+INSERT INTO sqlite_sequence VALUES('sales_categories',4);
+INSERT INTO sqlite_sequence VALUES('tax_rates',3);
+INSERT INTO sqlite_sequence VALUES('units_of_measurement',6);
+INSERT INTO sqlite_sequence VALUES('default_product_settings',1);
+INSERT INTO sqlite_sequence VALUES('system_settings',1);
+INSERT INTO sqlite_sequence VALUES('customers',1);
+INSERT INTO sqlite_sequence VALUES('product_categories',1);
+INSERT INTO sqlite_sequence VALUES('products',1);
+INSERT INTO sqlite_sequence VALUES('suppliers',1);
+INSERT INTO sqlite_sequence VALUES('sales_invoices',1);
+INSERT INTO sqlite_sequence VALUES('sales_invoice_items',1);
+INSERT INTO sqlite_sequence VALUES('purchase_invoices',1);
+CREATE VIEW invoices AS SELECT * FROM sales_invoices;
+CREATE VIEW invoice_items AS SELECT * FROM sales_invoice_items;
+CREATE INDEX idx_users_company_id ON users(company_id);
+CREATE INDEX idx_users_username ON users(username);
+CREATE INDEX idx_users_email ON users(email);
+CREATE INDEX idx_customers_company_id ON customers(company_id);
+CREATE INDEX idx_suppliers_company_id ON suppliers(company_id);
+CREATE INDEX idx_product_categories_company_id ON product_categories(company_id);
+CREATE INDEX idx_products_company_id ON products(company_id);
+CREATE INDEX idx_sales_invoices_company_id ON sales_invoices(company_id);
+CREATE INDEX idx_purchase_invoices_company_id ON purchase_invoices(company_id);
+CREATE INDEX idx_payment_types_company_id ON payment_types(company_id);
+CREATE INDEX idx_payments_company_id ON payments(company_id);
+CREATE INDEX idx_sales_categories_company_id ON sales_categories(company_id);
+CREATE INDEX idx_tax_rates_company_id ON tax_rates(company_id);
+CREATE INDEX idx_units_of_measurement_company_id ON units_of_measurement(company_id);
+CREATE INDEX idx_default_product_settings_company_id ON default_product_settings(company_id);
+CREATE INDEX idx_purchase_product_categories_company_id ON purchase_product_categories(company_id);
+CREATE INDEX idx_purchase_products_company_id ON purchase_products(company_id);
+CREATE INDEX idx_system_settings_company_id ON system_settings(company_id);
+CREATE INDEX idx_sales_invoices_created_by ON sales_invoices(created_by);
+CREATE INDEX idx_sales_invoices_updated_by ON sales_invoices(updated_by);
+CREATE INDEX idx_purchase_invoices_created_by ON purchase_invoices(created_by);
+CREATE INDEX idx_purchase_invoices_updated_by ON purchase_invoices(updated_by);
+CREATE INDEX idx_companies_logo_file_id ON companies(logo_file_id);
+COMMIT;
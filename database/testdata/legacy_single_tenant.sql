@@ -0,0 +1,283 @@
+PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE companies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			vat_number TEXT NOT NULL,
+			cr_number TEXT,
+			email TEXT,
+			phone TEXT,
+			address TEXT,
+			address_arabic TEXT,
+			city TEXT,
+			city_arabic TEXT,
+			country TEXT,
+			country_arabic TEXT,
+			logo TEXT
+		);
+INSERT INTO companies VALUES(1,'Dijibill Trading','ديجيبل للتجارة','310000000000003','1010000000','info@dijibill.sa','+966112345678','King Fahd Road','طريق الملك فهد','Riyadh','الرياض','Saudi Arabia','المملكة العربية السعودية','');
+CREATE TABLE customers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			vat_number TEXT,
+			email TEXT,
+			phone TEXT,
+			address TEXT,
+			address_arabic TEXT,
+			city TEXT,
+			city_arabic TEXT,
+			country TEXT,
+			country_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+INSERT INTO customers VALUES(1,'Al Noor Trading','النور للتجارة','300000000000003','info@alnoor.sa','','','','','','','','2023-03-01 09:00:00','2023-03-01 09:00:00');
+CREATE TABLE suppliers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			company_name TEXT NOT NULL,
+			company_name_arabic TEXT,
+			contact_person TEXT NOT NULL,
+			contact_person_arabic TEXT,
+			vat_number TEXT,
+			email TEXT,
+			phone TEXT,
+			address TEXT,
+			address_arabic TEXT,
+			city TEXT,
+			city_arabic TEXT,
+			country TEXT,
+			country_arabic TEXT,
+			payment_terms TEXT DEFAULT 'net_30',
+			active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+INSERT INTO suppliers VALUES(1,'Gulf Roasters','محمصة الخليج','Khalid','','300000000000013','','','','','','','','','net_30',1,'2023-03-01 09:00:00','2023-03-01 09:00:00');
+CREATE TABLE product_categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+INSERT INTO product_categories VALUES(1,'Beverages','مشروبات','','','2023-03-01 09:00:00','2023-03-01 09:00:00');
+CREATE TABLE products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			category_id INTEGER,
+			unit_price REAL NOT NULL,
+			vat_rate REAL DEFAULT 15.0,
+			unit TEXT DEFAULT 'pcs',
+			unit_arabic TEXT DEFAULT 'قطعة',
+			sku TEXT,
+			barcode TEXT,
+			stock INTEGER DEFAULT 0,
+			min_stock INTEGER DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (category_id) REFERENCES product_categories(id)
+		);
+INSERT INTO products VALUES(1,'Coffee Beans','حبوب القهوة','','',1,45.0,15.0,'pcs','قطعة','COF-1','',20,0,1,'2023-03-01 09:00:00','2023-03-01 09:00:00');
+CREATE TABLE sales_invoices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_number TEXT UNIQUE NOT NULL,
+			customer_id INTEGER NOT NULL,
+			sales_category_id INTEGER NOT NULL,
+			issue_date DATETIME NOT NULL,
+			due_date DATETIME,
+			sub_total REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			status TEXT DEFAULT 'draft',
+			notes TEXT,
+			notes_arabic TEXT,
+			qr_code TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (customer_id) REFERENCES customers(id),
+			FOREIGN KEY (sales_category_id) REFERENCES sales_categories(id)
+		);
+INSERT INTO sales_invoices VALUES(1,'INV-0001',1,1,'2023-03-01','2023-03-31',90.0,13.5,103.5,'paid','','','','2023-03-01 09:00:00','2023-03-01 09:00:00');
+CREATE TABLE sales_invoice_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			quantity REAL NOT NULL,
+			unit_price REAL NOT NULL,
+			vat_rate REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (invoice_id) REFERENCES sales_invoices(id) ON DELETE CASCADE,
+			FOREIGN KEY (product_id) REFERENCES products(id)
+		);
+INSERT INTO sales_invoice_items VALUES(1,1,1,2.0,45.0,15.0,13.5,103.5,'2023-03-01 09:00:00');
+CREATE TABLE purchase_invoices (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_number TEXT UNIQUE NOT NULL,
+			supplier_id INTEGER NOT NULL,
+			issue_date DATETIME NOT NULL,
+			due_date DATETIME,
+			sub_total REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			status TEXT DEFAULT 'draft',
+			notes TEXT,
+			notes_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (supplier_id) REFERENCES suppliers(id)
+		);
+INSERT INTO purchase_invoices VALUES(1,'PUR-0001',1,'2023-02-01','2023-03-01',100.0,15.0,115.0,'paid','','','2023-03-01 09:00:00','2023-03-01 09:00:00');
+CREATE TABLE purchase_invoice_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			quantity REAL NOT NULL,
+			unit_price REAL NOT NULL,
+			vat_rate REAL NOT NULL,
+			vat_amount REAL NOT NULL,
+			total_amount REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (invoice_id) REFERENCES purchase_invoices(id) ON DELETE CASCADE,
+			FOREIGN KEY (product_id) REFERENCES products(id)
+		);
+CREATE TABLE payment_types (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			code TEXT UNIQUE,
+			description TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+CREATE TABLE payments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			invoice_id INTEGER NOT NULL,
+			payment_type_id INTEGER NOT NULL,
+			amount REAL NOT NULL,
+			payment_date DATETIME NOT NULL,
+			reference TEXT,
+			notes TEXT,
+			notes_arabic TEXT,
+			status TEXT DEFAULT 'completed',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE,
+			FOREIGN KEY (payment_type_id) REFERENCES payment_types(id)
+		);
+CREATE TABLE sales_categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			code TEXT UNIQUE,
+			description TEXT,
+			description_arabic TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+INSERT INTO sales_categories VALUES(1,'Retail','تجزئة','retail',NULL,NULL,1,1,'2023-03-01 09:00:00','2023-03-01 09:00:00');
+CREATE TABLE tax_rates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			rate REAL NOT NULL,
+			description TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+CREATE TABLE units_of_measurement (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			value TEXT NOT NULL,
+			label TEXT NOT NULL,
+			arabic TEXT,
+			is_default BOOLEAN DEFAULT 0,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+CREATE TABLE default_product_settings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			default_stock INTEGER DEFAULT 0,
+			default_tax_rate_id INTEGER,
+			default_unit_id INTEGER,
+			default_product_type TEXT DEFAULT 'product',
+			default_product_status BOOLEAN DEFAULT 1,
+			default_markup REAL DEFAULT 0.0,
+			default_price_includes_tax BOOLEAN DEFAULT 0,
+			default_price_change_allowed BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (default_tax_rate_id) REFERENCES tax_rates(id),
+			FOREIGN KEY (default_unit_id) REFERENCES units_of_measurement(id)
+		);
+CREATE TABLE purchase_product_categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			is_active BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+CREATE TABLE purchase_products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			name_arabic TEXT,
+			description TEXT,
+			description_arabic TEXT,
+			category_id INTEGER,
+			unit_price REAL DEFAULT 0.0,
+			vat_rate REAL DEFAULT 15.0,
+			unit TEXT DEFAULT 'pcs',
+			unit_arabic TEXT DEFAULT 'قطعة',
+			sku TEXT,
+			barcode TEXT,
+			is_active BOOLEAN DEFAULT 1,
+			notes TEXT,
+			notes_arabic TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (category_id) REFERENCES purchase_product_categories(id)
+		);
+CREATE TABLE system_settings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			currency TEXT DEFAULT 'SAR',
+			language TEXT DEFAULT 'en',
+			timezone TEXT DEFAULT 'Asia/Riyadh',
+			date_format TEXT DEFAULT 'DD/MM/YYYY',
+			invoice_language TEXT DEFAULT 'english',
+			zatca_enabled BOOLEAN DEFAULT 1,
+			auto_backup BOOLEAN DEFAULT 1,
+			backup_frequency TEXT DEFAULT 'daily',
+			last_backup_time DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+INSERT INTO sqlite_sequence VALUES('companies',1);
+INSERT INTO sqlite_sequence VALUES('customers',1);
+INSERT INTO sqlite_sequence VALUES('product_categories',1);
+INSERT INTO sqlite_sequence VALUES('products',1);
+INSERT INTO sqlite_sequence VALUES('sales_categories',1);
+INSERT INTO sqlite_sequence VALUES('suppliers',1);
+INSERT INTO sqlite_sequence VALUES('sales_invoices',1);
+INSERT INTO sqlite_sequence VALUES('sales_invoice_items',1);
+INSERT INTO sqlite_sequence VALUES('purchase_invoices',1);
+CREATE VIEW invoices AS SELECT * FROM sales_invoices;
+CREATE VIEW invoice_items AS SELECT * FROM sales_invoice_items;
+COMMIT;
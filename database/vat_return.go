@@ -0,0 +1,445 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VAT categories of tax rates and invoice lines
+const (
+	VATCategoryStandard   = "standard"
+	VATCategoryZeroRated  = "zero_rated"
+	VATCategoryExempt     = "exempt"
+	VATCategoryOutOfScope = "out_of_scope"
+)
+
+var vatCategories = map[string]bool{
+	VATCategoryStandard:   true,
+	VATCategoryZeroRated:  true,
+	VATCategoryExempt:     true,
+	VATCategoryOutOfScope: true,
+}
+
+// MaxVATReturnCorrections is the largest net correction of earlier periods ZATCA accepts on a return;
+// larger errors need a voluntary disclosure
+const MaxVATReturnCorrections = 5000
+
+// vatReturnBoxes are the boxes of the ZATCA return filled from documents. Box 2 (private healthcare,
+// private education and first homes for citizens) is not tracked and stays out of the report.
+var vatReturnBoxes = []VATReturnBox{
+	{Box: 1, Label: "Standard rated sales", LabelArabic: "المبيعات الخاضعة للنسبة الأساسية"},
+	{Box: 3, Label: "Zero rated domestic sales", LabelArabic: "المبيعات المحلية الخاضعة للنسبة الصفرية"},
+	{Box: 4, Label: "Exports", LabelArabic: "الصادرات"},
+	{Box: 5, Label: "Exempt sales", LabelArabic: "المبيعات المعفاة"},
+	{Box: 6, Label: "Total sales", LabelArabic: "إجمالي المبيعات"},
+	{Box: 7, Label: "Standard rated domestic purchases", LabelArabic: "المشتريات المحلية الخاضعة للنسبة الأساسية"},
+	{Box: 8, Label: "Imports subject to VAT paid at customs", LabelArabic: "الاستيرادات الخاضعة لضريبة القيمة المضافة التي تدفع في الجمارك"},
+	{Box: 9, Label: "Imports subject to VAT accounted for through reverse charge", LabelArabic: "الاستيرادات الخاضعة لضريبة القيمة المضافة التي تطبق عليها آلية الاحتساب العكسي"},
+	{Box: 10, Label: "Zero rated purchases", LabelArabic: "المشتريات الخاضعة للنسبة الصفرية"},
+	{Box: 11, Label: "Exempt purchases", LabelArabic: "المشتريات المعفاة"},
+	{Box: 12, Label: "Total purchases", LabelArabic: "إجمالي المشتريات"},
+}
+
+// domesticCountries are the ways Saudi Arabia is written on customers and suppliers; an empty country is domestic
+var domesticCountries = map[string]bool{
+	"":                        true,
+	"sa":                      true,
+	"ksa":                     true,
+	"saudi arabia":            true,
+	"kingdom of saudi arabia": true,
+	"السعودية":                true,
+	"المملكة العربية السعودية": true,
+}
+
+func isForeignCountry(country string) bool {
+	return !domesticCountries[strings.ToLower(strings.TrimSpace(country))]
+}
+
+// defaultVATCategory is the category of a tax rate that has none
+func defaultVATCategory(rate float64) string {
+	if rate > 0 {
+		return VATCategoryStandard
+	}
+	return VATCategoryZeroRated
+}
+
+// vatLine is a group of lines of one document with the same rate and category
+type vatLine struct {
+	DocumentType   string
+	DocumentID     int
+	DocumentNumber string
+	Date           time.Time
+	PartyName      string
+	VATNumber      string
+	Country        string
+	Rate           float64
+	Category       string
+	Amount         float64
+	VATAmount      float64
+}
+
+// vatCategoryResolver gives the category of a line from the company's tax rates
+type vatCategoryResolver struct {
+	byRate       map[string]string
+	standardRate float64
+}
+
+func newVATCategoryResolver(exec execer, companyID int) (*vatCategoryResolver, error) {
+	rows, err := exec.Query(`
+		SELECT rate, COALESCE(vat_category, '') FROM tax_rates
+		WHERE company_id = ?
+		ORDER BY is_active DESC, is_default DESC, id`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resolver := &vatCategoryResolver{byRate: make(map[string]string)}
+	for rows.Next() {
+		var rate float64
+		var category string
+		if err := rows.Scan(&rate, &category); err != nil {
+			return nil, err
+		}
+		if category == "" {
+			category = defaultVATCategory(rate)
+		}
+		key := fmt.Sprintf("%.2f", rate)
+		if _, ok := resolver.byRate[key]; !ok {
+			resolver.byRate[key] = category
+		}
+		if category == VATCategoryStandard && resolver.standardRate == 0 {
+			resolver.standardRate = rate
+		}
+	}
+	if resolver.standardRate == 0 {
+		resolver.standardRate = 15
+	}
+	return resolver, rows.Err()
+}
+
+// category returns the category of a line: its own when set, otherwise the first active tax rate with its rate
+func (r *vatCategoryResolver) category(line vatLine) string {
+	if vatCategories[line.Category] {
+		return line.Category
+	}
+	if category, ok := r.byRate[fmt.Sprintf("%.2f", line.Rate)]; ok {
+		return category
+	}
+	return defaultVATCategory(line.Rate)
+}
+
+// salesBox returns the return box of a sales line, or 0 when it is out of scope
+func (r *vatCategoryResolver) salesBox(line vatLine) int {
+	category := r.category(line)
+	switch {
+	case category == VATCategoryOutOfScope:
+		return 0
+	case category == VATCategoryExempt:
+		return 5
+	case isForeignCountry(line.Country) && line.VATAmount == 0:
+		return 4
+	case category == VATCategoryZeroRated:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// purchaseBox returns the return box of a purchase line, or 0 when it is out of scope. Imports without
+// VAT on the invoice are services or goods the company has to account for under the reverse charge.
+func (r *vatCategoryResolver) purchaseBox(line vatLine) int {
+	category := r.category(line)
+	switch {
+	case category == VATCategoryOutOfScope:
+		return 0
+	case category == VATCategoryExempt:
+		return 11
+	case isForeignCountry(line.Country) && line.VATAmount != 0:
+		return 8
+	case isForeignCountry(line.Country):
+		return 9
+	case category == VATCategoryZeroRated:
+		return 10
+	default:
+		return 7
+	}
+}
+
+// queryVATLines reads lines of documents as vatLine rows in column order
+func queryVATLines(exec execer, query string, args ...interface{}) ([]vatLine, error) {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []vatLine
+	for rows.Next() {
+		var l vatLine
+		if err := rows.Scan(&l.DocumentType, &l.DocumentID, &l.DocumentNumber, &l.Date, &l.PartyName, &l.VATNumber, &l.Country,
+			&l.Rate, &l.Category, &l.Amount, &l.VATAmount); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// vatReturnDocuments classifies every document of a company in a period into the boxes of the VAT return
+func (d *Database) vatReturnDocuments(companyID int, from, to time.Time) ([]VATReturnDocument, *vatCategoryResolver, error) {
+	resolver, err := newVATCategoryResolver(d.db, companyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	period := []interface{}{companyID, dateOnly(from), dateOnly(to)}
+
+	// Sales invoices, including prepayment invoices which carry the VAT due on advances
+	sales, err := queryVATLines(d.db, `
+		SELECT 'sales_invoice', si.id, si.invoice_number, si.issue_date, COALESCE(c.name, ''), COALESCE(c.vat_number, ''), COALESCE(c.country, ''),
+			sii.vat_rate, COALESCE(sii.vat_category, ''), SUM(sii.total_amount - sii.vat_amount), SUM(sii.vat_amount)
+		FROM sales_invoices si
+		JOIN sales_invoice_items sii ON sii.invoice_id = si.id
+		LEFT JOIN customers c ON si.customer_id = c.id
+		WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND DATE(si.issue_date) >= DATE(?) AND DATE(si.issue_date) <= DATE(?)
+		GROUP BY si.id, sii.vat_rate, COALESCE(sii.vat_category, '')
+		UNION ALL
+		SELECT 'sales_invoice', si.id, si.invoice_number, si.issue_date, COALESCE(c.name, ''), COALESCE(c.vat_number, ''), COALESCE(c.country, ''),
+			CASE WHEN si.total_amount != si.vat_amount THEN ROUND(si.vat_amount * 100 / (si.total_amount - si.vat_amount), 2) ELSE 0 END, '',
+			si.total_amount - si.vat_amount, si.vat_amount
+		FROM sales_invoices si
+		LEFT JOIN customers c ON si.customer_id = c.id
+		WHERE si.company_id = ? AND si.status NOT IN ('draft', 'cancelled') AND DATE(si.issue_date) >= DATE(?) AND DATE(si.issue_date) <= DATE(?)
+			AND NOT EXISTS (SELECT 1 FROM sales_invoice_items WHERE invoice_id = si.id)`, append(period, period...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	creditNotes, err := queryVATLines(d.db, `
+		SELECT 'credit_note', cn.id, cn.credit_note_number, cn.issue_date, COALESCE(c.name, ''), COALESCE(c.vat_number, ''), COALESCE(c.country, ''),
+			cni.vat_rate, COALESCE(cni.vat_category, ''), -SUM(cni.total_amount - cni.vat_amount), -SUM(cni.vat_amount)
+		FROM credit_notes cn
+		JOIN credit_note_items cni ON cni.credit_note_id = cn.id
+		LEFT JOIN customers c ON cn.customer_id = c.id
+		WHERE cn.company_id = ? AND cn.status = 'issued' AND DATE(cn.issue_date) >= DATE(?) AND DATE(cn.issue_date) <= DATE(?)
+		GROUP BY cn.id, cni.vat_rate, COALESCE(cni.vat_category, '')
+		UNION ALL
+		SELECT 'credit_note', cn.id, cn.credit_note_number, cn.issue_date, COALESCE(c.name, ''), COALESCE(c.vat_number, ''), COALESCE(c.country, ''),
+			CASE WHEN cn.total_amount != cn.vat_amount THEN ROUND(cn.vat_amount * 100 / (cn.total_amount - cn.vat_amount), 2) ELSE 0 END, '',
+			-(cn.total_amount - cn.vat_amount), -cn.vat_amount
+		FROM credit_notes cn
+		LEFT JOIN customers c ON cn.customer_id = c.id
+		WHERE cn.company_id = ? AND cn.status = 'issued' AND DATE(cn.issue_date) >= DATE(?) AND DATE(cn.issue_date) <= DATE(?)
+			AND NOT EXISTS (SELECT 1 FROM credit_note_items WHERE credit_note_id = cn.id)`, append(period, period...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Advances deducted on final invoices or refunded reverse the VAT declared on their prepayment invoice
+	advances, err := queryVATLines(d.db, `
+		SELECT CASE WHEN cct.type = 'applied' THEN 'advance_deduction' ELSE 'advance_refund' END,
+			CASE WHEN cct.type = 'applied' THEN COALESCE(cct.invoice_id, pi.id) ELSE pi.id END,
+			CASE WHEN cct.type = 'applied' THEN COALESCE(si.invoice_number, pi.invoice_number) ELSE pi.invoice_number END,
+			cct.transaction_date, COALESCE(c.name, ''), COALESCE(c.vat_number, ''), COALESCE(c.country, ''),
+			COALESCE((SELECT vat_rate FROM sales_invoice_items WHERE invoice_id = pi.id LIMIT 1), 0),
+			COALESCE((SELECT vat_category FROM sales_invoice_items WHERE invoice_id = pi.id LIMIT 1), ''),
+			cct.amount * (pi.total_amount - pi.vat_amount) / pi.total_amount, cct.amount * pi.vat_amount / pi.total_amount
+		FROM customer_credit_transactions cct
+		JOIN sales_invoices pi ON cct.prepayment_invoice_id = pi.id
+		LEFT JOIN sales_invoices si ON cct.invoice_id = si.id
+		LEFT JOIN customers c ON cct.customer_id = c.id
+		WHERE cct.company_id = ? AND cct.type IN ('applied', 'refund') AND pi.total_amount != 0
+			AND DATE(cct.transaction_date) >= DATE(?) AND DATE(cct.transaction_date) <= DATE(?)`, period...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	purchases, err := queryVATLines(d.db, `
+		SELECT 'purchase_invoice', pi.id, pi.invoice_number, pi.issue_date, COALESCE(s.company_name, ''), COALESCE(s.vat_number, ''), COALESCE(s.country, ''),
+			pii.vat_rate, COALESCE(pii.vat_category, ''), SUM(pii.total_amount - pii.vat_amount), SUM(pii.vat_amount)
+		FROM purchase_invoices pi
+		JOIN purchase_invoice_items pii ON pii.invoice_id = pi.id
+		LEFT JOIN suppliers s ON pi.supplier_id = s.id
+		WHERE pi.company_id = ? AND pi.status NOT IN ('draft', 'cancelled') AND DATE(pi.issue_date) >= DATE(?) AND DATE(pi.issue_date) <= DATE(?)
+		GROUP BY pi.id, pii.vat_rate, COALESCE(pii.vat_category, '')
+		UNION ALL
+		SELECT 'purchase_invoice', pi.id, pi.invoice_number, pi.issue_date, COALESCE(s.company_name, ''), COALESCE(s.vat_number, ''), COALESCE(s.country, ''),
+			COALESCE(pi.vat_rate, 0), '', pi.total_amount - pi.vat_amount, pi.vat_amount
+		FROM purchase_invoices pi
+		LEFT JOIN suppliers s ON pi.supplier_id = s.id
+		WHERE pi.company_id = ? AND pi.status NOT IN ('draft', 'cancelled') AND DATE(pi.issue_date) >= DATE(?) AND DATE(pi.issue_date) <= DATE(?)
+			AND NOT EXISTS (SELECT 1 FROM purchase_invoice_items WHERE invoice_id = pi.id)`, append(period, period...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Expenses with non-recoverable VAT have nothing to claim and stay out of the return
+	expenses, err := queryVATLines(d.db, `
+		SELECT 'expense', e.id, e.expense_number, e.expense_date, COALESCE(e.payee_name, ''), COALESCE(s.vat_number, ''), COALESCE(s.country, ''),
+			e.vat_rate, COALESCE(e.vat_category, ''), e.amount, e.vat_amount
+		FROM expenses e
+		LEFT JOIN suppliers s ON e.supplier_id = s.id
+		WHERE e.company_id = ? AND e.status = 'recorded' AND e.deleted_at IS NULL AND e.vat_recoverable = 1
+			AND DATE(e.expense_date) >= DATE(?) AND DATE(e.expense_date) <= DATE(?)`, period...)
+	if err != nil {
+		return nil, nil, err
+	}
+	purchases = append(purchases, expenses...)
+
+	type documentKey struct {
+		box          int
+		documentType string
+		documentID   int
+		adjustment   bool
+	}
+	documents := make(map[documentKey]*VATReturnDocument)
+	var order []documentKey
+	add := func(box int, line vatLine, adjustment bool) {
+		if box == 0 {
+			return
+		}
+		key := documentKey{box, line.DocumentType, line.DocumentID, adjustment}
+		document, ok := documents[key]
+		if !ok {
+			document = &VATReturnDocument{
+				Box:            box,
+				DocumentType:   line.DocumentType,
+				DocumentID:     line.DocumentID,
+				DocumentNumber: line.DocumentNumber,
+				Date:           line.Date,
+				PartyName:      line.PartyName,
+				VATNumber:      line.VATNumber,
+				IsAdjustment:   adjustment,
+			}
+			documents[key] = document
+			order = append(order, key)
+		}
+		document.Amount += line.Amount
+		document.VATAmount += line.VATAmount
+	}
+
+	// Negative lines on sales invoices are returns and go in the adjustment column like credit notes
+	for _, line := range sales {
+		add(resolver.salesBox(line), line, line.Amount < 0)
+	}
+	for _, line := range creditNotes {
+		add(resolver.salesBox(line), line, true)
+	}
+	for _, line := range advances {
+		add(resolver.salesBox(line), line, true)
+	}
+	for _, line := range purchases {
+		box := resolver.purchaseBox(line)
+		if box == 9 {
+			line.VATAmount = line.Amount * resolver.standardRate / 100
+		}
+		add(box, line, line.Amount < 0)
+	}
+
+	result := make([]VATReturnDocument, 0, len(order))
+	for _, key := range order {
+		document := documents[key]
+		document.Amount = roundAmount(document.Amount)
+		document.VATAmount = roundAmount(document.VATAmount)
+		if document.Amount == 0 && document.VATAmount == 0 {
+			continue
+		}
+		result = append(result, *document)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Box != result[j].Box {
+			return result[i].Box < result[j].Box
+		}
+		if !result[i].Date.Equal(result[j].Date) {
+			return result[i].Date.Before(result[j].Date)
+		}
+		return result[i].DocumentNumber < result[j].DocumentNumber
+	})
+	return result, resolver, nil
+}
+
+// GetVATReturn fills the ZATCA VAT return boxes for a period from the company's documents. Corrections of
+// earlier periods and the credit carried forward are entered by the user as on the ZATCA form.
+func (d *Database) GetVATReturn(companyID int, from, to time.Time, corrections, creditCarriedForward float64) (*VATReturn, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("the period end is before its start")
+	}
+	if math.Abs(corrections) > MaxVATReturnCorrections {
+		return nil, fmt.Errorf("corrections of more than SAR %d must be filed as a voluntary disclosure", MaxVATReturnCorrections)
+	}
+	if creditCarriedForward < 0 {
+		return nil, fmt.Errorf("the credit carried forward cannot be negative")
+	}
+
+	documents, _, err := d.vatReturnDocuments(companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	vatReturn := &VATReturn{CompanyID: companyID, From: from, To: to, Corrections: roundAmount(corrections), CreditCarriedForward: roundAmount(creditCarriedForward)}
+	for _, box := range vatReturnBoxes {
+		if box.Box <= 6 {
+			vatReturn.Sales = append(vatReturn.Sales, box)
+		} else {
+			vatReturn.Purchases = append(vatReturn.Purchases, box)
+		}
+	}
+	boxes := make(map[int]*VATReturnBox)
+	for i := range vatReturn.Sales {
+		boxes[vatReturn.Sales[i].Box] = &vatReturn.Sales[i]
+	}
+	for i := range vatReturn.Purchases {
+		boxes[vatReturn.Purchases[i].Box] = &vatReturn.Purchases[i]
+	}
+
+	for _, document := range documents {
+		total := boxes[6]
+		if document.Box > 6 {
+			total = boxes[12]
+		}
+		for _, box := range []*VATReturnBox{boxes[document.Box], total} {
+			if document.IsAdjustment {
+				box.Adjustment += document.Amount
+			} else {
+				box.Amount += document.Amount
+			}
+			box.VATAmount += document.VATAmount
+			box.DocumentCount++
+		}
+	}
+	for _, box := range boxes {
+		box.Amount = roundAmount(box.Amount)
+		box.Adjustment = roundAmount(box.Adjustment)
+		box.VATAmount = roundAmount(box.VATAmount)
+	}
+
+	// Reverse charge VAT is due on the sales side and recoverable in box 9, so it nets out
+	vatReturn.ReverseChargeVAT = boxes[9].VATAmount
+	vatReturn.TotalVATDue = roundAmount(boxes[6].VATAmount + vatReturn.ReverseChargeVAT - boxes[12].VATAmount)
+	vatReturn.NetVATDue = roundAmount(vatReturn.TotalVATDue + vatReturn.Corrections - vatReturn.CreditCarriedForward)
+
+	return vatReturn, nil
+}
+
+// GetVATReturnDocuments returns the documents behind a box of the VAT return. Box 6 and 12 return all
+// sales or purchase documents and box 0 every document of the return.
+func (d *Database) GetVATReturnDocuments(companyID int, from, to time.Time, box int) ([]VATReturnDocument, error) {
+	documents, _, err := d.vatReturnDocuments(companyID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []VATReturnDocument{}
+	for _, document := range documents {
+		switch {
+		case box == 0, box == document.Box,
+			box == 6 && document.Box < 6,
+			box == 12 && document.Box > 6 && document.Box < 12:
+			filtered = append(filtered, document)
+		}
+	}
+	return filtered, nil
+}
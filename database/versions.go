@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// versionedTables are the business records people edit side by side. Each row has a version that a trigger
+// raises on every change, so an update made from a stale copy of a row can be refused.
+var versionedTables = []struct {
+	entity string
+	table  string
+}{
+	{"customer", "customers"},
+	{"supplier", "suppliers"},
+	{"product", "products"},
+	{"product_category", "product_categories"},
+	{"sales_invoice", "sales_invoices"},
+	{"purchase_invoice", "purchase_invoices"},
+	{"payment", "payments"},
+	{"expense", "expenses"},
+}
+
+// ConflictError is returned when a record is updated from a version that is no longer current, because
+// someone else changed it after it was read. The update is not applied; Current is the record as it is now.
+type ConflictError struct {
+	Entity         string      `json:"entity"`
+	ID             int         `json:"id"`
+	Version        int         `json:"version"`         // The version the update was made from
+	CurrentVersion int         `json:"current_version"` // The version of the record now
+	Current        interface{} `json:"current"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %d was changed by someone else since it was read (version %d, now %d)",
+		e.Entity, e.ID, e.Version, e.CurrentVersion)
+}
+
+// versionCondition restricts an update to the version of the record it was made from. Updates from
+// version 0 apply to any version, for callers that write a record without having read it.
+const versionCondition = "version = COALESCE(NULLIF(?, 0), version)"
+
+// addRecordVersions adds the version column of the versioned tables and the triggers that raise it
+func addRecordVersions(tx *sql.Tx) error {
+	for _, t := range versionedTables {
+		statements := []string{
+			fmt.Sprintf(`ALTER TABLE %s ADD COLUMN version INTEGER NOT NULL DEFAULT 1`, t.table),
+			fmt.Sprintf(`CREATE TRIGGER version_%[1]s AFTER UPDATE ON %[1]s WHEN NEW.version = OLD.version BEGIN
+				UPDATE %[1]s SET version = OLD.version + 1 WHERE id = NEW.id;
+			END`, t.table),
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("error versioning %s: %v", t.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkVersion completes an update of a versioned record made with versionCondition and returns the version
+// the record has now. When the update was refused because the record has another version, it returns a
+// ConflictError with the record as current reads it. When exec is a transaction it is rolled back first, as
+// current reads outside it and the encrypted store refuses readers while a write is open. Updates of missing
+// records are not conflicts.
+func checkVersion(exec execer, table string, id, version int, result sql.Result, current func() (interface{}, error)) (int, error) {
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	var now int
+	err = exec.QueryRow(fmt.Sprintf("SELECT version FROM %s WHERE id = ?", table), id).Scan(&now)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading the version of %s %d: %v", table, id, err)
+	}
+	if updated > 0 {
+		return now, nil
+	}
+
+	conflict := &ConflictError{ID: id, Version: version, CurrentVersion: now}
+	for _, t := range versionedTables {
+		if t.table == table {
+			conflict.Entity = t.entity
+		}
+	}
+	if tx, ok := exec.(*sql.Tx); ok {
+		tx.Rollback()
+	}
+	if conflict.Current, err = current(); err != nil {
+		return 0, err
+	}
+	return 0, conflict
+}
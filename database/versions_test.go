@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateFromStaleVersion(t *testing.T) {
+	d := openDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"))
+	if err := seedSalesInvoices(d.db, 1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two cashiers open the same product and both save it
+	first, err := d.GetProductByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := *first
+	first.Name = "Saved first"
+	if err := d.UpdateProduct(first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 2 {
+		t.Errorf("version %d after an update, want 2", first.Version)
+	}
+	second.Name = "Saved second"
+	err = d.UpdateProduct(&second)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("updating a stale product: %v, want a ConflictError", err)
+	}
+	if current, ok := conflict.Current.(*Product); !ok || current.Name != "Saved first" || conflict.CurrentVersion != 2 {
+		t.Errorf("conflict = %+v, want the product saved first", conflict)
+	}
+
+	// The other writes to the row, like a change of status, raise its version too
+	invoice, err := d.GetSalesInvoiceByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(`UPDATE sales_invoices SET status = 'paid' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	invoice.Notes = "Edited"
+	if err := d.UpdateSalesInvoice(invoice); !errors.As(err, &conflict) {
+		t.Errorf("updating an invoice paid since it was read: %v, want a ConflictError", err)
+	}
+	if stored, err := d.GetSalesInvoiceByID(1); err != nil || stored.Notes != "" || stored.Status != "paid" {
+		t.Errorf("the refused update changed the invoice: %+v, %v", stored, err)
+	}
+
+	// The version of a saved payment is handed back, so it can be saved again
+	if _, err := d.db.Exec(`INSERT INTO payments (company_id, invoice_id, payment_type_id, amount, payment_date, reference, notes, notes_arabic, status)
+		VALUES (1, 1, 1, 1.15, '2020-01-03', '', '', '', 'completed')`); err != nil {
+		t.Fatal(err)
+	}
+	payment, err := d.GetPaymentByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, notes := range []string{"First", "Second"} {
+		payment.Notes = notes
+		if err := d.UpdatePayment(&payment); err != nil {
+			t.Fatalf("saving the payment again: %v", err)
+		}
+	}
+	if payment.Version != 3 {
+		t.Errorf("payment version %d after two updates, want 3", payment.Version)
+	}
+
+	// Callers that never read the record write it without a check
+	second.Version = 0
+	if err := d.UpdateProduct(&second); err != nil {
+		t.Errorf("updating without a version: %v", err)
+	}
+}
+
+func TestUpdateFromStaleVersionEncrypted(t *testing.T) {
+	d := openEncryptedDatabase(t, filepath.Join(t.TempDir(), "dijibill.db"), nil)
+	if err := seedSalesInvoices(d.db, 1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(`INSERT INTO payments (company_id, invoice_id, payment_type_id, amount, payment_date, reference, notes, notes_arabic, status)
+		VALUES (1, 1, 1, 1.15, '2020-01-03', '', '', '', 'completed')`); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record in the conflict is read after the write transaction is over, as the encrypted store
+	// refuses readers while it is open
+	invoice, err := d.GetSalesInvoiceByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := d.GetPaymentByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(`UPDATE sales_invoices SET status = 'paid' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(`UPDATE payments SET notes = 'Elsewhere' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	var conflict *ConflictError
+	invoice.Notes = "Edited"
+	err = d.UpdateSalesInvoice(invoice)
+	if !errors.As(err, &conflict) {
+		t.Fatalf("updating a stale invoice: %v, want a ConflictError", err)
+	}
+	if current, ok := conflict.Current.(*SalesInvoice); !ok || current.Status != "paid" {
+		t.Errorf("conflict = %+v, want the paid invoice", conflict)
+	}
+	payment.Notes = "Edited"
+	err = d.UpdatePayment(&payment)
+	if !errors.As(err, &conflict) {
+		t.Fatalf("updating a stale payment: %v, want a ConflictError", err)
+	}
+	if current, ok := conflict.Current.(Payment); !ok || current.Notes != "Elsewhere" {
+		t.Errorf("conflict = %+v, want the payment saved elsewhere", conflict)
+	}
+}
@@ -36,8 +36,8 @@ cleanup() {
 trap cleanup SIGINT SIGTERM
 
 # Run wails dev
-echo "🔧 Running: wails dev"
-wails dev
+echo "🔧 Running: wails dev -tags sqlite_fts5"
+wails dev -tags sqlite_fts5
 
 # Cleanup when wails dev exits
 cleanup
\ No newline at end of file
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"dijibill/database"
+)
+
+// Export formats
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatXLSX = "xlsx"
+)
+
+// ExportColumn is a column that can be chosen for an export. Keys are the JSON names of the fields,
+// with nested fields prefixed by their parent, e.g. customer_name or buckets_over_90.
+type ExportColumn struct {
+	Key     string `json:"key"`
+	Header  string `json:"header"`
+	Type    string `json:"type"`    // string, integer, number, date, boolean
+	Default bool   `json:"default"` // Exported when no columns are chosen
+}
+
+// ExportTable is a list or report flattened into typed rows for CSV and XLSX
+type ExportTable struct {
+	Title   string
+	Columns []ExportColumn
+	Rows    [][]interface{} // string, int64, float64, time.Time, bool or nil
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	dateType = reflect.TypeOf(database.Date{})
+)
+
+// exportField is how a column is read from a record
+type exportField struct {
+	column ExportColumn
+	index  [][]int // Field indexes from the record down to the value, one per pointer to follow
+	list   bool    // A slice of numbers, exported as a column per element
+	slot   int     // Element of the slice
+}
+
+// exportFields lists the exportable columns of a record type. Scalars, dates and slices of numbers become
+// columns; value and embedded structs are flattened, related records behind a pointer are flattened one
+// level deep without being exported by default, and other slices such as invoice items are left out.
+func exportFields(t reflect.Type) []exportField {
+	var fields []exportField
+	var walk func(t reflect.Type, prefix string, path [][]int, related bool)
+	walk = func(t reflect.Type, prefix string, path [][]int, related bool) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			fieldPath := make([][]int, len(path))
+			copy(fieldPath, path)
+			last := len(path) - 1
+			fieldPath[last] = append(append([]int{}, path[last]...), i)
+
+			ft := f.Type
+			if f.Anonymous && ft.Kind() == reflect.Struct && ft != dateType {
+				walk(ft, prefix, fieldPath, related)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			key := prefix + name
+
+			pointer := ft.Kind() == reflect.Ptr
+			if pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType && ft != dateType {
+				switch {
+				case !pointer:
+					walk(ft, key+"_", fieldPath, related)
+				case !related:
+					walk(ft, key+"_", append(fieldPath, []int{}), true)
+				}
+				continue
+			}
+
+			field := exportField{column: ExportColumn{Key: key, Header: exportHeader(key), Default: !related}, index: fieldPath}
+			switch ft.Kind() {
+			case reflect.String:
+				field.column.Type = "string"
+			case reflect.Bool:
+				field.column.Type = "boolean"
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				field.column.Type = "integer"
+			case reflect.Float32, reflect.Float64:
+				field.column.Type = "number"
+			case reflect.Struct:
+				field.column.Type = "date"
+			case reflect.Slice:
+				if k := ft.Elem().Kind(); k != reflect.Float64 && k != reflect.Int {
+					continue
+				}
+				field.column.Type = "number"
+				field.list = true
+			default:
+				continue
+			}
+			fields = append(fields, field)
+		}
+	}
+	walk(t, "", [][]int{{}}, false)
+
+	// A related record's id repeats the foreign key of the record, e.g. customer_id
+	seen := make(map[string]bool, len(fields))
+	unique := fields[:0]
+	for _, field := range fields {
+		if !seen[field.column.Key] {
+			seen[field.column.Key] = true
+			unique = append(unique, field)
+		}
+	}
+	return unique
+}
+
+// exportHeader turns a column key into a header, e.g. vat_number into VAT Number
+func exportHeader(key string) string {
+	words := strings.Split(key, "_")
+	for i, word := range words {
+		switch word {
+		case "id", "vat", "qr", "sku", "uuid", "iban", "zatca":
+			words[i] = strings.ToUpper(word)
+		default:
+			if word != "" {
+				words[i] = strings.ToUpper(word[:1]) + word[1:]
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// lookup follows the field from a record, returning false when a pointer on the way is nil
+func (f exportField) lookup(record reflect.Value) (reflect.Value, bool) {
+	v := record
+	for i, index := range f.index {
+		if i > 0 {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.FieldByIndex(index)
+	}
+	return v, true
+}
+
+// value reads the field from a record as a cell
+func (f exportField) value(record reflect.Value) interface{} {
+	v, ok := f.lookup(record)
+	if !ok {
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Slice:
+		if f.slot >= v.Len() {
+			return nil
+		}
+		return v.Index(f.slot).Convert(reflect.TypeOf(float64(0))).Float()
+	case reflect.Struct:
+		var t time.Time
+		if v.Type() == dateType {
+			t = v.Interface().(database.Date).Time
+		} else {
+			t = v.Interface().(time.Time)
+		}
+		if t.IsZero() {
+			return nil
+		}
+		return t
+	}
+	return nil
+}
+
+// NewExportTable flattens a slice of records into a table with the chosen columns, or the default
+// columns when none are chosen
+func NewExportTable(title string, records interface{}, columns []string) (*ExportTable, error) {
+	list := reflect.ValueOf(records)
+	if list.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("cannot export %T", records)
+	}
+	t := list.Type().Elem()
+	pointers := t.Kind() == reflect.Ptr
+	if pointers {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot export %T", records)
+	}
+
+	fields := exportFields(t)
+	var selected []exportField
+	if len(columns) == 0 {
+		for _, field := range fields {
+			if field.column.Default {
+				selected = append(selected, field)
+			}
+		}
+	} else {
+		byKey := make(map[string]exportField, len(fields))
+		for _, field := range fields {
+			byKey[field.column.Key] = field
+		}
+		for _, key := range columns {
+			field, ok := byKey[key]
+			if !ok {
+				return nil, fmt.Errorf("unknown export column %q", key)
+			}
+			selected = append(selected, field)
+		}
+	}
+
+	// Slices of numbers, such as the amounts of each comparison column, get a column per element
+	var expanded []exportField
+	for _, field := range selected {
+		if !field.list {
+			expanded = append(expanded, field)
+			continue
+		}
+		length := 0
+		for i := 0; i < list.Len(); i++ {
+			record := list.Index(i)
+			if pointers {
+				if record.IsNil() {
+					continue
+				}
+				record = record.Elem()
+			}
+			if v, ok := field.lookup(record); ok && v.Len() > length {
+				length = v.Len()
+			}
+		}
+		for slot := 0; slot < length; slot++ {
+			f := field
+			f.slot = slot
+			f.column.Key = fmt.Sprintf("%s_%d", field.column.Key, slot+1)
+			f.column.Header = fmt.Sprintf("%s %d", field.column.Header, slot+1)
+			expanded = append(expanded, f)
+		}
+	}
+	selected = expanded
+
+	table := &ExportTable{Title: title}
+	for _, field := range selected {
+		table.Columns = append(table.Columns, field.column)
+	}
+	for i := 0; i < list.Len(); i++ {
+		record := list.Index(i)
+		if pointers {
+			if record.IsNil() {
+				continue
+			}
+			record = record.Elem()
+		}
+		row := make([]interface{}, len(selected))
+		for j, field := range selected {
+			row[j] = field.value(record)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// ExportColumnsOf lists the columns a record type can be exported with
+func ExportColumnsOf(record interface{}) []ExportColumn {
+	t := reflect.TypeOf(record)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	var columns []ExportColumn
+	for _, field := range exportFields(t) {
+		columns = append(columns, field.column)
+	}
+	return columns
+}
+
+// SetHeaders renames the headers of columns by key, e.g. to name comparison columns after their periods
+func (t *ExportTable) SetHeaders(headers map[string]string) {
+	for i, column := range t.Columns {
+		if header, ok := headers[column.Key]; ok {
+			t.Columns[i].Header = header
+		}
+	}
+}
+
+// Write writes the table in a format
+func (t *ExportTable) Write(w io.Writer, format string) error {
+	switch format {
+	case ExportFormatCSV:
+		return t.WriteCSV(w)
+	case ExportFormatXLSX:
+		return t.WriteXLSX(w)
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// WriteCSV writes the table as CSV. The UTF-8 byte order mark lets Excel show Arabic text.
+func (t *ExportTable) WriteCSV(w io.Writer) error {
+	if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	headers := make([]string, len(t.Columns))
+	for i, column := range t.Columns {
+		headers[i] = column.Header
+	}
+	cw.Write(headers)
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = csvCell(cell)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if isDateOnly(v) {
+			return v.Format("2006-01-02")
+		}
+		return v.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(cell)
+}
+
+func isDateOnly(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
+
+// Cell styles of the XLSX stylesheet, in the order of its cellXfs
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleHeader
+	xlsxStyleNumber
+	xlsxStyleDate
+	xlsxStyleDateTime
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="5">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+
+// WriteXLSX writes the table as a single sheet workbook. Numbers and dates are written as typed cells so
+// they can be summed and sorted; text is written inline so no shared strings table is needed.
+func (t *ExportTable) WriteXLSX(w io.Writer) error {
+	sheetName := t.Title
+	for _, c := range `\/?*[]:` {
+		sheetName = strings.ReplaceAll(sheetName, string(c), " ")
+	}
+	if runes := []rune(sheetName); len(runes) > 31 {
+		sheetName = string(runes[:31])
+	}
+	if strings.TrimSpace(sheetName) == "" {
+		sheetName = "Sheet1"
+	}
+
+	var sheet bytes.Buffer
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	if len(t.Columns) > 0 {
+		sheet.WriteString(`<cols>`)
+		for i, column := range t.Columns {
+			width := 12
+			if column.Type == "string" {
+				width = 24
+			}
+			fmt.Fprintf(&sheet, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width)
+		}
+		sheet.WriteString(`</cols>`)
+	}
+	sheet.WriteString(`<sheetData>`)
+	header := make([]interface{}, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = column.Header
+	}
+	writeXLSXRow(&sheet, 1, header, xlsxStyleHeader)
+	for i, row := range t.Rows {
+		writeXLSXRow(&sheet, i+2, row, xlsxStyleDefault)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	var name bytes.Buffer
+	xml.EscapeText(&name, []byte(sheetName))
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + name.String() + `" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+		{"xl/styles.xml", xlsxStyles},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeXLSXRow(buf *bytes.Buffer, number int, cells []interface{}, textStyle int) {
+	fmt.Fprintf(buf, `<row r="%d">`, number)
+	for i, cell := range cells {
+		ref := xlsxColumnName(i) + strconv.Itoa(number)
+		switch v := cell.(type) {
+		case nil:
+			continue
+		case string:
+			if v == "" {
+				continue
+			}
+			fmt.Fprintf(buf, `<c r="%s" t="inlineStr" s="%d"><is><t xml:space="preserve">`, ref, textStyle)
+			xml.EscapeText(buf, []byte(v))
+			buf.WriteString(`</t></is></c>`)
+		case int64:
+			fmt.Fprintf(buf, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case float64:
+			fmt.Fprintf(buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, xlsxStyleNumber, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			value := 0
+			if v {
+				value = 1
+			}
+			fmt.Fprintf(buf, `<c r="%s" t="b"><v>%d</v></c>`, ref, value)
+		case time.Time:
+			style := xlsxStyleDateTime
+			if isDateOnly(v) {
+				style = xlsxStyleDate
+			}
+			fmt.Fprintf(buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(excelSerial(v), 'f', -1, 64))
+		}
+	}
+	buf.WriteString(`</row>`)
+}
+
+// xlsxColumnName returns the letters of a zero-based column, e.g. 27 is AB
+func xlsxColumnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// excelSerial converts a time to an Excel date serial: days since 30 December 1899 in the time's own zone
+func excelSerial(t time.Time) float64 {
+	base := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return local.Sub(base).Hours() / 24
+}
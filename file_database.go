@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"time"
 
+	"dijibill/database"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -207,6 +209,28 @@ func (fd *FileDatabase) GetFileContent(metadataID int) ([]byte, error) {
 	return content, nil
 }
 
+// GetFileContentByHash retrieves the stored content of a file by its hash. Files saved again after
+// deduplication share the content stored with the first copy.
+func (fd *FileDatabase) GetFileContentByHash(hash string) ([]byte, error) {
+	query := `
+		SELECT fc.content FROM file_content fc
+		JOIN file_metadata fm ON fm.id = fc.file_metadata_id
+		WHERE fm.hash = ?
+		LIMIT 1
+	`
+
+	var content []byte
+	err := fd.db.QueryRow(query, hash).Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("file content not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
 // DeleteFileContent removes file content by metadata ID
 func (fd *FileDatabase) DeleteFileContent(metadataID int) error {
 	query := `DELETE FROM file_content WHERE file_metadata_id = ?`
@@ -360,11 +384,118 @@ func (fd *FileDatabase) GetFileByHash(hash string) (*FileMetadata, error) {
 	return &file, nil
 }
 
+// GetAllFileMetadata retrieves the metadata of every file
+func (fd *FileDatabase) GetAllFileMetadata() ([]FileMetadata, error) {
+	return fd.queryFileMetadata(`SELECT id, original_name, stored_name, relative_path, file_size, mime_type,
+			category, entity_type, entity_id, storage_type, hash,
+			created_at, created_by, updated_at, updated_by, sync_status, last_sync_at
+		FROM file_metadata
+		ORDER BY id`)
+}
+
+// GetFilesWithoutContent retrieves the metadata of files whose content is missing, stored neither with
+// the file nor with another copy of it that shares its content
+func (fd *FileDatabase) GetFilesWithoutContent() ([]FileMetadata, error) {
+	return fd.queryFileMetadata(`SELECT fm.id, fm.original_name, fm.stored_name, fm.relative_path, fm.file_size, fm.mime_type,
+			fm.category, fm.entity_type, fm.entity_id, fm.storage_type, fm.hash,
+			fm.created_at, fm.created_by, fm.updated_at, fm.updated_by, fm.sync_status, fm.last_sync_at
+		FROM file_metadata fm
+		WHERE NOT EXISTS (
+			SELECT 1 FROM file_content fc JOIN file_metadata copy ON copy.id = fc.file_metadata_id
+			WHERE copy.id = fm.id OR copy.hash = fm.hash
+		)
+		ORDER BY fm.id`)
+}
+
+func (fd *FileDatabase) queryFileMetadata(query string, args ...interface{}) ([]FileMetadata, error) {
+	rows, err := fd.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileMetadata
+	for rows.Next() {
+		var file FileMetadata
+		err := rows.Scan(
+			&file.ID, &file.OriginalName, &file.StoredName, &file.RelativePath,
+			&file.FileSize, &file.MimeType, &file.Category, &file.EntityType,
+			&file.EntityID, &file.StorageType, &file.Hash, &file.CreatedAt,
+			&file.CreatedBy, &file.UpdatedAt, &file.UpdatedBy, &file.SyncStatus,
+			&file.LastSyncAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// GetOrphanedContentIDs retrieves the ids of stored file content whose file metadata is missing
+func (fd *FileDatabase) GetOrphanedContentIDs() ([]int, error) {
+	rows, err := fd.db.Query(`SELECT fc.id FROM file_content fc
+		WHERE NOT EXISTS (SELECT 1 FROM file_metadata fm WHERE fm.id = fc.file_metadata_id)
+		ORDER BY fc.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteOrphanedContent removes stored file content, if its file metadata is still missing
+func (fd *FileDatabase) DeleteOrphanedContent(contentID int) error {
+	_, err := fd.db.Exec(`DELETE FROM file_content
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM file_metadata fm WHERE fm.id = file_content.file_metadata_id)`, contentID)
+	return err
+}
+
+// IntegrityCheck runs SQLite's integrity check on the file database and returns the problems it reports
+func (fd *FileDatabase) IntegrityCheck() ([]string, error) {
+	rows, err := fd.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	return problems, rows.Err()
+}
+
 // Close closes the file database connection
 func (fd *FileDatabase) Close() error {
 	return fd.db.Close()
 }
 
+// BackupTo writes a consistent snapshot of the file database to path
+func (fd *FileDatabase) BackupTo(path string) error {
+	return database.BackupSQLite(fd.db, path)
+}
+
+// Snapshot returns a consistent image of the file database, taken in memory
+func (fd *FileDatabase) Snapshot() ([]byte, error) {
+	return database.SnapshotSQLite(fd.db)
+}
+
 // GetDatabaseStats returns statistics about the file database
 func (fd *FileDatabase) GetDatabaseStats() (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
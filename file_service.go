@@ -317,6 +317,37 @@ func (fs *FileService) calculateFileHashFromBytes(content []byte) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// ImportFile stores a copy of a file brought in from another database under a new name, attached to
+// entityID. The copy keeps its own content: paths are unique, so it cannot share another file's entry.
+func (fs *FileService) ImportFile(source FileMetadata, content []byte, entityID int) (*FileMetadata, error) {
+	hash := fs.calculateFileHashFromBytes(content)
+	if hash != source.Hash {
+		return nil, fmt.Errorf("file %s does not match its checksum", source.OriginalName)
+	}
+
+	storedName := fs.generateUniqueFilename(source.OriginalName)
+	now := time.Now()
+	metadata := &FileMetadata{
+		OriginalName: source.OriginalName,
+		StoredName:   storedName,
+		RelativePath: filepath.Join(source.Category, storedName),
+		FileSize:     int64(len(content)),
+		MimeType:     source.MimeType,
+		Category:     source.Category,
+		EntityType:   source.EntityType,
+		EntityID:     entityID,
+		StorageType:  "database",
+		Hash:         hash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		SyncStatus:   "pending",
+	}
+	if err := fs.fileDB.CreateFileMetadataWithContent(metadata, content); err != nil {
+		return nil, fmt.Errorf("failed to create file metadata with content: %v", err)
+	}
+	return metadata, nil
+}
+
 // generateUniqueFilename creates a unique filename based on timestamp and hash
 func (fs *FileService) generateUniqueFilename(originalFilename string) string {
 	// Get file extension
@@ -129,7 +129,16 @@ export function showDbError(operation, entity, error) {
   };
   
   const title = messages[operation] || `${operation} failed`;
+  if (isConflictError(error)) {
+    return showWarning(title, `Someone else changed this ${entity} after you opened it. Reload it to see their changes, then make yours again.`);
+  }
   const message = error?.message || error || 'An unexpected error occurred. Please try again.';
   
   return showError(title, message);
+}
+
+// Updates made from an outdated copy of a record are rejected with a conflict, which carries the record
+// as it is now in error.current
+export function isConflictError(error) {
+  return error?.kind === 'conflict';
 }
\ No newline at end of file
@@ -196,19 +196,19 @@ export function UpdateCompany(arg1:database.Company):Promise<void>;
 
 export function UpdateCompressionSettings(arg1:number,arg2:number,arg3:number,arg4:number,arg5:number):Promise<void>;
 
-export function UpdateCustomer(arg1:database.Customer):Promise<void>;
+export function UpdateCustomer(arg1:database.Customer):Promise<database.Customer>;
 
 export function UpdateDefaultProductSettings(arg1:database.DefaultProductSettings):Promise<void>;
 
 export function UpdateLastBackupTime(arg1:time.Time):Promise<void>;
 
-export function UpdatePayment(arg1:database.Payment):Promise<void>;
+export function UpdatePayment(arg1:database.Payment):Promise<database.Payment>;
 
 export function UpdatePaymentType(arg1:database.PaymentType):Promise<void>;
 
-export function UpdateProduct(arg1:database.Product):Promise<void>;
+export function UpdateProduct(arg1:database.Product):Promise<database.Product>;
 
-export function UpdatePurchaseInvoice(arg1:database.PurchaseInvoice):Promise<void>;
+export function UpdatePurchaseInvoice(arg1:database.PurchaseInvoice):Promise<database.PurchaseInvoice>;
 
 export function UpdatePurchaseProduct(arg1:database.PurchaseProduct):Promise<void>;
 
@@ -216,9 +216,9 @@ export function UpdatePurchaseProductCategory(arg1:database.PurchaseProductCateg
 
 export function UpdateSalesCategory(arg1:database.SalesCategory):Promise<void>;
 
-export function UpdateSalesInvoice(arg1:database.SalesInvoice):Promise<void>;
+export function UpdateSalesInvoice(arg1:database.SalesInvoice):Promise<database.SalesInvoice>;
 
-export function UpdateSupplier(arg1:database.Supplier):Promise<void>;
+export function UpdateSupplier(arg1:database.Supplier):Promise<database.Supplier>;
 
 export function UpdateSystemSettings(arg1:database.SystemSettings):Promise<void>;
 
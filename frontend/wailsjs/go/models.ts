@@ -56,6 +56,7 @@ export namespace database {
 	    country_arabic: string;
 	    created_at: time.Time;
 	    updated_at: time.Time;
+	    version: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new Customer(source);
@@ -78,6 +79,7 @@ export namespace database {
 	        this.country_arabic = source["country_arabic"];
 	        this.created_at = this.convertValues(source["created_at"], time.Time);
 	        this.updated_at = this.convertValues(source["updated_at"], time.Time);
+	        this.version = source["version"];
 	    }
 	
 		convertValues(a: any, clazz: any, asMap: boolean = false): any {
@@ -219,6 +221,7 @@ export namespace database {
 	    description_arabic: string;
 	    created_at: time.Time;
 	    updated_at: time.Time;
+	    version: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new ProductCategory(source);
@@ -234,6 +237,7 @@ export namespace database {
 	        this.description_arabic = source["description_arabic"];
 	        this.created_at = this.convertValues(source["created_at"], time.Time);
 	        this.updated_at = this.convertValues(source["updated_at"], time.Time);
+	        this.version = source["version"];
 	    }
 	
 		convertValues(a: any, clazz: any, asMap: boolean = false): any {
@@ -278,6 +282,7 @@ export namespace database {
 	    service_not_using_stock: boolean;
 	    created_at: time.Time;
 	    updated_at: time.Time;
+	    version: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new Product(source);
@@ -308,6 +313,7 @@ export namespace database {
 	        this.service_not_using_stock = source["service_not_using_stock"];
 	        this.created_at = this.convertValues(source["created_at"], time.Time);
 	        this.updated_at = this.convertValues(source["updated_at"], time.Time);
+	        this.version = source["version"];
 	    }
 	
 		convertValues(a: any, clazz: any, asMap: boolean = false): any {
@@ -449,6 +455,7 @@ export namespace database {
 	    updated_by?: number;
 	    created_at: time.Time;
 	    updated_at: time.Time;
+	    version: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new SalesInvoice(source);
@@ -478,6 +485,7 @@ export namespace database {
 	        this.updated_by = source["updated_by"];
 	        this.created_at = this.convertValues(source["created_at"], time.Time);
 	        this.updated_at = this.convertValues(source["updated_at"], time.Time);
+	        this.version = source["version"];
 	    }
 	
 		convertValues(a: any, clazz: any, asMap: boolean = false): any {
@@ -513,6 +521,7 @@ export namespace database {
 	    status: string;
 	    created_at: time.Time;
 	    updated_at: time.Time;
+	    version: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new Payment(source);
@@ -534,6 +543,7 @@ export namespace database {
 	        this.status = source["status"];
 	        this.created_at = this.convertValues(source["created_at"], time.Time);
 	        this.updated_at = this.convertValues(source["updated_at"], time.Time);
+	        this.version = source["version"];
 	    }
 	
 		convertValues(a: any, clazz: any, asMap: boolean = false): any {
@@ -625,6 +635,7 @@ export namespace database {
 	    active: boolean;
 	    created_at: time.Time;
 	    updated_at: time.Time;
+	    version: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new Supplier(source);
@@ -651,6 +662,7 @@ export namespace database {
 	        this.active = source["active"];
 	        this.created_at = this.convertValues(source["created_at"], time.Time);
 	        this.updated_at = this.convertValues(source["updated_at"], time.Time);
+	        this.version = source["version"];
 	    }
 	
 		convertValues(a: any, clazz: any, asMap: boolean = false): any {
@@ -692,6 +704,7 @@ export namespace database {
 	    updated_by?: number;
 	    created_at: time.Time;
 	    updated_at: time.Time;
+	    version: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new PurchaseInvoice(source);
@@ -719,6 +732,7 @@ export namespace database {
 	        this.updated_by = source["updated_by"];
 	        this.created_at = this.convertValues(source["created_at"], time.Time);
 	        this.updated_at = this.convertValues(source["updated_at"], time.Time);
+	        this.version = source["version"];
 	    }
 	
 		convertValues(a: any, clazz: any, asMap: boolean = false): any {
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// GenerateFinancialStatementHTML renders a trial balance, income statement or balance sheet with the bilingual
+// financial statement template
+func (h *HTMLInvoiceService) GenerateFinancialStatementHTML(data FinancialStatementData, companyID, branchID int) (string, error) {
+	if h.templateService == nil {
+		return "", fmt.Errorf("template service not available")
+	}
+
+	tmpl := h.templateService.GetFinancialStatementTemplate()
+	if tmpl == nil {
+		return "", fmt.Errorf("financial statement template not found")
+	}
+
+	company, err := h.getReportCompany(companyID)
+	if err != nil {
+		return "", err
+	}
+	data.Company = company
+
+	if branchID > 0 {
+		branch, err := h.db.GetBranchByID(branchID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get branch: %v", err)
+		}
+		data.Branch = branch
+	}
+	data.GeneratedAt = time.Now()
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute template: %v", err)
+	}
+
+	return buf.String(), nil
+}
@@ -199,7 +199,13 @@ func (h *HTMLInvoiceService) GenerateInvoiceHTMLWithLanguage(invoiceID int, lang
 	items := make([]InvoiceItemData, len(invoice.Items))
 	for i, item := range invoice.Items {
 		product, productErr := h.db.GetProductByID(item.ProductID)
-		if productErr != nil {
+		if item.ProductID == 0 && invoice.InvoiceType == "prepayment" {
+			// The single line of a prepayment invoice is the advance itself
+			product = &database.Product{
+				Name:       "Advance payment",
+				NameArabic: "دفعة مقدمة",
+			}
+		} else if productErr != nil {
 			// Create a placeholder product if the product is not found
 			product = &database.Product{
 				Name:        fmt.Sprintf("Product ID: %d", item.ProductID),
@@ -220,6 +226,30 @@ func (h *HTMLInvoiceService) GenerateInvoiceHTMLWithLanguage(invoiceID int, lang
 		Items:   items,
 	}
 
+	// List every tender the invoice was paid with on the receipt
+	payments, err := h.db.GetPaymentsByInvoiceID(invoice.ID)
+	if err != nil {
+		log.Printf("Warning: Could not load payments for invoice %d: %v", invoice.ID, err)
+	}
+	for i := len(payments) - 1; i >= 0; i-- {
+		if payments[i].Status != "completed" {
+			continue
+		}
+		data.Payments = append(data.Payments, payments[i])
+		data.ChangeDue += payments[i].ChangeAmount
+	}
+
+	// Advances deducted from this invoice reference their prepayment invoices, as ZATCA requires
+	deductions, err := h.db.GetPrepaymentDeductions(invoice.ID)
+	if err != nil {
+		log.Printf("Warning: Could not load prepayment deductions for invoice %d: %v", invoice.ID, err)
+	}
+	data.PrepaymentDeductions = deductions
+	for _, deduction := range deductions {
+		data.PrepaymentTotal += deduction.Amount
+	}
+	data.AmountDue = invoice.TotalAmount - data.PrepaymentTotal
+
 	// Check if template service is available
 	if h.templateService == nil {
 		return "", fmt.Errorf("template service not available")
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dijibill/database"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// GenerateStatementHTML renders a customer statement of account with the bilingual statement template
+func (h *HTMLInvoiceService) GenerateStatementHTML(statement *database.CustomerStatement) (string, error) {
+	if h.templateService == nil {
+		return "", fmt.Errorf("template service not available")
+	}
+
+	tmpl := h.templateService.GetStatementTemplate()
+	if tmpl == nil {
+		return "", fmt.Errorf("statement template not found")
+	}
+
+	company, err := h.db.GetCompanyByID(statement.Customer.CompanyID)
+	if err != nil {
+		company, err = h.db.GetCompany()
+		if err != nil {
+			return "", fmt.Errorf("failed to get company: %v", err)
+		}
+	}
+
+	// Load company logo from file system if LogoFileID is available
+	if company.LogoFileID != nil && *company.LogoFileID > 0 && h.fileService != nil {
+		content, _, fileErr := h.fileService.GetFileContent(*company.LogoFileID)
+		if fileErr == nil && content != nil {
+			company.Logo = base64.StdEncoding.EncodeToString(content)
+		} else {
+			log.Printf("Warning: Could not load logo file ID %d: %v", *company.LogoFileID, fileErr)
+		}
+	}
+
+	data := StatementData{
+		Company:     company,
+		Statement:   statement,
+		GeneratedAt: time.Now(),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute template: %v", err)
+	}
+
+	return buf.String(), nil
+}
+
+// printStatementHTML wraps a rendered statement with print controls and opens it in the browser
+func (h *HTMLInvoiceService) printStatementHTML(htmlContent string, customerID int) error {
+	printHTML := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Print Statement</title>
+    <style>
+        @media print {
+            body { margin: 0; }
+            .no-print { display: none; }
+        }
+        @page {
+            margin: 0.5in;
+            size: A4;
+        }
+    </style>
+</head>
+<body>
+    <div class="no-print" style="padding: 20px; background: #f0f0f0; text-align: center;">
+        <button onclick="window.print()" style="padding: 10px 20px; font-size: 16px; background: #007bff; color: white; border: none; border-radius: 5px; cursor: pointer;">Print / Save as PDF</button>
+        <button onclick="window.close()" style="padding: 10px 20px; font-size: 16px; background: #6c757d; color: white; border: none; border-radius: 5px; cursor: pointer; margin-left: 10px;">Close</button>
+    </div>
+    %s
+</body>
+</html>`, htmlContent)
+
+	filename := fmt.Sprintf("Statement_%d_%s_print.html", customerID, time.Now().Format("20060102"))
+	tempFilePath := filepath.Join(os.TempDir(), filename)
+
+	if err := os.WriteFile(tempFilePath, []byte(printHTML), 0644); err != nil {
+		return err
+	}
+
+	// Open in browser for printing
+	runtime.BrowserOpenURL(h.ctx, "file://"+tempFilePath)
+	return nil
+}
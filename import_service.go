@@ -0,0 +1,342 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"dijibill/database"
+)
+
+// ImportPreview is a file chosen for import: its headers, the first rows and the suggested mapping of
+// fields to headers
+type ImportPreview struct {
+	FilePath   string            `json:"file_path"`
+	FileName   string            `json:"file_name"`
+	Headers    []string          `json:"headers"`
+	SampleRows [][]string        `json:"sample_rows"`
+	RowCount   int               `json:"row_count"`
+	Mapping    map[string]string `json:"mapping"` // Field key to header
+}
+
+// importLine is a row of an import file with its line number, counting the header row
+type importLine struct {
+	number int
+	cells  []string
+}
+
+// ReadImportFile reads the header and rows of a CSV file or of the first sheet of an XLSX workbook.
+// Blank rows are skipped but keep their place in the line numbers.
+func ReadImportFile(fileName string, data []byte) ([]string, []importLine, error) {
+	var records [][]string
+	var err error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".xlsx":
+		records, err = readXLSXRows(data)
+	case ".csv", ".txt":
+		records, err = readImportCSV(data)
+	default:
+		return nil, nil, fmt.Errorf("unsupported import file: %s", filepath.Base(fileName))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("%s is empty", filepath.Base(fileName))
+	}
+
+	headers := make([]string, len(records[0]))
+	for i, header := range records[0] {
+		headers[i] = strings.TrimSpace(header)
+	}
+	var lines []importLine
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		lines = append(lines, importLine{number: i + 2, cells: record})
+	}
+	return headers, lines, nil
+}
+
+// readImportCSV reads a CSV file separated by commas, semicolons or tabs, as saved by Excel in any locale
+func readImportCSV(data []byte) ([][]string, error) {
+	content := string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	firstLine := content
+	if i := strings.IndexAny(content, "\r\n"); i >= 0 {
+		firstLine = content[:i]
+	}
+	delimiter := ','
+	for _, candidate := range []rune{';', '\t'} {
+		if strings.Count(firstLine, string(candidate)) > strings.Count(firstLine, string(delimiter)) {
+			delimiter = candidate
+		}
+	}
+
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV file: %v", err)
+	}
+	return records, nil
+}
+
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, run := range t.Runs {
+		b.WriteString(run.T)
+	}
+	return b.String()
+}
+
+// readXLSXRows reads the cells of the first sheet of a workbook as text
+func readXLSXRows(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not an XLSX workbook: %v", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	decode := func(name string, v interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("the workbook has no %s", name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return xml.NewDecoder(r).Decode(v)
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, fmt.Errorf("the workbook has no sheets")
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	sheetPath := ""
+	for _, rel := range rels.Relationships {
+		if rel.ID == workbook.Sheets[0].ID {
+			if strings.HasPrefix(rel.Target, "/") {
+				sheetPath = strings.TrimPrefix(rel.Target, "/")
+			} else {
+				sheetPath = path.Join("xl", rel.Target)
+			}
+		}
+	}
+
+	var sharedStrings struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return nil, err
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Number int `xml:"r,attr"`
+			Cells  []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decode(sheetPath, &sheet); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	for i, row := range sheet.Rows {
+		number := row.Number
+		if number == 0 {
+			number = i + 1
+		}
+		for len(records) < number {
+			records = append(records, nil)
+		}
+		var record []string
+		for j, cell := range row.Cells {
+			column := j
+			if cell.Ref != "" {
+				column = xlsxColumnIndex(cell.Ref)
+			}
+			for len(record) <= column {
+				record = append(record, "")
+			}
+			switch cell.Type {
+			case "s":
+				var index int
+				if _, err := fmt.Sscan(cell.Value, &index); err == nil && index < len(sharedStrings.Items) {
+					record[column] = sharedStrings.Items[index].String()
+				}
+			case "inlineStr":
+				record[column] = cell.Inline.String()
+			default:
+				record[column] = cell.Value
+			}
+		}
+		records[number-1] = record
+	}
+	return records, nil
+}
+
+// xlsxColumnIndex returns the zero-based column of a cell reference, e.g. AB3 is 27
+func xlsxColumnIndex(ref string) int {
+	index := 0
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		index = index*26 + int(c-'A') + 1
+	}
+	return index - 1
+}
+
+// importHeaderKey normalises a header or field for matching, e.g. "Unit Price" and "unit_price" both become unitprice
+func importHeaderKey(value string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// importHeaderAliases are common headers of other systems' exports for the fields they map to
+var importHeaderAliases = map[string]string{
+	"price":        "unit_price",
+	"sellingprice": "unit_price",
+	"vat":          "vat_rate",
+	"taxrate":      "vat_rate",
+	"vatno":        "vat_number",
+	"taxnumber":    "vat_number",
+	"trn":          "vat_number",
+	"arabicname":   "name_arabic",
+	"nameinarabic": "name_arabic",
+	"quantity":     "stock",
+	"qty":          "stock",
+	"uom":          "unit",
+	"supplier":     "company_name",
+	"mobile":       "phone",
+	"الاسم":        "name",
+	"السعر":        "unit_price",
+	"الباركود":     "barcode",
+	"الفئة":        "category",
+	"الرقمالضريبي": "vat_number",
+}
+
+// suggestImportMapping maps each field to the header that names it, by key, label or a common alias
+func suggestImportMapping(fields []database.ImportField, headers []string) map[string]string {
+	mapping := make(map[string]string)
+	for _, field := range fields {
+		for _, header := range headers {
+			key := importHeaderKey(header)
+			if key == importHeaderKey(field.Key) || key == importHeaderKey(field.Label) || importHeaderAliases[key] == field.Key {
+				mapping[field.Key] = header
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// mapImportRows takes the values of the mapped fields from the rows of an import file
+func mapImportRows(headers []string, lines []importLine, mapping map[string]string) ([]database.ImportRow, error) {
+	columns := make(map[string]int)
+	for field, header := range mapping {
+		if header == "" {
+			continue
+		}
+		column := -1
+		for i, h := range headers {
+			if h == header {
+				column = i
+				break
+			}
+		}
+		if column < 0 {
+			return nil, fmt.Errorf("the file has no %q column", header)
+		}
+		columns[field] = column
+	}
+
+	rows := make([]database.ImportRow, 0, len(lines))
+	for _, line := range lines {
+		row := database.ImportRow{Line: line.number, Values: make(map[string]string, len(columns))}
+		for field, column := range columns {
+			if column < len(line.cells) {
+				row.Values[field] = line.cells[column]
+			} else {
+				row.Values[field] = ""
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readImportPreview reads an import file and suggests how its columns map to the fields of an import
+func readImportPreview(entity, filePath string, data []byte) (*ImportPreview, error) {
+	fields, err := database.GetImportFields(entity)
+	if err != nil {
+		return nil, err
+	}
+	headers, lines, err := ReadImportFile(filePath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &ImportPreview{
+		FilePath: filePath,
+		FileName: filepath.Base(filePath),
+		Headers:  headers,
+		RowCount: len(lines),
+		Mapping:  suggestImportMapping(fields, headers),
+	}
+	for i := 0; i < len(lines) && i < 10; i++ {
+		preview.SampleRows = append(preview.SampleRows, lines[i].cells)
+	}
+	return preview, nil
+}
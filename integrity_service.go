@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"dijibill/database"
+)
+
+// fileEntityTables are the entity types files are attached to, with the table the entity id refers to.
+// Company logos are attached to no entity and are found through the company instead.
+var fileEntityTables = map[string]string{
+	"company": "companies",
+	"product": "products",
+	"expense": "expenses",
+}
+
+// unusedLogoAge is how long an uploaded logo may go unused before it is reported, so that the logo of a
+// company that is still being edited is left alone
+const unusedLogoAge = 24 * time.Hour
+
+// CheckIntegrity checks the application database, then the QR codes stored with sales invoices and the
+// files against it. A stored QR code must hold the date and amounts of its invoice, and every file must
+// have its content and belong to a record or company that exists.
+func CheckIntegrity(db *database.Database, fileService *FileService) (*database.IntegrityReport, error) {
+	report, err := db.CheckIntegrity()
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Issues) > 0 && report.Issues[0].Check == database.IntegrityCorruption {
+		return report, nil
+	}
+	if err := checkQRCodes(db, report); err != nil {
+		return nil, err
+	}
+	if fileService != nil {
+		if err := checkFiles(db, fileService, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// RepairIntegrity checks the data and fixes the issues with the given keys, or every issue that can be
+// fixed when no key is given. The QR code of a draft invoice whose totals are fixed is regenerated with them.
+// It returns the issues left after the repair, with the fixes made and those that failed.
+func RepairIntegrity(db *database.Database, fileService *FileService, keys []string, userID *int) (*database.IntegrityReport, error) {
+	report, err := CheckIntegrity(db, fileService)
+	if err != nil {
+		return nil, err
+	}
+	report.Repair(keys, userID)
+
+	var stale []string
+	for _, issue := range report.Fixed {
+		if issue.Check == database.IntegrityTotals && issue.Table == "sales_invoices" {
+			stale = append(stale, fmt.Sprintf("%s:sales_invoices:%d:", database.IntegrityQRCode, issue.RecordID))
+		}
+	}
+	after, err := CheckIntegrity(db, fileService)
+	if err != nil {
+		return nil, err
+	}
+	if len(stale) > 0 {
+		after.Repair(stale, userID)
+		report.Fixed = append(report.Fixed, after.Fixed...)
+		report.Errors = append(report.Errors, after.Errors...)
+		if after, err = CheckIntegrity(db, fileService); err != nil {
+			return nil, err
+		}
+	}
+	after.Fixed, after.Errors = report.Fixed, report.Errors
+	return after, nil
+}
+
+// invoiceQRData returns the data the QR code of a draft sales invoice is generated from. The seller is the
+// company as it is now.
+func invoiceQRData(db *database.Database, invoice *database.SalesInvoice) (*ZATCAQRData, error) {
+	company, err := db.GetCompanyByID(invoice.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	return &ZATCAQRData{
+		SellerName:  company.Name,
+		VATNumber:   company.VATNumber,
+		Timestamp:   invoice.IssueDate.Time,
+		TotalAmount: invoice.TotalAmount,
+		VATAmount:   invoice.VATAmount,
+	}, nil
+}
+
+// qrCodeDifferences describes how the content of a QR code differs from the invoice it was generated for.
+// Only what is stored with the invoice is compared: the seller is the company as it was when the invoice
+// was issued, which may since have been renamed. The time of day is not compared either, as invoices are
+// dated without one.
+func qrCodeDifferences(qrService *ZATCAQRService, content string, invoice *database.SalesInvoice) []string {
+	tlv, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return []string{"is not valid base64"}
+	}
+	got, err := qrService.parseTLV(tlv)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot be read: %v", err)}
+	}
+
+	var differences []string
+	want := ZATCAQRData{Timestamp: invoice.IssueDate.Time, TotalAmount: invoice.TotalAmount, VATAmount: invoice.VATAmount}
+	if got, want := got.Timestamp.Format("2006-01-02"), want.Timestamp.Format("2006-01-02"); got != want {
+		differences = append(differences, fmt.Sprintf("holds date %s instead of %s", got, want))
+	}
+	if got, want := fmt.Sprintf("%.2f", got.TotalAmount), fmt.Sprintf("%.2f", want.TotalAmount); got != want {
+		differences = append(differences, fmt.Sprintf("holds total %s instead of %s", got, want))
+	}
+	if got, want := fmt.Sprintf("%.2f", got.VATAmount), fmt.Sprintf("%.2f", want.VATAmount); got != want {
+		differences = append(differences, fmt.Sprintf("holds VAT %s instead of %s", got, want))
+	}
+	return differences
+}
+
+// checkQRCodes compares the QR codes stored with sales invoices with their invoices. The QR code of a draft
+// is encoded again from the invoice as it is when the fix runs. That of an issued invoice is what the
+// customer holds, so it is only reported.
+func checkQRCodes(db *database.Database, report *database.IntegrityReport) error {
+	ids, err := db.GetSalesInvoiceIDsWithQRCode()
+	if err != nil {
+		return fmt.Errorf("error reading the QR codes of sales invoices: %v", err)
+	}
+	qrService := NewZATCAQRService()
+	for _, id := range ids {
+		// Invoices of a company that does not exist are reported by the company check
+		invoice, err := db.GetSalesInvoiceByID(id)
+		if err != nil {
+			return err
+		}
+		if exists, err := db.RowExists("companies", invoice.CompanyID); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		differences := qrCodeDifferences(qrService, invoice.QRCode, invoice)
+		if len(differences) == 0 {
+			continue
+		}
+
+		issue := database.IntegrityIssue{
+			Check:    database.IntegrityQRCode,
+			Table:    "sales_invoices",
+			RecordID: id,
+			Message:  fmt.Sprintf("The QR code of sales invoice %s %s", invoice.InvoiceNumber, strings.Join(differences, ", ")),
+		}
+		if invoice.Status != "" && invoice.Status != "draft" {
+			issue.Message += fmt.Sprintf(". %s is %s and its QR code cannot be changed; issue a credit note against it", invoice.InvoiceNumber, invoice.Status)
+			report.Add(issue, nil)
+			continue
+		}
+		issue.Fix = fmt.Sprintf("Generate the QR code of %s again from the invoice", invoice.InvoiceNumber)
+		id := id
+		report.Add(issue, func(userID *int) error {
+			invoice, err := db.GetSalesInvoiceByID(id)
+			if err != nil {
+				return err
+			}
+			data, err := invoiceQRData(db, invoice)
+			if err != nil {
+				return err
+			}
+			tlv, err := qrService.encodeTLV(*data)
+			if err != nil {
+				return fmt.Errorf("failed to encode TLV: %v", err)
+			}
+			return db.SetSalesInvoiceQRCode(id, base64.StdEncoding.EncodeToString(tlv), userID)
+		})
+	}
+	return nil
+}
+
+// checkFiles checks the file database: SQLite's integrity check, files whose content is missing, content
+// of no file, files attached to records that do not exist, logos of no company and companies whose logo
+// does not exist. Files are deleted only when no other file shares their content.
+func checkFiles(db *database.Database, fileService *FileService, report *database.IntegrityReport) error {
+	fileDB := fileService.fileDB
+	problems, err := fileDB.IntegrityCheck()
+	if err != nil {
+		return fmt.Errorf("error checking the file database: %v", err)
+	}
+	for i, problem := range problems {
+		report.Add(database.IntegrityIssue{Check: database.IntegrityCorruption, Table: "file_metadata", RecordID: i + 1,
+			Message: "File database: " + problem}, nil)
+	}
+	if len(problems) > 0 {
+		return nil
+	}
+
+	missing, err := fileDB.GetFilesWithoutContent()
+	if err != nil {
+		return fmt.Errorf("error checking file content: %v", err)
+	}
+	for _, file := range missing {
+		report.Add(database.IntegrityIssue{Check: database.IntegrityFile, Table: "file_metadata", RecordID: file.ID,
+			Column: "hash", Message: fmt.Sprintf("The content of file %s (%d) is missing", file.OriginalName, file.ID)}, nil)
+	}
+
+	orphaned, err := fileDB.GetOrphanedContentIDs()
+	if err != nil {
+		return fmt.Errorf("error checking file content: %v", err)
+	}
+	for _, id := range orphaned {
+		id := id
+		report.Add(database.IntegrityIssue{Check: database.IntegrityFile, Table: "file_content", RecordID: id,
+			Message: fmt.Sprintf("Stored file content %d belongs to no file", id),
+			Fix:     "Delete the content"}, func(userID *int) error {
+			return fileDB.DeleteOrphanedContent(id)
+		})
+	}
+
+	companies, err := db.GetCompanies()
+	if err != nil {
+		return err
+	}
+	logos := make(map[int]bool)
+	for _, company := range companies {
+		if company.LogoFileID == nil || *company.LogoFileID <= 0 {
+			continue
+		}
+		fileID := *company.LogoFileID
+		logos[fileID] = true
+		if logo, err := fileDB.GetFileMetadataByID(fileID); err != nil {
+			return err
+		} else if logo != nil {
+			continue
+		}
+		companyID := company.ID
+		report.Add(database.IntegrityIssue{Check: database.IntegrityFile, Table: "companies", RecordID: companyID,
+			Column:  "logo_file_id",
+			Message: fmt.Sprintf("The logo of company %s is file %d, which does not exist", company.Name, fileID),
+			Fix:     "Remove the logo of the company"}, func(userID *int) error {
+			return db.ClearCompanyLogoFile(companyID, fileID)
+		})
+	}
+
+	files, err := fileDB.GetAllFileMetadata()
+	if err != nil {
+		return fmt.Errorf("error reading files: %v", err)
+	}
+	copies := make(map[string]int)
+	for _, file := range files {
+		copies[file.Hash]++
+	}
+	for _, file := range files {
+		var message, column string
+		var unused func() (bool, error)
+		table, attached := fileEntityTables[file.EntityType]
+		switch {
+		case attached && file.EntityID > 0:
+			exists, err := db.RowExists(table, file.EntityID)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			message = fmt.Sprintf("File %s (%d) is attached to %s %d, which does not exist", file.OriginalName, file.ID, file.EntityType, file.EntityID)
+			column = "entity_id"
+			entityID := file.EntityID
+			unused = func() (bool, error) {
+				exists, err := db.RowExists(table, entityID)
+				return !exists, err
+			}
+		case file.Category == "company_logos" && !logos[file.ID] && time.Since(file.CreatedAt) > unusedLogoAge:
+			message = fmt.Sprintf("Logo %s (%d) is not the logo of any company", file.OriginalName, file.ID)
+			fileID := file.ID
+			unused = func() (bool, error) {
+				companies, err := db.GetCompanies()
+				for _, company := range companies {
+					if company.LogoFileID != nil && *company.LogoFileID == fileID {
+						return false, err
+					}
+				}
+				return true, err
+			}
+		default:
+			continue
+		}
+
+		issue := database.IntegrityIssue{Check: database.IntegrityFile, Table: "file_metadata", RecordID: file.ID, Column: column, Message: message}
+		if copies[file.Hash] > 1 {
+			issue.Message += ", and shares its content with other files"
+			report.Add(issue, nil)
+			continue
+		}
+		issue.Fix = fmt.Sprintf("Delete file %s", file.OriginalName)
+		fileID := file.ID
+		report.Add(issue, func(userID *int) error {
+			if ok, err := unused(); err != nil || !ok {
+				return err
+			}
+			return fileService.DeleteFile(fileID)
+		})
+	}
+	return nil
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"embed"
+	"errors"
+
+	"dijibill/database"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -25,6 +28,8 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
 		OnStartup:        app.startup,
+		OnShutdown:       app.shutdown,
+		ErrorFormatter:   formatError,
 		Bind: []interface{}{
 			app,
 		},
@@ -34,3 +39,18 @@ func main() {
 		println("Error:", err.Error())
 	}
 }
+
+// formatError turns the errors of App methods into what the frontend receives. Errors reach it as their
+// message, except conflicts, which reach it as an object of kind "conflict" holding the record as it is
+// now, so the frontend can tell the user someone else changed it.
+func formatError(err error) any {
+	var conflict *database.ConflictError
+	if errors.As(err, &conflict) {
+		return struct {
+			Kind    string `json:"kind"`
+			Message string `json:"message"`
+			*database.ConflictError
+		}{"conflict", err.Error(), conflict}
+	}
+	return err.Error()
+}
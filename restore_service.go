@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dijibill/database"
+)
+
+// RestoreResult reports a completed restore and where the replaced databases were kept
+type RestoreResult struct {
+	Summary        *database.BackupSummary `json:"summary"`
+	FilesRestored  bool                    `json:"files_restored"` // Whether the backup included the file database
+	PreRestoreCopy []string                `json:"pre_restore_copy"`
+	RestoredAt     time.Time               `json:"restored_at"`
+}
+
+// backupSource returns the database files to restore from a backup set directory or a single database file.
+// The files of a backup set are checked against the checksums in its manifest. filesFile is empty when there
+// is no file database to restore.
+func backupSource(path string) (mainFile, filesFile string, err error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", "", fmt.Errorf("backup not found: %v", err)
+	}
+	if !stat.IsDir() {
+		return path, "", nil
+	}
+
+	info, err := readBackupManifest(path)
+	if err != nil {
+		return "", "", err
+	}
+	for _, file := range info.Files {
+		actual, err := describeBackupFile(filepath.Join(path, file.Name))
+		if err != nil {
+			return "", "", err
+		}
+		if actual.SHA256 != file.SHA256 {
+			return "", "", fmt.Errorf("%s in backup %s has changed since it was written", file.Name, info.Name)
+		}
+		switch file.Name {
+		case mainBackupFileName, mainBackupFileName + sealedFileSuffix:
+			mainFile = filepath.Join(path, file.Name)
+		case filesBackupFileName, filesBackupFileName + sealedFileSuffix:
+			filesFile = filepath.Join(path, file.Name)
+		}
+	}
+	if mainFile == "" {
+		return "", "", fmt.Errorf("backup %s has no %s", info.Name, mainBackupFileName)
+	}
+	return mainFile, filesFile, nil
+}
+
+// backupKeyringPath returns the keyring copy kept with a backup set, or beside a single backup file
+func backupKeyringPath(path string) string {
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		return filepath.Join(path, backupKeyringName)
+	}
+	return filepath.Join(filepath.Dir(path), backupKeyringName)
+}
+
+// readBackupData reads a backup file into memory, decrypting it with keyring when it is sealed, and checks
+// the database it holds
+func readBackupData(path string, keyring *database.Keyring) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup: %v", err)
+	}
+	if database.IsSealed(data) {
+		if keyring == nil {
+			return nil, fmt.Errorf("%s is encrypted, enter the passphrase it was taken with", filepath.Base(path))
+		}
+		if data, err = keyring.Unseal(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := database.VerifyBackupData(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// inspectBackupFile summarises a backup database file, decrypting it with keyring when it is sealed
+func inspectBackupFile(path string, keyring *database.Keyring) (*database.BackupSummary, error) {
+	sealed, err := inspectSealed(path)
+	if err != nil {
+		return nil, err
+	}
+	if !sealed {
+		return database.InspectBackup(path)
+	}
+	image, err := readBackupData(path, keyring)
+	if err != nil {
+		return nil, err
+	}
+	return database.InspectBackupData(path, image)
+}
+
+// restoreSource is a backup file and the database file it replaces
+type restoreSource struct {
+	file   string
+	target string
+	seal   *database.Keyring // Seals the restored copy, for an encrypted database; nil to restore plaintext
+}
+
+// restoreTarget is a database file being replaced by a backup
+type restoreTarget struct {
+	path   string // The live database file
+	staged string // The backup copied next to it, ready to be renamed into place
+	kept   string // Where the live file was moved, once swapped
+}
+
+// restorePlan stages backup files next to the databases they replace, so the swap itself is only renames on
+// one filesystem
+type restorePlan struct {
+	targets []*restoreTarget
+}
+
+// stageRestore copies each backup file next to its target and verifies the copy. Sealed backups are decrypted
+// with keyring, and the copy is sealed again when its target is encrypted. Nothing live is touched.
+func stageRestore(sources []restoreSource, keyring *database.Keyring) (*restorePlan, error) {
+	plan := &restorePlan{}
+	for _, source := range sources {
+		staged := source.target + ".restore"
+		os.Remove(staged) // Left behind by an interrupted restore
+		plan.targets = append(plan.targets, &restoreTarget{path: source.target, staged: staged})
+		if err := stageFile(source, staged, keyring); err != nil {
+			plan.cleanup()
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// stageFile writes the content of one backup file to staged, ready to replace its target
+func stageFile(source restoreSource, staged string, keyring *database.Keyring) error {
+	sealed, err := inspectSealed(source.file)
+	if err != nil {
+		return err
+	}
+	if !sealed && source.seal == nil {
+		if err := copyFile(source.file, staged); err != nil {
+			return err
+		}
+		return database.VerifyBackupFile(staged)
+	}
+
+	data, err := readBackupData(source.file, keyring)
+	if err != nil {
+		return err
+	}
+	if source.seal != nil {
+		if data, err = source.seal.Seal(data); err != nil {
+			return err
+		}
+	}
+	if err := writeNewFile(staged, data); err != nil {
+		return err
+	}
+	_, err = readBackupData(staged, source.seal)
+	return err
+}
+
+// inspectSealed reports whether a file starts like data sealed with a keyring
+func inspectSealed(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("error reading backup: %v", err)
+	}
+	defer f.Close()
+	header := make([]byte, 16)
+	n, _ := io.ReadFull(f, header)
+	return database.IsSealed(header[:n]), nil
+}
+
+// swap moves each live database aside as a pre-restore copy and renames the staged backup into its place.
+// The databases must be closed. If a rename fails the files already swapped are put back.
+func (p *restorePlan) swap(stamp string) error {
+	for _, target := range p.targets {
+		kept := target.path + ".pre-restore-" + stamp
+		if _, err := os.Stat(target.path); err == nil {
+			if err := os.Rename(target.path, kept); err != nil {
+				p.rollback()
+				return fmt.Errorf("error keeping a copy of %s: %v", filepath.Base(target.path), err)
+			}
+			target.kept = kept
+		}
+		if err := os.Rename(target.staged, target.path); err != nil {
+			p.rollback()
+			return fmt.Errorf("error restoring %s: %v", filepath.Base(target.path), err)
+		}
+		target.staged = ""
+	}
+	return nil
+}
+
+// rollback puts the pre-restore copies back in place of the restored files
+func (p *restorePlan) rollback() error {
+	var failed error
+	for _, target := range p.targets {
+		if target.kept == "" {
+			continue
+		}
+		os.Remove(target.path)
+		if err := os.Rename(target.kept, target.path); err != nil {
+			if failed == nil {
+				failed = fmt.Errorf("error putting back %s, it is kept at %s: %v", filepath.Base(target.path), target.kept, err)
+			}
+			continue
+		}
+		target.kept = ""
+	}
+	return failed
+}
+
+// cleanup removes staged files that were not swapped in
+func (p *restorePlan) cleanup() {
+	for _, target := range p.targets {
+		if target.staged != "" {
+			os.Remove(target.staged)
+		}
+	}
+}
+
+// keptCopies returns the paths of the pre-restore copies
+func (p *restorePlan) keptCopies() []string {
+	kept := []string{}
+	for _, target := range p.targets {
+		if target.kept != "" {
+			kept = append(kept, target.kept)
+		}
+	}
+	return kept
+}
+
+// writeNewFile writes data to a file that must not exist yet and syncs it to disk
+func writeNewFile(path string, data []byte) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("error copying backup: %v", err)
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		os.Remove(path)
+		return fmt.Errorf("error copying backup: %v", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
+
+// copyFile copies a file, failing if the destination exists, and syncs it to disk
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("error copying backup: %v", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(destination)
+		return fmt.Errorf("error copying backup: %v", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(destination)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dijibill/database"
+)
+
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	dir := t.TempDir()
+	a := &App{dbPath: filepath.Join(dir, "dijibill.db"), fileDBPath: filepath.Join(dir, "dijibill_files.db"), sessionManager: NewSessionManager()}
+	err := a.openDatabases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.closeDatabases)
+	return a
+}
+
+func addCustomer(t *testing.T, a *App, name string) {
+	t.Helper()
+	if _, err := a.db.GetDB().Exec("INSERT INTO customers (name, company_id) VALUES (?, 1)", name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func customerNames(t *testing.T, a *App) string {
+	t.Helper()
+	var names sql.NullString
+	err := a.db.GetDB().QueryRow("SELECT GROUP_CONCAT(name, ',') FROM (SELECT name FROM customers ORDER BY id)").Scan(&names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names.String
+}
+
+func TestRestoreBackup(t *testing.T) {
+	a := newTestApp(t)
+	addCustomer(t, a, "Before")
+	info, err := a.BackupNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addCustomer(t, a, "After")
+
+	result, err := a.RestoreBackup(info.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := customerNames(t, a); got != "Before" {
+		t.Errorf("customers after the restore are %q, want Before", got)
+	}
+	if !result.FilesRestored || len(result.PreRestoreCopy) != 2 {
+		t.Errorf("restore result %+v, want both databases restored and kept", result)
+	}
+	for _, kept := range result.PreRestoreCopy {
+		if err := database.VerifyBackupFile(kept); err != nil {
+			t.Errorf("pre-restore copy: %v", err)
+		}
+	}
+	if _, err := os.Stat(a.dbPath + ".restore"); !os.IsNotExist(err) {
+		t.Error("the staged copy was left behind")
+	}
+}
+
+func TestRestoreRejectsChangedBackup(t *testing.T) {
+	a := newTestApp(t)
+	addCustomer(t, a, "Before")
+	info, err := a.BackupNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addCustomer(t, a, "After")
+
+	path := filepath.Join(info.Path, mainBackupFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.RestoreBackup(info.Path); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Fatalf("restoring a changed backup: %v", err)
+	}
+	if got := customerNames(t, a); got != "Before,After" {
+		t.Errorf("customers after the refused restore are %q", got)
+	}
+}
+
+func TestRestoreRejectsNewerSchema(t *testing.T) {
+	a := newTestApp(t)
+	addCustomer(t, a, "Current")
+	newer := filepath.Join(t.TempDir(), "newer.db")
+	if err := a.db.BackupTo(newer); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'future')", database.LatestSchemaVersion()+1)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.RestoreBackup(newer)
+	var tooNew *database.SchemaTooNewError
+	if !errors.As(err, &tooNew) {
+		t.Fatalf("restoring a newer backup: %v, want SchemaTooNewError", err)
+	}
+	if got := customerNames(t, a); got != "Current" {
+		t.Errorf("customers after the refused restore are %q", got)
+	}
+}
+
+func TestRestoreRollsBackWhenOpenFails(t *testing.T) {
+	a := newTestApp(t)
+	addCustomer(t, a, "Current")
+
+	// A database that passes inspection but that the migrations cannot bring up to date
+	broken := filepath.Join(t.TempDir(), "broken.db")
+	db, err := sql.Open("sqlite3", broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		CREATE TABLE companies (id INTEGER PRIMARY KEY, name TEXT, name_arabic TEXT);
+		CREATE TABLE customers (id INTEGER PRIMARY KEY);
+		CREATE TABLE products (id INTEGER PRIMARY KEY);
+		CREATE TABLE sales_invoices (id INTEGER PRIMARY KEY, issue_date DATETIME);
+		CREATE TABLE purchase_invoices (id INTEGER PRIMARY KEY, issue_date DATETIME);`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.RestoreBackup(broken)
+	if err == nil || !strings.Contains(err.Error(), "previous database is back in use") {
+		t.Fatalf("restoring a database that cannot be opened: %v", err)
+	}
+	if a.db == nil {
+		t.Fatal("the previous database was not reopened")
+	}
+	if got := customerNames(t, a); got != "Current" {
+		t.Errorf("customers after the rollback are %q", got)
+	}
+	entries, err := os.ReadDir(filepath.Dir(a.dbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".pre-restore-") || strings.HasSuffix(entry.Name(), ".restore") {
+			t.Errorf("%s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestRestoreEncryptedBackup(t *testing.T) {
+	a := newTestApp(t)
+	admin := &database.User{Username: "owner", Email: "owner@example.com", Password: "secret", Role: "admin", IsActive: true, CompanyID: 1}
+	if err := a.db.CreateUser(admin); err != nil {
+		t.Fatal(err)
+	}
+	a.currentSession = &Session{UserID: admin.ID, CompanyID: 1}
+	if err := a.EnableEncryption("correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	addCustomer(t, a, "Sealed")
+	info, err := a.BackupNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Encrypted || info.KeyID != a.keyring.Status().ActiveKeyID {
+		t.Errorf("backup info %+v, want it sealed with the active key", info)
+	}
+	for _, file := range info.Files {
+		data, err := os.ReadFile(filepath.Join(info.Path, file.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !database.IsSealed(data) {
+			t.Errorf("%s is not sealed", file.Name)
+		}
+	}
+
+	// The key the backup was taken with is retired and the passphrase replaced
+	if _, err := a.RotateEncryptionKey("correct horse battery", "battery staple horse"); err != nil {
+		t.Fatal(err)
+	}
+	addCustomer(t, a, "After")
+	if _, err := a.RestoreBackup(info.Path); err != nil {
+		t.Fatal(err)
+	}
+	if got := customerNames(t, a); got != "Sealed" {
+		t.Errorf("customers after restoring with a retired key are %q, want Sealed", got)
+	}
+	sealed, err := os.ReadFile(database.EncryptedPath(a.dbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := database.SealedKeyID(sealed); id != a.keyring.Status().ActiveKeyID {
+		t.Errorf("the restored database is sealed with key %s, want the active key", id)
+	}
+
+	// Without the live keyring the backup opens with the passphrase it was taken under
+	a.currentSession = &Session{UserID: admin.ID, CompanyID: 1}
+	if err := a.DisableEncryption("battery staple horse"); err != nil {
+		t.Fatal(err)
+	}
+	addCustomer(t, a, "Plain")
+	if _, err := a.RestoreBackup(info.Path); err == nil {
+		t.Fatal("an encrypted backup was restored without a keyring")
+	}
+	if _, err := a.RestoreBackupWithPassphrase(info.Path, "battery staple horse"); err == nil {
+		t.Fatal("the backup was restored with a passphrase it was not taken under")
+	}
+	if _, err := a.RestoreBackupWithPassphrase(info.Path, "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+	if got := customerNames(t, a); got != "Sealed" {
+		t.Errorf("customers after restoring with the passphrase are %q, want Sealed", got)
+	}
+	if err := database.VerifyBackupFile(a.dbPath); err != nil {
+		t.Errorf("the database restored with encryption off is not plaintext: %v", err)
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"time"
+
 	"dijibill/database"
 )
 
 // InvoiceData represents the data structure for invoice template
 type InvoiceData struct {
-	Invoice *database.Invoice
-	Company *database.Company
-	Items   []InvoiceItemData
+	Invoice   *database.Invoice
+	Company   *database.Company
+	Items     []InvoiceItemData
+	Payments  []database.Payment // Completed tenders shown on the receipt
+	ChangeDue float64
+
+	PrepaymentDeductions []database.PrepaymentDeduction // Advances deducted on this invoice
+	PrepaymentTotal      float64
+	AmountDue            float64 // Total less deducted advances
 }
 
 // InvoiceItemData represents invoice item with product details
@@ -16,3 +24,32 @@ type InvoiceItemData struct {
 	*database.InvoiceItem
 	Product *database.Product
 }
+
+
+// StatementData represents the data structure for the statement of account template
+type StatementData struct {
+	Company     *database.Company
+	Statement   *database.CustomerStatement
+	GeneratedAt time.Time
+}
+
+// VATReturnData represents the data structure for the VAT return template
+type VATReturnData struct {
+	Company     *database.Company
+	Return      *database.VATReturn
+	Documents   []database.VATReturnDocument
+	GeneratedAt time.Time
+}
+
+// FinancialStatementData represents the data structure for the financial statement template.
+// Either Statement or TrialBalance is set.
+type FinancialStatementData struct {
+	Company      *database.Company
+	Branch       *database.Branch
+	Title        string
+	TitleArabic  string
+	Columns      []database.FinancialReportColumn
+	Statement    *database.FinancialStatement
+	TrialBalance *database.TrialBalance
+	GeneratedAt  time.Time
+}
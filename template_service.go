@@ -14,6 +14,9 @@ type TemplateService struct {
 	englishTemplate  *template.Template
 	arabicTemplate   *template.Template
 	bilingualTemplate *template.Template
+	statementTemplate *template.Template
+	vatReturnTemplate *template.Template
+	financialStatementTemplate *template.Template
 }
 
 // NewTemplateService creates a new template service
@@ -53,6 +56,39 @@ func NewTemplateService() (*TemplateService, error) {
 		return nil, fmt.Errorf("failed to parse Bilingual template: %w", err)
 	}
 	
+	// Load customer statement template
+	statementContent, err := templateFS.ReadFile("templates/statement_bilingual.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read statement template: %w", err)
+	}
+	
+	service.statementTemplate, err = template.New("statement_bilingual").Parse(string(statementContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse statement template: %w", err)
+	}
+	
+	// Load VAT return template
+	vatReturnContent, err := templateFS.ReadFile("templates/vat_return_bilingual.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read VAT return template: %w", err)
+	}
+	
+	service.vatReturnTemplate, err = template.New("vat_return_bilingual").Parse(string(vatReturnContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VAT return template: %w", err)
+	}
+	
+	// Load financial statement template
+	financialStatementContent, err := templateFS.ReadFile("templates/financial_statement_bilingual.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read financial statement template: %w", err)
+	}
+	
+	service.financialStatementTemplate, err = template.New("financial_statement_bilingual").Parse(string(financialStatementContent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse financial statement template: %w", err)
+	}
+	
 	return service, nil
 }
 
@@ -66,4 +102,19 @@ func (ts *TemplateService) GetTemplate(language string) *template.Template {
 	default:
 		return ts.englishTemplate
 	}
-}
\ No newline at end of file
+}
+
+// GetStatementTemplate returns the bilingual statement of account template
+func (ts *TemplateService) GetStatementTemplate() *template.Template {
+	return ts.statementTemplate
+}
+
+// GetVATReturnTemplate returns the bilingual VAT return template
+func (ts *TemplateService) GetVATReturnTemplate() *template.Template {
+	return ts.vatReturnTemplate
+}
+
+// GetFinancialStatementTemplate returns the bilingual trial balance, income statement and balance sheet template
+func (ts *TemplateService) GetFinancialStatementTemplate() *template.Template {
+	return ts.financialStatementTemplate
+}